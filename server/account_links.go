@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// accountLinkRequestTTL is how long the code the bridge bot sends to a Matrix account stays valid
+	accountLinkRequestTTL = 15 * time.Minute
+	// accountLinkMaxAttempts is how many wrong codes end a pending link
+	accountLinkMaxAttempts = 5
+)
+
+// accountLinkRequest is a Matrix account a Mattermost user asked to link, waiting for the code sent to it
+type accountLinkRequest struct {
+	MatrixUserID string `json:"matrix_user_id"`
+	Code         string `json:"code"`
+	ExpiresAt    int64  `json:"expires_at"`
+	Attempts     int    `json:"attempts,omitempty"`
+}
+
+// accountLink is a Matrix account linked to an existing Mattermost user, so its activity appears as them
+type accountLink struct {
+	MatrixUserID string `json:"matrix_user_id"`
+	// ShadowUserID is the Mattermost user the bridge had created for the Matrix account, restored on unlinking
+	ShadowUserID string `json:"shadow_user_id,omitempty"`
+	// Merged is set when the shadow user's channels were merged into the linked user and it was deactivated
+	Merged   bool  `json:"merged,omitempty"`
+	LinkedAt int64 `json:"linked_at"`
+}
+
+// getAccountLink returns the Matrix account linked to a Mattermost user, or nil if there is none
+func (s *BridgeUtils) getAccountLink(mattermostUserID string) *accountLink {
+	if s.kvstore == nil || mattermostUserID == "" {
+		return nil
+	}
+	data, err := s.kvstore.Get(kvstore.BuildAccountLinkKey(mattermostUserID))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var link accountLink
+	if err := json.Unmarshal(data, &link); err != nil || link.MatrixUserID == "" {
+		return nil
+	}
+	return &link
+}
+
+// linkedMatrixUserID returns the Matrix account linked to a Mattermost user, or "" if there is none
+func (s *BridgeUtils) linkedMatrixUserID(mattermostUserID string) string {
+	if link := s.getAccountLink(mattermostUserID); link != nil {
+		return link.MatrixUserID
+	}
+	return ""
+}
+
+// RequestMatrixAccountLink starts linking a Matrix account to a Mattermost user: the bridge bot sends a code
+// to the Matrix account, which the user confirms in Mattermost to prove they control both
+func (p *Plugin) RequestMatrixAccountLink(mattermostUserID, matrixUserID string) error {
+	if p.matrixClient == nil {
+		return errors.New("matrix client not configured")
+	}
+	user, appErr := p.API.GetUser(mattermostUserID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get Mattermost user")
+	}
+	if user.IsRemote() || !p.accountLinkAvailable(mattermostUserID, matrixUserID) {
+		return command.ErrAccountLinkUnavailable
+	}
+
+	code, err := generateAccountLinkCode()
+	if err != nil {
+		return err
+	}
+	request := &accountLinkRequest{
+		MatrixUserID: matrixUserID,
+		Code:         code,
+		ExpiresAt:    time.Now().Add(accountLinkRequestTTL).UnixMilli(),
+	}
+	if err := p.storeAccountLinkRequest(mattermostUserID, request); err != nil {
+		return err
+	}
+
+	if err := p.sendAccountLinkCode(matrixUserID, user.Username, code); err != nil {
+		if deleteErr := p.kvstore.Delete(kvstore.BuildAccountLinkRequestKey(mattermostUserID)); deleteErr != nil {
+			p.logger.LogWarn("Failed to delete Matrix account link request", "error", deleteErr, "user_id", mattermostUserID)
+		}
+		return err
+	}
+
+	p.logger.LogInfo("Sent Matrix account link code", "user_id", mattermostUserID, "matrix_user_id", matrixUserID)
+	return nil
+}
+
+// ConfirmMatrixAccountLink links the Matrix account a Mattermost user requested once they give the code sent to
+// it. The Matrix user mappings then point at the Mattermost user; with merge set, the user the bridge had
+// created for the Matrix account hands its teams and channels over and is deactivated.
+func (p *Plugin) ConfirmMatrixAccountLink(mattermostUserID, code string, merge bool) (*command.AccountLinkResult, error) {
+	request := p.getAccountLinkRequest(mattermostUserID)
+	requestKey := kvstore.BuildAccountLinkRequestKey(mattermostUserID)
+	if request == nil || time.Now().UnixMilli() > request.ExpiresAt {
+		_ = p.kvstore.Delete(requestKey)
+		return nil, command.ErrAccountLinkNotPending
+	}
+
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(code)), []byte(request.Code)) != 1 {
+		request.Attempts++
+		if request.Attempts >= accountLinkMaxAttempts {
+			_ = p.kvstore.Delete(requestKey)
+		} else if err := p.storeAccountLinkRequest(mattermostUserID, request); err != nil {
+			return nil, err
+		}
+		return nil, command.ErrAccountLinkCodeInvalid
+	}
+	if err := p.kvstore.Delete(requestKey); err != nil {
+		return nil, errors.Wrap(err, "failed to delete Matrix account link request")
+	}
+
+	// The account may have been linked to someone else since the code was sent
+	matrixUserID := request.MatrixUserID
+	if !p.accountLinkAvailable(mattermostUserID, matrixUserID) {
+		return nil, command.ErrAccountLinkUnavailable
+	}
+
+	// A previously linked account goes back to its own user first
+	if previous := p.matrixToMattermostBridge.getAccountLink(mattermostUserID); previous != nil && previous.MatrixUserID != matrixUserID {
+		if err := p.removeAccountLink(mattermostUserID, previous); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &command.AccountLinkResult{MatrixUserID: matrixUserID}
+	link := &accountLink{MatrixUserID: matrixUserID, LinkedAt: time.Now().UnixMilli()}
+	if existing := p.matrixToMattermostBridge.getAccountLink(mattermostUserID); existing != nil && existing.MatrixUserID == matrixUserID {
+		link.ShadowUserID = existing.ShadowUserID
+		link.Merged = existing.Merged
+	} else if shadowUserID := p.getMattermostUserIDForMatrixUser(matrixUserID); shadowUserID != "" && shadowUserID != mattermostUserID {
+		if shadowUser, appErr := p.API.GetUser(shadowUserID); appErr == nil {
+			link.ShadowUserID = shadowUserID
+			result.ShadowUsername = shadowUser.Username
+		}
+	}
+
+	if err := p.storeAccountLink(mattermostUserID, link); err != nil {
+		return nil, err
+	}
+	if err := p.kvstore.Set(kvstore.BuildMatrixUserKey(matrixUserID), []byte(mattermostUserID)); err != nil {
+		return nil, errors.Wrap(err, "failed to store Matrix user mapping")
+	}
+	if err := p.kvstore.Set(kvstore.BuildMattermostUserKey(mattermostUserID), []byte(matrixUserID)); err != nil {
+		return nil, errors.Wrap(err, "failed to store reverse Matrix user mapping")
+	}
+
+	if merge && link.ShadowUserID != "" && !link.Merged {
+		result.MergedChannels = p.mergeShadowUser(link.ShadowUserID, mattermostUserID)
+		if appErr := p.API.UpdateUserActive(link.ShadowUserID, false); appErr != nil {
+			p.logger.LogWarn("Failed to deactivate merged Matrix user", "error", appErr, "user_id", link.ShadowUserID, "matrix_user_id", matrixUserID)
+		} else {
+			link.Merged = true
+			result.Merged = true
+			if err := p.storeAccountLink(mattermostUserID, link); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	p.logger.LogInfo("Linked Matrix account to Mattermost user", "user_id", mattermostUserID, "matrix_user_id", matrixUserID, "shadow_user_id", link.ShadowUserID, "merged", result.Merged)
+	return result, nil
+}
+
+// UnlinkMatrixAccount unlinks a Mattermost user's Matrix account, returning the account that was linked
+func (p *Plugin) UnlinkMatrixAccount(mattermostUserID string) (string, error) {
+	link := p.matrixToMattermostBridge.getAccountLink(mattermostUserID)
+	if link == nil {
+		return "", command.ErrAccountNotLinked
+	}
+	if err := p.removeAccountLink(mattermostUserID, link); err != nil {
+		return "", err
+	}
+
+	p.logger.LogInfo("Unlinked Matrix account from Mattermost user", "user_id", mattermostUserID, "matrix_user_id", link.MatrixUserID)
+	return link.MatrixUserID, nil
+}
+
+// removeAccountLink points a linked Matrix account back at the user the bridge had created for it, reactivating
+// that user if it was merged, or at nobody so a new one is created on its next activity
+func (p *Plugin) removeAccountLink(mattermostUserID string, link *accountLink) error {
+	matrixUserKey := kvstore.BuildMatrixUserKey(link.MatrixUserID)
+	if p.getMattermostUserIDForMatrixUser(link.MatrixUserID) == mattermostUserID {
+		var err error
+		if link.ShadowUserID != "" {
+			err = p.kvstore.Set(matrixUserKey, []byte(link.ShadowUserID))
+		} else {
+			err = p.kvstore.Delete(matrixUserKey)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to restore Matrix user mapping")
+		}
+	}
+
+	mattermostUserKey := kvstore.BuildMattermostUserKey(mattermostUserID)
+	if data, err := p.kvstore.Get(mattermostUserKey); err == nil && string(data) == link.MatrixUserID {
+		if err := p.kvstore.Delete(mattermostUserKey); err != nil {
+			return errors.Wrap(err, "failed to delete reverse Matrix user mapping")
+		}
+	}
+	if err := p.kvstore.Delete(kvstore.BuildAccountLinkKey(mattermostUserID)); err != nil {
+		return errors.Wrap(err, "failed to delete Matrix account link")
+	}
+
+	if link.Merged && link.ShadowUserID != "" {
+		if appErr := p.API.UpdateUserActive(link.ShadowUserID, true); appErr != nil {
+			p.logger.LogWarn("Failed to reactivate merged Matrix user", "error", appErr, "user_id", link.ShadowUserID, "matrix_user_id", link.MatrixUserID)
+		}
+	}
+
+	// The application service only logged in as the account because it was linked, so that login goes with it.
+	// Tokens the user gave the bridge themselves stay.
+	if record := p.mattermostToMatrixBridge.puppets.get(mattermostUserID); record != nil && record.AppserviceLogin && record.MatrixUserID == link.MatrixUserID {
+		if _, err := p.LogoutMatrixPuppet(mattermostUserID); err != nil {
+			return errors.Wrap(err, "failed to log out linked Matrix account")
+		}
+	}
+	return nil
+}
+
+// accountLinkAvailable reports whether a Mattermost user may link a Matrix account: it isn't one of the bridge's
+// own users and no other Mattermost user has linked it
+func (p *Plugin) accountLinkAvailable(mattermostUserID, matrixUserID string) bool {
+	if p.isGhostUser(matrixUserID) || strings.HasPrefix(matrixUserID, "@"+matrix.BridgeBotLocalpart+":") {
+		return false
+	}
+	ownerID := p.getMattermostUserIDForMatrixUser(matrixUserID)
+	return ownerID == "" || ownerID == mattermostUserID || p.matrixToMattermostBridge.getAccountLink(ownerID) == nil
+}
+
+// mergeShadowUser adds a linked Mattermost user to the teams and channels of the user the bridge had created for
+// their Matrix account, returning how many channels they were added to. Direct messages and posts stay with
+// the old user, since the plugin API can't change their members or authors.
+func (p *Plugin) mergeShadowUser(shadowUserID, mattermostUserID string) int {
+	teams, appErr := p.API.GetTeamsForUser(shadowUserID)
+	if appErr != nil {
+		p.logger.LogWarn("Failed to get teams of merged Matrix user", "error", appErr, "user_id", shadowUserID)
+		return 0
+	}
+
+	added := 0
+	for _, team := range teams {
+		channels, appErr := p.API.GetChannelsForTeamForUser(team.Id, shadowUserID, false)
+		if appErr != nil {
+			p.logger.LogWarn("Failed to get channels of merged Matrix user", "error", appErr, "user_id", shadowUserID, "team_id", team.Id)
+			continue
+		}
+		for _, channel := range channels {
+			if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
+				continue
+			}
+			if _, appErr := p.API.GetChannelMember(channel.Id, mattermostUserID); appErr == nil {
+				continue
+			}
+			if err := p.matrixToMattermostBridge.addUserToChannel(mattermostUserID, channel.Id); err != nil {
+				p.logger.LogWarn("Failed to add linked user to channel of merged Matrix user", "error", err, "user_id", mattermostUserID, "channel_id", channel.Id)
+				continue
+			}
+			added++
+		}
+	}
+	return added
+}
+
+// sendAccountLinkCode sends a link code to a Matrix account in a new direct chat with the bridge bot
+func (p *Plugin) sendAccountLinkCode(matrixUserID, username, code string) error {
+	botUserID, err := p.getBridgeBotUserID()
+	if err != nil {
+		return errors.Wrap(err, "failed to get bridge bot user ID")
+	}
+	roomID, err := p.matrixClient.CreateBridgeBotDirectRoom(matrixUserID, "Mattermost account link")
+	if err != nil {
+		return errors.Wrap(err, "failed to create direct chat for link code")
+	}
+
+	message := fmt.Sprintf("**@%s** on Mattermost wants to link this Matrix account to their Mattermost account. If that's you, run `/matrix link confirm %s` in Mattermost within %d minutes.\n\nIf it isn't you, ignore this message. Nothing is linked without the code.",
+		username, code, int(accountLinkRequestTTL.Minutes()))
+	plainText, htmlContent := convertMattermostToMatrix(message)
+	if _, err := p.matrixClient.SendNotice(roomID, botUserID, plainText, htmlContent); err != nil {
+		return errors.Wrap(err, "failed to send link code")
+	}
+	return nil
+}
+
+// getAccountLinkRequest returns a Mattermost user's pending Matrix account link, or nil if there is none
+func (p *Plugin) getAccountLinkRequest(mattermostUserID string) *accountLinkRequest {
+	data, err := p.kvstore.Get(kvstore.BuildAccountLinkRequestKey(mattermostUserID))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var request accountLinkRequest
+	if err := json.Unmarshal(data, &request); err != nil || request.Code == "" {
+		return nil
+	}
+	return &request
+}
+
+func (p *Plugin) storeAccountLinkRequest(mattermostUserID string, request *accountLinkRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal Matrix account link request")
+	}
+	return errors.Wrap(p.kvstore.Set(kvstore.BuildAccountLinkRequestKey(mattermostUserID), data), "failed to store Matrix account link request")
+}
+
+func (p *Plugin) storeAccountLink(mattermostUserID string, link *accountLink) error {
+	data, err := json.Marshal(link)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal Matrix account link")
+	}
+	return errors.Wrap(p.kvstore.Set(kvstore.BuildAccountLinkKey(mattermostUserID), data), "failed to store Matrix account link")
+}
+
+// generateAccountLinkCode returns a random six-digit code
+func generateAccountLinkCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate link code")
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatrixAccountLinking(t *testing.T) {
+	const matrixUserID = "@alice:matrix.org"
+
+	// setup returns a plugin whose homeserver records the direct chats the bridge bot creates and the notices it
+	// sends, with a Mattermost user and the user the bridge created earlier for the Matrix account
+	setup := func(t *testing.T) (*Plugin, *plugintest.API, *model.User, *model.User, func() []string) {
+		var mutex sync.Mutex
+		var notices []string
+		plugin, api, _ := setupMappedChannelTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/_matrix/client/v3/createRoom":
+				var body map[string]any
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				assert.Equal(t, []any{matrixUserID}, body["invite"])
+				_, _ = w.Write([]byte(`{"room_id":"!dm:test.com"}`))
+			case strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/rooms/!dm:test.com/send/m.room.message/"):
+				var body map[string]any
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				text, _ := body["body"].(string)
+				mutex.Lock()
+				notices = append(notices, text)
+				mutex.Unlock()
+				_, _ = w.Write([]byte(`{"event_id":"$notice"}`))
+			default:
+				http.Error(w, `{"errcode":"M_UNKNOWN"}`, http.StatusInternalServerError)
+			}
+		}))
+
+		user := &model.User{Id: model.NewId(), Username: "alice"}
+		shadow := &model.User{Id: model.NewId(), Username: "matrix_alice", RemoteId: model.NewPointer("remote_id")}
+		api.On("GetUser", user.Id).Return(user, nil)
+		api.On("GetUser", shadow.Id).Return(shadow, nil)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey(matrixUserID), []byte(shadow.Id)))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMattermostUserKey(shadow.Id), []byte(matrixUserID)))
+
+		return plugin, api, user, shadow, func() []string {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return append([]string(nil), notices...)
+		}
+	}
+	requestCode := func(t *testing.T, plugin *Plugin, userID string) string {
+		require.NoError(t, plugin.RequestMatrixAccountLink(userID, matrixUserID))
+		request := plugin.getAccountLinkRequest(userID)
+		require.NotNil(t, request)
+		return request.Code
+	}
+
+	t.Run("the bridge bot sends the code to the Matrix account", func(t *testing.T) {
+		plugin, _, user, _, notices := setup(t)
+
+		code := requestCode(t, plugin, user.Id)
+		assert.Len(t, code, 6)
+		require.Len(t, notices(), 1)
+		assert.Contains(t, notices()[0], "/matrix link confirm "+code)
+		assert.Contains(t, notices()[0], "@alice")
+	})
+
+	t.Run("confirming points the Matrix account at the Mattermost user", func(t *testing.T) {
+		plugin, _, user, shadow, _ := setup(t)
+		code := requestCode(t, plugin, user.Id)
+
+		result, err := plugin.ConfirmMatrixAccountLink(user.Id, code, false)
+		require.NoError(t, err)
+		assert.Equal(t, matrixUserID, result.MatrixUserID)
+		assert.Equal(t, shadow.Username, result.ShadowUsername)
+		assert.False(t, result.Merged)
+		assert.Nil(t, plugin.getAccountLinkRequest(user.Id))
+
+		// New Matrix activity is bridged as the Mattermost user, whose profile the bridge leaves alone
+		mattermostUserID, err := plugin.matrixToMattermostBridge.getOrCreateMattermostUser(matrixUserID, "")
+		require.NoError(t, err)
+		assert.Equal(t, user.Id, mattermostUserID)
+		matrixUserIDForUser, err := plugin.GetMatrixUserIDFromMattermostUser(user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, matrixUserID, matrixUserIDForUser)
+
+		// Unlinking hands the account back to the user the bridge created
+		unlinked, err := plugin.UnlinkMatrixAccount(user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, matrixUserID, unlinked)
+		assert.Equal(t, shadow.Id, plugin.getMattermostUserIDForMatrixUser(matrixUserID))
+		_, err = plugin.kvstore.Get(kvstore.BuildMattermostUserKey(user.Id))
+		assert.Error(t, err)
+
+		_, err = plugin.UnlinkMatrixAccount(user.Id)
+		assert.ErrorIs(t, err, command.ErrAccountNotLinked)
+	})
+
+	t.Run("merging moves channels and deactivates the bridged user", func(t *testing.T) {
+		plugin, api, user, shadow, _ := setup(t)
+		team := &model.Team{Id: model.NewId()}
+		channel := &model.Channel{Id: model.NewId(), TeamId: team.Id, Type: model.ChannelTypeOpen}
+		joined := &model.Channel{Id: model.NewId(), TeamId: team.Id, Type: model.ChannelTypeOpen}
+		dm := &model.Channel{Id: model.NewId(), Type: model.ChannelTypeDirect}
+		api.On("GetTeamsForUser", shadow.Id).Return([]*model.Team{team}, nil)
+		api.On("GetChannelsForTeamForUser", team.Id, shadow.Id, false).Return([]*model.Channel{channel, joined, dm}, nil)
+		api.On("GetChannelMember", channel.Id, user.Id).Return(nil, model.NewAppError("GetChannelMember", "not_found", nil, "", http.StatusNotFound))
+		api.On("GetChannelMember", joined.Id, user.Id).Return(&model.ChannelMember{}, nil)
+		api.On("GetChannel", channel.Id).Return(channel, nil)
+		api.On("GetTeamMember", team.Id, user.Id).Return(&model.TeamMember{}, nil)
+		api.On("AddChannelMember", channel.Id, user.Id).Return(&model.ChannelMember{}, nil).Once()
+		api.On("UpdateUserActive", shadow.Id, false).Return(nil).Once()
+		code := requestCode(t, plugin, user.Id)
+
+		result, err := plugin.ConfirmMatrixAccountLink(user.Id, code, true)
+		require.NoError(t, err)
+		assert.True(t, result.Merged)
+		assert.Equal(t, 1, result.MergedChannels)
+
+		api.On("UpdateUserActive", shadow.Id, true).Return(nil).Once()
+		_, err = plugin.UnlinkMatrixAccount(user.Id)
+		require.NoError(t, err)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("wrong codes are counted and end the request", func(t *testing.T) {
+		plugin, _, user, _, _ := setup(t)
+		requestCode(t, plugin, user.Id)
+
+		for range accountLinkMaxAttempts {
+			_, err := plugin.ConfirmMatrixAccountLink(user.Id, "wrong", false)
+			assert.ErrorIs(t, err, command.ErrAccountLinkCodeInvalid)
+		}
+		_, err := plugin.ConfirmMatrixAccountLink(user.Id, "wrong", false)
+		assert.ErrorIs(t, err, command.ErrAccountLinkNotPending)
+	})
+
+	t.Run("expired codes are refused", func(t *testing.T) {
+		plugin, _, user, _, _ := setup(t)
+		require.NoError(t, plugin.storeAccountLinkRequest(user.Id, &accountLinkRequest{
+			MatrixUserID: matrixUserID,
+			Code:         "123456",
+			ExpiresAt:    time.Now().Add(-time.Minute).UnixMilli(),
+		}))
+
+		_, err := plugin.ConfirmMatrixAccountLink(user.Id, "123456", false)
+		assert.ErrorIs(t, err, command.ErrAccountLinkNotPending)
+	})
+
+	t.Run("accounts linked to someone else or owned by the bridge can't be linked", func(t *testing.T) {
+		plugin, api, user, _, notices := setup(t)
+		code := requestCode(t, plugin, user.Id)
+		_, err := plugin.ConfirmMatrixAccountLink(user.Id, code, false)
+		require.NoError(t, err)
+
+		other := &model.User{Id: model.NewId(), Username: "mallory"}
+		api.On("GetUser", other.Id).Return(other, nil)
+		assert.ErrorIs(t, plugin.RequestMatrixAccountLink(other.Id, matrixUserID), command.ErrAccountLinkUnavailable)
+		assert.ErrorIs(t, plugin.RequestMatrixAccountLink(other.Id, "@_mattermost_"+user.Id+":test.com"), command.ErrAccountLinkUnavailable)
+		assert.Len(t, notices(), 1)
+	})
+
+	t.Run("leaving on Matrix keeps linked users in their channels", func(t *testing.T) {
+		plugin, _, user, _, _ := setup(t)
+		code := requestCode(t, plugin, user.Id)
+		_, err := plugin.ConfirmMatrixAccountLink(user.Id, code, false)
+		require.NoError(t, err)
+
+		// DeleteChannelMember isn't mocked, so removing the user would panic
+		stateKey := matrixUserID
+		event := MatrixEvent{
+			EventID:  "$leave",
+			Type:     "m.room.member",
+			Sender:   matrixUserID,
+			RoomID:   "!room:test.com",
+			StateKey: &stateKey,
+			Content:  map[string]any{"membership": "leave"},
+		}
+		assert.NoError(t, plugin.matrixToMattermostBridge.handleMatrixMemberLeave(event, model.NewId(), user.Id, true))
+	})
+}
+
+func TestDeactivateDisallowedMatrixUsersSkipsLinkedUsers(t *testing.T) {
+	plugin, api, _, _ := setupSyncDirectionTest(t)
+	plugin.configuration.FederationDeniedServers = "spam.example"
+
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey("@alice:spam.example"), []byte(user.Id)))
+	require.NoError(t, plugin.storeAccountLink(user.Id, &accountLink{MatrixUserID: "@alice:spam.example"}))
+	api.On("GetUser", user.Id).Return(user, nil)
+
+	result, err := plugin.DeactivateDisallowedMatrixUsers(false)
+	require.NoError(t, err)
+	assert.Empty(t, result.Deactivated)
+	api.AssertNotCalled(t, "UpdateUserActive", mock.Anything, mock.Anything)
+}
@@ -18,11 +18,34 @@ func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Req
 	matrixRouter := router.PathPrefix("/_matrix/app/v1").Subrouter()
 	matrixRouter.Use(p.MatrixAuthorizationRequired)
 	matrixRouter.HandleFunc("/transactions/{txnId}", p.handleMatrixTransaction).Methods(http.MethodPut)
+	matrixRouter.HandleFunc("/ping", p.handleMatrixPing).Methods(http.MethodPost)
+	matrixRouter.HandleFunc("/users/{userId}", p.handleMatrixUserQuery).Methods(http.MethodGet)
+	matrixRouter.HandleFunc("/rooms/{roomAlias}", p.handleMatrixRoomAliasQuery).Methods(http.MethodGet)
+	matrixRouter.HandleFunc("/thirdparty/protocol/{protocol}", p.handleThirdPartyProtocol).Methods(http.MethodGet)
+	matrixRouter.HandleFunc("/thirdparty/user/{protocol}", p.handleThirdPartyUserLookup).Methods(http.MethodGet)
+	matrixRouter.HandleFunc("/thirdparty/user", p.handleThirdPartyUserReverseLookup).Methods(http.MethodGet)
+	matrixRouter.HandleFunc("/thirdparty/location/{protocol}", p.handleThirdPartyLocationLookup).Methods(http.MethodGet)
+	matrixRouter.HandleFunc("/thirdparty/location", p.handleThirdPartyLocationReverseLookup).Methods(http.MethodGet)
+
+	// Legacy unprefixed transaction route still used by some homeservers
+	legacyRouter := router.PathPrefix("/transactions").Subrouter()
+	legacyRouter.Use(p.MatrixAuthorizationRequired)
+	legacyRouter.HandleFunc("/{txnId}", p.handleMatrixTransaction).Methods(http.MethodPut)
 
 	// Authenticated Mattermost API routes
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
 	apiRouter.Use(p.MattermostAuthorizationRequired)
 	apiRouter.HandleFunc("/hello", p.HelloWorld).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mappings/{channelId}/direction", p.handleGetSyncDirection).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mappings/{channelId}/direction", p.handleSetSyncDirection).Methods(http.MethodPut)
+	apiRouter.HandleFunc("/mappings/{channelId}/filters", p.handleGetContentFilters).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mappings/{channelId}/filters", p.handleSetContentFilters).Methods(http.MethodPut)
+	apiRouter.HandleFunc("/mappings/{channelId}/federation", p.handleGetFederationPolicy).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mappings/{channelId}/federation", p.handleSetFederationPolicy).Methods(http.MethodPut)
+	apiRouter.HandleFunc("/mappings/{channelId}/relay", p.handleGetRelaySetting).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mappings/{channelId}/relay", p.handleSetRelaySetting).Methods(http.MethodPut)
+	apiRouter.HandleFunc("/metrics", p.handleGetMetrics).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/media/{token}", p.handleGetProxiedMedia).Methods(http.MethodGet)
 
 	router.ServeHTTP(w, r)
 }
@@ -52,8 +75,12 @@ func (p *Plugin) MatrixAuthorizationRequired(next http.Handler) http.Handler {
 			return
 		}
 
-		// Verify hs_token in Authorization header
+		// Verify hs_token in Authorization header, falling back to the access_token query
+		// parameter that older homeservers send instead
 		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" && r.URL.Query().Has("access_token") {
+			authHeader = "Bearer " + r.URL.Query().Get("access_token")
+		}
 		expectedToken := "Bearer " + config.MatrixHSToken
 
 		if config.MatrixHSToken == "" {
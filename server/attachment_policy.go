@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/pkg/errors"
+)
+
+// getBlockedMimeTypes returns the MIME types of files that aren't bridged in a direction
+func (c *configuration) getBlockedMimeTypes(direction string) []string {
+	if direction == command.SyncDirectionToMatrix {
+		return splitSettingList(c.BlockedMimeTypesToMatrix)
+	}
+	return splitSettingList(c.BlockedMimeTypesToMattermost)
+}
+
+// validateMimeTypeList checks that every entry is a MIME type, a type/* wildcard or *
+func validateMimeTypeList(entries []string) error {
+	for _, entry := range entries {
+		if entry == "*" {
+			continue
+		}
+		mediaType, subtype, ok := strings.Cut(entry, "/")
+		if !ok || mediaType == "" || subtype == "" || mediaType == "*" || strings.ContainsAny(entry, " ;") ||
+			(strings.Contains(subtype, "*") && subtype != "*") {
+			return errors.Errorf("invalid MIME type %q", entry)
+		}
+	}
+	return nil
+}
+
+// mimeTypeBlocked reports whether a MIME type matches any entry of a block list. Files of unknown type only
+// match *.
+func mimeTypeBlocked(blocked []string, mimeType string) bool {
+	mimeType, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(mimeType)), ";")
+	mimeType = strings.TrimSpace(mimeType)
+	mediaType, _, _ := strings.Cut(mimeType, "/")
+	for _, entry := range blocked {
+		entry = strings.ToLower(entry)
+		if entry == "*" || (mimeType != "" && (entry == mimeType || entry == mediaType+"/*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentMimeType returns a file's MIME type, falling back to the one its extension suggests
+func attachmentMimeType(mimeType, filename string) string {
+	if mimeType != "" {
+		return mimeType
+	}
+	return mime.TypeByExtension(filepath.Ext(filename))
+}
+
+// matrixUploadLimit returns the largest file sent to Matrix: the plugin's limit or the homeserver's
+// m.upload.size, whichever is smaller. 0 means no limit.
+func (p *Plugin) matrixUploadLimit() int64 {
+	return smallestLimit(p.maxFileSize, p.matrixClient.UploadSizeLimit())
+}
+
+// mattermostFileLimit returns the largest file brought into Mattermost: the plugin's limit or Mattermost's
+// MaxFileSize, whichever is smaller. 0 means no limit.
+func (s *BridgeUtils) mattermostFileLimit() int64 {
+	var serverLimit int64
+	if config := s.API.GetConfig(); config != nil && config.FileSettings.MaxFileSize != nil {
+		serverLimit = *config.FileSettings.MaxFileSize
+	}
+	return smallestLimit(s.maxFileSize, serverLimit)
+}
+
+// smallestLimit returns the smallest of two size limits, where 0 means no limit
+func smallestLimit(a, b int64) int64 {
+	if a <= 0 {
+		return max(b, 0)
+	}
+	if b <= 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+// attachmentNotice is the text sent in place of a file that wasn't bridged
+type attachmentNotice struct {
+	Text string
+	HTML string
+}
+
+// blockedAttachmentNotice says a file wasn't bridged because its type isn't allowed
+func blockedAttachmentNotice(filename, mimeType string) attachmentNotice {
+	text := fmt.Sprintf("%s wasn't bridged: files of type %s aren't allowed.", filename, mimeType)
+	if mimeType == "" {
+		text = fmt.Sprintf("%s wasn't bridged: files of unknown type aren't allowed.", filename)
+	}
+	return attachmentNotice{Text: text, HTML: html.EscapeString(text)}
+}
+
+// oversizedAttachmentNotice says a file was too large to bridge, linking to where readers can sign in to get it
+func oversizedAttachmentNotice(filename string, size int64, linkText, link string) attachmentNotice {
+	description := filename
+	if size > 0 {
+		description = fmt.Sprintf("%s (%s)", filename, formatFileSize(size))
+	}
+	text := description + " is too large to bridge."
+	notice := attachmentNotice{Text: text, HTML: html.EscapeString(text)}
+	if link != "" {
+		notice.Text += fmt.Sprintf(" %s: %s", linkText, link)
+		notice.HTML += fmt.Sprintf(` <a href="%s">%s</a>`, html.EscapeString(link), html.EscapeString(linkText))
+	}
+	return notice
+}
+
+// matrixEventLink returns a matrix.to link to an event, which opens in the reader's own Matrix client
+func matrixEventLink(roomID, eventID string) string {
+	return "https://matrix.to/#/" + url.PathEscape(roomID) + "/" + url.PathEscape(eventID)
+}
+
+// formatFileSize formats a size in bytes for people to read
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 3 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[exponent])
+}
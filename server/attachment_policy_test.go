@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMimeTypeBlocked(t *testing.T) {
+	blocked := []string{"application/x-msdownload", "video/*"}
+
+	tests := []struct {
+		mimeType string
+		expected bool
+	}{
+		{"application/x-msdownload", true},
+		{"Application/X-MSDownload; charset=binary", true},
+		{"video/mp4", true},
+		{"image/png", false},
+		{"application/pdf", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mimeType, func(t *testing.T) {
+			assert.Equal(t, tt.expected, mimeTypeBlocked(blocked, tt.mimeType))
+		})
+	}
+
+	t.Run("* blocks everything", func(t *testing.T) {
+		assert.True(t, mimeTypeBlocked([]string{"*"}, ""))
+		assert.True(t, mimeTypeBlocked([]string{"*"}, "image/png"))
+	})
+}
+
+func TestValidateMimeTypeList(t *testing.T) {
+	assert.NoError(t, validateMimeTypeList([]string{"image/png", "video/*", "*", "application/vnd.ms-excel"}))
+	for _, entry := range []string{"image", "image/", "/png", "*/*", "image/p*", "image/png; charset=binary"} {
+		assert.Error(t, validateMimeTypeList([]string{entry}), entry)
+	}
+
+	plugin := setupPluginForTest()
+	assert.Error(t, plugin.validateConfiguration(&configuration{BlockedMimeTypesToMattermost: "video"}))
+}
+
+func TestSmallestLimit(t *testing.T) {
+	assert.Equal(t, int64(10), smallestLimit(10, 20))
+	assert.Equal(t, int64(10), smallestLimit(20, 10))
+	assert.Equal(t, int64(10), smallestLimit(0, 10))
+	assert.Equal(t, int64(10), smallestLimit(10, 0))
+	assert.Zero(t, smallestLimit(0, 0))
+}
+
+func TestAttachmentNotices(t *testing.T) {
+	t.Run("oversized files link to the original", func(t *testing.T) {
+		notice := oversizedAttachmentNotice("big <1>.zip", 75*1024*1024, "Open it in Matrix", "https://matrix.to/#/!room:test.com/$event")
+		assert.Equal(t, "big <1>.zip (75.0 MB) is too large to bridge. Open it in Matrix: https://matrix.to/#/!room:test.com/$event", notice.Text)
+		assert.Equal(t, `big &lt;1&gt;.zip (75.0 MB) is too large to bridge. <a href="https://matrix.to/#/!room:test.com/$event">Open it in Matrix</a>`, notice.HTML)
+	})
+
+	t.Run("without a link", func(t *testing.T) {
+		assert.Equal(t, "big.zip is too large to bridge.", oversizedAttachmentNotice("big.zip", 0, "Open it in Mattermost", "").Text)
+	})
+
+	t.Run("blocked files", func(t *testing.T) {
+		assert.Equal(t, "setup.exe wasn't bridged: files of type application/x-msdownload aren't allowed.", blockedAttachmentNotice("setup.exe", "application/x-msdownload").Text)
+		assert.Equal(t, "notes wasn't bridged: files of unknown type aren't allowed.", blockedAttachmentNotice("notes", "").Text)
+	})
+
+	t.Run("sizes", func(t *testing.T) {
+		assert.Equal(t, "512 B", formatFileSize(512))
+		assert.Equal(t, "1.5 KB", formatFileSize(1536))
+		assert.Equal(t, "2.0 GB", formatFileSize(2*1024*1024*1024))
+	})
+}
+
+func TestAttachmentFallbackToMatrix(t *testing.T) {
+	setup := func(t *testing.T, config *configuration) (*Plugin, string) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		config.MatrixServerURL, config.MatrixHSToken, config.EnableSync = "https://test.com", "hs_token", true
+		plugin.configuration = config
+		plugin.pendingFiles = NewPendingFileTracker()
+		plugin.maxFileSize = 1024
+		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewPointer("https://mattermost.example.com/")}})
+		api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+		return plugin, channelID
+	}
+
+	t.Run("oversized files become a notice linking to the post", func(t *testing.T) {
+		plugin, channelID := setup(t, &configuration{})
+		post := &model.Post{Id: model.NewId(), ChannelId: channelID}
+		fi := &model.FileInfo{Id: model.NewId(), Name: "video.mp4", MimeType: "video/mp4", Size: 2048}
+
+		require.NoError(t, plugin.OnSharedChannelsAttachmentSyncMsg(fi, post, nil))
+
+		files := plugin.pendingFiles.GetFiles(post.Id)
+		require.Len(t, files, 1)
+		assert.Empty(t, files[0].MxcURI)
+		assert.Equal(t, "video.mp4 (2.0 KB) is too large to bridge. Open it in Mattermost: https://mattermost.example.com/_redirect/pl/"+post.Id, files[0].Notice)
+	})
+
+	t.Run("blocked types become a notice without a link", func(t *testing.T) {
+		plugin, channelID := setup(t, &configuration{BlockedMimeTypesToMatrix: "video/*"})
+		post := &model.Post{Id: model.NewId(), ChannelId: channelID}
+		fi := &model.FileInfo{Id: model.NewId(), Name: "clip.mp4", MimeType: "video/mp4", Size: 10}
+
+		require.NoError(t, plugin.OnSharedChannelsAttachmentSyncMsg(fi, post, nil))
+
+		files := plugin.pendingFiles.GetFiles(post.Id)
+		require.Len(t, files, 1)
+		assert.Equal(t, "clip.mp4 wasn't bridged: files of type video/mp4 aren't allowed.", files[0].Notice)
+	})
+}
+
+func TestAttachmentFallbackToMattermost(t *testing.T) {
+	setup := func(t *testing.T, config *configuration, maxFileSize int64) (*Plugin, *plugintest.API, func(string)) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		config.MatrixServerURL, config.MatrixHSToken, config.EnableSync = "https://test.com", "hs_token", true
+		plugin.configuration = config
+
+		userID := model.NewId()
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey("@alice:matrix.org"), []byte(userID)))
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Type: model.ChannelTypeOpen}, nil)
+		api.On("GetConfig").Return(&model.Config{FileSettings: model.FileSettings{MaxFileSize: model.NewPointer(maxFileSize)}})
+
+		expectMessage := func(message string) {
+			api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return post.Message == message && len(post.FileIds) == 0
+			})).Return(&model.Post{Id: model.NewId()}, nil).Once()
+		}
+		return plugin, api, expectMessage
+	}
+	event := MatrixEvent{
+		EventID: "$video",
+		Type:    "m.room.message",
+		Sender:  "@alice:matrix.org",
+		RoomID:  "!room:test.com",
+		Content: map[string]any{
+			"msgtype":  "m.video",
+			"body":     "Our trip",
+			"filename": "trip.mp4",
+			"url":      "mxc://matrix.org/trip",
+			"info":     map[string]any{"mimetype": "video/mp4", "size": float64(4096)},
+		},
+	}
+
+	t.Run("files over Mattermost's limit become a notice linking to the event", func(t *testing.T) {
+		plugin, api, expectMessage := setup(t, &configuration{}, 1024)
+		expectMessage("Our trip\n\ntrip.mp4 (4.0 KB) is too large to bridge. Open it in Matrix: https://matrix.to/#/%21room:test.com/$video")
+
+		require.NoError(t, plugin.processMatrixEvent(event))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("blocked types become a notice", func(t *testing.T) {
+		plugin, api, expectMessage := setup(t, &configuration{BlockedMimeTypesToMattermost: "video/*"}, 0)
+		expectMessage("Our trip\n\ntrip.mp4 wasn't bridged: files of type video/mp4 aren't allowed.")
+
+		require.NoError(t, plugin.processMatrixEvent(event))
+		api.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// autoBridgeChannelTypesPublic limits the auto-bridge policy to public channels
+	autoBridgeChannelTypesPublic = "public"
+	// autoBridgeChannelTypesAll applies the auto-bridge policy to public and private channels
+	autoBridgeChannelTypesAll = "all"
+
+	// autoBridgeChannelsPerPage is the page size used when listing a team's existing channels
+	autoBridgeChannelsPerPage = 200
+)
+
+// autoBridgePolicy decides which channels are bridged to Matrix without a /matrix create or /matrix map
+type autoBridgePolicy struct {
+	teams          []string
+	pattern        *regexp.Regexp
+	includePrivate bool
+	excluded       map[string]bool
+}
+
+// getAutoBridgePolicy parses the auto-bridge settings. Returns nil if no teams are configured, which
+// disables auto-bridging.
+func (c *configuration) getAutoBridgePolicy() (*autoBridgePolicy, error) {
+	teams := splitSettingList(c.AutoBridgeTeams)
+	if len(teams) == 0 {
+		return nil, nil
+	}
+
+	policy := &autoBridgePolicy{
+		includePrivate: c.AutoBridgeChannelTypes == autoBridgeChannelTypesAll,
+		excluded:       make(map[string]bool),
+	}
+	for _, team := range teams {
+		policy.teams = append(policy.teams, strings.ToLower(team))
+	}
+
+	if pattern := strings.TrimSpace(c.AutoBridgeChannelPattern); pattern != "" {
+		// Anchor the pattern so it has to match the whole channel name
+		compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid channel pattern")
+		}
+		policy.pattern = compiled
+	}
+
+	for _, channel := range splitSettingList(c.AutoBridgeExcludedChannels) {
+		policy.excluded[strings.ToLower(channel)] = true
+	}
+
+	return policy, nil
+}
+
+// matches reports whether the policy bridges a channel in a team
+func (a *autoBridgePolicy) matches(team *model.Team, channel *model.Channel) bool {
+	if channel.DeleteAt != 0 {
+		return false
+	}
+
+	switch channel.Type {
+	case model.ChannelTypeOpen:
+	case model.ChannelTypePrivate:
+		if !a.includePrivate {
+			return false
+		}
+	default:
+		return false
+	}
+
+	teamListed := false
+	for _, name := range a.teams {
+		if name == strings.ToLower(team.Name) {
+			teamListed = true
+			break
+		}
+	}
+	if !teamListed {
+		return false
+	}
+
+	if a.excluded[strings.ToLower(channel.Id)] || a.excluded[strings.ToLower(channel.Name)] || a.excluded[strings.ToLower(team.Name+"/"+channel.Name)] {
+		return false
+	}
+
+	return a.pattern == nil || a.pattern.MatchString(channel.Name)
+}
+
+// ChannelHasBeenCreated bridges new channels that match the auto-bridge policy
+func (p *Plugin) ChannelHasBeenCreated(_ *plugin.Context, channel *model.Channel) {
+	config := p.getConfiguration()
+	if !config.EnableSync || p.matrixClient == nil || channel.TeamId == "" {
+		return
+	}
+
+	policy, err := config.getAutoBridgePolicy()
+	if err != nil || policy == nil {
+		return
+	}
+
+	// Channels created by /matrix join are mapped to an existing room by the command itself
+	skipKey := kvstore.BuildAutoBridgeSkipKey(channel.TeamId, channel.Name)
+	if data, err := p.kvstore.Get(skipKey); err == nil && len(data) > 0 {
+		if err := p.kvstore.Delete(skipKey); err != nil {
+			p.logger.LogWarn("Failed to clear auto-bridge exemption", "error", err, "channel_id", channel.Id)
+		}
+		return
+	}
+
+	team, appErr := p.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		p.logger.LogWarn("Failed to get team for auto-bridge", "error", appErr, "channel_id", channel.Id, "team_id", channel.TeamId)
+		return
+	}
+
+	if !policy.matches(team, channel) {
+		return
+	}
+
+	roomID, err := p.autoBridgeChannel(channel)
+	if err != nil {
+		p.logger.LogError("Failed to auto-bridge new channel", "error", err, "channel_id", channel.Id, "channel_name", channel.Name)
+		return
+	}
+
+	p.logger.LogInfo("Auto-bridged new channel to Matrix", "channel_id", channel.Id, "channel_name", channel.Name, "room_id", roomID)
+}
+
+// AutoBridgeExistingChannels bridges existing channels that match the auto-bridge policy. The plugin API
+// can only list the public channels of a team, so private channels are limited to those the given user
+// belongs to. With preview set, matching channels are reported without being bridged.
+func (p *Plugin) AutoBridgeExistingChannels(userID string, preview bool) (*command.AutoBridgeResult, error) {
+	if p.matrixClient == nil {
+		return nil, errors.New("matrix client not configured")
+	}
+
+	policy, err := p.getConfiguration().getAutoBridgePolicy()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid auto-bridge policy")
+	}
+	if policy == nil {
+		return nil, command.ErrAutoBridgeDisabled
+	}
+
+	result := &command.AutoBridgeResult{}
+	for _, teamName := range policy.teams {
+		team, appErr := p.API.GetTeamByName(teamName)
+		if appErr != nil {
+			p.logger.LogWarn("Auto-bridge team not found", "error", appErr, "team_name", teamName)
+			result.MissingTeams = append(result.MissingTeams, teamName)
+			continue
+		}
+
+		channels, err := p.listAutoBridgeCandidates(team, userID, policy.includePrivate)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list channels for team %s", teamName)
+		}
+
+		for _, channel := range channels {
+			if !policy.matches(team, channel) {
+				continue
+			}
+
+			displayName := team.Name + "/" + channel.Name
+			if existingRoom, err := p.kvstore.Get(kvstore.BuildChannelMappingKey(channel.Id)); err == nil && len(existingRoom) > 0 {
+				result.AlreadyBridged = append(result.AlreadyBridged, displayName)
+				continue
+			}
+
+			if preview {
+				result.Bridged = append(result.Bridged, displayName)
+				continue
+			}
+
+			if _, err := p.autoBridgeChannel(channel); err != nil {
+				p.logger.LogError("Failed to auto-bridge existing channel", "error", err, "channel_id", channel.Id, "channel_name", channel.Name)
+				result.Failed = append(result.Failed, displayName)
+				continue
+			}
+			result.Bridged = append(result.Bridged, displayName)
+		}
+	}
+
+	return result, nil
+}
+
+// listAutoBridgeCandidates returns a team's public channels and, if requested, the private channels the
+// given user belongs to
+func (p *Plugin) listAutoBridgeCandidates(team *model.Team, userID string, includePrivate bool) ([]*model.Channel, error) {
+	var channels []*model.Channel
+	for page := 0; ; page++ {
+		batch, appErr := p.API.GetPublicChannelsForTeam(team.Id, page, autoBridgeChannelsPerPage)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to list public channels")
+		}
+		channels = append(channels, batch...)
+		if len(batch) < autoBridgeChannelsPerPage {
+			break
+		}
+	}
+
+	if includePrivate && userID != "" {
+		userChannels, appErr := p.API.GetChannelsForTeamForUser(team.Id, userID, false)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to list private channels")
+		}
+		for _, channel := range userChannels {
+			if channel.Type == model.ChannelTypePrivate {
+				channels = append(channels, channel)
+			}
+		}
+	}
+
+	return channels, nil
+}
+
+// autoBridgeChannel creates a Matrix room for a channel, maps the two, shares the channel with the bridge
+// and lists the room in the team's space. Channel members join the room as they post.
+func (p *Plugin) autoBridgeChannel(channel *model.Channel) (string, error) {
+	if existingRoom, err := p.kvstore.Get(kvstore.BuildChannelMappingKey(channel.Id)); err == nil && len(existingRoom) > 0 {
+		return string(existingRoom), nil
+	}
+
+	serverDomain, err := p.matrixClient.GetServerDomain()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get Matrix server domain")
+	}
+
+	roomName := channel.DisplayName
+	if roomName == "" {
+		roomName = channel.Name
+	}
+
+	// Public channels get public rooms, matching what Mattermost users can already see
+	roomID, err := p.matrixClient.CreateRoom(roomName, channel.Purpose, serverDomain, channel.Type == model.ChannelTypeOpen, channel.Id)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create Matrix room")
+	}
+
+	if err := p.mattermostToMatrixBridge.setChannelRoomMapping(channel.Id, roomID); err != nil {
+		return "", errors.Wrap(err, "failed to save channel mapping")
+	}
+
+	if err := p.shareChannelWithBridge(channel); err != nil {
+		// The mapping is in place; an admin can still enable sharing for the channel manually
+		p.logger.LogWarn("Failed to share auto-bridged channel", "error", err, "channel_id", channel.Id, "room_id", roomID)
+	}
+
+	if err := p.AddChannelToTeamSpace(channel.Id, roomID); err != nil {
+		p.logger.LogWarn("Failed to add auto-bridged room to team space", "error", err, "channel_id", channel.Id, "room_id", roomID)
+	}
+
+	return roomID, nil
+}
+
+// splitSettingList splits a comma-separated setting into its trimmed, non-empty entries
+func splitSettingList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
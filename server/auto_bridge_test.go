@@ -0,0 +1,164 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoBridgePolicy(t *testing.T) {
+	team := &model.Team{Id: model.NewId(), Name: "engineering"}
+	channel := func(name string, channelType model.ChannelType) *model.Channel {
+		return &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: name, Type: channelType}
+	}
+
+	t.Run("no teams disables the policy", func(t *testing.T) {
+		policy, err := (&configuration{AutoBridgeChannelPattern: "eng-.*"}).getAutoBridgePolicy()
+		require.NoError(t, err)
+		assert.Nil(t, policy)
+	})
+
+	t.Run("invalid patterns are rejected", func(t *testing.T) {
+		_, err := (&configuration{AutoBridgeTeams: "engineering", AutoBridgeChannelPattern: "eng-("}).getAutoBridgePolicy()
+		assert.Error(t, err)
+	})
+
+	t.Run("matches whole channel names in listed teams", func(t *testing.T) {
+		policy, err := (&configuration{AutoBridgeTeams: "Sales, Engineering", AutoBridgeChannelPattern: "eng-.*"}).getAutoBridgePolicy()
+		require.NoError(t, err)
+
+		assert.True(t, policy.matches(team, channel("eng-backend", model.ChannelTypeOpen)))
+		assert.False(t, policy.matches(team, channel("team-eng-backend", model.ChannelTypeOpen)))
+		assert.False(t, policy.matches(&model.Team{Id: model.NewId(), Name: "support"}, channel("eng-backend", model.ChannelTypeOpen)))
+	})
+
+	t.Run("private channels need the all setting", func(t *testing.T) {
+		publicOnly, err := (&configuration{AutoBridgeTeams: "engineering"}).getAutoBridgePolicy()
+		require.NoError(t, err)
+		all, err := (&configuration{AutoBridgeTeams: "engineering", AutoBridgeChannelTypes: autoBridgeChannelTypesAll}).getAutoBridgePolicy()
+		require.NoError(t, err)
+
+		assert.False(t, publicOnly.matches(team, channel("secret", model.ChannelTypePrivate)))
+		assert.True(t, all.matches(team, channel("secret", model.ChannelTypePrivate)))
+		assert.False(t, all.matches(team, channel("dm", model.ChannelTypeDirect)))
+	})
+
+	t.Run("excluded channels never match", func(t *testing.T) {
+		excludedByID := channel("by-id", model.ChannelTypeOpen)
+		policy, err := (&configuration{
+			AutoBridgeTeams:            "engineering",
+			AutoBridgeExcludedChannels: "town-square, engineering/off-topic, " + excludedByID.Id,
+		}).getAutoBridgePolicy()
+		require.NoError(t, err)
+
+		assert.False(t, policy.matches(team, channel("town-square", model.ChannelTypeOpen)))
+		assert.False(t, policy.matches(team, channel("off-topic", model.ChannelTypeOpen)))
+		assert.False(t, policy.matches(team, excludedByID))
+		assert.True(t, policy.matches(team, channel("general", model.ChannelTypeOpen)))
+	})
+}
+
+func setupAutoBridgeTest(t *testing.T) (*Plugin, *plugintest.API) {
+	plugin, api, _ := setupTeamSpaceTest(t)
+	plugin.configuration = &configuration{
+		MatrixServerURL:          "https://test.com",
+		EnableSync:               true,
+		AutoBridgeTeams:          "engineering",
+		AutoBridgeChannelPattern: "eng-.*",
+	}
+	plugin.remoteID = "remote_id"
+	plugin.initBridges()
+	api.On("ShareChannel", mock.Anything).Return(&model.SharedChannel{}, nil)
+	api.On("InviteRemoteToChannel", mock.Anything, "remote_id", mock.Anything, false).Return(nil)
+	return plugin, api
+}
+
+func TestChannelHasBeenCreatedAutoBridge(t *testing.T) {
+	team := &model.Team{Id: model.NewId(), Name: "engineering", DisplayName: "Engineering"}
+
+	t.Run("matching channels are bridged", func(t *testing.T) {
+		plugin, api := setupAutoBridgeTest(t)
+		channel := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "eng-backend", DisplayName: "Backend", Type: model.ChannelTypeOpen}
+		api.On("GetTeam", team.Id).Return(team, nil)
+		api.On("GetChannel", channel.Id).Return(channel, nil)
+
+		plugin.ChannelHasBeenCreated(nil, channel)
+
+		roomID, err := plugin.kvstore.Get(kvstore.BuildChannelMappingKey(channel.Id))
+		require.NoError(t, err)
+		assert.NotEmpty(t, roomID)
+		channelID, err := plugin.kvstore.Get(kvstore.BuildRoomMappingKey(string(roomID)))
+		require.NoError(t, err)
+		assert.Equal(t, channel.Id, string(channelID))
+	})
+
+	t.Run("channels outside the policy are left alone", func(t *testing.T) {
+		plugin, api := setupAutoBridgeTest(t)
+		channel := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "random", Type: model.ChannelTypeOpen}
+		api.On("GetTeam", team.Id).Return(team, nil)
+
+		plugin.ChannelHasBeenCreated(nil, channel)
+
+		roomID, _ := plugin.kvstore.Get(kvstore.BuildChannelMappingKey(channel.Id))
+		assert.Empty(t, roomID)
+	})
+
+	t.Run("channels created by /matrix join are skipped once", func(t *testing.T) {
+		plugin, _ := setupAutoBridgeTest(t)
+		channel := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "eng-matrix", Type: model.ChannelTypeOpen}
+		skipKey := kvstore.BuildAutoBridgeSkipKey(team.Id, channel.Name)
+		require.NoError(t, plugin.kvstore.Set(skipKey, []byte("!existing:test.com")))
+
+		plugin.ChannelHasBeenCreated(nil, channel)
+
+		roomID, _ := plugin.kvstore.Get(kvstore.BuildChannelMappingKey(channel.Id))
+		assert.Empty(t, roomID)
+		marker, _ := plugin.kvstore.Get(skipKey)
+		assert.Empty(t, marker)
+	})
+}
+
+func TestAutoBridgeExistingChannels(t *testing.T) {
+	team := &model.Team{Id: model.NewId(), Name: "engineering"}
+	unbridged := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "eng-backend", Type: model.ChannelTypeOpen}
+	bridged := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "eng-frontend", Type: model.ChannelTypeOpen}
+	unmatched := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "random", Type: model.ChannelTypeOpen}
+
+	t.Run("preview lists matching channels without bridging them", func(t *testing.T) {
+		plugin, api := setupAutoBridgeTest(t)
+		api.On("GetTeamByName", "engineering").Return(team, nil)
+		api.On("GetPublicChannelsForTeam", team.Id, 0, autoBridgeChannelsPerPage).Return([]*model.Channel{unbridged, bridged, unmatched}, nil)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(bridged.Id), []byte("!frontend:test.com")))
+
+		result, err := plugin.AutoBridgeExistingChannels("admin-id", true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"engineering/eng-backend"}, result.Bridged)
+		assert.Equal(t, []string{"engineering/eng-frontend"}, result.AlreadyBridged)
+
+		roomID, _ := plugin.kvstore.Get(kvstore.BuildChannelMappingKey(unbridged.Id))
+		assert.Empty(t, roomID)
+	})
+
+	t.Run("missing teams are reported", func(t *testing.T) {
+		plugin, api := setupAutoBridgeTest(t)
+		api.On("GetTeamByName", "engineering").Return(nil, model.NewAppError("GetTeamByName", "not_found", nil, "", 404))
+
+		result, err := plugin.AutoBridgeExistingChannels("admin-id", true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"engineering"}, result.MissingTeams)
+	})
+
+	t.Run("no policy is an error", func(t *testing.T) {
+		plugin, _ := setupAutoBridgeTest(t)
+		plugin.configuration.AutoBridgeTeams = ""
+
+		_, err := plugin.AutoBridgeExistingChannels("admin-id", true)
+		assert.ErrorIs(t, err, command.ErrAutoBridgeDisabled)
+	})
+}
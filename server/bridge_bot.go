@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// bridgeBotCommandPrefix is the prefix Matrix users use to address the bridge bot
+const bridgeBotCommandPrefix = "!mm"
+
+const bridgeBotHelpText = `**Mattermost bridge commands**
+- ` + "`!mm help`" + ` - Show this help
+- ` + "`!mm status`" + ` - Show the bridge status for this room
+- ` + "`!mm whois <@user:server|mattermost-username>`" + ` - Show who a user is on the other side of the bridge
+- ` + "`!mm invite <mattermost-username>`" + ` - Add a Mattermost user to the bridged channel
+- ` + "`!mm ping`" + ` - Check that the bridge is responding`
+
+// bridgeBotCommand represents a parsed !mm command
+type bridgeBotCommand struct {
+	Name string
+	Args []string
+}
+
+// parseBridgeBotCommand parses a message body into a bridge bot command.
+// Returns false if the message is not addressed to the bridge bot.
+func parseBridgeBotCommand(body string) (*bridgeBotCommand, bool) {
+	fields := strings.Fields(strings.TrimSpace(body))
+	if len(fields) == 0 || !strings.EqualFold(fields[0], bridgeBotCommandPrefix) {
+		return nil, false
+	}
+
+	if len(fields) == 1 {
+		return &bridgeBotCommand{Name: "help"}, true
+	}
+
+	return &bridgeBotCommand{
+		Name: strings.ToLower(fields[1]),
+		Args: fields[2:],
+	}, true
+}
+
+// getBridgeBotUserID returns the Matrix user ID of the bridge bot
+func (p *Plugin) getBridgeBotUserID() (string, error) {
+	if p.matrixClient == nil {
+		return "", errors.New("matrix client not configured")
+	}
+	return p.matrixClient.GetBridgeBotUserID()
+}
+
+// handleBridgeBotEvent handles events addressed to the bridge bot: invites of the bot and !mm commands.
+// Returns true if the event was consumed and should not be bridged.
+func (p *Plugin) handleBridgeBotEvent(event MatrixEvent) (bool, error) {
+	if p.matrixClient == nil || p.isGhostUser(event.Sender) {
+		return false, nil
+	}
+
+	switch event.Type {
+	case "m.room.member":
+		return p.handleBridgeBotInvite(event)
+	case "m.room.message":
+		msgType, _ := event.Content["msgtype"].(string)
+		if msgType != "m.text" {
+			return false, nil
+		}
+
+		// Edits of earlier messages are never treated as commands
+		if relatesTo, ok := event.Content["m.relates_to"].(map[string]any); ok {
+			if relType, _ := relatesTo["rel_type"].(string); relType == "m.replace" {
+				return false, nil
+			}
+		}
+
+		body, _ := event.Content["body"].(string)
+		cmd, ok := parseBridgeBotCommand(body)
+		if !ok {
+			return false, nil
+		}
+
+		// Commands count against the inbound rate limits like any other message, so they can't be used to
+		// flood the bridge. Bot DMs aren't mapped to a channel.
+		channelID, _ := p.kvstore.Get(kvstore.BuildRoomMappingKey(event.RoomID))
+		if !p.allowInboundEvent(event, string(channelID)) {
+			return true, nil
+		}
+
+		return true, p.executeBridgeBotCommand(event, cmd)
+	default:
+		return false, nil
+	}
+}
+
+// handleBridgeBotInvite joins the bridge bot to rooms it is invited to, so users can talk to it in a DM
+func (p *Plugin) handleBridgeBotInvite(event MatrixEvent) (bool, error) {
+	if event.StateKey == nil {
+		return false, nil
+	}
+
+	membership, _ := event.Content["membership"].(string)
+	if membership != "invite" {
+		return false, nil
+	}
+
+	botUserID, err := p.getBridgeBotUserID()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get bridge bot user ID")
+	}
+
+	if *event.StateKey != botUserID {
+		return false, nil
+	}
+
+	if err := p.matrixClient.JoinRoom(event.RoomID); err != nil {
+		return true, errors.Wrap(err, "failed to join bridge bot to room")
+	}
+
+	p.logger.LogInfo("Bridge bot joined room after invite", "room_id", event.RoomID, "inviter", event.Sender)
+	return true, nil
+}
+
+// executeBridgeBotCommand runs a bridge bot command and replies in the room
+func (p *Plugin) executeBridgeBotCommand(event MatrixEvent, cmd *bridgeBotCommand) error {
+	p.logger.LogDebug("Executing bridge bot command", "command", cmd.Name, "sender", event.Sender, "room_id", event.RoomID)
+
+	var response string
+	switch cmd.Name {
+	case "help":
+		response = bridgeBotHelpText
+	case "ping":
+		response = p.executeBridgeBotPing(event)
+	case "status":
+		response = p.executeBridgeBotStatus(event)
+	case "whois":
+		response = p.executeBridgeBotWhois(cmd.Args)
+	case "invite":
+		response = p.executeBridgeBotInvite(event, cmd.Args)
+	default:
+		response = fmt.Sprintf("❌ Unknown command `%s`. Send `!mm help` for a list of commands.", cmd.Name)
+	}
+
+	return p.replyAsBridgeBot(event.RoomID, response)
+}
+
+// executeBridgeBotPing reports the delay between the command being sent and the bridge receiving it
+func (p *Plugin) executeBridgeBotPing(event MatrixEvent) string {
+	if event.Timestamp <= 0 {
+		return "🏓 Pong!"
+	}
+
+	delay := time.Since(time.UnixMilli(event.Timestamp))
+	if delay < 0 {
+		delay = 0
+	}
+	return fmt.Sprintf("🏓 Pong! The bridge received your message after %d ms.", delay.Milliseconds())
+}
+
+// executeBridgeBotStatus reports the bridge state for the room and the sender
+func (p *Plugin) executeBridgeBotStatus(event MatrixEvent) string {
+	var lines []string
+	lines = append(lines, "**Bridge status**")
+
+	config := p.getConfiguration()
+	if config.EnableSync {
+		lines = append(lines, "- Sync: ✅ enabled")
+	} else {
+		lines = append(lines, "- Sync: ❌ disabled")
+	}
+
+	channelID, err := p.getChannelIDFromMatrixRoom(event.RoomID)
+	switch {
+	case err != nil:
+		lines = append(lines, "- Room: ⚠️ failed to look up the bridged channel")
+	case channelID == "":
+		lines = append(lines, "- Room: not bridged to Mattermost")
+	default:
+		lines = append(lines, "- Room: "+p.describeBridgedChannel(channelID))
+	}
+
+	if mattermostUserID := p.getMattermostUserIDForMatrixUser(event.Sender); mattermostUserID != "" {
+		if user, appErr := p.API.GetUser(mattermostUserID); appErr == nil {
+			lines = append(lines, fmt.Sprintf("- You appear in Mattermost as `@%s`", user.Username))
+		}
+	} else {
+		lines = append(lines, "- You have not been bridged to Mattermost yet")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// describeBridgedChannel returns a short description of a bridged Mattermost channel
+func (p *Plugin) describeBridgedChannel(channelID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return fmt.Sprintf("bridged to Mattermost channel `%s`", channelID)
+	}
+
+	if channel.IsGroupOrDirect() {
+		return "bridged to a Mattermost direct message"
+	}
+
+	team, appErr := p.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		return fmt.Sprintf("bridged to Mattermost channel ~%s", channel.Name)
+	}
+
+	return fmt.Sprintf("bridged to Mattermost channel ~%s in team **%s**", channel.Name, team.DisplayName)
+}
+
+// executeBridgeBotWhois describes a Matrix or Mattermost user
+func (p *Plugin) executeBridgeBotWhois(args []string) string {
+	if len(args) != 1 {
+		return "❌ Usage: `!mm whois <@user:server|mattermost-username>`"
+	}
+	target := args[0]
+
+	// Matrix user ID: either one of our ghost users or a Matrix user bridged into Mattermost
+	if strings.HasPrefix(target, "@") && strings.Contains(target, ":") {
+		if p.isGhostUser(target) {
+			mattermostUserID := p.extractMattermostUserIDFromGhost(target)
+			user, appErr := p.API.GetUser(mattermostUserID)
+			if appErr != nil {
+				return fmt.Sprintf("⚠️ `%s` is a bridge user, but its Mattermost account could not be found.", target)
+			}
+			return fmt.Sprintf("`%s` is Mattermost user `@%s` (%s).", target, user.Username, user.GetDisplayName(model.ShowFullName))
+		}
+
+		mattermostUserID := p.getMattermostUserIDForMatrixUser(target)
+		if mattermostUserID == "" {
+			return fmt.Sprintf("`%s` has not been bridged to Mattermost.", target)
+		}
+		user, appErr := p.API.GetUser(mattermostUserID)
+		if appErr != nil {
+			return fmt.Sprintf("⚠️ `%s` is bridged, but its Mattermost account could not be found.", target)
+		}
+		return fmt.Sprintf("`%s` appears in Mattermost as `@%s`.", target, user.Username)
+	}
+
+	// Otherwise treat the argument as a Mattermost username
+	username := strings.TrimPrefix(target, "@")
+	user, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil {
+		return fmt.Sprintf("❌ No Mattermost user named `%s`.", username)
+	}
+
+	if user.IsRemote() {
+		if matrixUserID, err := p.GetMatrixUserIDFromMattermostUser(user.Id); err == nil {
+			return fmt.Sprintf("Mattermost user `@%s` is Matrix user `%s`.", user.Username, matrixUserID)
+		}
+		return fmt.Sprintf("Mattermost user `@%s` is a remote user.", user.Username)
+	}
+
+	if matrixUserID := p.matrixToMattermostBridge.linkedMatrixUserID(user.Id); matrixUserID != "" {
+		return fmt.Sprintf("Mattermost user `@%s` (%s) is Matrix user `%s`.", user.Username, user.GetDisplayName(model.ShowFullName), matrixUserID)
+	}
+
+	ghostUserID, exists := p.getGhostUser(user.Id)
+	if !exists {
+		return fmt.Sprintf("Mattermost user `@%s` (%s) has not been bridged to Matrix yet.", user.Username, user.GetDisplayName(model.ShowFullName))
+	}
+	return fmt.Sprintf("Mattermost user `@%s` (%s) appears in Matrix as `%s`.", user.Username, user.GetDisplayName(model.ShowFullName), ghostUserID)
+}
+
+// executeBridgeBotInvite adds a Mattermost user to the channel bridged with the room.
+// The sender needs the room's invite power level.
+func (p *Plugin) executeBridgeBotInvite(event MatrixEvent, args []string) string {
+	if len(args) != 1 {
+		return "❌ Usage: `!mm invite <mattermost-username>`"
+	}
+
+	channelID, err := p.getChannelIDFromMatrixRoom(event.RoomID)
+	if err != nil || channelID == "" {
+		return "❌ This room is not bridged to a Mattermost channel."
+	}
+
+	powerLevels, err := p.matrixClient.GetRoomPowerLevels(event.RoomID)
+	if err != nil {
+		p.logger.LogWarn("Failed to get room power levels for bridge bot invite", "error", err, "room_id", event.RoomID)
+		return "❌ Could not check your permissions in this room."
+	}
+	if powerLevels.UserLevel(event.Sender) < powerLevels.InviteLevel() {
+		return fmt.Sprintf("❌ You need power level %d to invite users to this room.", powerLevels.InviteLevel())
+	}
+
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return "❌ The bridged Mattermost channel could not be found."
+	}
+	if channel.IsGroupOrDirect() {
+		return "❌ Users cannot be invited to a direct message."
+	}
+
+	username := strings.TrimPrefix(args[0], "@")
+	user, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil {
+		return fmt.Sprintf("❌ No Mattermost user named `%s`.", username)
+	}
+	if user.IsRemote() || user.DeleteAt != 0 {
+		return fmt.Sprintf("❌ `@%s` cannot be invited.", user.Username)
+	}
+
+	if _, appErr := p.API.GetTeamMember(channel.TeamId, user.Id); appErr != nil {
+		return fmt.Sprintf("❌ `@%s` is not a member of the channel's team.", user.Username)
+	}
+
+	if _, appErr := p.API.AddChannelMember(channelID, user.Id); appErr != nil {
+		p.logger.LogWarn("Failed to add user to channel for bridge bot invite", "error", appErr, "user_id", user.Id, "channel_id", channelID)
+		return fmt.Sprintf("❌ Failed to add `@%s` to the channel.", user.Username)
+	}
+
+	// Make sure the user's ghost is in the room right away rather than on their first post, unless the bridge
+	// bot relays the channel's messages
+	if p.RelaysToMatrix(channelID) {
+		p.logger.LogDebug("Skipping ghost user join for relayed channel", "user_id", user.Id, "channel_id", channelID)
+	} else if ghostUserID, err := p.mattermostToMatrixBridge.CreateOrGetGhostUser(user.Id); err != nil {
+		p.logger.LogWarn("Failed to create ghost user for bridge bot invite", "error", err, "user_id", user.Id)
+	} else if err := p.mattermostToMatrixBridge.ensureGhostUserInRoom(ghostUserID, event.RoomID, user.Id); err != nil {
+		p.logger.LogWarn("Failed to join ghost user to room for bridge bot invite", "error", err, "user_id", user.Id, "room_id", event.RoomID)
+	}
+
+	p.logger.LogInfo("Bridge bot invited Mattermost user", "user_id", user.Id, "channel_id", channelID, "room_id", event.RoomID, "invited_by", event.Sender)
+	return fmt.Sprintf("✅ Added `@%s` to ~%s.", user.Username, channel.Name)
+}
+
+// getMattermostUserIDForMatrixUser returns the Mattermost user mapped to a Matrix user, if any
+func (p *Plugin) getMattermostUserIDForMatrixUser(matrixUserID string) string {
+	userIDBytes, err := p.kvstore.Get(kvstore.BuildMatrixUserKey(matrixUserID))
+	if err != nil || len(userIDBytes) == 0 {
+		return ""
+	}
+	return string(userIDBytes)
+}
+
+// replyAsBridgeBot sends a notice from the bridge bot, joining the room first if necessary
+func (p *Plugin) replyAsBridgeBot(roomID, markdown string) error {
+	botUserID, err := p.getBridgeBotUserID()
+	if err != nil {
+		return errors.Wrap(err, "failed to get bridge bot user ID")
+	}
+
+	plainText, htmlContent := convertMattermostToMatrix(markdown)
+
+	if _, err = p.matrixClient.SendNotice(roomID, botUserID, plainText, htmlContent); err == nil {
+		return nil
+	}
+
+	p.logger.LogDebug("Bridge bot reply failed, joining room and retrying", "error", err, "room_id", roomID)
+	if joinErr := p.matrixClient.JoinRoom(roomID); joinErr != nil {
+		return errors.Wrap(err, "failed to send bridge bot reply")
+	}
+
+	if _, err = p.matrixClient.SendNotice(roomID, botUserID, plainText, htmlContent); err != nil {
+		return errors.Wrap(err, "failed to send bridge bot reply")
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBridgeBotCommand(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		expectedOK   bool
+		expectedName string
+		expectedArgs []string
+	}{
+		{name: "plain message", body: "hello world", expectedOK: false},
+		{name: "empty message", body: "", expectedOK: false},
+		{name: "prefix inside word", body: "!mmm help", expectedOK: false},
+		{name: "bare prefix shows help", body: "!mm", expectedOK: true, expectedName: "help", expectedArgs: nil},
+		{name: "command without args", body: "!mm ping", expectedOK: true, expectedName: "ping", expectedArgs: []string{}},
+		{name: "command with args", body: "  !mm whois @alice:example.com ", expectedOK: true, expectedName: "whois", expectedArgs: []string{"@alice:example.com"}},
+		{name: "case insensitive", body: "!MM Status", expectedOK: true, expectedName: "status", expectedArgs: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, ok := parseBridgeBotCommand(tt.body)
+			assert.Equal(t, tt.expectedOK, ok)
+			if !tt.expectedOK {
+				assert.Nil(t, cmd)
+				return
+			}
+			assert.Equal(t, tt.expectedName, cmd.Name)
+			assert.Equal(t, tt.expectedArgs, cmd.Args)
+		})
+	}
+}
+
+// fakeBridgeBotHomeserver records notices sent by the bridge bot
+type fakeBridgeBotHomeserver struct {
+	mu          sync.Mutex
+	notices     []map[string]any
+	senders     []string
+	powerLevels map[string]any
+}
+
+func (f *fakeBridgeBotHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/state/m.room.power_levels/"):
+		_ = json.NewEncoder(w).Encode(f.powerLevels)
+	case strings.Contains(r.URL.Path, "/send/m.room.message/"):
+		var content map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&content)
+		f.notices = append(f.notices, content)
+		f.senders = append(f.senders, r.URL.Query().Get("user_id"))
+		_, _ = w.Write([]byte(`{"event_id":"$reply"}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func setupBridgeBotTest(t *testing.T) (*Plugin, *fakeBridgeBotHomeserver) {
+	homeserver := &fakeBridgeBotHomeserver{}
+	plugin, _ := setupPluginWithHomeserver(t, homeserver)
+	plugin.configuration = &configuration{MatrixServerURL: "https://test.com", EnableSync: true}
+	return plugin, homeserver
+}
+
+func TestHandleBridgeBotEvent(t *testing.T) {
+	t.Run("non-command messages are not consumed", func(t *testing.T) {
+		plugin, homeserver := setupBridgeBotTest(t)
+
+		handled, err := plugin.handleBridgeBotEvent(MatrixEvent{
+			Type:    "m.room.message",
+			Sender:  "@alice:test.com",
+			RoomID:  "!room:test.com",
+			Content: map[string]any{"msgtype": "m.text", "body": "hello"},
+		})
+		require.NoError(t, err)
+		assert.False(t, handled)
+		assert.Empty(t, homeserver.notices)
+	})
+
+	t.Run("ping replies with a notice from the bridge bot", func(t *testing.T) {
+		plugin, homeserver := setupBridgeBotTest(t)
+
+		handled, err := plugin.handleBridgeBotEvent(MatrixEvent{
+			Type:    "m.room.message",
+			Sender:  "@alice:test.com",
+			RoomID:  "!room:test.com",
+			Content: map[string]any{"msgtype": "m.text", "body": "!mm ping"},
+		})
+		require.NoError(t, err)
+		assert.True(t, handled)
+		require.Len(t, homeserver.notices, 1)
+		assert.Equal(t, "m.notice", homeserver.notices[0]["msgtype"])
+		assert.Contains(t, homeserver.notices[0]["body"], "Pong")
+		assert.Equal(t, "@_mattermost_bridge:test.com", homeserver.senders[0])
+	})
+
+	t.Run("commands over the inbound rate limit are dropped", func(t *testing.T) {
+		plugin, homeserver := setupBridgeBotTest(t)
+		plugin.configuration.InboundSenderRateLimit = 1
+		plugin.inboundLimiter = newInboundLimiter()
+
+		ping := MatrixEvent{
+			Type:    "m.room.message",
+			Sender:  "@alice:test.com",
+			RoomID:  "!dm:test.com",
+			Content: map[string]any{"msgtype": "m.text", "body": "!mm ping"},
+		}
+		for range 3 {
+			handled, err := plugin.handleBridgeBotEvent(ping)
+			require.NoError(t, err)
+			assert.True(t, handled)
+		}
+		assert.Len(t, homeserver.notices, 1)
+	})
+
+	t.Run("edits are not treated as commands", func(t *testing.T) {
+		plugin, homeserver := setupBridgeBotTest(t)
+
+		handled, err := plugin.handleBridgeBotEvent(MatrixEvent{
+			Type:   "m.room.message",
+			Sender: "@alice:test.com",
+			RoomID: "!room:test.com",
+			Content: map[string]any{
+				"msgtype":      "m.text",
+				"body":         "!mm ping",
+				"m.relates_to": map[string]any{"rel_type": "m.replace", "event_id": "$original"},
+			},
+		})
+		require.NoError(t, err)
+		assert.False(t, handled)
+		assert.Empty(t, homeserver.notices)
+	})
+
+	t.Run("invite requires the room's invite power level", func(t *testing.T) {
+		plugin, homeserver := setupBridgeBotTest(t)
+		homeserver.powerLevels = map[string]any{
+			"users":  map[string]any{"@admin:test.com": 100},
+			"invite": 50,
+		}
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildRoomMappingKey("!room:test.com"), []byte("channel_id")))
+
+		handled, err := plugin.handleBridgeBotEvent(MatrixEvent{
+			Type:    "m.room.message",
+			Sender:  "@alice:test.com",
+			RoomID:  "!room:test.com",
+			Content: map[string]any{"msgtype": "m.text", "body": "!mm invite bob"},
+		})
+		require.NoError(t, err)
+		assert.True(t, handled)
+		require.Len(t, homeserver.notices, 1)
+		assert.Contains(t, homeserver.notices[0]["body"], "power level 50")
+	})
+
+	t.Run("invite outside a bridged room is rejected", func(t *testing.T) {
+		plugin, homeserver := setupBridgeBotTest(t)
+
+		handled, err := plugin.handleBridgeBotEvent(MatrixEvent{
+			Type:    "m.room.message",
+			Sender:  "@alice:test.com",
+			RoomID:  "!dm:test.com",
+			Content: map[string]any{"msgtype": "m.text", "body": "!mm invite bob"},
+		})
+		require.NoError(t, err)
+		assert.True(t, handled)
+		require.Len(t, homeserver.notices, 1)
+		assert.Contains(t, homeserver.notices[0]["body"], "not bridged")
+	})
+}
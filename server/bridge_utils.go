@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"regexp"
 	"strings"
 
@@ -40,6 +41,7 @@ type BridgeUtilsConfig struct {
 	MaxProfileImageSize int64
 	MaxFileSize         int64
 	ConfigGetter        ConfigurationGetter
+	FilterMetrics       *contentFilterMetrics
 }
 
 // BridgeUtils contains common utilities used by both bridge types
@@ -52,6 +54,9 @@ type BridgeUtils struct {
 	maxProfileImageSize int64
 	maxFileSize         int64
 	configGetter        ConfigurationGetter
+	filterMetrics       *contentFilterMetrics
+	mediaCache          *mediaCache
+	puppets             *puppetStore
 }
 
 // NewBridgeUtils creates a new BridgeUtils instance
@@ -65,6 +70,9 @@ func NewBridgeUtils(config BridgeUtilsConfig) *BridgeUtils {
 		maxProfileImageSize: config.MaxProfileImageSize,
 		maxFileSize:         config.MaxFileSize,
 		configGetter:        config.ConfigGetter,
+		filterMetrics:       config.FilterMetrics,
+		mediaCache:          newMediaCache(config.KVStore, config.Logger),
+		puppets:             newPuppetStore(config.KVStore, config.Logger, config.ConfigGetter),
 	}
 }
 
@@ -281,6 +289,10 @@ func (s *BridgeUtils) getMattermostUsernameFromMatrix(matrixUserID string) strin
 	if ghostMattermostUserID := s.extractMattermostUserIDFromGhost(matrixUserID); ghostMattermostUserID != "" {
 		s.logger.LogDebug("Found ghost user for mention", "matrix_user_id", matrixUserID, "mattermost_user_id", ghostMattermostUserID)
 		mattermostUserID = ghostMattermostUserID
+	} else if linkedUserID := s.puppets.mattermostUserID(matrixUserID); linkedUserID != "" {
+		// Matrix accounts linked for double puppeting stand for the Mattermost user who linked them
+		s.logger.LogDebug("Found linked Matrix account for mention", "matrix_user_id", matrixUserID, "mattermost_user_id", linkedUserID)
+		mattermostUserID = linkedUserID
 	} else {
 		// Check if we have a mapping for this regular Matrix user
 		userMapKey := "matrix_user_" + matrixUserID
@@ -367,12 +379,51 @@ func (s *BridgeUtils) convertHTMLToMarkdownWithMentions(htmlContent string, even
 	return convertHTMLToMarkdown(s.logger, processedHTML)
 }
 
-func (s *BridgeUtils) downloadMatrixFile(mxcURL string) ([]byte, error) {
-	data, err := s.matrixClient.DownloadFile(mxcURL, s.maxFileSize, "")
+// copyMatrixFileToMattermost streams a Matrix file into the Mattermost file store through an upload session,
+// so only a buffer's worth is ever held in memory. Files whose size the media server doesn't announce can't
+// use an upload session and are read into memory, still no further than maxSize. Files over maxSize fail with
+// matrix.ErrFileTooLarge.
+func (s *BridgeUtils) copyMatrixFileToMattermost(mxcURL, channelID, userID, filename string, maxSize int64) (*model.FileInfo, error) {
+	download, err := s.matrixClient.DownloadFileStream(mxcURL, maxSize, "")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to download Matrix media")
 	}
-	return data, nil
+	defer func() { _ = download.Body.Close() }()
+
+	if download.Size <= 0 {
+		data, err := io.ReadAll(download.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to download Matrix media")
+		}
+		fileInfo, appErr := s.API.UploadFile(data, channelID, filename)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to upload file to Mattermost")
+		}
+		return fileInfo, nil
+	}
+
+	session, err := s.API.CreateUploadSession(&model.UploadSession{
+		Id:        model.NewId(),
+		Type:      model.UploadTypeAttachment,
+		CreateAt:  model.GetMillis(),
+		UserId:    userID,
+		ChannelId: channelID,
+		Filename:  filename,
+		FileSize:  download.Size,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Mattermost upload session")
+	}
+
+	fileInfo, err := s.API.UploadData(session, download.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to upload file to Mattermost")
+	}
+	if fileInfo == nil {
+		// The media server sent less than it announced
+		return nil, errors.Errorf("upload ended after %d of %d bytes", session.FileOffset, download.Size)
+	}
+	return fileInfo, nil
 }
 
 func (s *BridgeUtils) isGhostUser(matrixUserID string) bool {
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExtractMatrixMessageContent(t *testing.T) {
@@ -690,3 +695,53 @@ func TestReplaceMatrixMentionHTML(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyMatrixFileToMattermost(t *testing.T) {
+	setup := func(t *testing.T, handler http.HandlerFunc) (*Plugin, *plugintest.API) {
+		plugin, api := setupPluginWithHomeserver(t, handler)
+		plugin.maxFileSize = 16
+		plugin.initBridges()
+		return plugin, api
+	}
+	channelID, userID := model.NewId(), model.NewId()
+
+	t.Run("files of known size stream through an upload session", func(t *testing.T) {
+		plugin, api := setup(t, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("report"))
+		})
+		api.On("CreateUploadSession", mock.MatchedBy(func(us *model.UploadSession) bool {
+			return us.ChannelId == channelID && us.UserId == userID && us.Filename == "report.txt" && us.FileSize == 6
+		})).Return(func(us *model.UploadSession) (*model.UploadSession, error) { return us, nil })
+		api.On("UploadData", mock.Anything, mock.Anything).Return(func(_ *model.UploadSession, r io.Reader) (*model.FileInfo, error) {
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "report", string(data))
+			return &model.FileInfo{Id: "file-id"}, nil
+		})
+
+		fileInfo, err := plugin.matrixToMattermostBridge.copyMatrixFileToMattermost("mxc://test.com/media", channelID, userID, "report.txt", plugin.maxFileSize)
+		require.NoError(t, err)
+		assert.Equal(t, "file-id", fileInfo.Id)
+	})
+
+	t.Run("files over the limit are never uploaded", func(t *testing.T) {
+		// Uploading would call the unmocked plugin API
+		plugin, _ := setup(t, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(strings.Repeat("x", 32)))
+		})
+
+		_, err := plugin.matrixToMattermostBridge.copyMatrixFileToMattermost("mxc://test.com/media", channelID, userID, "big.bin", plugin.maxFileSize)
+		assert.ErrorIs(t, err, matrix.ErrFileTooLarge)
+	})
+
+	t.Run("incomplete uploads are errors", func(t *testing.T) {
+		plugin, api := setup(t, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("report"))
+		})
+		api.On("CreateUploadSession", mock.Anything).Return(func(us *model.UploadSession) (*model.UploadSession, error) { return us, nil })
+		api.On("UploadData", mock.Anything, mock.Anything).Return(nil, nil)
+
+		_, err := plugin.matrixToMattermostBridge.copyMatrixFileToMattermost("mxc://test.com/media", channelID, userID, "report.txt", plugin.maxFileSize)
+		assert.Error(t, err)
+	})
+}
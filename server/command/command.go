@@ -2,7 +2,9 @@
 package command
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
@@ -29,6 +31,109 @@ type MigrationResult struct {
 	ReverseDMMappingsCreated int
 }
 
+// DirectMessageResult describes a direct message opened with a Matrix user
+type DirectMessageResult struct {
+	ChannelID    string // Mattermost DM channel ID
+	RoomID       string // Matrix DM room ID
+	UserID       string // Mattermost user ID of the Matrix user
+	Username     string // Mattermost username of the Matrix user
+	RoomExisting bool   // True if the DM was already bridged to a Matrix room
+}
+
+// MatrixInvite describes a Matrix user invited to a bridged channel from Mattermost
+type MatrixInvite struct {
+	MatrixUserID     string // Invited Matrix user ID
+	MattermostUserID string // Mattermost user ID created for the Matrix user
+	RoomID           string // Matrix room the user was invited to
+	InvitedBy        string // Mattermost user ID of the inviter
+	Status           string // invited, joined or declined
+	CreateAt         int64  // When the invite was first sent
+	UpdateAt         int64  // When the status last changed
+}
+
+// HomeserverContact records the last application service ping received from the homeserver
+type HomeserverContact struct {
+	Timestamp     int64  `json:"timestamp"`
+	TransactionID string `json:"transaction_id"`
+}
+
+// AutoBridgeResult lists the channels an auto-bridge run covered, as team/channel names
+type AutoBridgeResult struct {
+	Bridged        []string // Channels bridged (or that would be, in a preview)
+	AlreadyBridged []string // Matching channels that were already mapped
+	Failed         []string // Matching channels that could not be bridged
+	MissingTeams   []string // Policy teams that don't exist
+}
+
+// ErrAutoBridgeDisabled is returned when no auto-bridge policy is configured
+var ErrAutoBridgeDisabled = errors.New("auto-bridge policy not configured")
+
+// FederationDeactivationResult lists the Matrix users deactivated because the federation policy no longer
+// accepts their server
+type FederationDeactivationResult struct {
+	Deactivated []string // Matrix user IDs deactivated (or that would be, in a preview)
+	Failed      []string // Matrix user IDs that could not be deactivated
+}
+
+// ErrFederationPolicyDisabled is returned when no federation allow or deny list is configured
+var ErrFederationPolicyDisabled = errors.New("federation policy not configured")
+
+// Errors linking Matrix accounts for double puppeting
+var (
+	// ErrPuppetEncryptionKeyMissing is returned when no key is configured to encrypt linked accounts with
+	ErrPuppetEncryptionKeyMissing = errors.New("double puppeting encryption key not configured")
+	// ErrAppserviceLoginUnavailable is returned when the bridge can't log in for the user
+	ErrAppserviceLoginUnavailable = errors.New("application service login not available")
+	// ErrPuppetTokenInvalid is returned when the homeserver doesn't accept an access token
+	ErrPuppetTokenInvalid = errors.New("invalid Matrix access token")
+	// ErrPuppetAccountInUse is returned for Matrix accounts that can't be linked, such as the bridge's own users
+	// or accounts another Mattermost user linked
+	ErrPuppetAccountInUse = errors.New("matrix account can't be linked")
+	// ErrPuppetNotLinked is returned when unlinking a user who hasn't linked an account
+	ErrPuppetNotLinked = errors.New("no linked Matrix account")
+	// ErrPuppetAccountNotVerified is returned when logging in without a token before the user has proven which
+	// Matrix account is theirs
+	ErrPuppetAccountNotVerified = errors.New("no verified Matrix account to log in as")
+)
+
+// AccountLinkResult describes a Matrix account linked to a Mattermost user
+type AccountLinkResult struct {
+	MatrixUserID   string // Linked Matrix user ID
+	ShadowUsername string // Username of the Mattermost user the bridge had created for the Matrix account, if any
+	Merged         bool   // True if that user's teams and channels were merged into the linked user
+	MergedChannels int    // Channels the linked user was added to by the merge
+}
+
+// Errors linking Matrix accounts to existing Mattermost accounts
+var (
+	// ErrAccountLinkUnavailable is returned for Matrix accounts that can't be linked, such as the bridge's own
+	// users or accounts already linked to another Mattermost user
+	ErrAccountLinkUnavailable = errors.New("matrix account can't be linked")
+	// ErrAccountLinkNotPending is returned when confirming a link that wasn't requested or has expired
+	ErrAccountLinkNotPending = errors.New("no pending Matrix account link")
+	// ErrAccountLinkCodeInvalid is returned when a link code doesn't match the one sent to the Matrix account
+	ErrAccountLinkCodeInvalid = errors.New("invalid Matrix account link code")
+	// ErrAccountNotLinked is returned when unlinking a user who hasn't linked a Matrix account
+	ErrAccountNotLinked = errors.New("no Matrix account linked")
+)
+
+// Sync directions of a channel mapping
+const (
+	SyncDirectionBoth         = "both"          // Sync in both directions (the default)
+	SyncDirectionToMatrix     = "to-matrix"     // Only sync from Mattermost to Matrix
+	SyncDirectionToMattermost = "to-mattermost" // Only sync from Matrix to Mattermost
+)
+
+// IsValidSyncDirection reports whether a string is a known sync direction
+func IsValidSyncDirection(direction string) bool {
+	switch direction {
+	case SyncDirectionBoth, SyncDirectionToMatrix, SyncDirectionToMattermost:
+		return true
+	default:
+		return false
+	}
+}
+
 // PluginAccessor defines the interface for plugin functionality needed by command handlers
 type PluginAccessor interface {
 	// Matrix client access
@@ -46,6 +151,42 @@ type PluginAccessor interface {
 	// Matrix user mapping access
 	GetMatrixUserIDFromMattermostUser(mattermostUserID string) (string, error)
 
+	// Direct message access
+	CreateDirectMessageWithMatrixUser(mattermostUserID, matrixUserID string) (*DirectMessageResult, error)
+
+	// Matrix history access
+	BackfillMatrixRoom(channelID, roomID string, limit int) (int, error)
+
+	// Matrix invite access
+	InviteMatrixUserToChannel(channelID, matrixUserID, invitedBy string) (*MatrixInvite, error)
+	GetMatrixInvites(channelID string) ([]*MatrixInvite, error)
+
+	// Matrix space access
+	AddChannelToTeamSpace(channelID, roomID string) error
+	RemoveChannelFromTeamSpace(roomID string) error
+
+	// Auto-bridge access
+	AutoBridgeExistingChannels(userID string, preview bool) (*AutoBridgeResult, error)
+
+	// Sync direction access
+	GetSyncDirection(channelID string) string
+	SetSyncDirection(channelID, direction string) error
+
+	// Relay mode access
+	RelaysToMatrix(channelID string) bool
+
+	// Federation policy access
+	DeactivateDisallowedMatrixUsers(preview bool) (*FederationDeactivationResult, error)
+
+	// Double puppeting access
+	LoginMatrixPuppet(mattermostUserID, accessToken string) (string, error)
+	LogoutMatrixPuppet(mattermostUserID string) (string, error)
+
+	// Account linking access
+	RequestMatrixAccountLink(mattermostUserID, matrixUserID string) error
+	ConfirmMatrixAccountLink(mattermostUserID, code string, merge bool) (*AccountLinkResult, error)
+	UnlinkMatrixAccount(mattermostUserID string) (string, error)
+
 	// Mattermost API access
 	GetPluginAPI() plugin.API
 	GetPluginAPIClient() *pluginapi.Client
@@ -109,6 +250,9 @@ func (c *Handler) syncChannelMembersToMatrixRoom(channelID, roomID string) (int,
 
 	c.client.Log.Info("Starting to sync channel members to Matrix room", "channel_id", channelID, "room_id", roomID)
 
+	// In relay mode the bridge bot speaks for Mattermost users, so they don't get ghost users
+	relayed := c.plugin.RelaysToMatrix(channelID)
+
 	// Process channel members with pagination - combine fetching and processing for memory efficiency
 	for {
 		pageMembers, appErr := c.pluginAPI.GetChannelMembers(channelID, offset, limit)
@@ -145,7 +289,7 @@ func (c *Handler) syncChannelMembersToMatrixRoom(channelID, roomID string) (int,
 					c.client.Log.Debug("Successfully invited Matrix user to room", "matrix_user_id", originalMatrixUserID, "mattermost_user_id", user.Id, "username", user.Username, "room_id", roomID)
 					joinedCount++
 				}
-			} else {
+			} else if !relayed {
 				// This is a local Mattermost user - create ghost user and join to room
 				ghostUserID, err := c.plugin.CreateOrGetGhostUser(user.Id)
 				if err != nil {
@@ -193,27 +337,59 @@ const (
 	matrixCommandTrigger = "matrix"
 
 	// Main command usage
-	matrixCommandUsage = "Usage: /matrix [test|create|map|unmap|list|status|migrate] [room_name|room_alias|room_id]"
+	matrixCommandUsage = "Usage: /matrix [test|create|map|join|unmap|list|status|migrate|dm|invite|autobridge|federation|login|logout|link|unlink] [room_name|room_alias|room_id|matrix_user_id]"
 
 	// Subcommand descriptions for autocomplete
-	testCommandDesc    = "Test Matrix server connection and configuration"
-	createCommandDesc  = "Create a new Matrix room and map to current channel (uses channel name if room name not provided)"
-	createCommandHint  = "[room_name] [publish=true|false]"
-	mapCommandDesc     = "Map current channel to Matrix room (prefer #alias:server.com)"
-	mapCommandHint     = "[room_alias|room_id]"
-	unmapCommandDesc   = "Remove mapping between current channel and Matrix room, and uninvite plugin from shared channel"
-	unmapCommandHint   = ""
-	listCommandDesc    = "List all channel-to-room mappings"
-	statusCommandDesc  = "Show bridge status"
-	migrateCommandDesc = "Reset and re-run KV store migrations to fix missing room mappings"
+	testCommandDesc       = "Test Matrix server connection and configuration"
+	createCommandDesc     = "Create a new Matrix room and map to current channel (uses channel name if room name not provided)"
+	createCommandHint     = "[room_name] [publish=true|false]"
+	mapCommandDesc        = "Map current channel to Matrix room (prefer #alias:server.com)"
+	mapCommandHint        = "[room_alias|room_id] [direction=both|to-matrix|to-mattermost]"
+	joinCommandDesc       = "Create a new channel in this team from an existing Matrix room and map it"
+	joinCommandHint       = "[room_alias|room_id] [backfill=N]"
+	unmapCommandDesc      = "Remove mapping between current channel and Matrix room, and uninvite plugin from shared channel"
+	unmapCommandHint      = ""
+	listCommandDesc       = "List all channel-to-room mappings"
+	statusCommandDesc     = "Show bridge status"
+	migrateCommandDesc    = "Reset and re-run KV store migrations to fix missing room mappings"
+	dmCommandDesc         = "Start a direct message with a Matrix user"
+	dmCommandHint         = "[@user:server.com]"
+	inviteCommandDesc     = "Invite Matrix users to the Matrix room mapped to the current channel, or list invites"
+	inviteCommandHint     = "[@user:server.com ...]"
+	autoBridgeCommandDesc = "Bridge existing channels that match the auto-bridge policy (system admins only)"
+	autoBridgeCommandHint = "[preview]"
+	federationCommandDesc = "Deactivate users from Matrix servers the federation policy no longer allows (system admins only)"
+	federationCommandHint = "deactivate [preview]"
+	loginCommandDesc      = "Send your messages to Matrix as your own Matrix account instead of a bridged user"
+	loginCommandHint      = "[access_token]"
+	logoutCommandDesc     = "Stop sending your messages to Matrix as your own Matrix account"
+	linkCommandDesc       = "Link your Matrix account, so your Matrix messages appear in Mattermost as you"
+	linkCommandHint       = "[@user:server.com] | confirm [code] [merge]"
+	unlinkCommandDesc     = "Unlink your Matrix account from your Mattermost account"
 
 	// Map command usage and validation
-	mapCommandUsage     = "Usage: /matrix map [room_alias|room_id]\nExample: /matrix map #test-sync:synapse-mydomain.com"
+	mapCommandUsage = "Usage: /matrix map [room_alias|room_id] [direction=both|to-matrix|to-mattermost]\nExample: /matrix map #test-sync:synapse-mydomain.com direction=to-matrix"
+	// Join command usage
+	joinCommandUsage     = "Usage: /matrix join [room_alias|room_id] [backfill=N]\nExample: /matrix join #community:matrix.org backfill=50"
+	joinPermissionDenied = "❌ You don't have permission to create a channel of this type in this team."
+	// DM command usage and validation
+	dmCommandUsage      = "Usage: /matrix dm [@user:server.com]\nExample: /matrix dm @alice:matrix.org"
+	matrixUserIDError   = "Invalid Matrix user ID format. Use a full Matrix ID such as `@alice:matrix.org`."
 	roomIdentifierError = "Invalid room identifier format. Use either:\n• Room alias: `#roomname:server.com` (preferred for joining)\n• Room ID: `!roomid:server.com`"
+	// Invite command usage
+	inviteCommandUsage = "Usage: /matrix invite [@user:server.com ...]\nExample: /matrix invite @alice:matrix.org @bob:example.com\nRun without arguments to list invites for this channel."
+	// Auto-bridge command usage
+	autoBridgeCommandUsage = "Usage: /matrix autobridge [preview]\nRun with `preview` to list matching channels without bridging them."
+	// Federation command usage
+	federationCommandUsage = "Usage: /matrix federation deactivate [preview]\nRun with `preview` to list the users from disallowed servers without deactivating them."
+	// Login command usage
+	loginCommandUsage = "Usage: /matrix login [access_token]\nGive an access token from your Matrix client, or run without one if your Matrix account is on the bridge's homeserver and you've linked it with `/matrix link`."
+	// Link command usage
+	linkCommandUsage = "Usage: /matrix link [@user:server.com]\n       /matrix link confirm [code] [merge]\nThe bridge bot sends a code to your Matrix account, which you then confirm here. Add `merge` to move the bridged user's teams and channels to your account."
 
 	// Error messages
 	matrixClientNotConfigured = "❌ Matrix client not configured. Please configure Matrix settings in System Console."
-	unknownSubcommandError    = "Unknown subcommand. Use: test, create, map, unmap, list, status, or migrate"
+	unknownSubcommandError    = "Unknown subcommand. Use: test, create, map, join, unmap, list, status, migrate, dm, invite, autobridge, federation, login, logout, link, or unlink"
 
 	// Status messages
 	autoJoinSuccess     = "\n\n✅ **Auto-joined** Matrix room successfully!"
@@ -241,10 +417,19 @@ const (
 		"• `/matrix map [room_alias|room_id]` - Map current channel to existing Matrix room\n"
 
 	commandsHelp = "**Commands:**\n" +
-		"• `/matrix map [room_alias|room_id]` - Map current channel to Matrix room\n" +
+		"• `/matrix map [room_alias|room_id] [direction=...]` - Map current channel to Matrix room, optionally one-way\n" +
 		"• `/matrix create` - Create new Matrix room using channel name and map to current channel\n" +
 		"• `/matrix create [room_name]` - Create new Matrix room with custom name and map to current channel\n" +
-		"• `/matrix status` - Check bridge status\n"
+		"• `/matrix join [room_alias|room_id]` - Create a new channel from an existing Matrix room\n" +
+		"• `/matrix status` - Check bridge status\n" +
+		"• `/matrix dm [@user:server.com]` - Start a direct message with a Matrix user\n" +
+		"• `/matrix invite [@user:server.com ...]` - Invite Matrix users to the current channel's Matrix room\n" +
+		"• `/matrix autobridge [preview]` - Bridge existing channels that match the auto-bridge policy\n" +
+		"• `/matrix federation deactivate [preview]` - Deactivate users from Matrix servers that are no longer allowed\n" +
+		"• `/matrix login [access_token]` - Send your messages to Matrix as your own Matrix account\n" +
+		"• `/matrix logout` - Go back to sending your messages to Matrix as a bridged user\n" +
+		"• `/matrix link [@user:server.com]` - Link your Matrix account to your Mattermost account\n" +
+		"• `/matrix unlink` - Unlink your Matrix account\n"
 
 	// Status command response
 	statusCommandResponse = "Matrix Bridge Status:\n- Plugin: Active\n- Configuration: Check System Console → Plugins → Matrix Bridge\n- Logs: Check plugin logs for connection status"
@@ -255,13 +440,8 @@ const (
 		"   • The channel will be automatically configured for syncing\n"
 )
 
-// NewCommandHandler creates and registers all slash commands for the Matrix Bridge plugin.
-func NewCommandHandler(plugin PluginAccessor) Command {
-	// Cache frequently used services for reduced verbosity
-	client := plugin.GetPluginAPIClient()
-	kvstore := plugin.GetKVStore()
-	pluginAPI := plugin.GetPluginAPI()
-
+// getAutocompleteData builds the autocomplete tree for the /matrix command
+func getAutocompleteData() *model.AutocompleteData {
 	matrixData := model.NewAutocompleteData(matrixCommandTrigger, "[subcommand]", "Matrix bridge commands")
 	matrixData.AddCommand(model.NewAutocompleteData("test", "", testCommandDesc))
 
@@ -274,8 +454,15 @@ func NewCommandHandler(plugin PluginAccessor) Command {
 	// Map command with argument completion
 	mapCmd := model.NewAutocompleteData("map", mapCommandHint, mapCommandDesc)
 	mapCmd.AddTextArgument("Matrix room alias or room ID", "[room_alias|room_id]", "")
+	mapCmd.AddTextArgument("Optional sync direction (defaults to both)", "[direction=both|to-matrix|to-mattermost]", "")
 	matrixData.AddCommand(mapCmd)
 
+	// Join command with argument completion
+	joinCmd := model.NewAutocompleteData("join", joinCommandHint, joinCommandDesc)
+	joinCmd.AddTextArgument("Matrix room alias or room ID", "[room_alias|room_id]", "")
+	joinCmd.AddTextArgument("Optional number of recent messages to import", "[backfill=N]", "")
+	matrixData.AddCommand(joinCmd)
+
 	// Unmap command
 	matrixData.AddCommand(model.NewAutocompleteData("unmap", unmapCommandHint, unmapCommandDesc))
 
@@ -283,6 +470,56 @@ func NewCommandHandler(plugin PluginAccessor) Command {
 	matrixData.AddCommand(model.NewAutocompleteData("status", "", statusCommandDesc))
 	matrixData.AddCommand(model.NewAutocompleteData("migrate", "", migrateCommandDesc))
 
+	// DM command with argument completion
+	dmCmd := model.NewAutocompleteData("dm", dmCommandHint, dmCommandDesc)
+	dmCmd.AddTextArgument("Full Matrix user ID", "[@user:server.com]", "")
+	matrixData.AddCommand(dmCmd)
+
+	// Invite command with argument completion
+	inviteCmd := model.NewAutocompleteData("invite", inviteCommandHint, inviteCommandDesc)
+	inviteCmd.AddTextArgument("One or more full Matrix user IDs (omit to list invites)", "[@user:server.com ...]", "")
+	matrixData.AddCommand(inviteCmd)
+
+	// Auto-bridge command with argument completion
+	autoBridgeCmd := model.NewAutocompleteData("autobridge", autoBridgeCommandHint, autoBridgeCommandDesc)
+	autoBridgeCmd.AddStaticListArgument("Optional preview flag", false, []model.AutocompleteListItem{
+		{Item: "preview", HelpText: "List matching channels without bridging them"},
+	})
+	matrixData.AddCommand(autoBridgeCmd)
+
+	// Federation command with argument completion
+	federationCmd := model.NewAutocompleteData("federation", federationCommandHint, federationCommandDesc)
+	deactivateCmd := model.NewAutocompleteData("deactivate", "[preview]", "Deactivate users from disallowed Matrix servers")
+	deactivateCmd.AddStaticListArgument("Optional preview flag", false, []model.AutocompleteListItem{
+		{Item: "preview", HelpText: "List the users without deactivating them"},
+	})
+	federationCmd.AddCommand(deactivateCmd)
+	matrixData.AddCommand(federationCmd)
+
+	// Login command with argument completion
+	loginCmd := model.NewAutocompleteData("login", loginCommandHint, loginCommandDesc)
+	loginCmd.AddTextArgument("Optional access token of your Matrix account", "[access_token]", "")
+	matrixData.AddCommand(loginCmd)
+	matrixData.AddCommand(model.NewAutocompleteData("logout", "", logoutCommandDesc))
+
+	// Link command with argument completion
+	linkCmd := model.NewAutocompleteData("link", linkCommandHint, linkCommandDesc)
+	linkCmd.AddTextArgument("Your full Matrix user ID, or confirm and the code the bridge bot sent you", "[@user:server.com] | confirm [code] [merge]", "")
+	matrixData.AddCommand(linkCmd)
+	matrixData.AddCommand(model.NewAutocompleteData("unlink", "", unlinkCommandDesc))
+
+	return matrixData
+}
+
+// NewCommandHandler creates and registers all slash commands for the Matrix Bridge plugin.
+func NewCommandHandler(plugin PluginAccessor) Command {
+	// Cache frequently used services for reduced verbosity
+	client := plugin.GetPluginAPIClient()
+	kvstore := plugin.GetKVStore()
+	pluginAPI := plugin.GetPluginAPI()
+
+	matrixData := getAutocompleteData()
+
 	err := client.SlashCommand.Register(&model.Command{
 		Trigger:          matrixCommandTrigger,
 		AutoComplete:     true,
@@ -328,7 +565,7 @@ func (c *Handler) getMatrixClientOrError() (*matrix.Client, *model.CommandRespon
 	return matrixClient, nil
 }
 
-func (c *Handler) executeMapCommand(args *model.CommandArgs, roomIdentifier string) *model.CommandResponse {
+func (c *Handler) executeMapCommand(args *model.CommandArgs, roomIdentifier, direction string) *model.CommandResponse {
 	// Get current Matrix client and fail fast if not configured
 	matrixClient, errResponse := c.getMatrixClientOrError()
 	if errResponse != nil {
@@ -367,6 +604,9 @@ func (c *Handler) executeMapCommand(args *model.CommandArgs, roomIdentifier stri
 		if appErr != nil {
 			c.client.Log.Warn("Failed to get command issuer for ghost user join", "error", appErr, "user_id", args.UserId)
 			joinStatus = autoJoinSuccess
+		} else if c.plugin.RelaysToMatrix(args.ChannelId) {
+			// The bridge bot relays the channel's messages, so the issuer doesn't need a ghost user
+			joinStatus = autoJoinSuccess
 		} else {
 			// Create or get ghost user for the command issuer
 			ghostUserID, err := c.plugin.CreateOrGetGhostUser(user.Id)
@@ -399,6 +639,7 @@ func (c *Handler) executeMapCommand(args *model.CommandArgs, roomIdentifier stri
 
 	// Store reverse mapping: room_mapping_<roomIdentifier> -> channelID
 	roomMappingKey := kvstore.BuildRoomMappingKey(roomIdentifier)
+	mappingKeys := []string{mappingKey, roomMappingKey}
 	err = c.kvstore.Set(roomMappingKey, []byte(args.ChannelId))
 	if err != nil {
 		c.client.Log.Error("Failed to save room mapping", "error", err, "room_identifier", roomIdentifier, "channel_id", args.ChannelId)
@@ -409,13 +650,30 @@ func (c *Handler) executeMapCommand(args *model.CommandArgs, roomIdentifier stri
 	if strings.HasPrefix(roomIdentifier, "#") {
 		if resolvedRoomID, err := matrixClient.ResolveRoomAlias(roomIdentifier); err == nil {
 			roomIDMappingKey := kvstore.BuildRoomMappingKey(resolvedRoomID)
+			mappingKeys = append(mappingKeys, roomIDMappingKey)
 			if err := c.kvstore.Set(roomIDMappingKey, []byte(args.ChannelId)); err != nil {
 				c.client.Log.Error("Failed to save room ID mapping", "error", err, "room_id", resolvedRoomID, "channel_id", args.ChannelId)
 			}
 		}
 	}
 
-	c.client.Log.Info("Channel mapping saved", "channel_id", args.ChannelId, "channel_name", channelName, "room_identifier", roomIdentifier)
+	// The sync direction can only be stored for a mapped channel, and a mapping without the direction asked for
+	// would sync both ways, so the mapping is removed again if it can't be stored
+	if err := c.plugin.SetSyncDirection(args.ChannelId, direction); err != nil {
+		c.client.Log.Error("Failed to save sync direction", "error", err, "channel_id", args.ChannelId, "direction", direction)
+		for _, key := range mappingKeys {
+			if err := c.kvstore.Delete(key); err != nil {
+				c.client.Log.Warn("Failed to remove mapping after failed sync direction", "error", err, "key", key)
+			}
+		}
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("❌ Failed to set the sync direction to `%s`, so the mapping was not saved. Check plugin logs for details.%s", direction, joinStatus),
+		}
+	}
+	directionStatus := syncDirectionStatus(direction)
+
+	c.client.Log.Info("Channel mapping saved", "channel_id", args.ChannelId, "channel_name", channelName, "room_identifier", roomIdentifier, "direction", direction)
 
 	// Add bridge alias for Matrix Application Service filtering
 	// Extract room name from the identifier for the bridge alias
@@ -463,6 +721,11 @@ func (c *Handler) executeMapCommand(args *model.CommandArgs, roomIdentifier stri
 		roomID = resolvedRoomID
 	}
 
+	// List the room in the team's Matrix space
+	if err := c.plugin.AddChannelToTeamSpace(args.ChannelId, roomID); err != nil {
+		c.client.Log.Warn("Failed to add Matrix room to team space", "error", err, "room_id", roomID, "channel_id", args.ChannelId)
+	}
+
 	joinedCount, totalMembers, syncErr := c.syncChannelMembersToMatrixRoom(args.ChannelId, roomID)
 	if syncErr != nil {
 		c.client.Log.Error("Failed to sync channel members to Matrix room", "error", syncErr, "room_id", roomID, "channel_id", args.ChannelId)
@@ -485,8 +748,251 @@ func (c *Handler) executeMapCommand(args *model.CommandArgs, roomIdentifier stri
 
 	return &model.CommandResponse{
 		ResponseType: model.CommandResponseTypeEphemeral,
-		Text:         fmt.Sprintf("✅ **Mapping Saved**\n\n**Channel:** %s\n**Matrix Room:** `%s`%s%s%s", channelName, roomIdentifier, joinStatus, memberSyncStatus, shareStatus),
+		Text:         fmt.Sprintf("✅ **Mapping Saved**\n\n**Channel:** %s\n**Matrix Room:** `%s`%s%s%s%s", channelName, roomIdentifier, directionStatus, joinStatus, memberSyncStatus, shareStatus),
+	}
+}
+
+// maxBackfillLimit caps how many recent Matrix messages /matrix join imports
+const maxBackfillLimit = 100
+
+// executeJoinCommand creates a new channel in the current team from an existing Matrix room, maps the two
+// and optionally imports the room's recent history
+func (c *Handler) executeJoinCommand(args *model.CommandArgs, roomIdentifier string, backfillLimit int) *model.CommandResponse {
+	// Get current Matrix client and fail fast if not configured
+	matrixClient, errResponse := c.getMatrixClientOrError()
+	if errResponse != nil {
+		return errResponse
+	}
+
+	// Validate room identifier format (should start with ! or # and contain a colon)
+	if (!strings.HasPrefix(roomIdentifier, "!") && !strings.HasPrefix(roomIdentifier, "#")) || !strings.Contains(roomIdentifier, ":") {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         roomIdentifierError,
+		}
+	}
+
+	// The room's join rule is only known once the bridge has joined it, so refuse users who can't create
+	// any channel before touching Matrix and check the specific channel type afterwards
+	canCreatePublic := c.pluginAPI.HasPermissionToTeam(args.UserId, args.TeamId, model.PermissionCreatePublicChannel)
+	canCreatePrivate := c.pluginAPI.HasPermissionToTeam(args.UserId, args.TeamId, model.PermissionCreatePrivateChannel)
+	if !canCreatePublic && !canCreatePrivate {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         joinPermissionDenied,
+		}
+	}
+
+	roomID, err := matrixClient.ResolveRoomAlias(roomIdentifier)
+	if err != nil {
+		c.client.Log.Warn("Failed to resolve Matrix room for join", "error", err, "room_identifier", roomIdentifier)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("❌ Could not find Matrix room `%s`. Check the alias and that its server is reachable.", roomIdentifier),
+		}
+	}
+
+	// Refuse rooms that are already bridged to another channel
+	for _, identifier := range []string{roomIdentifier, roomID} {
+		if channelIDBytes, err := c.kvstore.Get(kvstore.BuildRoomMappingKey(identifier)); err == nil && len(channelIDBytes) > 0 {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         fmt.Sprintf("❌ Matrix room `%s` is already mapped to a Mattermost channel.", roomIdentifier),
+			}
+		}
+	}
+
+	// The bridge bot has to be in the room to read its state
+	if err := matrixClient.JoinRoom(roomID); err != nil {
+		c.client.Log.Warn("Failed to join Matrix room for join command", "error", err, "room_id", roomID)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("❌ The bridge could not join Matrix room `%s`. Invite the bridge bot to the room or make the room public, then try again.", roomIdentifier),
+		}
+	}
+
+	roomInfo, err := matrixClient.GetRoomInfo(roomID)
+	if err != nil {
+		c.client.Log.Error("Failed to read Matrix room state", "error", err, "room_id", roomID)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("❌ Failed to read the details of Matrix room `%s`. Check plugin logs for details.", roomIdentifier),
+		}
+	}
+
+	channelType := matrixRoomChannelType(roomInfo)
+	if (channelType == model.ChannelTypeOpen && !canCreatePublic) || (channelType == model.ChannelTypePrivate && !canCreatePrivate) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         joinPermissionDenied,
+		}
+	}
+
+	channel, err := c.createChannelForMatrixRoom(args, roomIdentifier, roomInfo)
+	if err != nil {
+		c.client.Log.Error("Failed to create channel for Matrix room", "error", err, "room_id", roomID, "team_id", args.TeamId)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "❌ Failed to create a channel for the Matrix room. Check plugin logs for details.",
+		}
+	}
+
+	// Map, sync members and share exactly as /matrix map would from inside the new channel
+	channelArgs := *args
+	channelArgs.ChannelId = channel.Id
+	mapResponse := c.executeMapCommand(&channelArgs, roomIdentifier, SyncDirectionBoth)
+
+	// A channel the room couldn't be mapped to would be left empty and unbridged, so remove it again
+	if mappedRoom, err := c.kvstore.Get(kvstore.BuildChannelMappingKey(channel.Id)); err != nil || len(mappedRoom) == 0 {
+		c.client.Log.Error("Failed to map Matrix room to new channel", "room_id", roomID, "channel_id", channel.Id)
+		if err := c.client.Channel.Delete(channel.Id); err != nil {
+			c.client.Log.Warn("Failed to delete channel after failed mapping", "error", err, "channel_id", channel.Id)
+		}
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("❌ Failed to map Matrix room `%s` to a new channel, so the channel was removed.\n\n%s", roomIdentifier, mapResponse.Text),
+		}
+	}
+
+	var backfillStatus string
+	if backfillLimit > 0 {
+		imported, err := c.plugin.BackfillMatrixRoom(channel.Id, roomID, backfillLimit)
+		if err != nil {
+			c.client.Log.Warn("Failed to backfill Matrix room history", "error", err, "room_id", roomID, "channel_id", channel.Id)
+			backfillStatus = "\n\n⚠️ **Note:** Failed to import recent Matrix history. Check plugin logs for details."
+		} else {
+			backfillStatus = fmt.Sprintf("\n\n✅ **Imported %d recent messages** from Matrix.", imported)
+		}
+	}
+
+	channelVisibility := "Public"
+	if channel.Type == model.ChannelTypePrivate {
+		channelVisibility = "Private"
+	}
+
+	response := &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         fmt.Sprintf("✅ **Channel Created**\n\n**Channel:** ~%s (%s)\n\n%s%s", channel.Name, channelVisibility, mapResponse.Text, backfillStatus),
 	}
+
+	// Take the user straight to the new channel
+	if team, appErr := c.client.Team.Get(args.TeamId); appErr == nil {
+		response.GotoLocation = fmt.Sprintf("%s/%s/channels/%s", args.SiteURL, team.Name, channel.Name)
+	}
+
+	return response
+}
+
+// matrixRoomChannelType returns the channel type a Matrix room is bridged as: public when anyone can join
+// the room and private otherwise
+func matrixRoomChannelType(roomInfo *matrix.RoomInfo) model.ChannelType {
+	if roomInfo.JoinRule == "public" {
+		return model.ChannelTypeOpen
+	}
+	return model.ChannelTypePrivate
+}
+
+// createChannelForMatrixRoom creates a channel named after a Matrix room, public when anyone can join the
+// room and private otherwise, and adds the command issuer to it
+func (c *Handler) createChannelForMatrixRoom(args *model.CommandArgs, roomIdentifier string, roomInfo *matrix.RoomInfo) (*model.Channel, error) {
+	displayName := roomInfo.Name
+	if displayName == "" {
+		displayName = roomAliasLocalpart(roomInfo.CanonicalAlias)
+	}
+	if displayName == "" {
+		displayName = roomAliasLocalpart(roomIdentifier)
+	}
+	if displayName == "" {
+		displayName = "Matrix Room"
+	}
+	displayName = truncateRunes(displayName, model.ChannelDisplayNameMaxRunes)
+
+	// Matrix rooms have no Mattermost equivalent for an avatar, so only the name and topic carry over
+	channel := &model.Channel{
+		TeamId:      args.TeamId,
+		Type:        matrixRoomChannelType(roomInfo),
+		Name:        c.uniqueChannelName(args.TeamId, channelNameFromRoom(displayName)),
+		DisplayName: displayName,
+		Header:      truncateRunes(roomInfo.Topic, model.ChannelHeaderMaxRunes),
+		Purpose:     truncateRunes(fmt.Sprintf("Bridged from Matrix room %s", roomIdentifier), model.ChannelPurposeMaxRunes),
+		CreatorId:   args.UserId,
+	}
+
+	// The channel is about to be mapped to this room, so keep the auto-bridge policy from creating another.
+	// The exemption has to exist before the channel does, since the creation hook consumes it.
+	skipKey := kvstore.BuildAutoBridgeSkipKey(channel.TeamId, channel.Name)
+	if err := c.kvstore.Set(skipKey, []byte(roomInfo.RoomID)); err != nil {
+		c.client.Log.Warn("Failed to exempt new channel from auto-bridging", "error", err, "channel_name", channel.Name)
+	}
+
+	if err := c.client.Channel.Create(channel); err != nil {
+		// A stale exemption would keep a later channel with this name from being auto-bridged
+		if deleteErr := c.kvstore.Delete(skipKey); deleteErr != nil {
+			c.client.Log.Warn("Failed to clear auto-bridge exemption", "error", deleteErr, "channel_name", channel.Name)
+		}
+		return nil, errors.Wrap(err, "failed to create channel")
+	}
+
+	if _, err := c.client.Channel.AddMember(channel.Id, args.UserId); err != nil {
+		c.client.Log.Warn("Failed to add command issuer to new channel", "error", err, "channel_id", channel.Id, "user_id", args.UserId)
+	}
+
+	c.client.Log.Info("Created channel for Matrix room", "channel_id", channel.Id, "channel_name", channel.Name, "room_id", roomInfo.RoomID, "type", channel.Type)
+	return channel, nil
+}
+
+// uniqueChannelName appends a numeric suffix to name until it doesn't clash with an existing channel in the team
+func (c *Handler) uniqueChannelName(teamID, name string) string {
+	candidate := name
+	for i := 2; ; i++ {
+		if _, appErr := c.pluginAPI.GetChannelByName(teamID, candidate, true); appErr != nil {
+			return candidate
+		}
+		suffix := "-" + strconv.Itoa(i)
+		candidate = strings.TrimRight(name[:min(len(name), model.ChannelNameMaxLength-len(suffix))], "-_") + suffix
+	}
+}
+
+// channelNameFromRoom converts a Matrix room name into a valid channel name (lowercase letters, digits and dashes)
+func channelNameFromRoom(roomName string) string {
+	var name strings.Builder
+	lastDash := true
+	for _, r := range strings.ToLower(roomName) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			name.WriteRune(r)
+			lastDash = false
+		} else if !lastDash {
+			name.WriteRune('-')
+			lastDash = true
+		}
+	}
+
+	result := strings.Trim(name.String(), "-")
+	if len(result) > model.ChannelNameMaxLength {
+		result = strings.TrimRight(result[:model.ChannelNameMaxLength], "-")
+	}
+	if result == "" {
+		return "matrix-room"
+	}
+	return result
+}
+
+// roomAliasLocalpart extracts the local part of a room alias (#name:server.com -> name)
+func roomAliasLocalpart(alias string) string {
+	if !strings.HasPrefix(alias, "#") {
+		return ""
+	}
+	localpart, _, _ := strings.Cut(alias[1:], ":")
+	return localpart
+}
+
+// truncateRunes shortens s to at most maxRunes runes
+func truncateRunes(s string, maxRunes int) string {
+	runes := []rune(s)
+	if len(runes) <= maxRunes {
+		return s
+	}
+	return string(runes[:maxRunes])
 }
 
 func (c *Handler) executeUnmapCommand(args *model.CommandArgs) *model.CommandResponse {
@@ -533,6 +1039,11 @@ func (c *Handler) executeUnmapCommand(args *model.CommandArgs) *model.CommandRes
 
 	c.client.Log.Info("Successfully cleared Matrix room state", "room_id", matrixRoomIdentifier)
 
+	// Take the room out of the team's Matrix space
+	if err := c.plugin.RemoveChannelFromTeamSpace(matrixRoomIdentifier); err != nil {
+		c.client.Log.Warn("Failed to remove Matrix room from team space", "error", err, "room_id", matrixRoomIdentifier, "channel_id", args.ChannelId)
+	}
+
 	// Remove the channel->room mapping
 	if err := c.kvstore.Delete(channelMappingKey); err != nil {
 		c.client.Log.Error("Failed to remove channel mapping", "error", err, "channel_id", args.ChannelId, "room_identifier", matrixRoomIdentifier)
@@ -549,6 +1060,21 @@ func (c *Handler) executeUnmapCommand(args *model.CommandArgs) *model.CommandRes
 		// Continue - the main mapping was removed
 	}
 
+	// Forget the sync direction, content filters, federation policy and relay setting so a later mapping starts
+	// afresh
+	if err := c.kvstore.Delete(kvstore.BuildSyncDirectionKey(args.ChannelId)); err != nil {
+		c.client.Log.Warn("Failed to remove sync direction", "error", err, "channel_id", args.ChannelId)
+	}
+	if err := c.kvstore.Delete(kvstore.BuildContentFilterKey(args.ChannelId)); err != nil {
+		c.client.Log.Warn("Failed to remove content filters", "error", err, "channel_id", args.ChannelId)
+	}
+	if err := c.kvstore.Delete(kvstore.BuildFederationPolicyKey(args.ChannelId)); err != nil {
+		c.client.Log.Warn("Failed to remove federation policy", "error", err, "channel_id", args.ChannelId)
+	}
+	if err := c.kvstore.Delete(kvstore.BuildRelayModeKey(args.ChannelId)); err != nil {
+		c.client.Log.Warn("Failed to remove relay setting", "error", err, "channel_id", args.ChannelId)
+	}
+
 	c.client.Log.Info("Removed Matrix room mapping", "channel_id", args.ChannelId, "room_identifier", matrixRoomIdentifier)
 
 	// Uninvite this plugin from the shared channel
@@ -647,6 +1173,11 @@ func (c *Handler) executeCreateRoomCommand(args *model.CommandArgs, roomName str
 		// Continue anyway - the forward mapping was saved successfully
 	}
 
+	// List the room in the team's Matrix space
+	if err := c.plugin.AddChannelToTeamSpace(args.ChannelId, roomID); err != nil {
+		c.client.Log.Warn("Failed to add Matrix room to team space", "error", err, "room_id", roomID, "channel_id", args.ChannelId)
+	}
+
 	// Share the channel and invite this plugin to receive sync messages
 	shareStatus := c.shareChannelAndInvitePlugin(args, channelName, topic)
 
@@ -721,7 +1252,7 @@ func (c *Handler) executeListMappingsCommand(args *model.CommandArgs) *model.Com
 					channelName = channel.Name
 				}
 			}
-			responseText.WriteString(fmt.Sprintf("**Current Channel:** %s → `%s`\n\n", channelName, currentChannelMapping))
+			responseText.WriteString(fmt.Sprintf("**Current Channel:** %s %s `%s`\n\n", channelName, syncDirectionArrow(c.plugin.GetSyncDirection(args.ChannelId)), currentChannelMapping))
 		}
 
 		// Show all mappings
@@ -743,7 +1274,7 @@ func (c *Handler) executeListMappingsCommand(args *model.CommandArgs) *model.Com
 				currentMarker = " *(current)*"
 			}
 
-			responseText.WriteString(fmt.Sprintf("• %s → `%s`%s\n", channelName, roomID, currentMarker))
+			responseText.WriteString(fmt.Sprintf("• %s %s `%s`%s\n", channelName, syncDirectionArrow(c.plugin.GetSyncDirection(channelID)), roomID, currentMarker))
 		}
 	}
 
@@ -756,6 +1287,30 @@ func (c *Handler) executeListMappingsCommand(args *model.CommandArgs) *model.Com
 	}
 }
 
+// syncDirectionArrow shows a mapping's sync direction between a channel and its room
+func syncDirectionArrow(direction string) string {
+	switch direction {
+	case SyncDirectionToMatrix:
+		return "→"
+	case SyncDirectionToMattermost:
+		return "←"
+	default:
+		return "↔"
+	}
+}
+
+// syncDirectionStatus describes a one-way mapping for the map command's response
+func syncDirectionStatus(direction string) string {
+	switch direction {
+	case SyncDirectionToMatrix:
+		return "\n**Direction:** Mattermost → Matrix only"
+	case SyncDirectionToMattermost:
+		return "\n**Direction:** Matrix → Mattermost only"
+	default:
+		return ""
+	}
+}
+
 func (c *Handler) executeMatrixCommand(args *model.CommandArgs) *model.CommandResponse {
 	fields := strings.Fields(args.Command)
 	if len(fields) < 2 {
@@ -821,14 +1376,45 @@ func (c *Handler) executeMatrixCommand(args *model.CommandArgs) *model.CommandRe
 
 		return c.executeCreateRoomCommand(args, roomName, publish)
 	case "map":
-		if len(fields) < 3 {
+		if len(fields) < 3 || len(fields) > 4 {
 			return &model.CommandResponse{
 				ResponseType: model.CommandResponseTypeEphemeral,
 				Text:         mapCommandUsage,
 			}
 		}
+		direction := SyncDirectionBoth
+		if len(fields) == 4 {
+			value, found := strings.CutPrefix(fields[3], "direction=")
+			if !found || !IsValidSyncDirection(value) {
+				return &model.CommandResponse{
+					ResponseType: model.CommandResponseTypeEphemeral,
+					Text:         mapCommandUsage,
+				}
+			}
+			direction = value
+		}
 		roomID := fields[2]
-		return c.executeMapCommand(args, roomID)
+		return c.executeMapCommand(args, roomID, direction)
+	case "join":
+		if len(fields) < 3 || len(fields) > 4 {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         joinCommandUsage,
+			}
+		}
+		backfillLimit := 0
+		if len(fields) == 4 {
+			value, found := strings.CutPrefix(fields[3], "backfill=")
+			limit, err := strconv.Atoi(value)
+			if !found || err != nil || limit < 0 {
+				return &model.CommandResponse{
+					ResponseType: model.CommandResponseTypeEphemeral,
+					Text:         joinCommandUsage,
+				}
+			}
+			backfillLimit = min(limit, maxBackfillLimit)
+		}
+		return c.executeJoinCommand(args, fields[2], backfillLimit)
 	case "unmap":
 		return c.executeUnmapCommand(args)
 	case "list":
@@ -840,6 +1426,60 @@ func (c *Handler) executeMatrixCommand(args *model.CommandArgs) *model.CommandRe
 		}
 	case "migrate":
 		return c.executeMigrateCommand(args)
+	case "dm":
+		if len(fields) != 3 {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         dmCommandUsage,
+			}
+		}
+		return c.executeDMCommand(args, fields[2])
+	case "invite":
+		return c.executeInviteCommand(args, fields[2:])
+	case "autobridge":
+		if len(fields) > 3 || (len(fields) == 3 && fields[2] != "preview") {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         autoBridgeCommandUsage,
+			}
+		}
+		return c.executeAutoBridgeCommand(args, len(fields) == 3)
+	case "federation":
+		if len(fields) < 3 || len(fields) > 4 || fields[2] != "deactivate" || (len(fields) == 4 && fields[3] != "preview") {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         federationCommandUsage,
+			}
+		}
+		return c.executeFederationDeactivateCommand(args, len(fields) == 4)
+	case "login":
+		if len(fields) > 3 {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         loginCommandUsage,
+			}
+		}
+		accessToken := ""
+		if len(fields) == 3 {
+			accessToken = fields[2]
+		}
+		return c.executeLoginCommand(args, accessToken)
+	case "logout":
+		return c.executeLogoutCommand(args)
+	case "link":
+		switch {
+		case len(fields) == 3 && fields[2] != "confirm":
+			return c.executeLinkCommand(args, fields[2])
+		case (len(fields) == 4 || (len(fields) == 5 && fields[4] == "merge")) && fields[2] == "confirm":
+			return c.executeLinkConfirmCommand(args, fields[3], len(fields) == 5)
+		default:
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         linkCommandUsage,
+			}
+		}
+	case "unlink":
+		return c.executeUnlinkCommand(args)
 	default:
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
@@ -848,40 +1488,43 @@ func (c *Handler) executeMatrixCommand(args *model.CommandArgs) *model.CommandRe
 	}
 }
 
-// shareChannelAndInvitePlugin shares a channel and invites this plugin to receive sync messages
-func (c *Handler) shareChannelAndInvitePlugin(args *model.CommandArgs, channelName, purpose string) string {
+// ShareChannelWithBridge shares a channel and invites the bridge's remote to it. The invitation is critical:
+// without it, the bridge doesn't receive the channel's sync events.
+func ShareChannelWithBridge(api plugin.API, remoteID, channelID, teamID, creatorID, displayName, purpose string) error {
 	sharedChannel := &model.SharedChannel{
-		ChannelId:        args.ChannelId,
-		TeamId:           args.TeamId,
+		ChannelId:        channelID,
+		TeamId:           teamID,
 		Home:             true,
 		ReadOnly:         false,
-		ShareName:        sanitizeShareName(channelName),
-		ShareDisplayName: channelName,
+		ShareName:        sanitizeShareName(displayName),
+		ShareDisplayName: displayName,
 		SharePurpose:     purpose,
 		ShareHeader:      "",
-		CreatorId:        args.UserId,
+		CreatorId:        creatorID,
 		CreateAt:         model.GetMillis(),
 		UpdateAt:         model.GetMillis(),
 		RemoteId:         "",
 	}
 
-	_, shareErr := c.pluginAPI.ShareChannel(sharedChannel)
-	if shareErr != nil {
-		c.client.Log.Warn("Failed to automatically share channel", "error", shareErr, "channel_id", args.ChannelId)
-		return channelSharingFailed
+	if _, err := api.ShareChannel(sharedChannel); err != nil {
+		return errors.Wrap(err, "failed to share channel")
 	}
 
-	c.client.Log.Info("Automatically shared channel", "channel_id", args.ChannelId)
+	if err := api.InviteRemoteToChannel(channelID, remoteID, creatorID, false); err != nil {
+		return errors.Wrap(err, "failed to invite bridge to shared channel")
+	}
+
+	return nil
+}
 
-	// Invite this plugin to the shared channel to ensure we receive sync messages
-	// This is critical - without this invitation, the channel won't receive sync events
-	inviteErr := c.pluginAPI.InviteRemoteToChannel(args.ChannelId, c.plugin.GetRemoteID(), args.UserId, false)
-	if inviteErr != nil {
-		c.client.Log.Error("Failed to invite plugin to shared channel - bridge will not receive sync events", "error", inviteErr, "channel_id", args.ChannelId, "remote_id", c.plugin.GetRemoteID())
+// shareChannelAndInvitePlugin shares a channel and invites this plugin to receive sync messages
+func (c *Handler) shareChannelAndInvitePlugin(args *model.CommandArgs, channelName, purpose string) string {
+	if err := ShareChannelWithBridge(c.pluginAPI, c.plugin.GetRemoteID(), args.ChannelId, args.TeamId, args.UserId, channelName, purpose); err != nil {
+		c.client.Log.Error("Failed to share channel with the bridge - bridge will not receive sync events", "error", err, "channel_id", args.ChannelId, "remote_id", c.plugin.GetRemoteID())
 		return channelSharingFailed
 	}
 
-	c.client.Log.Info("Successfully invited plugin to shared channel", "channel_id", args.ChannelId, "remote_id", c.plugin.GetRemoteID())
+	c.client.Log.Info("Successfully shared channel and invited plugin", "channel_id", args.ChannelId, "remote_id", c.plugin.GetRemoteID())
 	return channelSharingEnabled
 }
 
@@ -997,6 +1640,9 @@ func (c *Handler) executeTestCommand(_ *model.CommandArgs) *model.CommandRespons
 		responseText.WriteString("✅ **Application Service:** Permissions verified (can query namespace)\n")
 	}
 
+	// Ask the homeserver to ping us back to verify it can reach the bridge
+	responseText.WriteString(c.testHomeserverToBridge(matrixClient))
+
 	// Test shared channels registration
 	responseText.WriteString(testCommandNextSteps)
 
@@ -1006,6 +1652,41 @@ func (c *Handler) executeTestCommand(_ *model.CommandArgs) *model.CommandRespons
 	}
 }
 
+// testHomeserverToBridge triggers an application service ping round-trip and reports the result
+func (c *Handler) testHomeserverToBridge(matrixClient *matrix.Client) string {
+	transactionID := "mattermost-bridge-test-" + model.NewId()
+	roundTrip, err := matrixClient.PingApplicationService(transactionID)
+	if err != nil {
+		var matrixErr *matrix.Error
+		if errors.As(err, &matrixErr) && matrixErr.ErrCode == "M_UNRECOGNIZED" {
+			return "⚠️ **Homeserver → Bridge:** Homeserver does not support application service pings" + c.lastHomeserverContact("") + "\n"
+		}
+		return fmt.Sprintf("❌ **Homeserver → Bridge:** Homeserver could not reach the bridge\n🔍 **Error:** %s\n"+
+			"📝 **Action:** Check the `url` in the registration file points at this Mattermost server and is reachable from the homeserver\n", err.Error())
+	}
+
+	return fmt.Sprintf("✅ **Homeserver → Bridge:** Ping round-trip succeeded (%dms)%s\n", roundTrip.Milliseconds(), c.lastHomeserverContact(transactionID))
+}
+
+// lastHomeserverContact describes the last recorded homeserver ping, or returns an empty string if the
+// last contact was the ping with skipTransactionID that is already being reported
+func (c *Handler) lastHomeserverContact(skipTransactionID string) string {
+	data, err := c.kvstore.Get(kvstore.KeyLastHomeserverContact)
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+
+	var contact HomeserverContact
+	if err := json.Unmarshal(data, &contact); err != nil || contact.Timestamp == 0 {
+		return ""
+	}
+	if skipTransactionID != "" && contact.TransactionID == skipTransactionID {
+		return ""
+	}
+
+	return fmt.Sprintf(" - last contact %s", model.GetTimeForMillis(contact.Timestamp).UTC().Format("2006-01-02 15:04:05 MST"))
+}
+
 func (c *Handler) executeMigrateCommand(_ *model.CommandArgs) *model.CommandResponse {
 	// Get current version before reset
 	kvstorage := c.plugin.GetKVStore()
@@ -1056,3 +1737,460 @@ func (c *Handler) executeMigrateCommand(_ *model.CommandArgs) *model.CommandResp
 			dmMappingsAdded, reverseDMMappingsAdded),
 	}
 }
+
+// isValidMatrixUserID checks that a string looks like a full Matrix user ID (@localpart:server)
+func isValidMatrixUserID(userID string) bool {
+	if !strings.HasPrefix(userID, "@") {
+		return false
+	}
+	localpart, server, found := strings.Cut(userID[1:], ":")
+	return found && localpart != "" && server != ""
+}
+
+func (c *Handler) executeDMCommand(args *model.CommandArgs, matrixUserID string) *model.CommandResponse {
+	if !isValidMatrixUserID(matrixUserID) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         matrixUserIDError,
+		}
+	}
+
+	// Ghost users are Mattermost users already - a regular Mattermost DM should be used instead
+	if strings.HasPrefix(matrixUserID, "@_mattermost_") {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("❌ `%s` is a Mattermost user bridged to Matrix. Message them directly in Mattermost instead.", matrixUserID),
+		}
+	}
+
+	// Get current Matrix client and fail fast if not configured
+	matrixClient, errResponse := c.getMatrixClientOrError()
+	if errResponse != nil {
+		return errResponse
+	}
+
+	// Validate that the Matrix user exists before creating anything
+	profile, err := matrixClient.LookupUserProfile(matrixUserID)
+	if err != nil {
+		c.client.Log.Warn("Failed to look up Matrix user profile", "error", err, "matrix_user_id", matrixUserID)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("❌ Could not find Matrix user `%s`. Check the user ID and that their server is reachable.", matrixUserID),
+		}
+	}
+
+	result, err := c.plugin.CreateDirectMessageWithMatrixUser(args.UserId, matrixUserID)
+	if err != nil {
+		c.client.Log.Error("Failed to create direct message with Matrix user", "error", err, "user_id", args.UserId, "matrix_user_id", matrixUserID)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("❌ Failed to start a direct message with `%s`. Check plugin logs for details.", matrixUserID),
+		}
+	}
+
+	displayName := profile.DisplayName
+	if displayName == "" {
+		displayName = matrixUserID
+	}
+
+	roomStatus := "Matrix DM room created"
+	if result.RoomExisting {
+		roomStatus = "Existing Matrix DM room"
+	}
+
+	response := &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         fmt.Sprintf("✅ **Direct Message Ready**\n\n**Matrix User:** %s (`%s`)\n**Mattermost User:** @%s\n**%s:** `%s`", displayName, matrixUserID, result.Username, roomStatus, result.RoomID),
+	}
+
+	// Take the user straight to the new conversation
+	if team, appErr := c.client.Team.Get(args.TeamId); appErr == nil {
+		response.GotoLocation = fmt.Sprintf("%s/%s/messages/@%s", args.SiteURL, team.Name, result.Username)
+	}
+
+	return response
+}
+
+// executeInviteCommand invites Matrix users to the room mapped to the current channel, or lists the
+// channel's tracked invites when no users are given
+func (c *Handler) executeInviteCommand(args *model.CommandArgs, matrixUserIDs []string) *model.CommandResponse {
+	if roomIDBytes, err := c.kvstore.Get(kvstore.BuildChannelMappingKey(args.ChannelId)); err != nil || len(roomIDBytes) == 0 {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "❌ **No Mapping Found**\n\nThis channel is not mapped to a Matrix room. Use `/matrix map` or `/matrix create` first.",
+		}
+	}
+
+	if len(matrixUserIDs) == 0 {
+		return c.executeListInvitesCommand(args)
+	}
+
+	// Validate every ID up front so a typo doesn't leave a partial set of invites
+	for _, matrixUserID := range matrixUserIDs {
+		if !isValidMatrixUserID(matrixUserID) {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         fmt.Sprintf("❌ `%s`: %s\n\n%s", matrixUserID, matrixUserIDError, inviteCommandUsage),
+			}
+		}
+	}
+
+	matrixClient, errResponse := c.getMatrixClientOrError()
+	if errResponse != nil {
+		return errResponse
+	}
+
+	var results strings.Builder
+	results.WriteString("**Matrix Invites**\n\n")
+	for _, matrixUserID := range matrixUserIDs {
+		if strings.HasPrefix(matrixUserID, "@_mattermost_") {
+			results.WriteString(fmt.Sprintf("❌ `%s` is a Mattermost user bridged to Matrix. Add them to the channel in Mattermost instead.\n", matrixUserID))
+			continue
+		}
+
+		if _, err := matrixClient.LookupUserProfile(matrixUserID); err != nil {
+			c.client.Log.Warn("Failed to look up Matrix user profile", "error", err, "matrix_user_id", matrixUserID)
+			results.WriteString(fmt.Sprintf("❌ `%s` - could not find this Matrix user\n", matrixUserID))
+			continue
+		}
+
+		invite, err := c.plugin.InviteMatrixUserToChannel(args.ChannelId, matrixUserID, args.UserId)
+		if err != nil {
+			c.client.Log.Error("Failed to invite Matrix user to channel", "error", err, "channel_id", args.ChannelId, "matrix_user_id", matrixUserID)
+			results.WriteString(fmt.Sprintf("❌ `%s` - failed to invite, check plugin logs for details\n", matrixUserID))
+			continue
+		}
+
+		if invite.Status == "joined" {
+			results.WriteString(fmt.Sprintf("✅ `%s` - already in the Matrix room\n", matrixUserID))
+		} else {
+			results.WriteString(fmt.Sprintf("✅ `%s` - invited\n", matrixUserID))
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         results.String(),
+	}
+}
+
+// executeListInvitesCommand lists the Matrix invites tracked for the current channel
+func (c *Handler) executeListInvitesCommand(args *model.CommandArgs) *model.CommandResponse {
+	invites, err := c.plugin.GetMatrixInvites(args.ChannelId)
+	if err != nil {
+		c.client.Log.Error("Failed to list Matrix invites", "error", err, "channel_id", args.ChannelId)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "❌ Failed to list Matrix invites. Check plugin logs for details.",
+		}
+	}
+
+	if len(invites) == 0 {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "No Matrix users have been invited to this channel.\n\n" + inviteCommandUsage,
+		}
+	}
+
+	var list strings.Builder
+	list.WriteString(fmt.Sprintf("**Matrix Invites (%d)**\n\n", len(invites)))
+	for _, invite := range invites {
+		var statusIcon string
+		switch invite.Status {
+		case "joined":
+			statusIcon = "✅"
+		case "declined":
+			statusIcon = "❌"
+		default:
+			statusIcon = "⏳"
+		}
+
+		inviter := invite.InvitedBy
+		if user, appErr := c.client.User.Get(invite.InvitedBy); appErr == nil {
+			inviter = "@" + user.Username
+		}
+
+		list.WriteString(fmt.Sprintf("%s `%s` - %s (invited by %s on %s)\n",
+			statusIcon, invite.MatrixUserID, invite.Status, inviter,
+			model.GetTimeForMillis(invite.CreateAt).UTC().Format("2006-01-02 15:04 MST")))
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         list.String(),
+	}
+}
+
+// executeAutoBridgeCommand bridges the existing channels that match the auto-bridge policy, or lists them
+// when previewing. New matching channels are bridged as they are created.
+func (c *Handler) executeAutoBridgeCommand(args *model.CommandArgs, preview bool) *model.CommandResponse {
+	if !c.pluginAPI.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "❌ Only system admins can run the auto-bridge policy.",
+		}
+	}
+
+	if _, errResponse := c.getMatrixClientOrError(); errResponse != nil {
+		return errResponse
+	}
+
+	result, err := c.plugin.AutoBridgeExistingChannels(args.UserId, preview)
+	if err != nil {
+		if errors.Is(err, ErrAutoBridgeDisabled) {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         "❌ No auto-bridge policy is configured. Add teams under **Auto-Bridge Teams** in System Console → Plugins → Matrix Bridge.",
+			}
+		}
+		c.client.Log.Error("Failed to run auto-bridge policy", "error", err)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "❌ Failed to run the auto-bridge policy. Check plugin logs for details.",
+		}
+	}
+
+	var text strings.Builder
+	bridgedHeading := "✅ **Bridged**"
+	if preview {
+		text.WriteString("**Auto-Bridge Preview**\n\n")
+		bridgedHeading = "🔍 **Would bridge**"
+	} else {
+		text.WriteString("**Auto-Bridge Complete**\n\n")
+	}
+
+	writeChannelList := func(heading string, channels []string) {
+		if len(channels) == 0 {
+			return
+		}
+		text.WriteString(fmt.Sprintf("%s (%d): ", heading, len(channels)))
+		for i, channel := range channels {
+			if i > 0 {
+				text.WriteString(", ")
+			}
+			text.WriteString("`" + channel + "`")
+		}
+		text.WriteString("\n")
+	}
+
+	writeChannelList(bridgedHeading, result.Bridged)
+	writeChannelList("ℹ️ **Already bridged**", result.AlreadyBridged)
+	writeChannelList("❌ **Failed**", result.Failed)
+	writeChannelList("⚠️ **Teams not found**", result.MissingTeams)
+
+	if len(result.Bridged)+len(result.AlreadyBridged)+len(result.Failed) == 0 {
+		text.WriteString("No existing channels match the auto-bridge policy.\n")
+	}
+	if len(result.Failed) > 0 {
+		text.WriteString("\nCheck plugin logs for details on failed channels.\n")
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text.String(),
+	}
+}
+
+// executeFederationDeactivateCommand deactivates the users of Matrix users whose server the federation policy
+// no longer allows, or lists them when previewing
+func (c *Handler) executeFederationDeactivateCommand(args *model.CommandArgs, preview bool) *model.CommandResponse {
+	if !c.pluginAPI.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "❌ Only system admins can deactivate users from disallowed Matrix servers.",
+		}
+	}
+
+	result, err := c.plugin.DeactivateDisallowedMatrixUsers(preview)
+	if err != nil {
+		if errors.Is(err, ErrFederationPolicyDisabled) {
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         "❌ No federation policy is configured. Add servers under **Federation Allowed Servers** or **Federation Denied Servers** in System Console → Plugins → Matrix Bridge.",
+			}
+		}
+		c.client.Log.Error("Failed to deactivate users from disallowed Matrix servers", "error", err)
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "❌ Failed to deactivate users from disallowed Matrix servers. Check plugin logs for details.",
+		}
+	}
+
+	var text strings.Builder
+	deactivatedHeading := "✅ **Deactivated**"
+	if preview {
+		text.WriteString("**Federation Deactivation Preview**\n\n")
+		deactivatedHeading = "🔍 **Would deactivate**"
+	} else {
+		text.WriteString("**Federation Deactivation Complete**\n\n")
+	}
+
+	writeUserList := func(heading string, users []string) {
+		if len(users) == 0 {
+			return
+		}
+		text.WriteString(fmt.Sprintf("%s (%d): ", heading, len(users)))
+		for i, user := range users {
+			if i > 0 {
+				text.WriteString(", ")
+			}
+			text.WriteString("`" + user + "`")
+		}
+		text.WriteString("\n")
+	}
+
+	writeUserList(deactivatedHeading, result.Deactivated)
+	writeUserList("❌ **Failed**", result.Failed)
+
+	if len(result.Deactivated)+len(result.Failed) == 0 {
+		text.WriteString("No active users are from disallowed Matrix servers.\n")
+	}
+	if len(result.Failed) > 0 {
+		text.WriteString("\nCheck plugin logs for details on failed users.\n")
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text.String(),
+	}
+}
+
+// executeLoginCommand links the user's Matrix account so the bridge sends their messages as it (double
+// puppeting), using an access token they give or, without one, an application service login
+func (c *Handler) executeLoginCommand(args *model.CommandArgs, accessToken string) *model.CommandResponse {
+	matrixUserID, err := c.plugin.LoginMatrixPuppet(args.UserId, accessToken)
+	if err != nil {
+		var text string
+		switch {
+		case errors.Is(err, ErrPuppetEncryptionKeyMissing):
+			text = "❌ Logging in to Matrix isn't set up. Ask a system admin to generate the **Double Puppeting Encryption Key** in System Console → Plugins → Matrix Bridge."
+		case errors.Is(err, ErrPuppetTokenInvalid):
+			text = "❌ The Matrix homeserver didn't accept that access token. Copy it again from your Matrix client's settings."
+		case errors.Is(err, ErrAppserviceLoginUnavailable) && accessToken == "":
+			text = "❌ The bridge can't log in to Matrix for you. Run `/matrix login [access_token]` with an access token from your Matrix client instead.\n\n" + loginCommandUsage
+		case errors.Is(err, ErrPuppetAccountInUse):
+			text = "❌ That Matrix account can't be linked. It belongs to the bridge or is already linked to another Mattermost user."
+		case errors.Is(err, ErrPuppetAccountNotVerified):
+			text = "❌ The bridge only logs in to a Matrix account you've proven is yours. Run `/matrix link @you:server.com` and confirm the code first, or give an access token from your Matrix client.\n\n" + loginCommandUsage
+		default:
+			c.client.Log.Error("Failed to log in to Matrix", "error", err, "user_id", args.UserId)
+			text = "❌ Failed to log in to Matrix. Check plugin logs for details."
+		}
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         fmt.Sprintf("✅ **Logged in to Matrix** as `%s`\n\nYour messages are now sent to Matrix as your own account in rooms it has joined. Use `/matrix logout` to stop.", matrixUserID),
+	}
+}
+
+// executeLogoutCommand unlinks the user's Matrix account, so their messages go out as their bridged user again
+func (c *Handler) executeLogoutCommand(args *model.CommandArgs) *model.CommandResponse {
+	matrixUserID, err := c.plugin.LogoutMatrixPuppet(args.UserId)
+	if err != nil {
+		text := "❌ Failed to log out of Matrix. Check plugin logs for details."
+		if errors.Is(err, ErrPuppetNotLinked) {
+			text = "You aren't logged in to Matrix. Use `/matrix login` to send your messages as your own Matrix account."
+		} else {
+			c.client.Log.Error("Failed to log out of Matrix", "error", err, "user_id", args.UserId)
+		}
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         fmt.Sprintf("✅ **Logged out of Matrix** (`%s`)\n\nYour messages are sent to Matrix as your bridged user again.", matrixUserID),
+	}
+}
+
+// executeLinkCommand asks the bridge bot to send a code to a Matrix account the user wants to link
+func (c *Handler) executeLinkCommand(args *model.CommandArgs, matrixUserID string) *model.CommandResponse {
+	if !isValidMatrixUserID(matrixUserID) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         matrixUserIDError,
+		}
+	}
+
+	if err := c.plugin.RequestMatrixAccountLink(args.UserId, matrixUserID); err != nil {
+		text := "❌ Failed to send a link code to Matrix. Check plugin logs for details."
+		if errors.Is(err, ErrAccountLinkUnavailable) {
+			text = fmt.Sprintf("❌ `%s` can't be linked. It belongs to the bridge or is already linked to another Mattermost user.", matrixUserID)
+		} else {
+			c.client.Log.Error("Failed to request Matrix account link", "error", err, "user_id", args.UserId, "matrix_user_id", matrixUserID)
+		}
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         fmt.Sprintf("📨 The bridge bot has sent a code to `%s` on Matrix. Accept its invite, then run `/matrix link confirm [code]` here to finish linking.\n\nAdd `merge` to also move the teams and channels of the user the bridge created for your Matrix account to your account.", matrixUserID),
+	}
+}
+
+// executeLinkConfirmCommand links the Matrix account the user requested once they confirm the code sent to it
+func (c *Handler) executeLinkConfirmCommand(args *model.CommandArgs, code string, merge bool) *model.CommandResponse {
+	result, err := c.plugin.ConfirmMatrixAccountLink(args.UserId, code, merge)
+	if err != nil {
+		var text string
+		switch {
+		case errors.Is(err, ErrAccountLinkNotPending):
+			text = "❌ There's no link waiting to be confirmed, or its code has expired. Run `/matrix link [@user:server.com]` to get a new code."
+		case errors.Is(err, ErrAccountLinkCodeInvalid):
+			text = "❌ That code doesn't match the one the bridge bot sent. Check the code and try again."
+		case errors.Is(err, ErrAccountLinkUnavailable):
+			text = "❌ That Matrix account has been linked to another Mattermost user in the meantime."
+		default:
+			c.client.Log.Error("Failed to confirm Matrix account link", "error", err, "user_id", args.UserId)
+			text = "❌ Failed to link your Matrix account. Check plugin logs for details."
+		}
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	text := fmt.Sprintf("✅ **Linked Matrix account** `%s`\n\nMessages from your Matrix account now appear in Mattermost as you. Use `/matrix unlink` to undo this.", result.MatrixUserID)
+	switch {
+	case result.Merged:
+		text += fmt.Sprintf("\n\nYou were added to %d channels of `@%s`, which has been deactivated. Its earlier messages stay under its name.", result.MergedChannels, result.ShadowUsername)
+	case result.ShadowUsername != "":
+		text += fmt.Sprintf("\n\n`@%s` keeps the messages and channels bridged from your Matrix account before now.", result.ShadowUsername)
+	}
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
+
+// executeUnlinkCommand unlinks the user's Matrix account
+func (c *Handler) executeUnlinkCommand(args *model.CommandArgs) *model.CommandResponse {
+	matrixUserID, err := c.plugin.UnlinkMatrixAccount(args.UserId)
+	if err != nil {
+		text := "❌ Failed to unlink your Matrix account. Check plugin logs for details."
+		if errors.Is(err, ErrAccountNotLinked) {
+			text = "You haven't linked a Matrix account. Use `/matrix link [@user:server.com]` to link one."
+		} else {
+			c.client.Log.Error("Failed to unlink Matrix account", "error", err, "user_id", args.UserId)
+		}
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         fmt.Sprintf("✅ **Unlinked Matrix account** `%s`\n\nMessages from it are bridged as a separate user again.", matrixUserID),
+	}
+}
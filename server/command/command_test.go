@@ -1,6 +1,8 @@
 package command
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -10,7 +12,9 @@ import (
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 type env struct {
@@ -36,11 +40,12 @@ func (m *mockConfiguration) GetMatrixUsernamePrefixForServer(_ string) string {
 
 // mockPlugin implements the PluginAccessor interface for testing
 type mockPlugin struct {
-	client       *pluginapi.Client
-	kvstore      kvstore.KVStore
-	matrixClient *matrix.Client
-	config       Configuration
-	pluginAPI    *plugintest.API
+	client           *pluginapi.Client
+	kvstore          kvstore.KVStore
+	matrixClient     *matrix.Client
+	config           Configuration
+	pluginAPI        *plugintest.API
+	syncDirectionErr error
 }
 
 func (m *mockPlugin) GetMatrixClient() *matrix.Client {
@@ -91,6 +96,127 @@ func (m *mockPlugin) GetMatrixUserIDFromMattermostUser(mattermostUserID string)
 	return "@test_" + mattermostUserID + ":test.com", nil
 }
 
+func (m *mockPlugin) CreateDirectMessageWithMatrixUser(_, matrixUserID string) (*DirectMessageResult, error) {
+	// Mock implementation - return a test DM
+	return &DirectMessageResult{
+		ChannelID: "dm-channel-id",
+		RoomID:    "!dm:test.com",
+		UserID:    "remote-user-id",
+		Username:  "matrix:" + strings.TrimPrefix(strings.Split(matrixUserID, ":")[0], "@"),
+	}, nil
+}
+
+func (m *mockPlugin) InviteMatrixUserToChannel(_, matrixUserID, invitedBy string) (*MatrixInvite, error) {
+	// Mock implementation - return a pending invite
+	return &MatrixInvite{
+		MatrixUserID:     matrixUserID,
+		MattermostUserID: "remote-user-id",
+		RoomID:           "!room:test.com",
+		InvitedBy:        invitedBy,
+		Status:           "invited",
+	}, nil
+}
+
+func (m *mockPlugin) BackfillMatrixRoom(_, _ string, _ int) (int, error) {
+	// Mock implementation - nothing imported
+	return 0, nil
+}
+
+func (m *mockPlugin) GetMatrixInvites(_ string) ([]*MatrixInvite, error) {
+	// Mock implementation - no tracked invites
+	return nil, nil
+}
+
+func (m *mockPlugin) AddChannelToTeamSpace(_, _ string) error {
+	// Mock implementation - spaces are not tracked
+	return nil
+}
+
+func (m *mockPlugin) RemoveChannelFromTeamSpace(_ string) error {
+	// Mock implementation - spaces are not tracked
+	return nil
+}
+
+func (m *mockPlugin) AutoBridgeExistingChannels(_ string, _ bool) (*AutoBridgeResult, error) {
+	// Mock implementation - one matching channel, one already bridged
+	return &AutoBridgeResult{
+		Bridged:        []string{"team/eng-new"},
+		AlreadyBridged: []string{"team/general"},
+	}, nil
+}
+
+func (m *mockPlugin) GetSyncDirection(_ string) string {
+	// Mock implementation - every mapping syncs both ways
+	return SyncDirectionBoth
+}
+
+func (m *mockPlugin) SetSyncDirection(_, direction string) error {
+	// Mock implementation - validates without storing
+	if !IsValidSyncDirection(direction) {
+		return errors.New("invalid sync direction")
+	}
+	return m.syncDirectionErr
+}
+
+func (m *mockPlugin) RelaysToMatrix(_ string) bool {
+	// Mock implementation - messages are sent as ghost users
+	return false
+}
+
+func (m *mockPlugin) DeactivateDisallowedMatrixUsers(_ bool) (*FederationDeactivationResult, error) {
+	// Mock implementation - one user from a denied server
+	return &FederationDeactivationResult{
+		Deactivated: []string{"@spammer:spam.example"},
+	}, nil
+}
+
+func (m *mockPlugin) LoginMatrixPuppet(mattermostUserID, accessToken string) (string, error) {
+	// Mock implementation - tokens other than "valid" are refused, and logging in without one is unavailable,
+	// or needs a linked account for users other than test-user-id
+	switch {
+	case accessToken == "valid":
+		return "@alice:test.com", nil
+	case accessToken == "" && mattermostUserID != "test-user-id":
+		return "", ErrPuppetAccountNotVerified
+	case accessToken == "":
+		return "", ErrAppserviceLoginUnavailable
+	default:
+		return "", errors.Wrap(ErrPuppetTokenInvalid, "M_UNKNOWN_TOKEN")
+	}
+}
+
+func (m *mockPlugin) LogoutMatrixPuppet(mattermostUserID string) (string, error) {
+	// Mock implementation - only test-user-id is logged in
+	if mattermostUserID != "test-user-id" {
+		return "", ErrPuppetNotLinked
+	}
+	return "@alice:test.com", nil
+}
+
+func (m *mockPlugin) RequestMatrixAccountLink(_, matrixUserID string) error {
+	// Mock implementation - the bridge's own users can't be linked
+	if strings.HasPrefix(matrixUserID, "@_mattermost_") {
+		return ErrAccountLinkUnavailable
+	}
+	return nil
+}
+
+func (m *mockPlugin) ConfirmMatrixAccountLink(_, code string, merge bool) (*AccountLinkResult, error) {
+	// Mock implementation - only 123456 is a valid code, and the account had a bridged user
+	if code != "123456" {
+		return nil, ErrAccountLinkCodeInvalid
+	}
+	return &AccountLinkResult{MatrixUserID: "@alice:test.com", ShadowUsername: "matrix_alice", Merged: merge, MergedChannels: 2}, nil
+}
+
+func (m *mockPlugin) UnlinkMatrixAccount(mattermostUserID string) (string, error) {
+	// Mock implementation - only test-user-id has linked an account
+	if mattermostUserID != "test-user-id" {
+		return "", ErrAccountNotLinked
+	}
+	return "@alice:test.com", nil
+}
+
 func setupTest() *env {
 	api := &plugintest.API{}
 	driver := &plugintest.Driver{}
@@ -358,8 +484,15 @@ func setupCommandRegistration(env *env) {
 	// Map command with argument completion
 	mapCmd := model.NewAutocompleteData("map", mapCommandHint, mapCommandDesc)
 	mapCmd.AddTextArgument("Matrix room alias or room ID", "[room_alias|room_id]", "")
+	mapCmd.AddTextArgument("Optional sync direction (defaults to both)", "[direction=both|to-matrix|to-mattermost]", "")
 	matrixData.AddCommand(mapCmd)
 
+	// Join command with argument completion
+	joinCmd := model.NewAutocompleteData("join", joinCommandHint, joinCommandDesc)
+	joinCmd.AddTextArgument("Matrix room alias or room ID", "[room_alias|room_id]", "")
+	joinCmd.AddTextArgument("Optional number of recent messages to import", "[backfill=N]", "")
+	matrixData.AddCommand(joinCmd)
+
 	// Unmap command
 	matrixData.AddCommand(model.NewAutocompleteData("unmap", unmapCommandHint, unmapCommandDesc))
 
@@ -367,6 +500,44 @@ func setupCommandRegistration(env *env) {
 	matrixData.AddCommand(model.NewAutocompleteData("status", "", statusCommandDesc))
 	matrixData.AddCommand(model.NewAutocompleteData("migrate", "", migrateCommandDesc))
 
+	// DM command with argument completion
+	dmCmd := model.NewAutocompleteData("dm", dmCommandHint, dmCommandDesc)
+	dmCmd.AddTextArgument("Full Matrix user ID", "[@user:server.com]", "")
+	matrixData.AddCommand(dmCmd)
+
+	// Invite command with argument completion
+	inviteCmd := model.NewAutocompleteData("invite", inviteCommandHint, inviteCommandDesc)
+	inviteCmd.AddTextArgument("One or more full Matrix user IDs (omit to list invites)", "[@user:server.com ...]", "")
+	matrixData.AddCommand(inviteCmd)
+
+	// Auto-bridge command with argument completion
+	autoBridgeCmd := model.NewAutocompleteData("autobridge", autoBridgeCommandHint, autoBridgeCommandDesc)
+	autoBridgeCmd.AddStaticListArgument("Optional preview flag", false, []model.AutocompleteListItem{
+		{Item: "preview", HelpText: "List matching channels without bridging them"},
+	})
+	matrixData.AddCommand(autoBridgeCmd)
+
+	// Federation command with argument completion
+	federationCmd := model.NewAutocompleteData("federation", federationCommandHint, federationCommandDesc)
+	deactivateCmd := model.NewAutocompleteData("deactivate", "[preview]", "Deactivate users from disallowed Matrix servers")
+	deactivateCmd.AddStaticListArgument("Optional preview flag", false, []model.AutocompleteListItem{
+		{Item: "preview", HelpText: "List the users without deactivating them"},
+	})
+	federationCmd.AddCommand(deactivateCmd)
+	matrixData.AddCommand(federationCmd)
+
+	// Login command with argument completion
+	loginCmd := model.NewAutocompleteData("login", loginCommandHint, loginCommandDesc)
+	loginCmd.AddTextArgument("Optional access token of your Matrix account", "[access_token]", "")
+	matrixData.AddCommand(loginCmd)
+	matrixData.AddCommand(model.NewAutocompleteData("logout", "", logoutCommandDesc))
+
+	// Link command with argument completion
+	linkCmd := model.NewAutocompleteData("link", linkCommandHint, linkCommandDesc)
+	linkCmd.AddTextArgument("Your full Matrix user ID, or confirm and the code the bridge bot sent you", "[@user:server.com] | confirm [code] [merge]", "")
+	matrixData.AddCommand(linkCmd)
+	matrixData.AddCommand(model.NewAutocompleteData("unlink", "", unlinkCommandDesc))
+
 	env.api.On("RegisterCommand", &model.Command{
 		Trigger:          matrixCommandTrigger,
 		AutoComplete:     true,
@@ -593,3 +764,611 @@ func TestChannelNameFallback(t *testing.T) {
 		assert.Equal("", capturedRoomName)
 	}
 }
+
+func TestMatrixDMCommand(t *testing.T) {
+	tests := []struct {
+		name             string
+		command          string
+		expectedResponse string
+	}{
+		{
+			name:             "dm without user ID",
+			command:          "/matrix dm",
+			expectedResponse: dmCommandUsage,
+		},
+		{
+			name:             "dm with too many arguments",
+			command:          "/matrix dm @alice:matrix.org @bob:matrix.org",
+			expectedResponse: dmCommandUsage,
+		},
+		{
+			name:             "dm with missing server",
+			command:          "/matrix dm @alice",
+			expectedResponse: matrixUserIDError,
+		},
+		{
+			name:             "dm with room alias instead of user ID",
+			command:          "/matrix dm #room:matrix.org",
+			expectedResponse: matrixUserIDError,
+		},
+		{
+			name:             "dm with ghost user",
+			command:          "/matrix dm @_mattermost_abc123:matrix.org",
+			expectedResponse: "is a Mattermost user bridged to Matrix",
+		},
+		{
+			name:             "dm without Matrix client",
+			command:          "/matrix dm @alice:matrix.org",
+			expectedResponse: matrixClientNotConfigured,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			env := setupTest()
+
+			// Set up expectations for command registration
+			setupCommandRegistration(env)
+
+			mockPlugin := &mockPlugin{
+				client:       env.client,
+				kvstore:      kvstore.NewKVStore(env.client),
+				matrixClient: nil,
+				config:       &mockConfiguration{serverURL: "http://test.com"},
+				pluginAPI:    env.api,
+			}
+			cmdHandler := NewCommandHandler(mockPlugin)
+
+			response, err := cmdHandler.Handle(&model.CommandArgs{
+				Command:   tt.command,
+				ChannelId: "test-channel-id",
+				UserId:    "test-user-id",
+			})
+
+			assert.Nil(err)
+			assert.Contains(response.Text, tt.expectedResponse)
+		})
+	}
+}
+
+func TestMatrixInviteCommand(t *testing.T) {
+	tests := []struct {
+		name             string
+		command          string
+		mapped           bool
+		expectedResponse string
+	}{
+		{
+			name:             "invite in unmapped channel",
+			command:          "/matrix invite @alice:matrix.org",
+			mapped:           false,
+			expectedResponse: "not mapped to a Matrix room",
+		},
+		{
+			name:             "list invites with none tracked",
+			command:          "/matrix invite",
+			mapped:           true,
+			expectedResponse: "No Matrix users have been invited",
+		},
+		{
+			name:             "invite with invalid user ID",
+			command:          "/matrix invite @alice:matrix.org bob",
+			mapped:           true,
+			expectedResponse: matrixUserIDError,
+		},
+		{
+			name:             "invite without Matrix client",
+			command:          "/matrix invite @alice:matrix.org",
+			mapped:           true,
+			expectedResponse: matrixClientNotConfigured,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			env := setupTest()
+
+			// Set up expectations for command registration
+			setupCommandRegistration(env)
+
+			var mapping []byte
+			if tt.mapped {
+				mapping = []byte("!room:test.com")
+			}
+			env.api.On("KVGet", kvstore.BuildChannelMappingKey("test-channel-id")).Return(mapping, nil)
+
+			mockPlugin := &mockPlugin{
+				client:       env.client,
+				kvstore:      kvstore.NewKVStore(env.client),
+				matrixClient: nil,
+				config:       &mockConfiguration{serverURL: "http://test.com"},
+				pluginAPI:    env.api,
+			}
+			cmdHandler := NewCommandHandler(mockPlugin)
+
+			response, err := cmdHandler.Handle(&model.CommandArgs{
+				Command:   tt.command,
+				ChannelId: "test-channel-id",
+				UserId:    "test-user-id",
+			})
+
+			assert.Nil(err)
+			assert.Contains(response.Text, tt.expectedResponse)
+		})
+	}
+}
+
+func TestMatrixJoinCommand(t *testing.T) {
+	tests := []struct {
+		name             string
+		command          string
+		withMatrixClient bool
+		expectedResponse string
+	}{
+		{
+			name:             "join without room",
+			command:          "/matrix join",
+			expectedResponse: joinCommandUsage,
+		},
+		{
+			name:             "join with invalid backfill",
+			command:          "/matrix join #room:matrix.org backfill=lots",
+			expectedResponse: joinCommandUsage,
+		},
+		{
+			name:             "join with unknown option",
+			command:          "/matrix join #room:matrix.org publish=true",
+			expectedResponse: joinCommandUsage,
+		},
+		{
+			name:             "join without Matrix client",
+			command:          "/matrix join #room:matrix.org backfill=20",
+			expectedResponse: matrixClientNotConfigured,
+		},
+		{
+			name:             "join without permission to create channels",
+			command:          "/matrix join !room:matrix.org",
+			withMatrixClient: true,
+			expectedResponse: joinPermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			env := setupTest()
+
+			// Set up expectations for command registration
+			setupCommandRegistration(env)
+			env.api.On("HasPermissionToTeam", "test-user-id", "test-team-id", model.PermissionCreatePublicChannel).Return(false)
+			env.api.On("HasPermissionToTeam", "test-user-id", "test-team-id", model.PermissionCreatePrivateChannel).Return(false)
+
+			mockPlugin := &mockPlugin{
+				client:       env.client,
+				kvstore:      kvstore.NewKVStore(env.client),
+				matrixClient: nil,
+				config:       &mockConfiguration{serverURL: "http://test.com"},
+				pluginAPI:    env.api,
+			}
+			if tt.withMatrixClient {
+				// The homeserver is never reached because the permission check comes first
+				mockPlugin.matrixClient = matrix.NewClientWithLoggerAndRateLimit("http://test.com", "as_token", "remote_id", "", matrix.NewTestLogger(t), matrix.UnitTestRateLimitConfig())
+			}
+			cmdHandler := NewCommandHandler(mockPlugin)
+
+			response, err := cmdHandler.Handle(&model.CommandArgs{
+				Command:   tt.command,
+				ChannelId: "test-channel-id",
+				TeamId:    "test-team-id",
+				UserId:    "test-user-id",
+			})
+
+			assert.Nil(err)
+			assert.Contains(response.Text, tt.expectedResponse)
+		})
+	}
+}
+
+func TestMatrixAutoBridgeCommand(t *testing.T) {
+	tests := []struct {
+		name             string
+		command          string
+		isAdmin          bool
+		expectedResponse string
+	}{
+		{
+			name:             "non-admins are refused",
+			command:          "/matrix autobridge",
+			isAdmin:          false,
+			expectedResponse: "Only system admins",
+		},
+		{
+			name:             "unknown arguments show usage",
+			command:          "/matrix autobridge now",
+			isAdmin:          true,
+			expectedResponse: autoBridgeCommandUsage,
+		},
+		{
+			name:             "preview lists matching channels",
+			command:          "/matrix autobridge preview",
+			isAdmin:          true,
+			expectedResponse: "**Would bridge** (1): `team/eng-new`",
+		},
+		{
+			name:             "run reports bridged channels",
+			command:          "/matrix autobridge",
+			isAdmin:          true,
+			expectedResponse: "**Already bridged** (1): `team/general`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			env := setupTest()
+
+			// Set up expectations for command registration
+			setupCommandRegistration(env)
+			env.api.On("HasPermissionTo", "test-user-id", model.PermissionManageSystem).Return(tt.isAdmin)
+
+			mockPlugin := &mockPlugin{
+				client:       env.client,
+				kvstore:      kvstore.NewKVStore(env.client),
+				matrixClient: matrix.NewClientWithLoggerAndRateLimit("http://test.com", "as_token", "remote_id", "", matrix.NewTestLogger(t), matrix.UnitTestRateLimitConfig()),
+				config:       &mockConfiguration{serverURL: "http://test.com"},
+				pluginAPI:    env.api,
+			}
+			cmdHandler := NewCommandHandler(mockPlugin)
+
+			response, err := cmdHandler.Handle(&model.CommandArgs{
+				Command:   tt.command,
+				ChannelId: "test-channel-id",
+				UserId:    "test-user-id",
+			})
+
+			assert.Nil(err)
+			assert.Contains(response.Text, tt.expectedResponse)
+		})
+	}
+}
+
+func TestMatrixMapCommandDirection(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+	}{
+		{name: "unknown direction", command: "/matrix map #room:test.com direction=sideways"},
+		{name: "argument without direction prefix", command: "/matrix map #room:test.com to-matrix"},
+		{name: "too many arguments", command: "/matrix map #room:test.com direction=to-matrix extra"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			env := setupTest()
+
+			// Set up expectations for command registration
+			setupCommandRegistration(env)
+
+			mockPlugin := &mockPlugin{
+				client:       env.client,
+				kvstore:      kvstore.NewKVStore(env.client),
+				matrixClient: nil,
+				config:       &mockConfiguration{serverURL: "http://test.com"},
+				pluginAPI:    env.api,
+			}
+			cmdHandler := NewCommandHandler(mockPlugin)
+
+			response, err := cmdHandler.Handle(&model.CommandArgs{
+				Command:   tt.command,
+				ChannelId: "test-channel-id",
+				UserId:    "test-user-id",
+			})
+
+			assert.Nil(err)
+			assert.Equal(mapCommandUsage, response.Text)
+		})
+	}
+}
+
+func TestMatrixMapCommandDirectionFailure(t *testing.T) {
+	env := setupTest()
+	setupCommandRegistration(env)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"errcode":"M_UNKNOWN"}`, http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	channelMappingKey := kvstore.BuildChannelMappingKey("test-channel-id")
+	roomMappingKey := kvstore.BuildRoomMappingKey("!room:test.com")
+	env.api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	env.api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	env.api.On("GetChannel", "test-channel-id").Return(&model.Channel{Id: "test-channel-id", Name: "test-channel"}, nil)
+	env.api.On("KVSetWithOptions", channelMappingKey, []byte("!room:test.com"), mock.Anything).Return(true, nil).Once()
+	env.api.On("KVSetWithOptions", roomMappingKey, []byte("test-channel-id"), mock.Anything).Return(true, nil).Once()
+	env.api.On("KVSetWithOptions", channelMappingKey, []byte(nil), mock.Anything).Return(true, nil).Once()
+	env.api.On("KVSetWithOptions", roomMappingKey, []byte(nil), mock.Anything).Return(true, nil).Once()
+
+	mockPlugin := &mockPlugin{
+		client:           env.client,
+		kvstore:          kvstore.NewKVStore(env.client),
+		matrixClient:     matrix.NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", matrix.NewTestLogger(t), matrix.UnitTestRateLimitConfig()),
+		config:           &mockConfiguration{serverURL: server.URL},
+		pluginAPI:        env.api,
+		syncDirectionErr: errors.New("kv store unavailable"),
+	}
+	cmdHandler := NewCommandHandler(mockPlugin)
+
+	response, err := cmdHandler.Handle(&model.CommandArgs{
+		Command:   "/matrix map !room:test.com direction=to-matrix",
+		ChannelId: "test-channel-id",
+		UserId:    "test-user-id",
+	})
+
+	// A mapping without its direction would sync both ways, so it's removed again
+	assert.Nil(t, err)
+	assert.Contains(t, response.Text, "Failed to set the sync direction to `to-matrix`")
+	env.api.AssertExpectations(t)
+}
+
+func TestSyncDirectionArrow(t *testing.T) {
+	assert.Equal(t, "↔", syncDirectionArrow(SyncDirectionBoth))
+	assert.Equal(t, "→", syncDirectionArrow(SyncDirectionToMatrix))
+	assert.Equal(t, "←", syncDirectionArrow(SyncDirectionToMattermost))
+}
+
+func TestMatrixFederationCommand(t *testing.T) {
+	tests := []struct {
+		name             string
+		command          string
+		isAdmin          bool
+		expectedResponse string
+	}{
+		{
+			name:             "non-admins are refused",
+			command:          "/matrix federation deactivate",
+			isAdmin:          false,
+			expectedResponse: "Only system admins",
+		},
+		{
+			name:             "missing action shows usage",
+			command:          "/matrix federation",
+			isAdmin:          true,
+			expectedResponse: federationCommandUsage,
+		},
+		{
+			name:             "unknown arguments show usage",
+			command:          "/matrix federation deactivate now",
+			isAdmin:          true,
+			expectedResponse: federationCommandUsage,
+		},
+		{
+			name:             "preview lists users",
+			command:          "/matrix federation deactivate preview",
+			isAdmin:          true,
+			expectedResponse: "**Would deactivate** (1): `@spammer:spam.example`",
+		},
+		{
+			name:             "run reports deactivated users",
+			command:          "/matrix federation deactivate",
+			isAdmin:          true,
+			expectedResponse: "**Deactivated** (1): `@spammer:spam.example`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			env := setupTest()
+
+			// Set up expectations for command registration
+			setupCommandRegistration(env)
+			env.api.On("HasPermissionTo", "test-user-id", model.PermissionManageSystem).Return(tt.isAdmin)
+
+			mockPlugin := &mockPlugin{
+				client:       env.client,
+				kvstore:      kvstore.NewKVStore(env.client),
+				matrixClient: nil,
+				config:       &mockConfiguration{serverURL: "http://test.com"},
+				pluginAPI:    env.api,
+			}
+			cmdHandler := NewCommandHandler(mockPlugin)
+
+			response, err := cmdHandler.Handle(&model.CommandArgs{
+				Command:   tt.command,
+				ChannelId: "test-channel-id",
+				UserId:    "test-user-id",
+			})
+
+			assert.Nil(err)
+			assert.Contains(response.Text, tt.expectedResponse)
+		})
+	}
+}
+
+func TestChannelNameFromRoom(t *testing.T) {
+	assert.Equal(t, "matrix-hq", channelNameFromRoom("Matrix HQ"))
+	assert.Equal(t, "go-lang-talk", channelNameFromRoom("  Go/Lang -- Talk! "))
+	assert.Equal(t, "matrix-room", channelNameFromRoom("🎉🎉"))
+	assert.Len(t, channelNameFromRoom(strings.Repeat("a", 100)), model.ChannelNameMaxLength)
+}
+
+func TestRoomAliasLocalpart(t *testing.T) {
+	assert.Equal(t, "community", roomAliasLocalpart("#community:matrix.org"))
+	assert.Equal(t, "", roomAliasLocalpart("!abc:matrix.org"))
+	assert.Equal(t, "", roomAliasLocalpart(""))
+}
+
+func TestIsValidMatrixUserID(t *testing.T) {
+	assert.True(t, isValidMatrixUserID("@alice:matrix.org"))
+	assert.True(t, isValidMatrixUserID("@alice:localhost:8448"))
+	assert.False(t, isValidMatrixUserID("alice:matrix.org"))
+	assert.False(t, isValidMatrixUserID("@alice"))
+	assert.False(t, isValidMatrixUserID("@:matrix.org"))
+	assert.False(t, isValidMatrixUserID("@alice:"))
+}
+
+func TestMatrixLoginCommand(t *testing.T) {
+	tests := []struct {
+		name             string
+		command          string
+		userID           string
+		expectedResponse string
+	}{
+		{
+			name:             "access tokens log in as their account",
+			command:          "/matrix login valid",
+			userID:           "test-user-id",
+			expectedResponse: "**Logged in to Matrix** as `@alice:test.com`",
+		},
+		{
+			name:             "rejected tokens are reported",
+			command:          "/matrix login expired",
+			userID:           "test-user-id",
+			expectedResponse: "didn't accept that access token",
+		},
+		{
+			name:             "logging in without a token explains the alternative",
+			command:          "/matrix login",
+			userID:           "test-user-id",
+			expectedResponse: loginCommandUsage,
+		},
+		{
+			name:             "logging in without a token needs a linked account",
+			command:          "/matrix login",
+			userID:           "other-user-id",
+			expectedResponse: "Run `/matrix link @you:server.com` and confirm the code first",
+		},
+		{
+			name:             "extra arguments show usage",
+			command:          "/matrix login valid extra",
+			userID:           "test-user-id",
+			expectedResponse: loginCommandUsage,
+		},
+		{
+			name:             "logged in users can log out",
+			command:          "/matrix logout",
+			userID:           "test-user-id",
+			expectedResponse: "**Logged out of Matrix** (`@alice:test.com`)",
+		},
+		{
+			name:             "logging out without logging in is explained",
+			command:          "/matrix logout",
+			userID:           "other-user-id",
+			expectedResponse: "You aren't logged in to Matrix",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupTest()
+			setupCommandRegistration(env)
+
+			cmdHandler := NewCommandHandler(&mockPlugin{
+				client:    env.client,
+				kvstore:   kvstore.NewKVStore(env.client),
+				config:    &mockConfiguration{serverURL: "http://test.com"},
+				pluginAPI: env.api,
+			})
+
+			response, err := cmdHandler.Handle(&model.CommandArgs{
+				Command:   tt.command,
+				ChannelId: "test-channel-id",
+				UserId:    tt.userID,
+			})
+
+			assert.Nil(t, err)
+			assert.Contains(t, response.Text, tt.expectedResponse)
+		})
+	}
+}
+
+func TestMatrixLinkCommand(t *testing.T) {
+	tests := []struct {
+		name             string
+		command          string
+		userID           string
+		expectedResponse string
+	}{
+		{
+			name:             "linking sends a code",
+			command:          "/matrix link @alice:test.com",
+			userID:           "test-user-id",
+			expectedResponse: "The bridge bot has sent a code to `@alice:test.com`",
+		},
+		{
+			name:             "invalid Matrix user IDs are rejected",
+			command:          "/matrix link alice",
+			userID:           "test-user-id",
+			expectedResponse: matrixUserIDError,
+		},
+		{
+			name:             "bridge users can't be linked",
+			command:          "/matrix link @_mattermost_abc:test.com",
+			userID:           "test-user-id",
+			expectedResponse: "can't be linked",
+		},
+		{
+			name:             "confirming links the account",
+			command:          "/matrix link confirm 123456",
+			userID:           "test-user-id",
+			expectedResponse: "`@matrix_alice` keeps the messages and channels",
+		},
+		{
+			name:             "confirming with merge reports the merge",
+			command:          "/matrix link confirm 123456 merge",
+			userID:           "test-user-id",
+			expectedResponse: "You were added to 2 channels of `@matrix_alice`",
+		},
+		{
+			name:             "wrong codes are reported",
+			command:          "/matrix link confirm 654321",
+			userID:           "test-user-id",
+			expectedResponse: "doesn't match",
+		},
+		{
+			name:             "missing codes show usage",
+			command:          "/matrix link confirm",
+			userID:           "test-user-id",
+			expectedResponse: linkCommandUsage,
+		},
+		{
+			name:             "linked users can unlink",
+			command:          "/matrix unlink",
+			userID:           "test-user-id",
+			expectedResponse: "**Unlinked Matrix account** `@alice:test.com`",
+		},
+		{
+			name:             "unlinking without a link is explained",
+			command:          "/matrix unlink",
+			userID:           "other-user-id",
+			expectedResponse: "You haven't linked a Matrix account",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupTest()
+			setupCommandRegistration(env)
+
+			cmdHandler := NewCommandHandler(&mockPlugin{
+				client:    env.client,
+				kvstore:   kvstore.NewKVStore(env.client),
+				config:    &mockConfiguration{serverURL: "http://test.com"},
+				pluginAPI: env.api,
+			})
+
+			response, err := cmdHandler.Handle(&model.CommandArgs{
+				Command:   tt.command,
+				ChannelId: "test-channel-id",
+				UserId:    tt.userID,
+			})
+
+			assert.Nil(t, err)
+			assert.Contains(t, response.Text, tt.expectedResponse)
+		})
+	}
+}
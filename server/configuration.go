@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"reflect"
+	"strings"
 
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
 	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
 	"github.com/pkg/errors"
 )
@@ -11,6 +13,9 @@ import (
 // DefaultMatrixUsernamePrefix is the default username prefix for Matrix-originated users
 const DefaultMatrixUsernamePrefix = "matrix"
 
+// DefaultRelayTemplate is the default template messages relayed by the bridge bot are posted with
+const DefaultRelayTemplate = "**{{displayname}}**: {{message}}"
+
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
 // configuration, as well as values computed from the configuration. Any public fields will be
 // deserialized from the Mattermost server configuration in OnConfigurationChange.
@@ -30,6 +35,34 @@ type configuration struct {
 	EnableSync           bool   `json:"enable_sync"`
 	MatrixUsernamePrefix string `json:"matrix_username_prefix"`
 	RateLimitingMode     string `json:"rate_limiting_mode"`
+
+	AutoBridgeTeams            string `json:"auto_bridge_teams"`
+	AutoBridgeChannelPattern   string `json:"auto_bridge_channel_pattern"`
+	AutoBridgeChannelTypes     string `json:"auto_bridge_channel_types"`
+	AutoBridgeExcludedChannels string `json:"auto_bridge_excluded_channels"`
+
+	ContentFilters string `json:"content_filters"`
+
+	FederationAllowedServers string `json:"federation_allowed_servers"`
+	FederationDeniedServers  string `json:"federation_denied_servers"`
+
+	InboundSenderRateLimit int `json:"inbound_sender_rate_limit"`
+	InboundRoomRateLimit   int `json:"inbound_room_rate_limit"`
+	InboundMuteThreshold   int `json:"inbound_mute_threshold"`
+	InboundMuteMinutes     int `json:"inbound_mute_minutes"`
+
+	MediaCaptions bool `json:"media_captions"`
+
+	BlockedMimeTypesToMatrix     string `json:"blocked_mime_types_to_matrix"`
+	BlockedMimeTypesToMattermost string `json:"blocked_mime_types_to_mattermost"`
+
+	MediaProxy bool `json:"media_proxy"`
+
+	PuppetEncryptionKey string `json:"puppet_encryption_key"`
+	DoublePuppetASToken string `json:"double_puppet_as_token"`
+
+	RelayMode     bool   `json:"relay_mode"`
+	RelayTemplate string `json:"relay_template"`
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -123,6 +156,34 @@ func (p *Plugin) validateConfiguration(config *configuration) error {
 	parsedMode := matrix.ParseRateLimitingMode(config.RateLimitingMode)
 	config.RateLimitingMode = string(parsedMode)
 
+	// Validate the auto-bridge policy so a bad pattern is reported when saving rather than ignored later
+	if _, err := config.getAutoBridgePolicy(); err != nil {
+		return errors.Wrap(err, "invalid auto-bridge policy")
+	}
+	if config.AutoBridgeChannelTypes != autoBridgeChannelTypesAll {
+		config.AutoBridgeChannelTypes = autoBridgeChannelTypesPublic
+	}
+
+	// Compile the global content filters so mistakes are reported when saving
+	if _, err := parseContentFilter(config.ContentFilters); err != nil {
+		return errors.Wrap(err, "invalid content filters")
+	}
+
+	if err := config.getFederationPolicy().validate(); err != nil {
+		return errors.Wrap(err, "invalid federation policy")
+	}
+
+	if err := validateMimeTypeList(config.getBlockedMimeTypes(command.SyncDirectionToMatrix)); err != nil {
+		return errors.Wrap(err, "invalid blocked MIME types for files sent to Matrix")
+	}
+	if err := validateMimeTypeList(config.getBlockedMimeTypes(command.SyncDirectionToMattermost)); err != nil {
+		return errors.Wrap(err, "invalid blocked MIME types for files sent to Mattermost")
+	}
+
+	if config.InboundSenderRateLimit < 0 || config.InboundRoomRateLimit < 0 || config.InboundMuteThreshold < 0 || config.InboundMuteMinutes < 0 {
+		return errors.New("inbound rate limits and mute settings can't be negative")
+	}
+
 	// Validate and normalize MatrixServerName if provided
 	if config.MatrixServerName != "" {
 		normalized, err := matrix.NormalizeServerName(config.MatrixServerName)
@@ -154,6 +215,14 @@ func (c *configuration) GetMatrixUsernamePrefix() string {
 	return c.MatrixUsernamePrefix
 }
 
+// GetRelayTemplate returns the template messages relayed by the bridge bot are posted with
+func (c *configuration) GetRelayTemplate() string {
+	if strings.TrimSpace(c.RelayTemplate) == "" {
+		return DefaultRelayTemplate
+	}
+	return c.RelayTemplate
+}
+
 // GetMatrixUsernamePrefixForServer returns the username prefix for a specific Matrix server
 // This allows for future extensibility to support different prefixes per server
 func (c *configuration) GetMatrixUsernamePrefixForServer(_ string) string {
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Content filter rule actions
+const (
+	filterActionDrop         = "drop"          // Drop messages matching the pattern
+	filterActionReplace      = "replace"       // Replace text matching the pattern
+	filterActionAllowSenders = "allow_senders" // Drop messages from anyone not listed
+	filterActionDenySenders  = "deny_senders"  // Drop messages from anyone listed
+	filterActionDropTypes    = "drop_types"    // Drop Mattermost post types or Matrix msgtypes
+
+	// defaultFilterReplacement is used by replace rules without a replacement
+	defaultFilterReplacement = "[redacted]"
+
+	// contentFilterCacheSize is the most compiled filters kept in memory
+	contentFilterCacheSize = 1000
+)
+
+// contentFilterRule is a single step of a content filter pipeline, as configured in the plugin settings or
+// through the API
+type contentFilterRule struct {
+	Action      string   `json:"action"`
+	Direction   string   `json:"direction,omitempty"` // both (default), to-matrix or to-mattermost
+	Pattern     string   `json:"pattern,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+	Senders     []string `json:"senders,omitempty"`
+	Types       []string `json:"types,omitempty"`
+}
+
+// compiledFilterRule is a content filter rule ready to apply
+type compiledFilterRule struct {
+	contentFilterRule
+	pattern *regexp.Regexp
+	senders map[string]bool
+	types   map[string]bool
+}
+
+// contentFilter is an ordered list of compiled rules
+type contentFilter struct {
+	rules []compiledFilterRule
+}
+
+// filterMessage is the part of a message content filters look at
+type filterMessage struct {
+	SenderIDs []string // Identifiers of the sender: user ID and username, or Matrix user ID
+	Type      string   // Mattermost post type or Matrix msgtype
+	Text      string   // Message text, rewritten by replace rules
+}
+
+// compiledFilters caches compiled filters by their JSON so regexes aren't compiled for every message. Keying by
+// the rules themselves means changed or removed rules never need invalidating; they just fall out of the cache.
+var compiledFilters = newContentFilterCache(contentFilterCacheSize)
+
+// contentFilterCache is a least recently used cache of compiled filters by their JSON
+type contentFilterCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	order      *list.List               // most recently used first
+	entries    map[string]*list.Element // rules JSON -> element holding a *contentFilterCacheEntry
+}
+
+type contentFilterCacheEntry struct {
+	rulesJSON string
+	filter    *contentFilter
+}
+
+func newContentFilterCache(maxEntries int) *contentFilterCache {
+	return &contentFilterCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the compiled filter for a JSON list of rules, or nil if it isn't cached
+func (c *contentFilterCache) get(rulesJSON string) *contentFilter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[rulesJSON]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*contentFilterCacheEntry).filter
+}
+
+// put caches the compiled filter for a JSON list of rules, dropping the least recently used filter if full
+func (c *contentFilterCache) put(rulesJSON string, filter *contentFilter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[rulesJSON]; ok {
+		c.order.Remove(element)
+	}
+	c.entries[rulesJSON] = c.order.PushFront(&contentFilterCacheEntry{rulesJSON: rulesJSON, filter: filter})
+
+	for c.order.Len() > c.maxEntries {
+		entry := c.order.Remove(c.order.Back()).(*contentFilterCacheEntry)
+		delete(c.entries, entry.rulesJSON)
+	}
+}
+
+// parseContentFilter parses and compiles a JSON list of content filter rules. An empty string is an empty filter.
+func parseContentFilter(rulesJSON string) (*contentFilter, error) {
+	rulesJSON = strings.TrimSpace(rulesJSON)
+	if cached := compiledFilters.get(rulesJSON); cached != nil {
+		return cached, nil
+	}
+
+	var rules []contentFilterRule
+	if rulesJSON != "" {
+		if err := json.Unmarshal([]byte(rulesJSON), &rules); err != nil {
+			return nil, errors.Wrap(err, "content filters must be a JSON list of rules")
+		}
+	}
+
+	filter, err := compileContentFilter(rules)
+	if err != nil {
+		return nil, err
+	}
+	compiledFilters.put(rulesJSON, filter)
+	return filter, nil
+}
+
+// compileContentFilter validates and compiles content filter rules
+func compileContentFilter(rules []contentFilterRule) (*contentFilter, error) {
+	filter := &contentFilter{}
+	for i, rule := range rules {
+		compiled := compiledFilterRule{contentFilterRule: rule}
+
+		if rule.Direction == "" {
+			compiled.Direction = command.SyncDirectionBoth
+		} else if !command.IsValidSyncDirection(rule.Direction) {
+			return nil, errors.Errorf("rule %d: unknown direction %q", i+1, rule.Direction)
+		}
+
+		switch rule.Action {
+		case filterActionDrop, filterActionReplace:
+			if rule.Pattern == "" {
+				return nil, errors.Errorf("rule %d: %s needs a pattern", i+1, rule.Action)
+			}
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %d: invalid pattern", i+1)
+			}
+			compiled.pattern = pattern
+			if rule.Action == filterActionReplace && rule.Replacement == "" {
+				compiled.Replacement = defaultFilterReplacement
+			}
+		case filterActionAllowSenders, filterActionDenySenders:
+			if len(rule.Senders) == 0 {
+				return nil, errors.Errorf("rule %d: %s needs senders", i+1, rule.Action)
+			}
+			compiled.senders = make(map[string]bool, len(rule.Senders))
+			for _, sender := range rule.Senders {
+				compiled.senders[strings.ToLower(strings.TrimSpace(sender))] = true
+			}
+		case filterActionDropTypes:
+			if len(rule.Types) == 0 {
+				return nil, errors.Errorf("rule %d: %s needs types", i+1, rule.Action)
+			}
+			compiled.types = make(map[string]bool, len(rule.Types))
+			for _, postType := range rule.Types {
+				compiled.types[strings.TrimSpace(postType)] = true
+			}
+		default:
+			return nil, errors.Errorf("rule %d: unknown action %q", i+1, rule.Action)
+		}
+
+		filter.rules = append(filter.rules, compiled)
+	}
+	return filter, nil
+}
+
+// apply runs the rules for a sync direction over a message. Returns false if the message must be dropped,
+// and whether replace rules changed its text.
+func (f *contentFilter) apply(direction string, msg *filterMessage) (keep bool, redacted bool) {
+	for _, rule := range f.rules {
+		if rule.Direction != command.SyncDirectionBoth && rule.Direction != direction {
+			continue
+		}
+
+		switch rule.Action {
+		case filterActionDrop:
+			if rule.pattern.MatchString(msg.Text) {
+				return false, redacted
+			}
+		case filterActionReplace:
+			if replaced := rule.pattern.ReplaceAllString(msg.Text, rule.Replacement); replaced != msg.Text {
+				msg.Text = replaced
+				redacted = true
+			}
+		case filterActionAllowSenders:
+			if !rule.matchesSender(msg.SenderIDs) {
+				return false, redacted
+			}
+		case filterActionDenySenders:
+			if rule.matchesSender(msg.SenderIDs) {
+				return false, redacted
+			}
+		case filterActionDropTypes:
+			if rule.types[msg.Type] {
+				return false, redacted
+			}
+		}
+	}
+	return true, redacted
+}
+
+// matchesSender reports whether any of a sender's identifiers is listed in the rule. Usernames may be
+// listed with or without a leading @.
+func (r *compiledFilterRule) matchesSender(senderIDs []string) bool {
+	for _, id := range senderIDs {
+		id = strings.ToLower(id)
+		if r.senders[id] || r.senders["@"+id] {
+			return true
+		}
+	}
+	return false
+}
+
+// contentFilterMetrics counts the messages content filters dropped or redacted, per sync direction
+type contentFilterMetrics struct {
+	mu       sync.Mutex
+	dropped  map[string]int64
+	redacted map[string]int64
+}
+
+// contentFilterCounts is the JSON form of the content filter metrics for one direction
+type contentFilterCounts struct {
+	Dropped  int64 `json:"dropped"`
+	Redacted int64 `json:"redacted"`
+}
+
+func newContentFilterMetrics() *contentFilterMetrics {
+	return &contentFilterMetrics{
+		dropped:  make(map[string]int64),
+		redacted: make(map[string]int64),
+	}
+}
+
+func (m *contentFilterMetrics) record(direction string, keep, redacted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !keep {
+		m.dropped[direction]++
+	} else if redacted {
+		m.redacted[direction]++
+	}
+}
+
+// snapshot returns the current counts by direction
+func (m *contentFilterMetrics) snapshot() map[string]contentFilterCounts {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]contentFilterCounts)
+	for _, direction := range []string{command.SyncDirectionToMatrix, command.SyncDirectionToMattermost} {
+		counts[direction] = contentFilterCounts{Dropped: m.dropped[direction], Redacted: m.redacted[direction]}
+	}
+	return counts
+}
+
+// getChannelContentFilter returns the content filter rules configured for a channel's mapping
+func (s *BridgeUtils) getChannelContentFilter(channelID string) (*contentFilter, error) {
+	data, err := s.kvstore.Get(kvstore.BuildContentFilterKey(channelID))
+	if err != nil || len(data) == 0 {
+		return &contentFilter{}, nil
+	}
+	return parseContentFilter(string(data))
+}
+
+// filterContent runs a message through the global content filters and then those of the channel's mapping.
+// Returns false if the message must not be bridged. The message text is rewritten in place.
+func (s *BridgeUtils) filterContent(channelID, direction string, msg *filterMessage) bool {
+	global, err := parseContentFilter(s.getConfiguration().ContentFilters)
+	if err != nil {
+		// Invalid settings are rejected when saved, so this only happens with a config edited on disk
+		s.logger.LogWarn("Ignoring invalid global content filters", "error", err)
+		global = &contentFilter{}
+	}
+
+	channel, err := s.getChannelContentFilter(channelID)
+	if err != nil {
+		s.logger.LogWarn("Ignoring invalid content filters for channel", "error", err, "channel_id", channelID)
+		channel = &contentFilter{}
+	}
+
+	keep, redacted := global.apply(direction, msg)
+	if keep {
+		var channelRedacted bool
+		keep, channelRedacted = channel.apply(direction, msg)
+		redacted = redacted || channelRedacted
+	}
+
+	if s.filterMetrics != nil {
+		s.filterMetrics.record(direction, keep, redacted)
+	}
+	if !keep {
+		s.logger.LogDebug("Content filter dropped message", "channel_id", channelID, "direction", direction)
+	}
+	return keep
+}
+
+// handleGetContentFilters handles GET requests for the content filter rules of a channel's mapping
+func (p *Plugin) handleGetContentFilters(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := mux.Vars(r)["channelId"]
+
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	rules := []contentFilterRule{}
+	if data, err := p.kvstore.Get(kvstore.BuildContentFilterKey(channelID)); err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			p.logger.LogError("Failed to read content filters", "error", err, "channel_id", channelID)
+			http.Error(w, "Failed to read content filters", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	p.writeJSON(w, rules)
+}
+
+// handleSetContentFilters handles PUT requests replacing the content filter rules of a channel's mapping.
+// An empty list removes them.
+func (p *Plugin) handleSetContentFilters(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := mux.Vars(r)["channelId"]
+
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID); err != nil || roomID == "" {
+		http.Error(w, "Channel is not mapped to a Matrix room", http.StatusNotFound)
+		return
+	}
+
+	var rules []contentFilterRule
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, "Body must be a JSON list of content filter rules", http.StatusBadRequest)
+		return
+	}
+	if _, err := compileContentFilter(rules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := kvstore.BuildContentFilterKey(channelID)
+	if len(rules) == 0 {
+		if err := p.kvstore.Delete(key); err != nil {
+			p.logger.LogError("Failed to delete content filters", "error", err, "channel_id", channelID)
+			http.Error(w, "Failed to save content filters", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		data, err := json.Marshal(rules)
+		if err != nil {
+			http.Error(w, "Failed to save content filters", http.StatusInternalServerError)
+			return
+		}
+		if err := p.kvstore.Set(key, data); err != nil {
+			p.logger.LogError("Failed to save content filters", "error", err, "channel_id", channelID)
+			http.Error(w, "Failed to save content filters", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	p.logger.LogInfo("Content filters changed", "channel_id", channelID, "rule_count", len(rules), "user_id", userID)
+	if rules == nil {
+		rules = []contentFilterRule{}
+	}
+	p.writeJSON(w, rules)
+}
+
+// handleGetMetrics handles GET requests for the bridge's metrics. Only system admins can read them.
+func (p *Plugin) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
+	if !p.API.HasPermissionTo(r.Header.Get("Mattermost-User-ID"), model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	p.writeJSON(w, map[string]any{
+		"content_filters":     p.filterMetrics.snapshot(),
+		"inbound_rate_limits": p.inboundLimiter.snapshot(),
+	})
+}
+
+// writeJSON writes a JSON response
+func (p *Plugin) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		p.logger.LogError("Failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentFilter(t *testing.T) {
+	t.Run("empty settings are an empty filter", func(t *testing.T) {
+		filter, err := parseContentFilter("  ")
+		require.NoError(t, err)
+		assert.Empty(t, filter.rules)
+	})
+
+	invalid := map[string]string{
+		"not JSON":          `{"action": "drop"}`,
+		"unknown action":    `[{"action": "shout"}]`,
+		"bad pattern":       `[{"action": "drop", "pattern": "("}]`,
+		"missing pattern":   `[{"action": "replace"}]`,
+		"missing senders":   `[{"action": "deny_senders"}]`,
+		"missing types":     `[{"action": "drop_types"}]`,
+		"unknown direction": `[{"action": "drop", "pattern": "x", "direction": "sideways"}]`,
+	}
+	for name, rules := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseContentFilter(rules)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestContentFilterCache(t *testing.T) {
+	cache := newContentFilterCache(2)
+	a, b, c := &contentFilter{}, &contentFilter{}, &contentFilter{}
+	cache.put("a", a)
+	cache.put("b", b)
+
+	// Using a makes b the least recently used, so b goes when c is added
+	require.Same(t, a, cache.get("a"))
+	cache.put("c", c)
+	assert.Same(t, a, cache.get("a"))
+	assert.Nil(t, cache.get("b"))
+	assert.Same(t, c, cache.get("c"))
+	assert.Equal(t, 2, cache.order.Len())
+}
+
+func TestContentFilterApply(t *testing.T) {
+	filter, err := parseContentFilter(`[
+		{"action": "drop", "pattern": "#internal\\b", "direction": "to-matrix"},
+		{"action": "replace", "pattern": "[a-z0-9-]+\\.corp\\.example\\.com"},
+		{"action": "replace", "pattern": "JIRA-\\d+", "replacement": "<ticket>"},
+		{"action": "deny_senders", "senders": ["@github:matrix.org", "@build-bot"]},
+		{"action": "drop_types", "types": ["m.notice", "system_join_channel"], "direction": "to-mattermost"}
+	]`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		direction    string
+		msg          filterMessage
+		keep         bool
+		redacted     bool
+		expectedText string
+	}{
+		{
+			name:      "drop rules match anywhere in the text",
+			direction: command.SyncDirectionToMatrix,
+			msg:       filterMessage{SenderIDs: []string{"id", "alice"}, Text: "release notes #internal"},
+			keep:      false,
+		},
+		{
+			name:         "rules limited to one direction don't apply to the other",
+			direction:    command.SyncDirectionToMattermost,
+			msg:          filterMessage{SenderIDs: []string{"@alice:matrix.org"}, Text: "#internal"},
+			keep:         true,
+			expectedText: "#internal",
+		},
+		{
+			name:         "replace rules redact matches",
+			direction:    command.SyncDirectionToMatrix,
+			msg:          filterMessage{SenderIDs: []string{"id", "alice"}, Text: "see build-01.corp.example.com for JIRA-42"},
+			keep:         true,
+			redacted:     true,
+			expectedText: "see [redacted] for <ticket>",
+		},
+		{
+			name:      "denied Matrix senders are dropped",
+			direction: command.SyncDirectionToMattermost,
+			msg:       filterMessage{SenderIDs: []string{"@GitHub:matrix.org"}, Text: "push"},
+			keep:      false,
+		},
+		{
+			name:      "denied usernames match with or without @",
+			direction: command.SyncDirectionToMatrix,
+			msg:       filterMessage{SenderIDs: []string{"id", "build-bot"}, Text: "build passed"},
+			keep:      false,
+		},
+		{
+			name:      "dropped types",
+			direction: command.SyncDirectionToMattermost,
+			msg:       filterMessage{SenderIDs: []string{"@bot:matrix.org"}, Type: "m.notice", Text: "noise"},
+			keep:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.msg
+			keep, redacted := filter.apply(tt.direction, &msg)
+			assert.Equal(t, tt.keep, keep)
+			assert.Equal(t, tt.redacted, redacted)
+			if tt.keep {
+				assert.Equal(t, tt.expectedText, msg.Text)
+			}
+		})
+	}
+
+	t.Run("allow lists drop everyone else", func(t *testing.T) {
+		allow, err := parseContentFilter(`[{"action": "allow_senders", "senders": ["alice"]}]`)
+		require.NoError(t, err)
+
+		keep, _ := allow.apply(command.SyncDirectionToMatrix, &filterMessage{SenderIDs: []string{"id1", "alice"}})
+		assert.True(t, keep)
+		keep, _ = allow.apply(command.SyncDirectionToMatrix, &filterMessage{SenderIDs: []string{"id2", "bob"}})
+		assert.False(t, keep)
+	})
+}
+
+func TestFilterContent(t *testing.T) {
+	plugin, _, channelID, _ := setupSyncDirectionTest(t)
+	plugin.configuration.ContentFilters = `[{"action": "replace", "pattern": "secret", "replacement": "***"}]`
+	require.NoError(t, plugin.kvstore.Set(kvstore.BuildContentFilterKey(channelID), []byte(`[{"action": "drop", "pattern": "#internal"}]`)))
+
+	msg := &filterMessage{SenderIDs: []string{"@bob:matrix.org"}, Text: "the secret word"}
+	assert.True(t, plugin.matrixToMattermostBridge.filterContent(channelID, command.SyncDirectionToMattermost, msg))
+	assert.Equal(t, "the *** word", msg.Text)
+
+	assert.False(t, plugin.matrixToMattermostBridge.filterContent(channelID, command.SyncDirectionToMattermost, &filterMessage{Text: "#internal"}))
+
+	// Mapping rules only apply to their own channel
+	assert.True(t, plugin.matrixToMattermostBridge.filterContent(model.NewId(), command.SyncDirectionToMattermost, &filterMessage{Text: "#internal"}))
+
+	counts := plugin.filterMetrics.snapshot()
+	assert.Equal(t, contentFilterCounts{Dropped: 1, Redacted: 1}, counts[command.SyncDirectionToMattermost])
+	assert.Equal(t, contentFilterCounts{}, counts[command.SyncDirectionToMatrix])
+}
+
+func TestMatrixMessageContentFilter(t *testing.T) {
+	plugin, _, _, requests := setupSyncDirectionTest(t)
+	plugin.configuration.ContentFilters = `[{"action": "deny_senders", "senders": ["@github:matrix.org"]}]`
+
+	// A denied sender never gets a Mattermost user or a post, which would call the unmocked plugin API
+	err := plugin.processMatrixEvent(MatrixEvent{
+		EventID: "$push",
+		Type:    "m.room.message",
+		Sender:  "@github:matrix.org",
+		RoomID:  "!room:test.com",
+		Content: map[string]any{"msgtype": "m.text", "body": "pushed 3 commits"},
+	})
+	require.NoError(t, err)
+	assert.Zero(t, *requests)
+	assert.Equal(t, int64(1), plugin.filterMetrics.snapshot()[command.SyncDirectionToMattermost].Dropped)
+}
+
+func TestMattermostPostContentFilter(t *testing.T) {
+	plugin, api, channelID, requests := setupSyncDirectionTest(t)
+	require.NoError(t, plugin.kvstore.Set(kvstore.BuildContentFilterKey(channelID), []byte(`[{"action": "drop", "pattern": "#internal"}]`)))
+	user := &model.User{Id: model.NewId(), Username: "alice"}
+	api.On("GetUser", user.Id).Return(user, nil)
+
+	err := plugin.mattermostToMatrixBridge.SyncPostToMatrix(&model.Post{Id: model.NewId(), ChannelId: channelID, UserId: user.Id, Message: "Q3 numbers #internal"}, channelID)
+	require.NoError(t, err)
+	assert.Zero(t, *requests)
+	assert.Equal(t, int64(1), plugin.filterMetrics.snapshot()[command.SyncDirectionToMatrix].Dropped)
+}
+
+func TestContentFiltersAPI(t *testing.T) {
+	serve := func(plugin *Plugin, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", "admin-id")
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("admins can set and read a mapping's filters", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+
+		w := serve(plugin, http.MethodPut, "/api/v1/mappings/"+channelID+"/filters", `[{"action": "drop", "pattern": "#internal"}]`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = serve(plugin, http.MethodGet, "/api/v1/mappings/"+channelID+"/filters", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var rules []contentFilterRule
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rules))
+		assert.Equal(t, []contentFilterRule{{Action: filterActionDrop, Pattern: "#internal"}}, rules)
+
+		w = serve(plugin, http.MethodPut, "/api/v1/mappings/"+channelID+"/filters", `[]`)
+		require.Equal(t, http.StatusOK, w.Code)
+		stored, _ := plugin.kvstore.Get(kvstore.BuildContentFilterKey(channelID))
+		assert.Empty(t, stored)
+	})
+
+	t.Run("invalid rules are rejected", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+
+		w := serve(plugin, http.MethodPut, "/api/v1/mappings/"+channelID+"/filters", `[{"action": "drop", "pattern": "("}]`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("other users are refused", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(false)
+
+		assert.Equal(t, http.StatusForbidden, serve(plugin, http.MethodPut, "/api/v1/mappings/"+channelID+"/filters", `[]`).Code)
+		assert.Equal(t, http.StatusForbidden, serve(plugin, http.MethodGet, "/api/v1/metrics", "").Code)
+	})
+
+	t.Run("metrics report filtered messages", func(t *testing.T) {
+		plugin, api, _, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+		plugin.filterMetrics.record(command.SyncDirectionToMatrix, false, false)
+
+		w := serve(plugin, http.MethodGet, "/api/v1/metrics", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"content_filters": {"to-matrix": {"dropped": 1, "redacted": 0}, "to-mattermost": {"dropped": 0, "redacted": 0}}, "inbound_rate_limits": {"dropped": 0, "muted": 0, "muted_senders": 0}}`, w.Body.String())
+	})
+}
+
+func TestMattermostPostContentFilterRedaction(t *testing.T) {
+	var sentBody string
+	plugin, api, channelID := setupMappedChannelTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/send/m.room.message/") {
+			var content map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&content)
+			sentBody, _ = content["body"].(string)
+			_, _ = w.Write([]byte(`{"event_id":"$sent"}`))
+			return
+		}
+		http.Error(w, `{"errcode":"M_UNKNOWN"}`, http.StatusInternalServerError)
+	}))
+	plugin.configuration.RelayMode = true
+	plugin.configuration.ContentFilters = `[{"action": "replace", "pattern": "hunter2", "replacement": "***"}]`
+
+	user := &model.User{Id: model.NewId(), Username: "alice"}
+	api.On("GetUser", user.Id).Return(user, nil)
+	api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Type: model.ChannelTypeOpen}, nil)
+	var updated *model.Post
+	api.On("UpdatePost", mock.Anything).Run(func(args mock.Arguments) {
+		updated = args.Get(0).(*model.Post)
+	}).Return(&model.Post{UpdateAt: 1}, nil)
+
+	post := &model.Post{Id: model.NewId(), ChannelId: channelID, UserId: user.Id, Message: "the password is hunter2"}
+	post.AddProp("attachments", []*model.SlackAttachment{{Text: "hunter2 again"}})
+	require.NoError(t, plugin.mattermostToMatrixBridge.SyncPostToMatrix(post, channelID))
+
+	// Matrix gets the filtered text of the message and its attachments
+	assert.Contains(t, sentBody, "the password is ***")
+	assert.Contains(t, sentBody, "*** again")
+	assert.NotContains(t, sentBody, "hunter2")
+
+	// The Mattermost post only gains the event ID
+	require.NotNil(t, updated)
+	assert.Equal(t, "the password is hunter2", updated.Message)
+	assert.Equal(t, "$sent", updated.GetProp("matrix_event_id_test_com"))
+	assert.Len(t, updated.Attachments(), 1)
+}
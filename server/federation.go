@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// errMatrixServerNotAllowed is returned when a Matrix user's server is excluded by the federation policy
+var errMatrixServerNotAllowed = errors.New("Matrix server is not allowed by the federation policy")
+
+// federationPolicy decides which Matrix servers the bridge accepts senders from. Entries are server names,
+// or *.example.com for any subdomain of example.com.
+type federationPolicy struct {
+	Allowed []string `json:"allowed"` // If set, only these servers are accepted
+	Denied  []string `json:"denied"`  // These servers are never accepted
+}
+
+// getFederationPolicy returns the global federation policy from the plugin settings
+func (c *configuration) getFederationPolicy() *federationPolicy {
+	return &federationPolicy{
+		Allowed: splitSettingList(c.FederationAllowedServers),
+		Denied:  splitSettingList(c.FederationDeniedServers),
+	}
+}
+
+// validate checks that every entry is a server name or wildcard
+func (f *federationPolicy) validate() error {
+	for _, entry := range append(append([]string{}, f.Allowed...), f.Denied...) {
+		if entry == "" || strings.ContainsAny(entry, "@/ ") || (strings.Contains(entry, "*") && entry != "*" && !strings.HasPrefix(entry, "*.")) {
+			return errors.Errorf("invalid server name %q", entry)
+		}
+	}
+	return nil
+}
+
+// allows reports whether the policy accepts a server. The deny list wins over the allow list.
+func (f *federationPolicy) allows(serverName string) bool {
+	if serverName == "" {
+		return false
+	}
+	if matchesServerList(f.Denied, serverName) {
+		return false
+	}
+	return len(f.Allowed) == 0 || matchesServerList(f.Allowed, serverName)
+}
+
+// matchesServerList reports whether a server name matches any entry of a list
+func matchesServerList(entries []string, serverName string) bool {
+	serverName = strings.ToLower(serverName)
+	for _, entry := range entries {
+		entry = strings.ToLower(entry)
+		switch {
+		case entry == "*", entry == serverName:
+			return true
+		case strings.HasPrefix(entry, "*.") && strings.HasSuffix(serverName, entry[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// matrixServerName returns the server name of a Matrix user ID, without any port
+func matrixServerName(matrixUserID string) string {
+	_, serverName, found := strings.Cut(matrixUserID, ":")
+	if !found {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(serverName); err == nil {
+		return host
+	}
+	return serverName
+}
+
+// getChannelFederationPolicy returns the federation policy of a channel's mapping, or nil if it has none
+func (s *BridgeUtils) getChannelFederationPolicy(channelID string) *federationPolicy {
+	if channelID == "" {
+		return nil
+	}
+	data, err := s.kvstore.Get(kvstore.BuildFederationPolicyKey(channelID))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var policy federationPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		s.logger.LogWarn("Ignoring invalid federation policy for channel", "error", err, "channel_id", channelID)
+		return nil
+	}
+	return &policy
+}
+
+// allowsMatrixSender reports whether the global federation policy, and the policy of the channel's mapping if
+// it has one, accept a Matrix user. Ghost users always pass; they're the bridge's own.
+func (s *BridgeUtils) allowsMatrixSender(matrixUserID, channelID string) bool {
+	if s.isGhostUser(matrixUserID) {
+		return true
+	}
+
+	serverName := matrixServerName(matrixUserID)
+	if !s.getConfiguration().getFederationPolicy().allows(serverName) {
+		return false
+	}
+	if policy := s.getChannelFederationPolicy(channelID); policy != nil && !policy.allows(serverName) {
+		return false
+	}
+	return true
+}
+
+// DeactivateDisallowedMatrixUsers deactivates the Mattermost users of Matrix users whose server the global
+// federation policy no longer accepts. With preview set, they are reported without being deactivated.
+func (p *Plugin) DeactivateDisallowedMatrixUsers(preview bool) (*command.FederationDeactivationResult, error) {
+	policy := p.getConfiguration().getFederationPolicy()
+	if len(policy.Allowed) == 0 && len(policy.Denied) == 0 {
+		return nil, command.ErrFederationPolicyDisabled
+	}
+
+	keys, err := p.listKeysWithPrefix(kvstore.KeyPrefixMatrixUser)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list Matrix users")
+	}
+
+	result := &command.FederationDeactivationResult{}
+	for _, key := range keys {
+		matrixUserID := strings.TrimPrefix(key, kvstore.KeyPrefixMatrixUser)
+		if policy.allows(matrixServerName(matrixUserID)) {
+			continue
+		}
+
+		data, err := p.kvstore.Get(key)
+		if err != nil || len(data) == 0 || p.matrixToMattermostBridge.getAccountLink(string(data)) != nil {
+			// Unmapped, or linked to an existing Mattermost account the bridge didn't create
+			continue
+		}
+		user, appErr := p.API.GetUser(string(data))
+		if appErr != nil || user.DeleteAt != 0 {
+			// Already gone or deactivated
+			continue
+		}
+
+		if preview {
+			result.Deactivated = append(result.Deactivated, matrixUserID)
+			continue
+		}
+
+		if appErr := p.API.UpdateUserActive(user.Id, false); appErr != nil {
+			p.logger.LogError("Failed to deactivate Matrix user", "error", appErr, "user_id", user.Id, "matrix_user_id", matrixUserID)
+			result.Failed = append(result.Failed, matrixUserID)
+			continue
+		}
+		p.logger.LogInfo("Deactivated user from disallowed Matrix server", "user_id", user.Id, "matrix_user_id", matrixUserID)
+		result.Deactivated = append(result.Deactivated, matrixUserID)
+	}
+
+	return result, nil
+}
+
+// handleGetFederationPolicy handles GET requests for the federation policy of a channel's mapping
+func (p *Plugin) handleGetFederationPolicy(w http.ResponseWriter, r *http.Request) {
+	channelID := mux.Vars(r)["channelId"]
+
+	if !p.API.HasPermissionTo(r.Header.Get("Mattermost-User-ID"), model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	policy := p.mattermostToMatrixBridge.getChannelFederationPolicy(channelID)
+	if policy == nil {
+		policy = &federationPolicy{}
+	}
+	p.writeJSON(w, policy)
+}
+
+// handleSetFederationPolicy handles PUT requests replacing the federation policy of a channel's mapping. An
+// empty policy removes it, leaving only the global one.
+func (p *Plugin) handleSetFederationPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := mux.Vars(r)["channelId"]
+
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID); err != nil || roomID == "" {
+		http.Error(w, "Channel is not mapped to a Matrix room", http.StatusNotFound)
+		return
+	}
+
+	var policy federationPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Body must be a JSON object with allowed and denied server lists", http.StatusBadRequest)
+		return
+	}
+	if err := policy.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := kvstore.BuildFederationPolicyKey(channelID)
+	if len(policy.Allowed) == 0 && len(policy.Denied) == 0 {
+		if err := p.kvstore.Delete(key); err != nil {
+			p.logger.LogError("Failed to delete federation policy", "error", err, "channel_id", channelID)
+			http.Error(w, "Failed to save federation policy", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		data, err := json.Marshal(policy)
+		if err != nil {
+			http.Error(w, "Failed to save federation policy", http.StatusInternalServerError)
+			return
+		}
+		if err := p.kvstore.Set(key, data); err != nil {
+			p.logger.LogError("Failed to save federation policy", "error", err, "channel_id", channelID)
+			http.Error(w, "Failed to save federation policy", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	p.logger.LogInfo("Federation policy changed", "channel_id", channelID, "allowed", policy.Allowed, "denied", policy.Denied, "user_id", userID)
+	p.writeJSON(w, policy)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFederationPolicy(t *testing.T) {
+	t.Run("no lists accept every server", func(t *testing.T) {
+		policy := (&configuration{}).getFederationPolicy()
+		assert.True(t, policy.allows("matrix.org"))
+		assert.False(t, policy.allows(""))
+	})
+
+	t.Run("allow lists accept only listed servers", func(t *testing.T) {
+		policy := (&configuration{FederationAllowedServers: "example.com, *.example.org"}).getFederationPolicy()
+		assert.True(t, policy.allows("example.com"))
+		assert.True(t, policy.allows("Chat.Example.org"))
+		assert.False(t, policy.allows("example.org"))
+		assert.False(t, policy.allows("matrix.org"))
+	})
+
+	t.Run("deny lists win over allow lists", func(t *testing.T) {
+		policy := (&configuration{FederationAllowedServers: "*", FederationDeniedServers: "spam.example"}).getFederationPolicy()
+		assert.True(t, policy.allows("matrix.org"))
+		assert.False(t, policy.allows("spam.example"))
+	})
+
+	t.Run("invalid entries are rejected", func(t *testing.T) {
+		assert.NoError(t, (&configuration{FederationDeniedServers: "*.spam.example, spam.example"}).getFederationPolicy().validate())
+		assert.Error(t, (&configuration{FederationDeniedServers: "@spammer:spam.example"}).getFederationPolicy().validate())
+		assert.Error(t, (&configuration{FederationAllowedServers: "matrix.*"}).getFederationPolicy().validate())
+	})
+}
+
+func TestMatrixServerName(t *testing.T) {
+	assert.Equal(t, "matrix.org", matrixServerName("@alice:matrix.org"))
+	assert.Equal(t, "example.com", matrixServerName("@bob:example.com:8448"))
+	assert.Equal(t, "", matrixServerName("alice"))
+}
+
+func TestFederationPolicyEnforcement(t *testing.T) {
+	messageFrom := func(sender string) MatrixEvent {
+		return MatrixEvent{
+			EventID: "$message",
+			Type:    "m.room.message",
+			Sender:  sender,
+			RoomID:  "!room:test.com",
+			Content: map[string]any{"msgtype": "m.text", "body": "buy now"},
+		}
+	}
+
+	t.Run("denied servers are dropped before user creation", func(t *testing.T) {
+		plugin, _, _, requests := setupSyncDirectionTest(t)
+		plugin.configuration.FederationDeniedServers = "spam.example"
+
+		// Creating a user would call the unmocked plugin API
+		require.NoError(t, plugin.processMatrixEvent(messageFrom("@spammer:spam.example")))
+		assert.Zero(t, *requests)
+
+		_, err := plugin.matrixToMattermostBridge.getOrCreateMattermostUser("@spammer:spam.example", "")
+		assert.ErrorIs(t, err, errMatrixServerNotAllowed)
+	})
+
+	t.Run("mappings can narrow the policy", func(t *testing.T) {
+		plugin, _, channelID, requests := setupSyncDirectionTest(t)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildFederationPolicyKey(channelID), []byte(`{"allowed": ["example.com"]}`)))
+
+		require.NoError(t, plugin.processMatrixEvent(messageFrom("@alice:matrix.org")))
+		assert.Zero(t, *requests)
+
+		assert.True(t, plugin.matrixToMattermostBridge.allowsMatrixSender("@alice:matrix.org", model.NewId()))
+		assert.True(t, plugin.matrixToMattermostBridge.allowsMatrixSender("@bob:example.com", channelID))
+	})
+
+	t.Run("ghost users always pass", func(t *testing.T) {
+		plugin, _, _, _ := setupSyncDirectionTest(t)
+		plugin.configuration.FederationAllowedServers = "example.com"
+
+		assert.True(t, plugin.matrixToMattermostBridge.allowsMatrixSender("@_mattermost_"+model.NewId()+":test.com", ""))
+	})
+}
+
+func TestDeactivateDisallowedMatrixUsers(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *plugintest.API, *model.User) {
+		plugin, api, _, _ := setupSyncDirectionTest(t)
+		plugin.configuration.FederationDeniedServers = "spam.example"
+
+		spammer := &model.User{Id: model.NewId()}
+		friend := &model.User{Id: model.NewId()}
+		deactivated := &model.User{Id: model.NewId(), DeleteAt: 1}
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey("@spammer:spam.example"), []byte(spammer.Id)))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey("@old:spam.example"), []byte(deactivated.Id)))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey("@friend:matrix.org"), []byte(friend.Id)))
+		api.On("GetUser", spammer.Id).Return(spammer, nil)
+		api.On("GetUser", deactivated.Id).Return(deactivated, nil)
+		return plugin, api, spammer
+	}
+
+	t.Run("preview lists users without deactivating them", func(t *testing.T) {
+		plugin, _, _ := setup(t)
+
+		// UpdateUserActive is not mocked, so deactivating would panic
+		result, err := plugin.DeactivateDisallowedMatrixUsers(true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"@spammer:spam.example"}, result.Deactivated)
+	})
+
+	t.Run("users from denied servers are deactivated", func(t *testing.T) {
+		plugin, api, spammer := setup(t)
+		api.On("UpdateUserActive", spammer.Id, false).Return(nil).Once()
+
+		result, err := plugin.DeactivateDisallowedMatrixUsers(false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"@spammer:spam.example"}, result.Deactivated)
+		assert.Empty(t, result.Failed)
+	})
+
+	t.Run("no policy is an error", func(t *testing.T) {
+		plugin, _, _ := setup(t)
+		plugin.configuration.FederationDeniedServers = ""
+
+		_, err := plugin.DeactivateDisallowedMatrixUsers(true)
+		assert.ErrorIs(t, err, command.ErrFederationPolicyDisabled)
+	})
+}
+
+func TestFederationPolicyAPI(t *testing.T) {
+	serve := func(plugin *Plugin, method, channelID, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/api/v1/mappings/"+channelID+"/federation", strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", "admin-id")
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("admins can set and read a mapping's policy", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+
+		w := serve(plugin, http.MethodPut, channelID, `{"denied": ["spam.example"]}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.False(t, plugin.matrixToMattermostBridge.allowsMatrixSender("@spammer:spam.example", channelID))
+
+		w = serve(plugin, http.MethodGet, channelID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var policy federationPolicy
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+		assert.Equal(t, []string{"spam.example"}, policy.Denied)
+
+		w = serve(plugin, http.MethodPut, channelID, `{}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		stored, _ := plugin.kvstore.Get(kvstore.BuildFederationPolicyKey(channelID))
+		assert.Empty(t, stored)
+	})
+
+	t.Run("invalid server names are rejected", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+
+		w := serve(plugin, http.MethodPut, channelID, `{"denied": ["@spammer:spam.example"]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("other users are refused", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(false)
+
+		assert.Equal(t, http.StatusForbidden, serve(plugin, http.MethodPut, channelID, `{}`).Code)
+	})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// syncDirectRoomMembership follows membership changes in Matrix rooms bridged to DMs and group messages.
+// Mattermost can't add or remove members of a DM or GM, so when the room's members change it is remapped
+// to the DM or GM for the new set of members instead. Returns false for rooms bridged to regular channels,
+// which are left to the regular member sync.
+func (p *Plugin) syncDirectRoomMembership(event MatrixEvent, channelID string) (bool, error) {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to get channel")
+	}
+	if channel.Type != model.ChannelTypeDirect && channel.Type != model.ChannelTypeGroup {
+		return false, nil
+	}
+
+	membership, _ := event.Content["membership"].(string)
+	if event.StateKey == nil || (membership != "join" && membership != "invite" && membership != "leave" && membership != "ban") {
+		return true, nil
+	}
+
+	_, channelMemberIDs, err := p.mattermostToMatrixBridge.isDirectChannel(channelID)
+	if err != nil {
+		return true, errors.Wrap(err, "failed to get channel members")
+	}
+
+	roomMembers, err := p.getDirectRoomMembers(event.RoomID, channelMemberIDs)
+	if err != nil {
+		return true, errors.Wrap(err, "failed to get Matrix room members")
+	}
+
+	memberIDs, matrixUserIDs := p.mattermostUsersForRoomMembers(roomMembers)
+
+	if sameMembers(memberIDs, channelMemberIDs) {
+		// Nothing to remap - a join here is a profile change or a member accepting their invite
+		if membership == "join" {
+			return true, p.matrixToMattermostBridge.syncMatrixMemberEventToMattermost(event, channelID)
+		}
+		return true, nil
+	}
+
+	if len(memberIDs) < 2 {
+		p.logger.LogDebug("Matrix DM room has too few members to remap", "room_id", event.RoomID, "channel_id", channelID, "member_count", len(memberIDs))
+		return true, nil
+	}
+
+	if len(memberIDs) > model.ChannelGroupMaxUsers {
+		p.logger.LogWarn("Matrix DM room has more members than a group message allows", "room_id", event.RoomID, "channel_id", channelID, "member_count", len(memberIDs))
+		p.sendDirectRoomNotice(event.RoomID, fmt.Sprintf("This conversation now has %d members, but Mattermost group messages allow at most %d. New messages won't reach Mattermost until members leave.", len(memberIDs), model.ChannelGroupMaxUsers))
+		return true, nil
+	}
+
+	var newChannel *model.Channel
+	if len(memberIDs) == 2 {
+		newChannel, appErr = p.API.GetDirectChannel(memberIDs[0], memberIDs[1])
+	} else {
+		newChannel, appErr = p.API.GetGroupChannel(memberIDs)
+	}
+	if appErr != nil {
+		return true, errors.Wrap(appErr, "failed to get channel for new members")
+	}
+
+	if err := p.remapDirectRoom(event.RoomID, channelID, newChannel.Id); err != nil {
+		return true, errors.Wrap(err, "failed to remap Matrix room")
+	}
+
+	// Mattermost participants are in the new channel already, so their ghosts join the room with them
+	for _, memberID := range memberIDs {
+		ghostUserID, exists := p.getGhostUser(memberID)
+		if !exists {
+			continue
+		}
+		if err := p.mattermostToMatrixBridge.ensureGhostUserInRoom(ghostUserID, event.RoomID, memberID); err != nil {
+			p.logger.LogWarn("Failed to join ghost user to remapped Matrix room", "error", err, "ghost_user_id", ghostUserID, "room_id", event.RoomID)
+		}
+	}
+
+	var departedUserIDs []string
+	if membership == "leave" || membership == "ban" {
+		departedUserIDs = append(departedUserIDs, *event.StateKey)
+	}
+	p.mattermostToMatrixBridge.updateGhostDirectRooms(event.RoomID, matrixUserIDs, departedUserIDs)
+
+	p.logger.LogInfo("Remapped Matrix room to follow membership change", "room_id", event.RoomID, "old_channel_id", channelID, "new_channel_id", newChannel.Id, "member_count", len(memberIDs))
+	return true, nil
+}
+
+// getDirectRoomMembers reads the members of a DM room. The bridge bot isn't in rooms Matrix users started,
+// so the ghosts of the channel's Mattermost members are tried too.
+func (p *Plugin) getDirectRoomMembers(roomID string, channelMemberIDs []string) (map[string]string, error) {
+	readers := []string{""}
+	for _, memberID := range channelMemberIDs {
+		if ghostUserID, exists := p.getGhostUser(memberID); exists {
+			readers = append(readers, ghostUserID)
+		}
+	}
+
+	var lastErr error
+	for _, reader := range readers {
+		members, err := p.matrixClient.GetRoomMembersAsUser(roomID, reader)
+		if err == nil {
+			return members, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// mattermostUsersForRoomMembers maps joined and invited room members to Mattermost users, sorted by ID.
+// Returns the Mattermost user IDs and the Matrix user IDs they came from. The bridge bot isn't a participant.
+func (p *Plugin) mattermostUsersForRoomMembers(roomMembers map[string]string) ([]string, []string) {
+	botUserID, _ := p.matrixClient.GetBridgeBotUserID()
+
+	var memberIDs, matrixUserIDs []string
+	for matrixUserID, membership := range roomMembers {
+		if (membership != "join" && membership != "invite") || matrixUserID == botUserID {
+			continue
+		}
+
+		var mattermostUserID string
+		if p.isGhostUser(matrixUserID) {
+			mattermostUserID = p.extractMattermostUserIDFromGhost(matrixUserID)
+			if _, appErr := p.API.GetUser(mattermostUserID); appErr != nil {
+				p.logger.LogWarn("Ghost user in Matrix DM room has no Mattermost user", "ghost_user_id", matrixUserID)
+				continue
+			}
+		} else {
+			var err error
+			mattermostUserID, err = p.matrixToMattermostBridge.getOrCreateMattermostUser(matrixUserID, "")
+			if err != nil {
+				p.logger.LogWarn("Failed to get Mattermost user for Matrix DM room member", "error", err, "matrix_user_id", matrixUserID)
+				continue
+			}
+		}
+
+		if !slices.Contains(memberIDs, mattermostUserID) {
+			memberIDs = append(memberIDs, mattermostUserID)
+			matrixUserIDs = append(matrixUserIDs, matrixUserID)
+		}
+	}
+
+	sort.Strings(memberIDs)
+	sort.Strings(matrixUserIDs)
+	return memberIDs, matrixUserIDs
+}
+
+// remapDirectRoom moves a room's mapping from one DM or GM channel to another. The old channel keeps its
+// history but no longer syncs.
+func (p *Plugin) remapDirectRoom(roomID, oldChannelID, newChannelID string) error {
+	if oldChannelID == newChannelID {
+		return nil
+	}
+
+	if err := p.kvstore.Delete(kvstore.BuildChannelMappingKey(oldChannelID)); err != nil {
+		return errors.Wrap(err, "failed to delete old channel mapping")
+	}
+
+	if existingRoomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(newChannelID); err == nil && existingRoomID != "" && existingRoomID != roomID {
+		p.logger.LogWarn("Group message was already bridged to another Matrix room, replacing the mapping", "channel_id", newChannelID, "old_room_id", existingRoomID, "room_id", roomID)
+		// Otherwise events from the replaced room would still be delivered to the channel
+		if err := p.kvstore.Delete(kvstore.BuildRoomMappingKey(existingRoomID)); err != nil {
+			return errors.Wrap(err, "failed to delete replaced room mapping")
+		}
+	}
+
+	return p.mattermostToMatrixBridge.setChannelRoomMapping(newChannelID, roomID)
+}
+
+// sendDirectRoomNotice tells a DM room's Matrix members about something the bridge couldn't do
+func (p *Plugin) sendDirectRoomNotice(roomID, message string) {
+	botUserID, err := p.matrixClient.GetBridgeBotUserID()
+	if err != nil {
+		p.logger.LogWarn("Failed to get bridge bot user ID for notice", "error", err, "room_id", roomID)
+		return
+	}
+	if _, err := p.matrixClient.SendNotice(roomID, botUserID, message, ""); err != nil {
+		p.logger.LogWarn("Failed to send notice to Matrix DM room", "error", err, "room_id", roomID)
+	}
+}
+
+// updateGhostDirectRooms keeps the m.direct account data of a DM room's ghost users current, listing the room
+// under every other member so Matrix clients show it as a DM. Departed ghosts have the room removed.
+func (s *BridgeUtils) updateGhostDirectRooms(roomID string, memberUserIDs []string, departedUserIDs []string) {
+	for _, userID := range append(slices.Clone(memberUserIDs), departedUserIDs...) {
+		if !s.isGhostUser(userID) {
+			continue
+		}
+
+		direct, err := s.matrixClient.GetDirectRoomsAsUser(userID)
+		if err != nil {
+			s.logger.LogWarn("Failed to get ghost user's DM rooms", "error", err, "ghost_user_id", userID)
+			continue
+		}
+
+		// Drop the room everywhere first so members who left it are no longer listed
+		for otherUserID, roomIDs := range direct {
+			roomIDs = slices.DeleteFunc(roomIDs, func(id string) bool { return id == roomID })
+			if len(roomIDs) == 0 {
+				delete(direct, otherUserID)
+			} else {
+				direct[otherUserID] = roomIDs
+			}
+		}
+
+		if slices.Contains(memberUserIDs, userID) {
+			for _, otherUserID := range memberUserIDs {
+				if otherUserID != userID {
+					direct[otherUserID] = append(direct[otherUserID], roomID)
+				}
+			}
+		}
+
+		if err := s.matrixClient.SetDirectRoomsAsUser(userID, direct); err != nil {
+			s.logger.LogWarn("Failed to update ghost user's DM rooms", "error", err, "ghost_user_id", userID, "room_id", roomID)
+		}
+	}
+}
+
+// sameMembers reports whether two lists of user IDs contain the same users
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := slices.Clone(a)
+	sortedB := slices.Clone(b)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	return slices.Equal(sortedA, sortedB)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDirectRoomHomeserver serves room members and keeps the m.direct account data the bridge writes
+type fakeDirectRoomHomeserver struct {
+	mu      sync.Mutex
+	members map[string]string
+	direct  map[string]map[string][]string
+}
+
+func (f *fakeDirectRoomHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/members"):
+		var chunk []map[string]any
+		for userID, membership := range f.members {
+			chunk = append(chunk, map[string]any{
+				"type":      "m.room.member",
+				"state_key": userID,
+				"content":   map[string]any{"membership": membership},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"chunk": chunk})
+	case strings.HasSuffix(r.URL.Path, "/account_data/m.direct"):
+		userID, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(r.URL.EscapedPath(), "/_matrix/client/v3/user/"), "/account_data/m.direct"))
+		if r.Method == http.MethodPut {
+			body, _ := io.ReadAll(r.Body)
+			direct := map[string][]string{}
+			_ = json.Unmarshal(body, &direct)
+			f.direct[userID] = direct
+			_, _ = w.Write([]byte("{}"))
+			return
+		}
+		direct, ok := f.direct[userID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"Account data not found"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(direct)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeDirectRoomHomeserver) directRooms(userID string) map[string][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.direct[userID]
+}
+
+func setupDirectRoomTest(t *testing.T, members map[string]string) (*Plugin, *plugintest.API, *fakeDirectRoomHomeserver) {
+	homeserver := &fakeDirectRoomHomeserver{members: members, direct: map[string]map[string][]string{}}
+	plugin, api := setupPluginWithHomeserver(t, homeserver)
+	plugin.configuration = &configuration{MatrixServerURL: "https://test.com"}
+	plugin.initBridges()
+
+	return plugin, api, homeserver
+}
+
+func TestSyncDirectRoomMembership(t *testing.T) {
+	roomID := "!dm:test.com"
+	dmChannelID := model.NewId()
+	aliceID := model.NewId()
+	bobID := model.NewId()
+	carolID := model.NewId()
+	aliceGhost := "@_mattermost_" + aliceID + ":test.com"
+	remoteID := "remote_id"
+
+	memberEvent := func(sender, target, membership string) MatrixEvent {
+		return MatrixEvent{
+			EventID:  "$member",
+			Type:     "m.room.member",
+			Sender:   sender,
+			RoomID:   roomID,
+			StateKey: &target,
+			Content:  map[string]any{"membership": membership},
+		}
+	}
+
+	setup := func(t *testing.T, members map[string]string) (*Plugin, *plugintest.API, *fakeDirectRoomHomeserver) {
+		plugin, api, homeserver := setupDirectRoomTest(t, members)
+		api.On("GetChannel", dmChannelID).Return(&model.Channel{Id: dmChannelID, Type: model.ChannelTypeDirect}, nil)
+		api.On("GetChannelMembers", dmChannelID, 0, 10).Return(model.ChannelMembers{{UserId: aliceID}, {UserId: bobID}}, nil)
+		api.On("GetUser", aliceID).Return(&model.User{Id: aliceID}, nil)
+		api.On("GetUser", bobID).Return(&model.User{Id: bobID, RemoteId: &remoteID}, nil)
+		api.On("GetUser", carolID).Return(&model.User{Id: carolID, RemoteId: &remoteID}, nil)
+
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildGhostUserKey(aliceID), []byte(aliceGhost)))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildGhostRoomKey(aliceID, roomID), []byte("joined")))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey("@bob:matrix.org"), []byte(bobID)))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey("@carol:matrix.org"), []byte(carolID)))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(dmChannelID), []byte(roomID)))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildRoomMappingKey(roomID), []byte(dmChannelID)))
+		return plugin, api, homeserver
+	}
+
+	t.Run("a third member turns the DM into a group message", func(t *testing.T) {
+		plugin, api, homeserver := setup(t, map[string]string{
+			aliceGhost:          "join",
+			"@bob:matrix.org":   "join",
+			"@carol:matrix.org": "invite",
+		})
+		gmChannelID := model.NewId()
+		api.On("GetGroupChannel", sortedIDs(aliceID, bobID, carolID)).Return(&model.Channel{Id: gmChannelID, Type: model.ChannelTypeGroup}, nil)
+
+		handled, err := plugin.syncDirectRoomMembership(memberEvent("@bob:matrix.org", "@carol:matrix.org", "invite"), dmChannelID)
+		require.NoError(t, err)
+		assert.True(t, handled)
+
+		channelID, err := plugin.kvstore.Get(kvstore.BuildRoomMappingKey(roomID))
+		require.NoError(t, err)
+		assert.Equal(t, gmChannelID, string(channelID))
+		mappedRoom, err := plugin.kvstore.Get(kvstore.BuildChannelMappingKey(gmChannelID))
+		require.NoError(t, err)
+		assert.Equal(t, roomID, string(mappedRoom))
+		oldMapping, _ := plugin.kvstore.Get(kvstore.BuildChannelMappingKey(dmChannelID))
+		assert.Empty(t, oldMapping)
+
+		assert.Equal(t, map[string][]string{
+			"@bob:matrix.org":   {roomID},
+			"@carol:matrix.org": {roomID},
+		}, homeserver.directRooms(aliceGhost))
+	})
+
+	t.Run("unchanged membership keeps the mapping", func(t *testing.T) {
+		plugin, _, _ := setup(t, map[string]string{
+			aliceGhost:        "join",
+			"@bob:matrix.org": "join",
+		})
+
+		handled, err := plugin.syncDirectRoomMembership(memberEvent("@bob:matrix.org", "@bob:matrix.org", "leave"), dmChannelID)
+		require.NoError(t, err)
+		assert.True(t, handled)
+
+		channelID, err := plugin.kvstore.Get(kvstore.BuildRoomMappingKey(roomID))
+		require.NoError(t, err)
+		assert.Equal(t, dmChannelID, string(channelID))
+	})
+
+	t.Run("regular channels are left to the member sync", func(t *testing.T) {
+		plugin, api, _ := setupDirectRoomTest(t, nil)
+		channelID := model.NewId()
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Type: model.ChannelTypeOpen}, nil)
+
+		handled, err := plugin.syncDirectRoomMembership(memberEvent("@bob:matrix.org", "@carol:matrix.org", "join"), channelID)
+		require.NoError(t, err)
+		assert.False(t, handled)
+	})
+}
+
+func TestUpdateGhostDirectRooms(t *testing.T) {
+	plugin, _, homeserver := setupDirectRoomTest(t, nil)
+	ghost := "@_mattermost_" + model.NewId() + ":test.com"
+	homeserver.direct[ghost] = map[string][]string{
+		"@bob:matrix.org":  {"!other:test.com", "!gm:test.com"},
+		"@dave:matrix.org": {"!gm:test.com"},
+	}
+
+	plugin.mattermostToMatrixBridge.updateGhostDirectRooms("!gm:test.com", []string{ghost, "@bob:matrix.org", "@carol:matrix.org"}, []string{"@dave:matrix.org"})
+
+	assert.Equal(t, map[string][]string{
+		"@bob:matrix.org":   {"!other:test.com", "!gm:test.com"},
+		"@carol:matrix.org": {"!gm:test.com"},
+	}, homeserver.directRooms(ghost))
+}
+
+func sortedIDs(ids ...string) []string {
+	sorted := slices.Clone(ids)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestRemapDirectRoom(t *testing.T) {
+	plugin, _, _ := setupDirectRoomTest(t, map[string]string{})
+	oldChannelID := model.NewId()
+	newChannelID := model.NewId()
+	require.NoError(t, plugin.mattermostToMatrixBridge.setChannelRoomMapping(oldChannelID, "!dm:test.com"))
+	require.NoError(t, plugin.mattermostToMatrixBridge.setChannelRoomMapping(newChannelID, "!other:test.com"))
+
+	require.NoError(t, plugin.remapDirectRoom("!dm:test.com", oldChannelID, newChannelID))
+
+	roomID, err := plugin.mattermostToMatrixBridge.GetMatrixRoomID(newChannelID)
+	require.NoError(t, err)
+	assert.Equal(t, "!dm:test.com", roomID)
+	channelID, err := plugin.kvstore.Get(kvstore.BuildRoomMappingKey("!dm:test.com"))
+	require.NoError(t, err)
+	assert.Equal(t, newChannelID, string(channelID))
+
+	// Neither the old channel nor the replaced room stay mapped
+	oldRoomID, _ := plugin.kvstore.Get(kvstore.BuildChannelMappingKey(oldChannelID))
+	assert.Empty(t, oldRoomID)
+	replacedChannelID, _ := plugin.kvstore.Get(kvstore.BuildRoomMappingKey("!other:test.com"))
+	assert.Empty(t, replacedChannelID)
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/pkg/errors"
 )
@@ -17,9 +21,15 @@ func (p *Plugin) OnSharedChannelsSyncMsg(msg *model.SyncMsg, _ *model.RemoteClus
 		return model.SyncResponse{}, errors.New("matrix client not initialized")
 	}
 
+	// Channels mapped one-way from Matrix still sync profiles, but nothing else flows to Matrix
+	toMatrix := p.syncsToMatrix(msg.ChannelId)
+
 	// Process user sync events first (display name changes, etc.)
 	for _, user := range msg.Users {
 		if user.IsRemote() {
+			if !toMatrix {
+				continue
+			}
 			// This is a Matrix-originated user - invite them to the Matrix room if not already there
 			if err := p.inviteRemoteUserToMatrixRoom(user, msg.ChannelId); err != nil {
 				p.logger.LogError("Failed to invite remote user to Matrix room", "error", err, "user_id", user.Id, "username", user.Username, "channel_id", msg.ChannelId)
@@ -33,6 +43,11 @@ func (p *Plugin) OnSharedChannelsSyncMsg(msg *model.SyncMsg, _ *model.RemoteClus
 		}
 	}
 
+	if !toMatrix {
+		p.logger.LogDebug("Skipping sync to Matrix for channel mapped from Matrix only", "channel_id", msg.ChannelId, "post_count", len(msg.Posts), "reaction_count", len(msg.Reactions))
+		return model.SyncResponse{}, nil
+	}
+
 	// Then process post sync events
 	for _, post := range msg.Posts {
 		// Skip syncing posts that originated from Matrix to prevent loops, except for deletions
@@ -109,6 +124,10 @@ func (p *Plugin) OnSharedChannelsAttachmentSyncMsg(fi *model.FileInfo, post *mod
 		return nil
 	}
 
+	if !p.syncsToMatrix(post.ChannelId) {
+		return nil
+	}
+
 	p.logger.LogDebug("Received attachment sync", "file_id", fi.Id, "post_id", post.Id, "filename", fi.Name)
 
 	// Check if this is a file deletion
@@ -127,6 +146,37 @@ func (p *Plugin) OnSharedChannelsAttachmentSyncMsg(fi *model.FileInfo, post *mod
 		return nil
 	}
 
+	// Files of blocked types are replaced with a notice saying so
+	mimeType := attachmentMimeType(fi.MimeType, fi.Name)
+	if mimeTypeBlocked(config.getBlockedMimeTypes(command.SyncDirectionToMatrix), mimeType) {
+		p.logger.LogInfo("Replacing attachment of a blocked type with a notice", "file_id", fi.Id, "post_id", post.Id, "mime_type", mimeType)
+		p.addAttachmentNotice(fi, post, blockedAttachmentNotice(fi.Name, mimeType))
+		return nil
+	}
+
+	// The plugin API only hands out whole files, so check the size before loading one. Files too large for the
+	// homeserver are replaced with a notice linking back to the post.
+	if limit := p.matrixUploadLimit(); limit > 0 && fi.Size > limit {
+		p.logger.LogWarn("Replacing attachment larger than the maximum file size with a notice", "file_id", fi.Id, "post_id", post.Id, "size", fi.Size, "max", limit)
+		p.addAttachmentNotice(fi, post, oversizedAttachmentNotice(fi.Name, fi.Size, "Open it in Mattermost", p.mattermostPostLink(post.Id)))
+		return nil
+	}
+
+	// Files already uploaded, such as when a post is synced again, are sent with their earlier upload
+	cache := p.mattermostToMatrixBridge.mediaCache
+	if cached := cache.getFile(fi.Id); cached != nil {
+		p.pendingFiles.AddFile(post.Id, &PendingFile{
+			FileID:   fi.Id,
+			Filename: fi.Name,
+			MxcURI:   cached.MxcURI,
+			MimeType: fi.MimeType,
+			Size:     fi.Size,
+			Info:     cached.Info,
+		})
+		p.logger.LogDebug("Reusing earlier Matrix upload of attachment (pending post)", "filename", fi.Name, "post_id", post.Id, "mxc_uri", cached.MxcURI)
+		return nil
+	}
+
 	// Get the file data from Mattermost
 	fileData, appErr := p.API.GetFile(fi.Id)
 	if appErr != nil {
@@ -139,6 +189,11 @@ func (p *Plugin) OnSharedChannelsAttachmentSyncMsg(fi *model.FileInfo, post *mod
 		return errors.Wrap(err, "failed to upload file to Matrix")
 	}
 
+	info := p.buildMediaInfo(fi, fileData)
+	if isVoicePost(post) && strings.HasPrefix(fi.MimeType, "audio/") {
+		voiceMediaInfo(&info, post, fileData)
+	}
+
 	// Store the uploaded file as pending for this post
 	pendingFile := &PendingFile{
 		FileID:   fi.Id,
@@ -146,13 +201,37 @@ func (p *Plugin) OnSharedChannelsAttachmentSyncMsg(fi *model.FileInfo, post *mod
 		MxcURI:   mxcURI,
 		MimeType: fi.MimeType,
 		Size:     fi.Size,
+		Info:     info,
 	}
 	p.pendingFiles.AddFile(post.Id, pendingFile)
+	cache.storeFile(fi.Id, &cachedFile{MxcURI: mxcURI, Info: info})
 
 	p.logger.LogDebug("Successfully uploaded attachment to Matrix (pending post)", "filename", fi.Name, "size", fi.Size, "post_id", post.Id, "mxc_uri", mxcURI)
 	return nil
 }
 
+// addAttachmentNotice queues a notice to be sent to Matrix with a post in place of one of its files
+func (p *Plugin) addAttachmentNotice(fi *model.FileInfo, post *model.Post, notice attachmentNotice) {
+	p.pendingFiles.AddFile(post.Id, &PendingFile{
+		FileID:     fi.Id,
+		Filename:   fi.Name,
+		MimeType:   fi.MimeType,
+		Size:       fi.Size,
+		Notice:     notice.Text,
+		NoticeHTML: notice.HTML,
+	})
+}
+
+// mattermostPostLink returns a permalink to a post, which asks readers to sign in to Mattermost, or "" if the
+// site URL isn't configured
+func (p *Plugin) mattermostPostLink(postID string) string {
+	site := siteURL(p.API)
+	if site == "" {
+		return ""
+	}
+	return site + "/_redirect/pl/" + postID
+}
+
 // deleteFileFromMatrix handles deleting a file attachment from Matrix
 func (p *Plugin) deleteFileFromMatrix(fi *model.FileInfo, post *model.Post) error {
 	p.logger.LogDebug("Deleting file attachment from Matrix", "file_id", fi.Id, "post_id", post.Id, "filename", fi.Name)
@@ -298,12 +377,21 @@ func (p *Plugin) OnSharedChannelsProfileImageSyncMsg(user *model.User, _ *model.
 		return nil
 	}
 
+	// Setting an avatar announces a profile change in every room the ghost user is in, so skip unchanged ones
+	cache := p.mattermostToMatrixBridge.mediaCache
+	hash := matrix.MediaHash(avatarData)
+	if avatar := cache.getAvatar(user.Id); avatar != nil && avatar.Hash == hash {
+		p.logger.LogDebug("Profile image unchanged, skipping ghost user avatar update", "user_id", user.Id, "ghost_user_id", ghostUserID)
+		return nil
+	}
+
 	// Update the avatar for the ghost user (upload and set)
 	err := p.matrixClient.UpdateGhostUserAvatar(ghostUserID, avatarData, "image/png")
 	if err != nil {
 		p.logger.LogError("Failed to update ghost user avatar", "error", err, "user_id", user.Id, "ghost_user_id", ghostUserID)
 		return errors.Wrap(err, "failed to update ghost user avatar on Matrix")
 	}
+	cache.storeAvatar(user.Id, &avatarRecord{Hash: hash})
 
 	p.logger.LogDebug("Successfully updated ghost user avatar", "user_id", user.Id, "username", user.Username, "ghost_user_id", ghostUserID)
 	return nil
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// inboundStrikeWindow is how far back dropped events count towards muting a sender
+	inboundStrikeWindow = 5 * time.Minute
+	// inboundLimiterIdleTime is how long a sender or room's bucket is kept after its last event
+	inboundLimiterIdleTime = 10 * time.Minute
+	// systemAdminsPerPage is the page size used when looking for system admins to notify
+	systemAdminsPerPage = 100
+)
+
+// inboundLimitSettings are the settings the inbound limiter was built with
+type inboundLimitSettings struct {
+	senderPerMinute int
+	roomPerMinute   int
+	muteThreshold   int
+	muteDuration    time.Duration
+}
+
+// getInboundLimitSettings returns the inbound rate limit settings
+func (c *configuration) getInboundLimitSettings() inboundLimitSettings {
+	return inboundLimitSettings{
+		senderPerMinute: c.InboundSenderRateLimit,
+		roomPerMinute:   c.InboundRoomRateLimit,
+		muteThreshold:   c.InboundMuteThreshold,
+		muteDuration:    time.Duration(c.InboundMuteMinutes) * time.Minute,
+	}
+}
+
+// inboundDecision is the outcome of checking an inbound event against the limits
+type inboundDecision int
+
+const (
+	inboundAllowed       inboundDecision = iota
+	inboundSenderMuted                   // The sender is muted
+	inboundSenderLimited                 // The sender exceeded their limit
+	inboundRoomLimited                   // The room exceeded its limit
+	inboundMutedNow                      // The sender exceeded their limit once too often and was just muted
+)
+
+// inboundSenderState tracks one Matrix sender
+type inboundSenderState struct {
+	bucket     *matrix.TokenBucket
+	strikes    []time.Time
+	mutedUntil time.Time
+	lastSeen   time.Time
+}
+
+// inboundRoomState tracks one Matrix room
+type inboundRoomState struct {
+	bucket   *matrix.TokenBucket
+	lastSeen time.Time
+}
+
+// inboundLimiter limits how fast Matrix senders and rooms can post to Mattermost. The buckets reuse the token
+// buckets protecting outgoing Matrix calls. State is kept in memory, so it resets when the plugin restarts.
+type inboundLimiter struct {
+	mu        sync.Mutex
+	settings  inboundLimitSettings
+	senders   map[string]*inboundSenderState
+	rooms     map[string]*inboundRoomState
+	lastSweep time.Time
+	dropped   int64
+	muted     int64
+
+	now func() time.Time
+}
+
+// inboundLimitCounts is the JSON form of the inbound limiter metrics
+type inboundLimitCounts struct {
+	Dropped      int64 `json:"dropped"`
+	Muted        int64 `json:"muted"`
+	MutedSenders int   `json:"muted_senders"`
+}
+
+func newInboundLimiter() *inboundLimiter {
+	return &inboundLimiter{
+		senders: make(map[string]*inboundSenderState),
+		rooms:   make(map[string]*inboundRoomState),
+		now:     time.Now,
+	}
+}
+
+// newPerMinuteBucket returns a token bucket allowing perMinute events a minute, in bursts of up to perMinute
+func newPerMinuteBucket(perMinute int) *matrix.TokenBucket {
+	return matrix.NewTokenBucket(matrix.TokenBucketConfig{
+		Rate:      float64(perMinute) / 60,
+		BurstSize: perMinute,
+	})
+}
+
+// check decides whether an event from a sender in a room may be bridged, consuming a token from both buckets
+// if so. Settings that differ from the last call reset every bucket.
+func (l *inboundLimiter) check(settings inboundLimitSettings, sender, roomID string) inboundDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if settings != l.settings {
+		l.settings = settings
+		l.senders = make(map[string]*inboundSenderState)
+		l.rooms = make(map[string]*inboundRoomState)
+	}
+	l.sweep(now)
+
+	senderState := l.senders[sender]
+	if senderState == nil {
+		senderState = &inboundSenderState{}
+		if settings.senderPerMinute > 0 {
+			senderState.bucket = newPerMinuteBucket(settings.senderPerMinute)
+		}
+		l.senders[sender] = senderState
+	}
+	senderState.lastSeen = now
+
+	if now.Before(senderState.mutedUntil) {
+		l.dropped++
+		return inboundSenderMuted
+	}
+	if !senderState.mutedUntil.IsZero() {
+		// Senders start afresh once their mute ends
+		senderState.mutedUntil = time.Time{}
+		if settings.senderPerMinute > 0 {
+			senderState.bucket = newPerMinuteBucket(settings.senderPerMinute)
+		}
+	}
+
+	if senderState.bucket != nil && !senderState.bucket.Allow() {
+		l.dropped++
+		if settings.muteThreshold <= 0 || settings.muteDuration <= 0 {
+			return inboundSenderLimited
+		}
+
+		strikes := senderState.strikes[:0]
+		for _, strike := range senderState.strikes {
+			if now.Sub(strike) < inboundStrikeWindow {
+				strikes = append(strikes, strike)
+			}
+		}
+		senderState.strikes = append(strikes, now)
+		if len(senderState.strikes) < settings.muteThreshold {
+			return inboundSenderLimited
+		}
+
+		senderState.strikes = nil
+		senderState.mutedUntil = now.Add(settings.muteDuration)
+		l.muted++
+		return inboundMutedNow
+	}
+
+	if settings.roomPerMinute > 0 {
+		roomState := l.rooms[roomID]
+		if roomState == nil {
+			roomState = &inboundRoomState{bucket: newPerMinuteBucket(settings.roomPerMinute)}
+			l.rooms[roomID] = roomState
+		}
+		roomState.lastSeen = now
+		if !roomState.bucket.Allow() {
+			l.dropped++
+			return inboundRoomLimited
+		}
+	}
+
+	return inboundAllowed
+}
+
+// sweep forgets senders and rooms that have been idle for a while, at most once a minute
+func (l *inboundLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for sender, state := range l.senders {
+		if now.Sub(state.lastSeen) > inboundLimiterIdleTime && !now.Before(state.mutedUntil) {
+			delete(l.senders, sender)
+		}
+	}
+	for roomID, state := range l.rooms {
+		if now.Sub(state.lastSeen) > inboundLimiterIdleTime {
+			delete(l.rooms, roomID)
+		}
+	}
+}
+
+// snapshot returns the limiter's counts
+func (l *inboundLimiter) snapshot() inboundLimitCounts {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := inboundLimitCounts{Dropped: l.dropped, Muted: l.muted}
+	now := l.now()
+	for _, state := range l.senders {
+		if now.Before(state.mutedUntil) {
+			counts.MutedSenders++
+		}
+	}
+	return counts
+}
+
+// allowInboundEvent applies the inbound rate limits to an event that would create or change a Mattermost post,
+// or run a bridge bot command. channelID is empty for rooms not mapped to a channel.
+// Senders who keep exceeding their limit are muted, and the system admins in the channel are told.
+func (p *Plugin) allowInboundEvent(event MatrixEvent, channelID string) bool {
+	settings := p.getConfiguration().getInboundLimitSettings()
+	if settings.senderPerMinute <= 0 && settings.roomPerMinute <= 0 {
+		return true
+	}
+
+	switch p.inboundLimiter.check(settings, event.Sender, event.RoomID) {
+	case inboundAllowed:
+		return true
+	case inboundSenderMuted:
+		p.logger.LogDebug("Dropping event from muted Matrix user", "event_id", event.EventID, "sender", event.Sender, "room_id", event.RoomID)
+	case inboundSenderLimited:
+		p.logger.LogDebug("Dropping event from Matrix user over the inbound rate limit", "event_id", event.EventID, "sender", event.Sender, "room_id", event.RoomID)
+	case inboundRoomLimited:
+		p.logger.LogDebug("Dropping event from Matrix room over the inbound rate limit", "event_id", event.EventID, "sender", event.Sender, "room_id", event.RoomID)
+	case inboundMutedNow:
+		p.logger.LogWarn("Muted Matrix user for exceeding the inbound rate limit", "sender", event.Sender, "room_id", event.RoomID, "channel_id", channelID, "duration", settings.muteDuration.String())
+		// Sending the notices calls out to Mattermost and Matrix, which would hold up the rest of the transaction
+		go p.notifyMatrixUserMuted(event.Sender, event.RoomID, channelID, settings.muteDuration)
+	}
+	return false
+}
+
+// notifyMatrixUserMuted tells the system admins in the Mattermost channel, who can change the inbound rate
+// limits, and the Matrix room that a sender was muted
+func (p *Plugin) notifyMatrixUserMuted(sender, roomID, channelID string, duration time.Duration) {
+	message := fmt.Sprintf("Matrix user %s was muted for %s for sending messages too quickly. Their messages won't reach Mattermost until then.", sender, duration)
+
+	// Rooms without a channel, such as DMs with the bridge bot, only get the Matrix notice
+	for page := 0; channelID != ""; page++ {
+		admins, appErr := p.API.GetUsers(&model.UserGetOptions{Role: model.SystemAdminRoleId, Active: true, Page: page, PerPage: systemAdminsPerPage})
+		if appErr != nil {
+			p.logger.LogWarn("Failed to get system admins to notify about muted Matrix user", "error", appErr, "channel_id", channelID)
+			break
+		}
+		for _, admin := range admins {
+			if _, appErr := p.API.GetChannelMember(channelID, admin.Id); appErr != nil {
+				continue
+			}
+			// Without a user ID the notice shows as a system message
+			p.API.SendEphemeralPost(admin.Id, &model.Post{
+				ChannelId: channelID,
+				Message:   message,
+			})
+		}
+		if len(admins) < systemAdminsPerPage {
+			break
+		}
+	}
+
+	botUserID, err := p.getBridgeBotUserID()
+	if err != nil {
+		p.logger.LogWarn("Failed to get bridge bot user ID for mute notice", "error", err, "room_id", roomID)
+		return
+	}
+	if _, err := p.matrixClient.SendNotice(roomID, botUserID, message, ""); err != nil {
+		p.logger.LogWarn("Failed to send mute notice to Matrix room", "error", err, "room_id", roomID)
+	}
+}
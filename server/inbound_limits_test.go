@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInboundLimiter(t *testing.T) {
+	newLimiter := func() (*inboundLimiter, *time.Time) {
+		limiter := newInboundLimiter()
+		now := time.Now()
+		limiter.now = func() time.Time { return now }
+		return limiter, &now
+	}
+
+	t.Run("senders are limited separately", func(t *testing.T) {
+		limiter, _ := newLimiter()
+		settings := inboundLimitSettings{senderPerMinute: 2}
+
+		assert.Equal(t, inboundAllowed, limiter.check(settings, "@alice:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundAllowed, limiter.check(settings, "@alice:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundSenderLimited, limiter.check(settings, "@alice:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundAllowed, limiter.check(settings, "@bob:matrix.org", "!room:test.com"))
+	})
+
+	t.Run("rooms are limited across senders", func(t *testing.T) {
+		limiter, _ := newLimiter()
+		settings := inboundLimitSettings{roomPerMinute: 1}
+
+		assert.Equal(t, inboundAllowed, limiter.check(settings, "@alice:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundRoomLimited, limiter.check(settings, "@bob:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundAllowed, limiter.check(settings, "@bob:matrix.org", "!other:test.com"))
+	})
+
+	t.Run("repeat offenders are muted for the configured time", func(t *testing.T) {
+		limiter, now := newLimiter()
+		settings := inboundLimitSettings{senderPerMinute: 1, muteThreshold: 2, muteDuration: 10 * time.Minute}
+
+		assert.Equal(t, inboundAllowed, limiter.check(settings, "@spammer:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundSenderLimited, limiter.check(settings, "@spammer:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundMutedNow, limiter.check(settings, "@spammer:matrix.org", "!room:test.com"))
+
+		*now = now.Add(9 * time.Minute)
+		assert.Equal(t, inboundSenderMuted, limiter.check(settings, "@spammer:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundLimitCounts{Dropped: 3, Muted: 1, MutedSenders: 1}, limiter.snapshot())
+
+		*now = now.Add(2 * time.Minute)
+		assert.Equal(t, inboundAllowed, limiter.check(settings, "@spammer:matrix.org", "!room:test.com"))
+	})
+
+	t.Run("old strikes don't count", func(t *testing.T) {
+		limiter, now := newLimiter()
+		settings := inboundLimitSettings{senderPerMinute: 1, muteThreshold: 2, muteDuration: 10 * time.Minute}
+
+		limiter.check(settings, "@alice:matrix.org", "!room:test.com")
+		assert.Equal(t, inboundSenderLimited, limiter.check(settings, "@alice:matrix.org", "!room:test.com"))
+
+		*now = now.Add(inboundStrikeWindow)
+		limiter.senders["@alice:matrix.org"].bucket = newPerMinuteBucket(1)
+		limiter.check(settings, "@alice:matrix.org", "!room:test.com")
+		assert.Equal(t, inboundSenderLimited, limiter.check(settings, "@alice:matrix.org", "!room:test.com"))
+	})
+
+	t.Run("changed settings reset the buckets", func(t *testing.T) {
+		limiter, _ := newLimiter()
+
+		limiter.check(inboundLimitSettings{senderPerMinute: 1}, "@alice:matrix.org", "!room:test.com")
+		assert.Equal(t, inboundSenderLimited, limiter.check(inboundLimitSettings{senderPerMinute: 1}, "@alice:matrix.org", "!room:test.com"))
+		assert.Equal(t, inboundAllowed, limiter.check(inboundLimitSettings{senderPerMinute: 5}, "@alice:matrix.org", "!room:test.com"))
+	})
+}
+
+func TestInboundRateLimitEnforcement(t *testing.T) {
+	messageFrom := func(sender string) MatrixEvent {
+		return MatrixEvent{
+			EventID: "$" + model.NewId(),
+			Type:    "m.room.message",
+			Sender:  sender,
+			RoomID:  "!room:test.com",
+			Content: map[string]any{"msgtype": "m.text", "body": "buy now"},
+		}
+	}
+
+	t.Run("events over the limit are dropped", func(t *testing.T) {
+		plugin, _, channelID, requests := setupSyncDirectionTest(t)
+		plugin.configuration.InboundSenderRateLimit = 1
+
+		require.True(t, plugin.allowInboundEvent(messageFrom("@spammer:matrix.org"), channelID))
+
+		// Bridging the message would call the unmocked plugin API
+		require.NoError(t, plugin.processMatrixEvent(messageFrom("@spammer:matrix.org")))
+		assert.Zero(t, *requests)
+	})
+
+	t.Run("no limits allow everything", func(t *testing.T) {
+		plugin, _, channelID, _ := setupSyncDirectionTest(t)
+
+		for range 100 {
+			require.True(t, plugin.allowInboundEvent(messageFrom("@alice:matrix.org"), channelID))
+		}
+	})
+
+	t.Run("system admins in the channel are told when a sender is muted", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		plugin.configuration.InboundSenderRateLimit = 1
+		plugin.configuration.InboundMuteThreshold = 1
+		plugin.configuration.InboundMuteMinutes = 10
+
+		admin := &model.User{Id: model.NewId()}
+		outsider := &model.User{Id: model.NewId()}
+		api.On("GetUsers", mock.MatchedBy(func(options *model.UserGetOptions) bool {
+			return options.Role == model.SystemAdminRoleId && options.Page == 0
+		})).Return([]*model.User{admin, outsider}, nil)
+		api.On("GetChannelMember", channelID, admin.Id).Return(&model.ChannelMember{}, nil)
+		api.On("GetChannelMember", channelID, outsider.Id).Return(nil, model.NewAppError("GetChannelMember", "not_found", nil, "", http.StatusNotFound))
+		notified := make(chan *model.Post, 1)
+		api.On("SendEphemeralPost", admin.Id, mock.Anything).Return(&model.Post{}).Run(func(args mock.Arguments) {
+			notified <- args.Get(1).(*model.Post)
+		}).Once()
+
+		require.True(t, plugin.allowInboundEvent(messageFrom("@spammer:matrix.org"), channelID))
+		require.False(t, plugin.allowInboundEvent(messageFrom("@spammer:matrix.org"), channelID))
+		require.False(t, plugin.allowInboundEvent(messageFrom("@spammer:matrix.org"), channelID))
+
+		select {
+		case post := <-notified:
+			assert.Equal(t, channelID, post.ChannelId)
+			assert.Empty(t, post.UserId)
+			assert.Contains(t, post.Message, "@spammer:matrix.org was muted for 10m0s")
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "system admin was not notified")
+		}
+		assert.Equal(t, inboundLimitCounts{Dropped: 2, Muted: 1, MutedSenders: 1}, plugin.inboundLimiter.snapshot())
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 func (p *Plugin) runJob() {
-	// Include job logic here
 	p.logger.LogInfo("Job is currently running")
+
+	p.reconcileTeamSpaces()
 }
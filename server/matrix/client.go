@@ -9,7 +9,9 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,6 +19,12 @@ import (
 	"github.com/pkg/errors"
 )
 
+// BridgeBotLocalpart is the localpart of the application service's sender user (sender_localpart in the registration)
+const BridgeBotLocalpart = "_mattermost_bridge"
+
+// ApplicationServiceID is the id of the application service in the registration file
+const ApplicationServiceID = "mattermost-bridge"
+
 // Error represents a Matrix API error response
 type Error struct {
 	ErrCode    string `json:"errcode"`
@@ -188,6 +196,13 @@ type Client struct {
 	serverDomain         string           // override server domain for testing
 	configuredServerName string           // configured Matrix server name from config
 	serverDiscovery      *ServerDiscovery // utility for server name discovery
+	mediaCache           MediaCache       // optional cache of uploaded content
+	puppetStore          PuppetStore      // optional Matrix accounts linked to ghost users
+
+	// Homeserver media config, fetched on demand
+	mediaConfigMu        sync.Mutex
+	uploadSizeLimit      int64
+	mediaConfigFetchedAt time.Time
 
 	// Rate limiting
 	rateLimitConfig     RateLimitConfig
@@ -241,10 +256,36 @@ type MessageContent struct {
 
 // FileAttachment represents a file attachment for Matrix messages.
 type FileAttachment struct {
-	Filename string `json:"filename"`
+	Filename string    `json:"filename"`
+	MxcURI   string    `json:"mxc_uri"`
+	MimeType string    `json:"mimetype"`
+	Size     int64     `json:"size"`
+	Info     MediaInfo `json:"info"`
+
+	// Notice, when set, is sent as a notice in place of a file that couldn't be uploaded
+	Notice     string `json:"notice,omitempty"`
+	NoticeHTML string `json:"notice_html,omitempty"`
+}
+
+// MediaInfo is the optional metadata Matrix clients use to lay out and preview a file before downloading it.
+// Zero values are left out of the message.
+type MediaInfo struct {
+	Width      int             `json:"w,omitempty"`
+	Height     int             `json:"h,omitempty"`
+	DurationMs int64           `json:"duration,omitempty"`
+	BlurHash   string          `json:"blurhash,omitempty"`
+	Thumbnail  *MediaThumbnail `json:"thumbnail,omitempty"`
+	Voice      bool            `json:"voice,omitempty"`    // Send as a voice message (MSC3245)
+	Waveform   []int           `json:"waveform,omitempty"` // Voice message waveform, values from 0 to 1024
+}
+
+// MediaThumbnail is an uploaded thumbnail of a file
+type MediaThumbnail struct {
 	MxcURI   string `json:"mxc_uri"`
 	MimeType string `json:"mimetype"`
 	Size     int64  `json:"size"`
+	Width    int    `json:"w"`
+	Height   int    `json:"h"`
 }
 
 // MessageRequest represents a request to send a message as a ghost user with all optional parameters.
@@ -258,6 +299,19 @@ type MessageRequest struct {
 	Files          []FileAttachment `json:"files"`             // Optional: File attachments
 	ReplyToEventID string           `json:"reply_to_event_id"` // Optional: Event ID to reply to (for files)
 	Mentions       map[string]any   `json:"mentions"`          // Optional: Matrix mentions data (m.mentions field)
+	Captions       bool             `json:"captions"`          // Optional: Send the text as the caption of a single file (MSC2530)
+	RelaySender    *RelaySender     `json:"relay_sender"`      // Optional: Mattermost user the bridge bot relays the message for
+}
+
+// RelaySenderKey holds the Mattermost user behind a message the bridge bot sent in relay mode, so the message can
+// be traced back to its author
+const RelaySenderKey = "com.mattermost.bridge.relay"
+
+// RelaySender is the Mattermost user a message relayed by the bridge bot came from
+type RelaySender struct {
+	UserID      string `json:"user_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayname"`
 }
 
 // SendEventResponse represents the response from Matrix when sending events.
@@ -357,47 +411,12 @@ func (c *Client) RedactEventAsGhost(roomID, eventID, ghostUserID string) (*SendE
 	if err != nil {
 		return nil, errors.Wrap(err, "invalid room or event ID")
 	}
-	reqURL := c.serverURL + endpoint
-
-	// Add user_id query parameter for impersonation
-	if ghostUserID != "" {
-		reqURL += "?user_id=" + url.QueryEscape(ghostUserID)
-	}
-
-	jsonData, err := json.Marshal(content)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal redaction content")
-	}
-
-	req, err := http.NewRequest("PUT", reqURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create redaction request")
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.asToken)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to send redaction request")
-	}
-	defer func() { _ = resp.Body.Close() }()
 
-	body, err := io.ReadAll(resp.Body)
+	response, err := c.putEventAsUser(endpoint, content, ghostUserID)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read redaction response body")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("matrix redaction API error: %d %s", resp.StatusCode, string(body))
-	}
-
-	var response SendEventResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal redaction response")
+		return nil, errors.Wrap(err, "failed to send redaction")
 	}
-
-	return &response, nil
+	return response, nil
 }
 
 // GetEvent retrieves a single Matrix event by ID
@@ -487,6 +506,141 @@ func (c *Client) GetEventRelationsAsUser(roomID, eventID, userID string) ([]map[
 	return response.Chunk, nil
 }
 
+// GetRoomMembersAsUser returns the membership of everyone in a room, keyed by Matrix user ID. Pass a userID
+// in the bridge's namespace that is in the room to read rooms the bridge bot hasn't joined.
+func (c *Client) GetRoomMembersAsUser(roomID, userID string) (map[string]string, error) {
+	if c.serverURL == "" || c.asToken == "" {
+		return nil, errors.New("matrix client not configured")
+	}
+
+	requestURL := c.serverURL + "/_matrix/client/v3/rooms/" + url.PathEscape(roomID) + "/members"
+
+	// Add user_id query parameter for impersonation
+	if userID != "" {
+		requestURL += "?user_id=" + url.QueryEscape(userID)
+	}
+
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create room members request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send room members request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read room members response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseMatrixError(resp.StatusCode, body)
+	}
+
+	var response struct {
+		Chunk []struct {
+			StateKey string `json:"state_key"`
+			Content  struct {
+				Membership string `json:"membership"`
+			} `json:"content"`
+		} `json:"chunk"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal room members response")
+	}
+
+	members := make(map[string]string, len(response.Chunk))
+	for _, event := range response.Chunk {
+		members[event.StateKey] = event.Content.Membership
+	}
+	return members, nil
+}
+
+// GetDirectRoomsAsUser returns a user's m.direct account data: the DM rooms they are in, keyed by the
+// Matrix user ID of the other party. Users without any DMs get an empty map.
+func (c *Client) GetDirectRoomsAsUser(userID string) (map[string][]string, error) {
+	if c.serverURL == "" || c.asToken == "" {
+		return nil, errors.New("matrix client not configured")
+	}
+
+	requestURL := c.serverURL + "/_matrix/client/v3/user/" + url.PathEscape(userID) + "/account_data/m.direct?user_id=" + url.QueryEscape(userID)
+
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create account data request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send account data request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read account data response")
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return map[string][]string{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseMatrixError(resp.StatusCode, body)
+	}
+
+	direct := map[string][]string{}
+	if err := json.Unmarshal(body, &direct); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal m.direct account data")
+	}
+	return direct, nil
+}
+
+// SetDirectRoomsAsUser replaces a user's m.direct account data, which clients use to show rooms as DMs
+func (c *Client) SetDirectRoomsAsUser(userID string, direct map[string][]string) error {
+	if c.serverURL == "" || c.asToken == "" {
+		return errors.New("matrix client not configured")
+	}
+
+	jsonData, err := json.Marshal(direct)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal m.direct account data")
+	}
+
+	requestURL := c.serverURL + "/_matrix/client/v3/user/" + url.PathEscape(userID) + "/account_data/m.direct?user_id=" + url.QueryEscape(userID)
+
+	req, err := http.NewRequest("PUT", requestURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return errors.Wrap(err, "failed to create account data request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send account data request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read account data response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return parseMatrixError(resp.StatusCode, body)
+	}
+
+	return nil
+}
+
 // TestConnection verifies that the Matrix client can connect to the server.
 func (c *Client) TestConnection() error {
 	if c.serverURL == "" || c.asToken == "" {
@@ -709,6 +863,197 @@ func (c *Client) GetRoomJoinRule(roomID string) (string, error) {
 	return joinRuleEvent.JoinRule, nil
 }
 
+// RoomInfo holds the descriptive state of a Matrix room
+type RoomInfo struct {
+	RoomID         string
+	Name           string
+	Topic          string
+	AvatarURL      string
+	CanonicalAlias string
+	JoinRule       string
+}
+
+// GetRoomInfo reads a room's name, topic, avatar, canonical alias and join rule from its current state.
+// The bridge bot must be able to see the room state, so it should have joined the room first.
+func (c *Client) GetRoomInfo(roomID string) (*RoomInfo, error) {
+	if c.serverURL == "" || c.asToken == "" {
+		return nil, errors.New("matrix client not configured")
+	}
+
+	requestURL := c.serverURL + "/_matrix/client/v3/rooms/" + url.PathEscape(roomID) + "/state"
+
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create room state request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send room state request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read room state response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get room state: %d %s", resp.StatusCode, string(body))
+	}
+
+	var stateEvents []struct {
+		Type     string         `json:"type"`
+		StateKey string         `json:"state_key"`
+		Content  map[string]any `json:"content"`
+	}
+	if err := json.Unmarshal(body, &stateEvents); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal room state response")
+	}
+
+	info := &RoomInfo{RoomID: roomID}
+	for _, event := range stateEvents {
+		if event.StateKey != "" {
+			continue
+		}
+		switch event.Type {
+		case "m.room.name":
+			info.Name, _ = event.Content["name"].(string)
+		case "m.room.topic":
+			info.Topic, _ = event.Content["topic"].(string)
+		case "m.room.avatar":
+			info.AvatarURL, _ = event.Content["url"].(string)
+		case "m.room.canonical_alias":
+			info.CanonicalAlias, _ = event.Content["alias"].(string)
+		case "m.room.join_rules":
+			info.JoinRule, _ = event.Content["join_rule"].(string)
+		}
+	}
+
+	return info, nil
+}
+
+// GetRoomMessages returns up to limit of the most recent events in a room as the bridge bot, newest first
+func (c *Client) GetRoomMessages(roomID string, limit int) ([]map[string]any, error) {
+	if c.serverURL == "" || c.asToken == "" {
+		return nil, errors.New("matrix client not configured")
+	}
+
+	requestURL := c.serverURL + "/_matrix/client/v3/rooms/" + url.PathEscape(roomID) + "/messages?dir=b&limit=" + strconv.Itoa(limit)
+
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create room messages request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send room messages request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read room messages response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get room messages: %d %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Chunk []map[string]any `json:"chunk"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal room messages response")
+	}
+
+	return response.Chunk, nil
+}
+
+// PowerLevels represents the content of a room's m.room.power_levels state event
+type PowerLevels struct {
+	Users         map[string]int `json:"users"`
+	UsersDefault  int            `json:"users_default"`
+	Events        map[string]int `json:"events"`
+	EventsDefault int            `json:"events_default"`
+	StateDefault  *int           `json:"state_default,omitempty"`
+	Invite        *int           `json:"invite,omitempty"`
+	Kick          *int           `json:"kick,omitempty"`
+	Ban           *int           `json:"ban,omitempty"`
+	Redact        *int           `json:"redact,omitempty"`
+}
+
+// UserLevel returns the power level of a user, falling back to users_default
+func (pl *PowerLevels) UserLevel(userID string) int {
+	if level, ok := pl.Users[userID]; ok {
+		return level
+	}
+	return pl.UsersDefault
+}
+
+// InviteLevel returns the power level required to invite users (defaults to 0 per the Matrix spec)
+func (pl *PowerLevels) InviteLevel() int {
+	if pl.Invite != nil {
+		return *pl.Invite
+	}
+	return 0
+}
+
+// StateLevel returns the power level required to send state events (defaults to 50 per the Matrix spec)
+func (pl *PowerLevels) StateLevel() int {
+	if pl.StateDefault != nil {
+		return *pl.StateDefault
+	}
+	return 50
+}
+
+// GetRoomPowerLevels retrieves the power levels of a Matrix room
+func (c *Client) GetRoomPowerLevels(roomID string) (*PowerLevels, error) {
+	if c.serverURL == "" || c.asToken == "" {
+		return nil, errors.New("matrix client not configured")
+	}
+
+	requestURL, err := BuildSecureURL(c.serverURL+"/_matrix/client/v3/rooms/", roomID, "state", "m.room.power_levels")
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid room ID")
+	}
+	requestURL += "/"
+
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create power levels request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send power levels request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read power levels response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get power levels: %d %s", resp.StatusCode, string(body))
+	}
+
+	var powerLevels PowerLevels
+	if err := json.Unmarshal(body, &powerLevels); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal power levels response")
+	}
+
+	return &powerLevels, nil
+}
+
 // InviteAndJoinGhostUser invites a ghost user to a room (via application service) and then joins them
 // This checks the room's join rules first to determine if invitation is required
 func (c *Client) InviteAndJoinGhostUser(roomIdentifier, ghostUserID string) error {
@@ -1000,43 +1345,207 @@ func (c *Client) CreateRoom(name, topic, serverDomain string, publish bool, matt
 	return response.RoomID, nil
 }
 
-// CreateDirectRoom creates a Matrix DM room and invites the specified ghost users
-func (c *Client) CreateDirectRoom(ghostUserIDs []string, roomName string) (string, error) {
+// CreateSpace creates a Matrix space for a Mattermost team. Only the bridge can change the space's
+// state, so its children always mirror the team's bridged channels.
+func (c *Client) CreateSpace(name, topic string, public bool, mattermostTeamID string) (string, error) {
 	if c.serverURL == "" || c.asToken == "" {
 		return "", errors.New("matrix client not configured")
 	}
 
-	if len(ghostUserIDs) < 2 {
-		return "", errors.New("direct room requires at least 2 users")
-	}
-
-	// Apply rate limiting for room creation operations
-	if err := c.waitForRateLimit(c.roomCreationLimiter, "Direct room creation"); err != nil {
+	// Apply rate limiting for room creation
+	if err := c.waitForRateLimit(c.roomCreationLimiter, "Space creation"); err != nil {
 		return "", err
 	}
 
-	c.logger.LogDebug("Creating Matrix DM room", "users", ghostUserIDs)
+	preset := "private_chat"
+	if public {
+		preset = "public_chat"
+	}
 
 	roomData := map[string]any{
-		"preset":    "private_chat",
-		"is_direct": true,
-		"invite":    ghostUserIDs,
+		"name":       name,
+		"topic":      topic,
+		"preset":     preset,
+		"visibility": "private",
 		"creation_content": map[string]any{
+			"type":       "m.space",
 			"m.federate": true,
 		},
+		"power_level_content_override": map[string]any{
+			"events_default": 100,
+			"state_default":  100,
+			"invite":         50,
+		},
 		"initial_state": []map[string]any{
 			{
-				"type":      "m.room.history_visibility",
+				"type":      "com.mattermost.bridge.team",
 				"state_key": "",
 				"content": map[string]any{
-					"history_visibility": "shared",
+					"mattermost_team_id": mattermostTeamID,
+					"created_at":         time.Now().Unix(),
 				},
 			},
 		},
 	}
 
-	// Set room name for better identification
-	if roomName != "" {
+	jsonData, err := json.Marshal(roomData)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal space creation data")
+	}
+
+	req, err := http.NewRequest("POST", c.serverURL+"/_matrix/client/v3/createRoom", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create space creation request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to send space creation request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read space creation response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		c.logger.LogError("Matrix space creation failed", "status_code", resp.StatusCode, "response", string(body), "space_name", name)
+		return "", parseMatrixError(resp.StatusCode, body)
+	}
+
+	var response struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", errors.Wrap(err, "failed to unmarshal space creation response")
+	}
+
+	c.logger.LogDebug("Created Matrix space", "space_id", response.RoomID, "name", name, "team_id", mattermostTeamID)
+	return response.RoomID, nil
+}
+
+// AddSpaceChild lists a room as a child of a space
+func (c *Client) AddSpaceChild(spaceID, roomID string) error {
+	serverDomain, err := c.extractServerDomain()
+	if err != nil {
+		return errors.Wrap(err, "failed to extract server domain")
+	}
+	return c.setRoomState(spaceID, "m.space.child", roomID, map[string]any{"via": []string{serverDomain}})
+}
+
+// RemoveSpaceChild removes a room from a space's children
+func (c *Client) RemoveSpaceChild(spaceID, roomID string) error {
+	return c.setRoomState(spaceID, "m.space.child", roomID, map[string]any{})
+}
+
+// SetSpaceParent marks a space as the canonical parent of a room
+func (c *Client) SetSpaceParent(roomID, spaceID string) error {
+	serverDomain, err := c.extractServerDomain()
+	if err != nil {
+		return errors.Wrap(err, "failed to extract server domain")
+	}
+	return c.setRoomState(roomID, "m.space.parent", spaceID, map[string]any{"via": []string{serverDomain}, "canonical": true})
+}
+
+// RemoveSpaceParent removes a space from a room's parents
+func (c *Client) RemoveSpaceParent(roomID, spaceID string) error {
+	return c.setRoomState(roomID, "m.space.parent", spaceID, map[string]any{})
+}
+
+// SetRoomName sets a room's (or space's) name as the bridge bot
+func (c *Client) SetRoomName(roomID, name string) error {
+	return c.setRoomState(roomID, "m.room.name", "", map[string]any{"name": name})
+}
+
+// SetRoomAvatar sets a room's (or space's) avatar as the bridge bot. An empty URL removes the avatar.
+func (c *Client) SetRoomAvatar(roomID, avatarURL string) error {
+	content := map[string]any{}
+	if avatarURL != "" {
+		content["url"] = avatarURL
+	}
+	return c.setRoomState(roomID, "m.room.avatar", "", content)
+}
+
+// setRoomState sends a state event to a room as the bridge bot
+func (c *Client) setRoomState(roomID, eventType, stateKey string, content any) error {
+	if c.serverURL == "" || c.asToken == "" {
+		return errors.New("matrix client not configured")
+	}
+
+	jsonData, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal state event content")
+	}
+
+	requestURL := c.serverURL + "/_matrix/client/v3/rooms/" + url.PathEscape(roomID) + "/state/" + url.PathEscape(eventType) + "/" + url.PathEscape(stateKey)
+
+	req, err := http.NewRequest("PUT", requestURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return errors.Wrap(err, "failed to create state event request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send state event request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read state event response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return parseMatrixError(resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// CreateDirectRoom creates a Matrix DM room and invites the specified ghost users
+func (c *Client) CreateDirectRoom(ghostUserIDs []string, roomName string) (string, error) {
+	if c.serverURL == "" || c.asToken == "" {
+		return "", errors.New("matrix client not configured")
+	}
+
+	if len(ghostUserIDs) < 2 {
+		return "", errors.New("direct room requires at least 2 users")
+	}
+
+	// Apply rate limiting for room creation operations
+	if err := c.waitForRateLimit(c.roomCreationLimiter, "Direct room creation"); err != nil {
+		return "", err
+	}
+
+	c.logger.LogDebug("Creating Matrix DM room", "users", ghostUserIDs)
+
+	roomData := map[string]any{
+		"preset":    "private_chat",
+		"is_direct": true,
+		"invite":    ghostUserIDs,
+		"creation_content": map[string]any{
+			"m.federate": true,
+		},
+		"initial_state": []map[string]any{
+			{
+				"type":      "m.room.history_visibility",
+				"state_key": "",
+				"content": map[string]any{
+					"history_visibility": "shared",
+				},
+			},
+		},
+	}
+
+	// Set room name for better identification
+	if roomName != "" {
 		roomData["name"] = roomName
 	}
 
@@ -1092,6 +1601,45 @@ func (c *Client) CreateDirectRoom(ghostUserIDs []string, roomName string) (strin
 	return response.RoomID, nil
 }
 
+// CreateBridgeBotDirectRoom creates a direct chat between the bridge bot and a Matrix user, who is invited to it
+func (c *Client) CreateBridgeBotDirectRoom(userID, roomName string) (string, error) {
+	if c.serverURL == "" || c.asToken == "" {
+		return "", errors.New("matrix client not configured")
+	}
+
+	// Apply rate limiting for room creation operations
+	if err := c.waitForRateLimit(c.roomCreationLimiter, "Bridge bot direct room creation"); err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(map[string]any{
+		"preset":    "trusted_private_chat",
+		"is_direct": true,
+		"invite":    []string{userID},
+		"name":      roomName,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal bridge bot DM room creation data")
+	}
+
+	req, err := http.NewRequest("POST", c.serverURL+"/_matrix/client/v3/createRoom", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create bridge bot DM room creation request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	var response struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := c.doJSON(req, &response); err != nil {
+		return "", errors.Wrap(err, "failed to create bridge bot DM room")
+	}
+
+	c.logger.LogDebug("Created bridge bot DM room", "room_id", response.RoomID, "user_id", userID)
+	return response.RoomID, nil
+}
+
 // extractServerDomain extracts the Matrix server name (domain for Matrix IDs)
 // It uses the following chain:
 // 1. Override server domain (for testing)
@@ -1391,54 +1939,24 @@ func (c *Client) SetAvatarURL(userID, avatarURL string) error {
 	return nil
 }
 
-// UploadMedia uploads media content to the Matrix server and returns the mxc:// URI
+// UploadMedia uploads media content to the Matrix server and returns the mxc:// URI. Content already in the
+// media cache isn't uploaded again.
 func (c *Client) UploadMedia(data []byte, filename, contentType string) (string, error) {
-	if c.asToken == "" {
-		return "", errors.New("application service token not configured")
-	}
-
-	// Apply rate limiting for media upload operations
-	if err := c.waitForRateLimit(c.messageLimiter, "Media upload"); err != nil {
-		return "", err
+	if c.mediaCache == nil {
+		return c.UploadMediaStream(bytes.NewReader(data), int64(len(data)), filename, contentType)
 	}
 
-	// Use the media upload endpoint
-	requestURL := c.serverURL + "/_matrix/media/v3/upload"
-	if filename != "" {
-		requestURL += "?filename=" + url.QueryEscape(filename)
+	hash := MediaHash(data)
+	if mxcURI, ok := c.mediaCache.GetMediaURI(hash); ok {
+		c.logger.LogDebug("Reusing previously uploaded media", "filename", filename, "mxc_uri", mxcURI)
+		return mxcURI, nil
 	}
-
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(data))
-	if err != nil {
-		return "", errors.Wrap(err, "failed to create media upload request")
-	}
-
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("Authorization", "Bearer "+c.asToken)
-
-	resp, err := c.httpClient.Do(req)
+	mxcURI, err := c.UploadMediaStream(bytes.NewReader(data), int64(len(data)), filename, contentType)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to send media upload request")
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to read media upload response")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to upload media: %d %s", resp.StatusCode, string(body))
-	}
-
-	var response struct {
-		ContentURI string `json:"content_uri"`
-	}
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", errors.Wrap(err, "failed to unmarshal media upload response")
+		return "", err
 	}
-
-	return response.ContentURI, nil
+	c.mediaCache.StoreMediaURI(hash, mxcURI)
+	return mxcURI, nil
 }
 
 // UploadAvatarFromData uploads avatar image data to Matrix and returns mxc:// URI
@@ -1533,6 +2051,67 @@ func (c *Client) EditMessageAsGhost(roomID, eventID, newMessage, htmlMessage, gh
 	return c.sendEventAsUser(roomID, "m.room.message", content, ghostUserID)
 }
 
+// EditMediaCaptionAsGhost edits the caption of a media event (MSC2530) as a ghost user. The new content repeats
+// the media from the current content, so the file stays in place; an empty caption leaves just the file name.
+func (c *Client) EditMediaCaptionAsGhost(roomID, eventID string, media map[string]any, caption, htmlCaption, ghostUserID string) (*SendEventResponse, error) {
+	if c.asToken == "" {
+		return nil, errors.New("application service token not configured")
+	}
+
+	// Apply rate limiting for message edit operations
+	if err := c.waitForRateLimit(c.messageLimiter, "Message edit"); err != nil {
+		return nil, err
+	}
+
+	newContent := make(map[string]any)
+	for _, key := range []string{"msgtype", "url", "file", "info", VoiceKey, VoiceAudioKey} {
+		if value, ok := media[key]; ok {
+			newContent[key] = value
+		}
+	}
+
+	filename, _ := media["filename"].(string)
+	if filename == "" {
+		filename, _ = media["body"].(string)
+	}
+	newContent["filename"] = filename
+	newContent["body"] = filename
+	if caption != "" || htmlCaption != "" {
+		newContent["body"] = caption
+		if htmlCaption != "" {
+			newContent["format"] = "org.matrix.custom.html"
+			newContent["formatted_body"] = htmlCaption
+		}
+	}
+
+	content := map[string]any{
+		"msgtype":       newContent["msgtype"],
+		"body":          " * " + newContent["body"].(string), // Fallback for clients that don't support edits
+		"m.new_content": newContent,
+		"m.relates_to": map[string]any{
+			"rel_type": "m.replace",
+			"event_id": eventID,
+		},
+	}
+
+	return c.sendEventAsUser(roomID, "m.room.message", content, ghostUserID)
+}
+
+// Content keys of MSC3245 voice messages
+const (
+	VoiceKey      = "org.matrix.msc3245.voice"
+	VoiceAudioKey = "org.matrix.msc1767.audio"
+)
+
+// IsMediaMsgType reports whether a Matrix msgtype carries a file
+func IsMediaMsgType(msgType string) bool {
+	switch msgType {
+	case "m.image", "m.file", "m.video", "m.audio":
+		return true
+	}
+	return false
+}
+
 // SendMessage sends a message as a ghost user with all optional parameters consolidated into a single request.
 func (c *Client) SendMessage(req MessageRequest) (*SendEventResponse, error) {
 	if c.asToken == "" {
@@ -1564,12 +2143,65 @@ func (c *Client) SendMessage(req MessageRequest) (*SendEventResponse, error) {
 	return c.sendMattermostPost(req)
 }
 
+// SendNotice sends an m.notice message as the given user, typically the bridge bot
+func (c *Client) SendNotice(roomID, senderUserID, message, htmlMessage string) (*SendEventResponse, error) {
+	if c.asToken == "" {
+		return nil, errors.New("application service token not configured")
+	}
+
+	// Apply rate limiting for message sending
+	if err := c.waitForRateLimit(c.messageLimiter, "Notice sending"); err != nil {
+		return nil, err
+	}
+
+	content := map[string]any{
+		"msgtype": "m.notice",
+		"body":    message,
+	}
+
+	if htmlMessage != "" {
+		content["format"] = "org.matrix.custom.html"
+		content["formatted_body"] = htmlMessage
+	}
+
+	if c.remoteID != "" {
+		content["mattermost_remote_id"] = c.remoteID
+	}
+
+	return c.sendEventAsUser(roomID, "m.room.message", content, senderUserID)
+}
+
+// GetServerDomain returns the server name used in the bridge's Matrix user IDs and aliases
+func (c *Client) GetServerDomain() (string, error) {
+	return c.extractServerDomain()
+}
+
+// GetBridgeBotUserID returns the Matrix user ID of the application service's sender user
+func (c *Client) GetBridgeBotUserID() (string, error) {
+	serverDomain, err := c.extractServerDomain()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to extract server domain")
+	}
+
+	return fmt.Sprintf("@%s:%s", BridgeBotLocalpart, serverDomain), nil
+}
+
 // sendMattermostPost sends all content from a Mattermost post as separate Matrix messages
 // Text (if any) and each file become separate top-level messages, linked via m.relates_to
 func (c *Client) sendMattermostPost(req MessageRequest) (*SendEventResponse, error) {
 	var primaryResponse *SendEventResponse
 	var rootEventID string
 
+	// With captions, a single file carries the text itself instead of following a separate text message
+	if req.Captions && len(req.Files) == 1 && req.Files[0].Notice == "" && (req.Message != "" || req.HTMLMessage != "") {
+		response, err := c.sendFileMessage(req, req.Files[0], "", true)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to send captioned file message")
+		}
+		c.logger.LogDebug("Sent captioned file message", "event_id", response.EventID, "filename", req.Files[0].Filename)
+		return response, nil
+	}
+
 	// Send text message first if present
 	if req.Message != "" || req.HTMLMessage != "" {
 		textResponse, err := c.sendTextMessage(req, "")
@@ -1583,7 +2215,7 @@ func (c *Client) sendMattermostPost(req MessageRequest) (*SendEventResponse, err
 
 	// Send each file as separate top-level message
 	for _, file := range req.Files {
-		fileResponse, err := c.sendFileMessage(req, file, rootEventID)
+		fileResponse, err := c.sendFileMessage(req, file, rootEventID, false)
 		if err != nil {
 			// Log error but continue with other files
 			c.logger.LogWarn("Failed to send file message", "filename", file.Filename, "error", err)
@@ -1648,16 +2280,22 @@ func (c *Client) sendTextMessage(req MessageRequest, rootEventID string) (*SendE
 	if c.remoteID != "" {
 		content["mattermost_remote_id"] = c.remoteID
 	}
+	if req.RelaySender != nil {
+		content[RelaySenderKey] = req.RelaySender
+	}
 
 	return c.sendEventAsUser(req.RoomID, "m.room.message", content, req.GhostUserID)
 }
 
-// sendFileMessage sends a file message with optional relation to root event
-func (c *Client) sendFileMessage(req MessageRequest, file FileAttachment, rootEventID string) (*SendEventResponse, error) {
+// sendFileMessage sends a file message with optional relation to root event. With caption set, the request's
+// text is sent as the file's caption.
+func (c *Client) sendFileMessage(req MessageRequest, file FileAttachment, rootEventID string, caption bool) (*SendEventResponse, error) {
 	content := make(map[string]any)
 
 	// Determine message type based on MIME type
 	switch {
+	case file.Notice != "":
+		content["msgtype"] = "m.notice"
 	case strings.HasPrefix(file.MimeType, "image/"):
 		content["msgtype"] = "m.image"
 	case strings.HasPrefix(file.MimeType, "video/"):
@@ -1668,12 +2306,42 @@ func (c *Client) sendFileMessage(req MessageRequest, file FileAttachment, rootEv
 		content["msgtype"] = "m.file"
 	}
 
-	// File content
-	content["body"] = file.Filename
-	content["url"] = file.MxcURI
-	content["info"] = map[string]any{
-		"size":     file.Size,
-		"mimetype": file.MimeType,
+	// File content, or the notice sent in its place
+	if file.Notice != "" {
+		content["body"] = file.Notice
+		if file.NoticeHTML != "" {
+			content["format"] = "org.matrix.custom.html"
+			content["formatted_body"] = file.NoticeHTML
+		}
+	} else {
+		content["body"] = file.Filename
+		content["url"] = file.MxcURI
+		content["info"] = fileInfoContent(file)
+	}
+
+	// MSC3245: voice messages are audio messages marked as voice, with the duration and waveform repeated in the
+	// extensible events audio block
+	if file.Info.Voice {
+		audio := map[string]any{"duration": file.Info.DurationMs}
+		if len(file.Info.Waveform) > 0 {
+			audio["waveform"] = file.Info.Waveform
+		}
+		content["msgtype"] = "m.audio"
+		content[VoiceAudioKey] = audio
+		content[VoiceKey] = map[string]any{}
+	}
+
+	// MSC2530: the body is the caption, and the file name moves to its own field
+	if caption {
+		content["filename"] = file.Filename
+		content["body"] = req.Message
+		if req.HTMLMessage != "" {
+			content["format"] = "org.matrix.custom.html"
+			content["formatted_body"] = req.HTMLMessage
+		}
+		if req.Mentions != nil {
+			content["m.mentions"] = req.Mentions
+		}
 	}
 
 	// Add threading if provided (takes priority over post grouping)
@@ -1697,58 +2365,50 @@ func (c *Client) sendFileMessage(req MessageRequest, file FileAttachment, rootEv
 	if c.remoteID != "" {
 		content["mattermost_remote_id"] = c.remoteID
 	}
+	if req.RelaySender != nil {
+		content[RelaySenderKey] = req.RelaySender
+	}
 
 	return c.sendEventAsUser(req.RoomID, "m.room.message", content, req.GhostUserID)
 }
 
-// sendEventAsUser sends an event as a specific user (using application service impersonation)
-func (c *Client) sendEventAsUser(roomID, eventType string, content any, userID string) (*SendEventResponse, error) {
-	txnID := uuid.New().String()
-	endpoint, err := BuildSecureURL("/_matrix/client/v3/rooms/", roomID, "send", eventType, txnID)
-	if err != nil {
-		return nil, errors.Wrap(err, "invalid room ID or event type")
+// fileInfoContent builds the info block of a file message
+func fileInfoContent(file FileAttachment) map[string]any {
+	info := map[string]any{
+		"size":     file.Size,
+		"mimetype": file.MimeType,
 	}
-	reqURL := c.serverURL + endpoint
-
-	// Add user_id query parameter for impersonation
-	if userID != "" {
-		reqURL += "?user_id=" + url.QueryEscape(userID)
+	if file.Info.Width > 0 && file.Info.Height > 0 {
+		info["w"] = file.Info.Width
+		info["h"] = file.Info.Height
 	}
-
-	jsonData, err := json.Marshal(content)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal event content")
+	if file.Info.DurationMs > 0 {
+		info["duration"] = file.Info.DurationMs
 	}
-
-	req, err := http.NewRequest("PUT", reqURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request")
+	if file.Info.BlurHash != "" {
+		info["xyz.amorgan.blurhash"] = file.Info.BlurHash
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.asToken) // Use AS token for impersonation
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to send request")
+	if thumbnail := file.Info.Thumbnail; thumbnail != nil && thumbnail.MxcURI != "" {
+		info["thumbnail_url"] = thumbnail.MxcURI
+		info["thumbnail_info"] = map[string]any{
+			"w":        thumbnail.Width,
+			"h":        thumbnail.Height,
+			"mimetype": thumbnail.MimeType,
+			"size":     thumbnail.Size,
+		}
 	}
-	defer func() { _ = resp.Body.Close() }()
+	return info
+}
 
-	body, err := io.ReadAll(resp.Body)
+// sendEventAsUser sends an event as a specific user (using application service impersonation), or as the
+// Matrix account linked to a ghost user
+func (c *Client) sendEventAsUser(roomID, eventType string, content any, userID string) (*SendEventResponse, error) {
+	txnID := uuid.New().String()
+	endpoint, err := BuildSecureURL("/_matrix/client/v3/rooms/", roomID, "send", eventType, txnID)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read response body")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("matrix API error: %d %s", resp.StatusCode, string(body))
-	}
-
-	var response SendEventResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal response")
+		return nil, errors.Wrap(err, "invalid room ID or event type")
 	}
-
-	return &response, nil
+	return c.putEventAsUser(endpoint, content, userID)
 }
 
 // ResolveRoomAlias resolves a Matrix room alias to its room ID.
@@ -1806,6 +2466,23 @@ type UserProfile struct {
 
 // GetUserProfile retrieves the profile information for a Matrix user
 func (c *Client) GetUserProfile(userID string) (*UserProfile, error) {
+	profile, err := c.LookupUserProfile(userID)
+	if err != nil {
+		var matrixErr *Error
+		if errors.As(err, &matrixErr) {
+			c.logger.LogWarn("Failed to get Matrix user profile", "status_code", matrixErr.StatusCode, "response", matrixErr.ErrMsg, "user_id", userID)
+			// Return empty profile rather than error - user might not have set a display name
+			return &UserProfile{}, nil
+		}
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// LookupUserProfile retrieves the profile information for a Matrix user.
+// Unlike GetUserProfile, it returns an *Error when the homeserver does not know the user.
+func (c *Client) LookupUserProfile(userID string) (*UserProfile, error) {
 	if c.serverURL == "" || c.asToken == "" {
 		return nil, errors.New("matrix client not configured")
 	}
@@ -1833,9 +2510,7 @@ func (c *Client) GetUserProfile(userID string) (*UserProfile, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		c.logger.LogWarn("Failed to get Matrix user profile", "status_code", resp.StatusCode, "response", string(body), "user_id", userID)
-		// Return empty profile rather than error - user might not have set a display name
-		return &UserProfile{}, nil
+		return nil, parseMatrixError(resp.StatusCode, body)
 	}
 
 	var profile UserProfile
@@ -1849,147 +2524,17 @@ func (c *Client) GetUserProfile(userID string) (*UserProfile, error) {
 
 // DownloadFile downloads file data from a Matrix MXC URI with configurable size limit
 func (c *Client) DownloadFile(mxcURI string, maxSize int64, contentTypePrefix string) ([]byte, error) {
-	if mxcURI == "" {
-		return nil, errors.New("MXC URI is empty")
-	}
-
-	// Matrix file URIs are in the format mxc://server/media_id
-	if !strings.HasPrefix(mxcURI, "mxc://") {
-		return nil, errors.New("invalid Matrix MXC URI format")
-	}
-
-	// Extract server and media ID from mxc://server/media_id
-	mxcParts := strings.TrimPrefix(mxcURI, "mxc://")
-	parts := strings.SplitN(mxcParts, "/", 2)
-	if len(parts) != 2 {
-		return nil, errors.New("invalid Matrix MXC URI format")
-	}
-
-	serverName := parts[0]
-	mediaID := parts[1]
-
-	// Validate MXC URI components for path traversal attacks
-	if err := ValidateMXCComponents(serverName, mediaID); err != nil {
-		return nil, errors.Wrap(err, "invalid MXC URI components")
-	}
-
-	// Build secure media download URLs with proper escaping
-	var downloadURLs []string
-	var validationErrors []error
-
-	// NEW: Try client API endpoints first (newer Synapse versions use these)
-	url1, err := BuildSecureURL(c.serverURL+"/_matrix/client/v1/media/download/", serverName, mediaID)
-	if err == nil {
-		downloadURLs = append(downloadURLs, url1)
-	} else {
-		validationErrors = append(validationErrors, errors.Wrap(err, "client v1 with server"))
-	}
-
-	url2, err := BuildSecureURL(c.serverURL+"/_matrix/client/v1/media/download/", mediaID)
-	if err == nil {
-		downloadURLs = append(downloadURLs, url2)
-	} else {
-		validationErrors = append(validationErrors, errors.Wrap(err, "client v1 media-only"))
-	}
-
-	// Standard Matrix media repository API v3
-	url3, err := BuildSecureURL(c.serverURL+"/_matrix/media/v3/download/", serverName, mediaID)
-	if err == nil {
-		downloadURLs = append(downloadURLs, url3)
-	} else {
-		validationErrors = append(validationErrors, errors.Wrap(err, "media v3 with server"))
-	}
-
-	// Fallback to v1 API (some older servers)
-	url4, err := BuildSecureURL(c.serverURL+"/_matrix/media/v1/download/", serverName, mediaID)
-	if err == nil {
-		downloadURLs = append(downloadURLs, url4)
-	} else {
-		validationErrors = append(validationErrors, errors.Wrap(err, "media v1 with server"))
-	}
-
-	// Alternative endpoint without server name (some configurations)
-	url5, err := BuildSecureURL(c.serverURL+"/_matrix/media/v3/download/", mediaID)
-	if err == nil {
-		downloadURLs = append(downloadURLs, url5)
-	} else {
-		validationErrors = append(validationErrors, errors.Wrap(err, "media v3 media-only"))
-	}
-
-	url6, err := BuildSecureURL(c.serverURL+"/_matrix/media/v1/download/", mediaID)
-	if err == nil {
-		downloadURLs = append(downloadURLs, url6)
-	} else {
-		validationErrors = append(validationErrors, errors.Wrap(err, "media v1 media-only"))
-	}
-
-	if len(downloadURLs) == 0 {
-		// Collect all validation error messages for debugging
-		var errorMsgs []string
-		for _, validationErr := range validationErrors {
-			errorMsgs = append(errorMsgs, validationErr.Error())
-		}
-		return nil, errors.Errorf("failed to construct any valid download URLs for MXC URI %s - validation errors: %s",
-			mxcURI, strings.Join(errorMsgs, "; "))
+	download, err := c.DownloadFileStream(mxcURI, maxSize, contentTypePrefix)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = download.Body.Close() }()
 
-	var lastErr error
-	for i, downloadURL := range downloadURLs {
-		c.logger.LogDebug("Attempting to download Matrix file", "url", downloadURL, "attempt", i+1, "mxc_uri", mxcURI)
-
-		req, err := http.NewRequest("GET", downloadURL, nil)
-		if err != nil {
-			c.logger.LogWarn("Failed to create file download request", "error", err, "url", downloadURL)
-			lastErr = err
-			continue
-		}
-
-		// Add authorization header for authenticated download
-		req.Header.Set("Authorization", "Bearer "+c.asToken)
-
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			c.logger.LogWarn("Failed to download file from URL", "error", err, "url", downloadURL)
-			lastErr = err
-			continue
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode != http.StatusOK {
-			c.logger.LogWarn("Matrix media endpoint returned error", "url", downloadURL, "status", resp.StatusCode)
-			lastErr = fmt.Errorf("HTTP %d from %s", resp.StatusCode, downloadURL)
-			continue
-		}
-
-		// Check content type if specified
-		contentType := resp.Header.Get("Content-Type")
-		if contentTypePrefix != "" && !strings.HasPrefix(contentType, contentTypePrefix) {
-			c.logger.LogWarn("Invalid content type", "content_type", contentType, "expected_prefix", contentTypePrefix, "url", downloadURL)
-			lastErr = fmt.Errorf("invalid content type: %s (expected prefix: %s)", contentType, contentTypePrefix)
-			continue
-		}
-
-		// Read the file data
-		fileData, err := io.ReadAll(resp.Body)
-		if err != nil {
-			c.logger.LogWarn("Failed to read file data", "error", err, "url", downloadURL)
-			lastErr = err
-			continue
-		}
-
-		// Check size limit
-		if maxSize > 0 && int64(len(fileData)) > maxSize {
-			c.logger.LogWarn("File too large", "size", len(fileData), "max", maxSize, "url", downloadURL)
-			lastErr = fmt.Errorf("file too large: %d bytes (max %d)", len(fileData), maxSize)
-			continue
-		}
-
-		c.logger.LogDebug("Successfully downloaded Matrix file", "url", downloadURL, "size", len(fileData), "content_type", contentType, "mxc_uri", mxcURI)
-		return fileData, nil
+	fileData, err := io.ReadAll(download.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read file data for MXC URI: %s", mxcURI)
 	}
-
-	// If we get here, all attempts failed
-	return nil, errors.Wrapf(lastErr, "failed to download file from any endpoint for MXC URI: %s", mxcURI)
+	return fileData, nil
 }
 
 // PublishRoomToDirectory explicitly publishes a room to the public directory
@@ -2069,47 +2614,8 @@ func (c *Client) AddFileMetadataToMessage(roomID, messageEventID string, fileEve
 
 // sendCustomEventAsUser sends a custom event type as a specific user
 func (c *Client) sendCustomEventAsUser(roomID, eventType string, content any, userID string) error {
-	txnID := uuid.New().String()
-	endpoint, err := BuildSecureURL("/_matrix/client/v3/rooms/", roomID, "send", eventType, txnID)
-	if err != nil {
-		return errors.Wrap(err, "invalid room ID or event type")
-	}
-	reqURL := c.serverURL + endpoint
-
-	// Add user_id query parameter for impersonation
-	if userID != "" {
-		reqURL += "?user_id=" + url.QueryEscape(userID)
-	}
-
-	jsonData, err := json.Marshal(content)
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal event content")
-	}
-
-	req, err := http.NewRequest("PUT", reqURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return errors.Wrap(err, "failed to create request")
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.asToken)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return errors.Wrap(err, "failed to send request")
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return errors.Wrap(err, "failed to read response body")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("matrix API error: %d %s", resp.StatusCode, string(body))
-	}
-
-	return nil
+	_, err := c.sendEventAsUser(roomID, eventType, content, userID)
+	return err
 }
 
 // ServerVersionResponse represents the response from the Matrix server version endpoint
@@ -2255,6 +2761,53 @@ func (c *Client) extractServerDomainForTest() string {
 	return domain
 }
 
+// PingApplicationService asks the homeserver to ping the bridge's application service endpoint (MSC2659),
+// verifying homeserver-to-bridge connectivity. Returns the round trip time the homeserver measured.
+func (c *Client) PingApplicationService(transactionID string) (time.Duration, error) {
+	if c.serverURL == "" || c.asToken == "" {
+		return 0, errors.New("matrix client not configured")
+	}
+
+	requestURL := c.serverURL + "/_matrix/client/v1/appservice/" + url.PathEscape(ApplicationServiceID) + "/ping"
+
+	jsonData, err := json.Marshal(map[string]string{"transaction_id": transactionID})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to marshal ping request")
+	}
+
+	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create ping request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to send ping request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read ping response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, parseMatrixError(resp.StatusCode, body)
+	}
+
+	var response struct {
+		DurationMs int64 `json:"duration_ms"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, errors.Wrap(err, "failed to unmarshal ping response")
+	}
+
+	return time.Duration(response.DurationMs) * time.Millisecond, nil
+}
+
 // GetServerInfo retrieves both server name and version information
 func (c *Client) GetServerInfo() (*ServerInfo, error) {
 	if c.serverURL == "" {
@@ -0,0 +1,305 @@
+package matrix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFileTooLarge is returned when media is larger than the size limit it was transferred with
+var ErrFileTooLarge = errors.New("file too large")
+
+// MediaDownload is media being streamed from the Matrix media repository. The caller must close Body.
+type MediaDownload struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64 // Size announced by the server, or -1 if unknown
+}
+
+// MediaCache remembers the mxc:// URIs of uploaded content by its hash, so identical content is only uploaded
+// once. Matrix clients take a file's name from the message that sends it, not from the upload.
+type MediaCache interface {
+	GetMediaURI(hash string) (string, bool)
+	StoreMediaURI(hash, mxcURI string)
+}
+
+// MediaHash returns the hex encoded SHA-256 hash content is cached by
+func MediaHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetMediaCache sets the cache UploadMedia looks content up in before uploading it
+func (c *Client) SetMediaCache(cache MediaCache) {
+	c.mediaCache = cache
+}
+
+// mediaConfigTTL is how long the homeserver's media config is trusted before it's fetched again
+const mediaConfigTTL = time.Hour
+
+// MediaConfig is the homeserver's media repository configuration
+type MediaConfig struct {
+	UploadSize int64 `json:"m.upload.size"` // Largest upload the homeserver accepts, or 0 if it doesn't say
+}
+
+// GetMediaConfig fetches the homeserver's media repository configuration, trying the authenticated client API
+// before the deprecated media API
+func (c *Client) GetMediaConfig() (*MediaConfig, error) {
+	if c.asToken == "" {
+		return nil, errors.New("application service token not configured")
+	}
+
+	var lastErr error
+	for _, endpoint := range []string{"/_matrix/client/v1/media/config", "/_matrix/media/v3/config"} {
+		req, err := http.NewRequest("GET", c.serverURL+endpoint, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create media config request")
+		}
+		req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			lastErr = errors.Wrap(err, "failed to send media config request")
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			lastErr = errors.Wrap(err, "failed to read media config response")
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("failed to get media config: %d %s", resp.StatusCode, string(body))
+			continue
+		}
+
+		var config MediaConfig
+		if err := json.Unmarshal(body, &config); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal media config response")
+		}
+		return &config, nil
+	}
+	return nil, lastErr
+}
+
+// UploadSizeLimit returns the largest upload the homeserver accepts, or 0 if it isn't known. The media config
+// is fetched at most once an hour; failures are retried on the next call.
+func (c *Client) UploadSizeLimit() int64 {
+	c.mediaConfigMu.Lock()
+	defer c.mediaConfigMu.Unlock()
+
+	if !c.mediaConfigFetchedAt.IsZero() && time.Since(c.mediaConfigFetchedAt) < mediaConfigTTL {
+		return c.uploadSizeLimit
+	}
+	config, err := c.GetMediaConfig()
+	if err != nil {
+		c.logger.LogWarn("Failed to get homeserver media config", "error", err)
+		return c.uploadSizeLimit
+	}
+	c.uploadSizeLimit = config.UploadSize
+	c.mediaConfigFetchedAt = time.Now()
+	return c.uploadSizeLimit
+}
+
+// sizeLimitedReader fails with ErrFileTooLarge once more than maxSize bytes have been read
+type sizeLimitedReader struct {
+	io.ReadCloser
+	maxSize int64
+	read    int64
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if r.read > r.maxSize {
+		return n, errors.Wrapf(ErrFileTooLarge, "more than %d bytes", r.maxSize)
+	}
+	return n, err
+}
+
+// UploadMediaStream uploads media content read from r to the Matrix server and returns the mxc:// URI. The
+// content is streamed, never held in memory. size is the content's length, or -1 if unknown.
+func (c *Client) UploadMediaStream(r io.Reader, size int64, filename, contentType string) (string, error) {
+	if c.asToken == "" {
+		return "", errors.New("application service token not configured")
+	}
+
+	// Apply rate limiting for media upload operations
+	if err := c.waitForRateLimit(c.messageLimiter, "Media upload"); err != nil {
+		return "", err
+	}
+
+	// Use the media upload endpoint
+	requestURL := c.serverURL + "/_matrix/media/v3/upload"
+	if filename != "" {
+		requestURL += "?filename=" + url.QueryEscape(filename)
+	}
+
+	req, err := http.NewRequest("POST", requestURL, r)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create media upload request")
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to send media upload request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read media upload response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to upload media: %d %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		ContentURI string `json:"content_uri"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", errors.Wrap(err, "failed to unmarshal media upload response")
+	}
+
+	return response.ContentURI, nil
+}
+
+// DownloadFileStream starts downloading a Matrix MXC URI and returns the response body for the caller to
+// stream. Files whose announced size is over maxSize are refused up front; the returned body also fails with
+// ErrFileTooLarge as soon as more than maxSize bytes arrive, so servers can't get around the limit.
+func (c *Client) DownloadFileStream(mxcURI string, maxSize int64, contentTypePrefix string) (*MediaDownload, error) {
+	downloadURLs, err := mediaDownloadURLs(c.serverURL, mxcURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for i, downloadURL := range downloadURLs {
+		c.logger.LogDebug("Attempting to download Matrix file", "url", downloadURL, "attempt", i+1, "mxc_uri", mxcURI)
+
+		req, err := http.NewRequest("GET", downloadURL, nil)
+		if err != nil {
+			c.logger.LogWarn("Failed to create file download request", "error", err, "url", downloadURL)
+			lastErr = err
+			continue
+		}
+
+		// Add authorization header for authenticated download
+		req.Header.Set("Authorization", "Bearer "+c.asToken)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			c.logger.LogWarn("Failed to download file from URL", "error", err, "url", downloadURL)
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			c.logger.LogWarn("Matrix media endpoint returned error", "url", downloadURL, "status", resp.StatusCode)
+			lastErr = fmt.Errorf("HTTP %d from %s", resp.StatusCode, downloadURL)
+			continue
+		}
+
+		// Check content type if specified
+		contentType := resp.Header.Get("Content-Type")
+		if contentTypePrefix != "" && !strings.HasPrefix(contentType, contentTypePrefix) {
+			_ = resp.Body.Close()
+			c.logger.LogWarn("Invalid content type", "content_type", contentType, "expected_prefix", contentTypePrefix, "url", downloadURL)
+			lastErr = fmt.Errorf("invalid content type: %s (expected prefix: %s)", contentType, contentTypePrefix)
+			continue
+		}
+
+		// Check size limit before reading anything
+		if maxSize > 0 && resp.ContentLength > maxSize {
+			_ = resp.Body.Close()
+			c.logger.LogWarn("File too large", "size", resp.ContentLength, "max", maxSize, "url", downloadURL)
+			return nil, errors.Wrapf(ErrFileTooLarge, "%d bytes (max %d)", resp.ContentLength, maxSize)
+		}
+
+		body := resp.Body
+		if maxSize > 0 {
+			body = &sizeLimitedReader{ReadCloser: resp.Body, maxSize: maxSize}
+		}
+
+		c.logger.LogDebug("Streaming Matrix file", "url", downloadURL, "size", resp.ContentLength, "content_type", contentType, "mxc_uri", mxcURI)
+		return &MediaDownload{Body: body, ContentType: contentType, Size: resp.ContentLength}, nil
+	}
+
+	// If we get here, all attempts failed
+	return nil, errors.Wrapf(lastErr, "failed to download file from any endpoint for MXC URI: %s", mxcURI)
+}
+
+// mediaDownloadURLs returns the media repository URLs to try, in order, for downloading an MXC URI
+func mediaDownloadURLs(serverURL, mxcURI string) ([]string, error) {
+	if mxcURI == "" {
+		return nil, errors.New("MXC URI is empty")
+	}
+
+	// Matrix file URIs are in the format mxc://server/media_id
+	if !strings.HasPrefix(mxcURI, "mxc://") {
+		return nil, errors.New("invalid Matrix MXC URI format")
+	}
+
+	// Extract server and media ID from mxc://server/media_id
+	mxcParts := strings.TrimPrefix(mxcURI, "mxc://")
+	parts := strings.SplitN(mxcParts, "/", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid Matrix MXC URI format")
+	}
+
+	serverName := parts[0]
+	mediaID := parts[1]
+
+	// Validate MXC URI components for path traversal attacks
+	if err := ValidateMXCComponents(serverName, mediaID); err != nil {
+		return nil, errors.Wrap(err, "invalid MXC URI components")
+	}
+
+	// Build secure media download URLs with proper escaping. Client API endpoints come first, as newer
+	// Synapse versions use these, then the media repository API with and without the server name.
+	candidates := []struct {
+		base     string
+		segments []string
+		label    string
+	}{
+		{"/_matrix/client/v1/media/download/", []string{serverName, mediaID}, "client v1 with server"},
+		{"/_matrix/client/v1/media/download/", []string{mediaID}, "client v1 media-only"},
+		{"/_matrix/media/v3/download/", []string{serverName, mediaID}, "media v3 with server"},
+		{"/_matrix/media/v1/download/", []string{serverName, mediaID}, "media v1 with server"},
+		{"/_matrix/media/v3/download/", []string{mediaID}, "media v3 media-only"},
+		{"/_matrix/media/v1/download/", []string{mediaID}, "media v1 media-only"},
+	}
+
+	var downloadURLs []string
+	var errorMsgs []string
+	for _, candidate := range candidates {
+		downloadURL, err := BuildSecureURL(serverURL+candidate.base, candidate.segments...)
+		if err != nil {
+			errorMsgs = append(errorMsgs, errors.Wrap(err, candidate.label).Error())
+			continue
+		}
+		downloadURLs = append(downloadURLs, downloadURL)
+	}
+
+	if len(downloadURLs) == 0 {
+		return nil, errors.Errorf("failed to construct any valid download URLs for MXC URI %s - validation errors: %s",
+			mxcURI, strings.Join(errorMsgs, "; "))
+	}
+	return downloadURLs, nil
+}
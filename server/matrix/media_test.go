@@ -0,0 +1,286 @@
+package matrix
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadFileStream(t *testing.T) {
+	newClient := func(t *testing.T, handler http.HandlerFunc) *Client {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		return NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", NewTestLogger(t), TestRateLimitConfig())
+	}
+
+	t.Run("streams the file", func(t *testing.T) {
+		client := newClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("hello"))
+		})
+
+		download, err := client.DownloadFileStream("mxc://test.com/media", 10, "")
+		require.NoError(t, err)
+		defer func() { _ = download.Body.Close() }()
+
+		assert.Equal(t, int64(5), download.Size)
+		assert.Equal(t, "text/plain", download.ContentType)
+		data, err := io.ReadAll(download.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("announced sizes over the limit are refused up front", func(t *testing.T) {
+		client := newClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		})
+
+		_, err := client.DownloadFileStream("mxc://test.com/media", 10, "")
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+	})
+
+	t.Run("unannounced sizes are enforced while streaming", func(t *testing.T) {
+		client := newClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			// Flushing before the end forces a chunked response without a Content-Length
+			_, _ = w.Write([]byte(strings.Repeat("x", 8)))
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte(strings.Repeat("x", 8)))
+		})
+
+		download, err := client.DownloadFileStream("mxc://test.com/media", 10, "")
+		require.NoError(t, err)
+		defer func() { _ = download.Body.Close() }()
+
+		assert.Equal(t, int64(-1), download.Size)
+		_, err = io.ReadAll(download.Body)
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+	})
+
+	t.Run("DownloadFile reads the whole stream", func(t *testing.T) {
+		client := newClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("png"))
+		})
+
+		data, err := client.DownloadFile("mxc://test.com/media", 10, "image/")
+		require.NoError(t, err)
+		assert.Equal(t, "png", string(data))
+
+		_, err = client.DownloadFile("mxc://test.com/media", 10, "video/")
+		assert.Error(t, err)
+	})
+}
+
+func TestUploadMediaStream(t *testing.T) {
+	var received string
+	var contentLength int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_matrix/media/v3/upload", r.URL.Path)
+		assert.Equal(t, "notes.txt", r.URL.Query().Get("filename"))
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		contentLength = r.ContentLength
+		_, _ = w.Write([]byte(`{"content_uri": "mxc://test.com/uploaded"}`))
+	}))
+	defer server.Close()
+	client := NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", NewTestLogger(t), TestRateLimitConfig())
+
+	mxcURI, err := client.UploadMediaStream(strings.NewReader("some notes"), 10, "notes.txt", "text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, "mxc://test.com/uploaded", mxcURI)
+	assert.Equal(t, "some notes", received)
+	assert.Equal(t, int64(10), contentLength)
+}
+
+func TestFileInfoContent(t *testing.T) {
+	t.Run("plain files only have a size and type", func(t *testing.T) {
+		assert.Equal(t, map[string]any{"size": int64(3), "mimetype": "text/plain"}, fileInfoContent(FileAttachment{Size: 3, MimeType: "text/plain"}))
+	})
+
+	t.Run("media metadata", func(t *testing.T) {
+		info := fileInfoContent(FileAttachment{
+			Size:     1024,
+			MimeType: "image/png",
+			Info: MediaInfo{
+				Width:    640,
+				Height:   480,
+				BlurHash: "LEHV6nWB2yk8pyo0adR*.7kCMdnj",
+				Thumbnail: &MediaThumbnail{
+					MxcURI:   "mxc://test.com/thumbnail",
+					MimeType: "image/jpeg",
+					Size:     100,
+					Width:    64,
+					Height:   48,
+				},
+			},
+		})
+
+		assert.Equal(t, 640, info["w"])
+		assert.Equal(t, 480, info["h"])
+		assert.Equal(t, "LEHV6nWB2yk8pyo0adR*.7kCMdnj", info["xyz.amorgan.blurhash"])
+		assert.Equal(t, "mxc://test.com/thumbnail", info["thumbnail_url"])
+		assert.Equal(t, map[string]any{"w": 64, "h": 48, "mimetype": "image/jpeg", "size": int64(100)}, info["thumbnail_info"])
+		assert.NotContains(t, info, "duration")
+	})
+
+	t.Run("durations", func(t *testing.T) {
+		info := fileInfoContent(FileAttachment{MimeType: "audio/mp4", Info: MediaInfo{DurationMs: 2500}})
+		assert.Equal(t, int64(2500), info["duration"])
+	})
+}
+
+func TestMediaCaptions(t *testing.T) {
+	var events []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&content))
+		events = append(events, content)
+		_, _ = w.Write([]byte(`{"event_id": "$event"}`))
+	}))
+	defer server.Close()
+	client := NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", NewTestLogger(t), TestRateLimitConfig())
+
+	photo := FileAttachment{Filename: "photo.jpg", MxcURI: "mxc://test.com/photo", MimeType: "image/jpeg", Size: 1024}
+	request := MessageRequest{
+		RoomID:      "!room:test.com",
+		GhostUserID: "@_mattermost_alice:test.com",
+		Message:     "Look at this",
+		HTMLMessage: "Look at <em>this</em>",
+		Files:       []FileAttachment{photo},
+		Captions:    true,
+	}
+
+	t.Run("a single file carries the text as its caption", func(t *testing.T) {
+		events = nil
+		_, err := client.SendMessage(request)
+		require.NoError(t, err)
+
+		require.Len(t, events, 1)
+		assert.Equal(t, "m.image", events[0]["msgtype"])
+		assert.Equal(t, "Look at this", events[0]["body"])
+		assert.Equal(t, "Look at <em>this</em>", events[0]["formatted_body"])
+		assert.Equal(t, "photo.jpg", events[0]["filename"])
+		assert.Equal(t, "mxc://test.com/photo", events[0]["url"])
+	})
+
+	t.Run("several files are sent after the text", func(t *testing.T) {
+		events = nil
+		several := request
+		several.Files = []FileAttachment{photo, photo}
+		_, err := client.SendMessage(several)
+		require.NoError(t, err)
+
+		require.Len(t, events, 3)
+		assert.Equal(t, "m.text", events[0]["msgtype"])
+		assert.Equal(t, "photo.jpg", events[1]["body"])
+		assert.NotContains(t, events[1], "filename")
+	})
+
+	t.Run("caption edits keep the media", func(t *testing.T) {
+		events = nil
+		media := map[string]any{"msgtype": "m.image", "body": "Look at this", "filename": "photo.jpg", "url": "mxc://test.com/photo", "info": map[string]any{"size": 1024}}
+
+		_, err := client.EditMediaCaptionAsGhost("!room:test.com", "$photo", media, "Look again", "", "@_mattermost_alice:test.com")
+		require.NoError(t, err)
+		_, err = client.EditMediaCaptionAsGhost("!room:test.com", "$photo", media, "", "", "@_mattermost_alice:test.com")
+		require.NoError(t, err)
+
+		require.Len(t, events, 2)
+		assert.Equal(t, map[string]any{"rel_type": "m.replace", "event_id": "$photo"}, events[0]["m.relates_to"])
+		assert.Equal(t, map[string]any{
+			"msgtype":  "m.image",
+			"body":     "Look again",
+			"filename": "photo.jpg",
+			"url":      "mxc://test.com/photo",
+			"info":     map[string]any{"size": float64(1024)},
+		}, events[0]["m.new_content"])
+		assert.Equal(t, "photo.jpg", events[1]["m.new_content"].(map[string]any)["body"])
+	})
+}
+
+func TestVoiceMessages(t *testing.T) {
+	var content map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&content))
+		_, _ = w.Write([]byte(`{"event_id": "$voice"}`))
+	}))
+	defer server.Close()
+	client := NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", NewTestLogger(t), TestRateLimitConfig())
+
+	_, err := client.SendMessage(MessageRequest{
+		RoomID:      "!room:test.com",
+		GhostUserID: "@_mattermost_alice:test.com",
+		Files: []FileAttachment{{
+			Filename: "recording.mp3",
+			MxcURI:   "mxc://test.com/recording",
+			MimeType: "audio/mpeg",
+			Size:     2048,
+			Info:     MediaInfo{DurationMs: 2500, Voice: true, Waveform: []int{0, 512, 1024}},
+		}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "m.audio", content["msgtype"])
+	assert.Equal(t, map[string]any{}, content[VoiceKey])
+	assert.Equal(t, map[string]any{"duration": float64(2500), "waveform": []any{float64(0), float64(512), float64(1024)}}, content[VoiceAudioKey])
+	assert.Equal(t, float64(2500), content["info"].(map[string]any)["duration"])
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path == "/_matrix/client/v1/media/config" {
+			http.Error(w, `{"errcode":"M_UNRECOGNIZED"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"m.upload.size": 10485760}`))
+	}))
+	defer server.Close()
+	client := NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", NewTestLogger(t), TestRateLimitConfig())
+
+	assert.Equal(t, int64(10485760), client.UploadSizeLimit())
+	assert.Equal(t, []string{"/_matrix/client/v1/media/config", "/_matrix/media/v3/config"}, requests)
+
+	// The config is only fetched again once it's an hour old
+	assert.Equal(t, int64(10485760), client.UploadSizeLimit())
+	assert.Len(t, requests, 2)
+}
+
+func TestFileNotices(t *testing.T) {
+	var content map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&content))
+		_, _ = w.Write([]byte(`{"event_id": "$notice"}`))
+	}))
+	defer server.Close()
+	client := NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", NewTestLogger(t), TestRateLimitConfig())
+
+	_, err := client.SendMessage(MessageRequest{
+		RoomID:      "!room:test.com",
+		GhostUserID: "@_mattermost_alice:test.com",
+		PostID:      "post",
+		Files: []FileAttachment{{
+			Filename:   "video.mp4",
+			MimeType:   "video/mp4",
+			Size:       1 << 30,
+			Notice:     "video.mp4 is too large to bridge.",
+			NoticeHTML: "<em>video.mp4</em> is too large to bridge.",
+		}},
+		Captions: true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "m.notice", content["msgtype"])
+	assert.Equal(t, "video.mp4 is too large to bridge.", content["body"])
+	assert.Equal(t, "<em>video.mp4</em> is too large to bridge.", content["formatted_body"])
+	assert.Equal(t, "post", content["mattermost_post_id"])
+	assert.NotContains(t, content, "url")
+	assert.NotContains(t, content, "info")
+}
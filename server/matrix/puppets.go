@@ -0,0 +1,213 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// PuppetEventKey marks the content of events the bridge sent as a Mattermost user's own Matrix account, so
+// their echoes aren't bridged back to Mattermost
+const PuppetEventKey = "com.mattermost.bridge.puppet"
+
+// Puppet is a Matrix account a Mattermost user has linked, which the bridge acts as in place of their ghost
+// user (double puppeting)
+type Puppet struct {
+	UserID      string
+	AccessToken string
+}
+
+// PuppetStore looks up the Matrix accounts linked to ghost users
+type PuppetStore interface {
+	// GetPuppet returns the Matrix account linked to a ghost user, or nil if there is none
+	GetPuppet(ghostUserID string) *Puppet
+}
+
+// SetPuppetStore sets the store events sent as ghost users look up linked Matrix accounts in
+func (c *Client) SetPuppetStore(store PuppetStore) {
+	c.puppetStore = store
+}
+
+// puppetFor returns the Matrix account linked to a ghost user, or nil if events go out as the ghost user
+func (c *Client) puppetFor(ghostUserID string) *Puppet {
+	if c.puppetStore == nil || ghostUserID == "" {
+		return nil
+	}
+	puppet := c.puppetStore.GetPuppet(ghostUserID)
+	if puppet == nil || puppet.UserID == "" || puppet.AccessToken == "" {
+		return nil
+	}
+	return puppet
+}
+
+// IsPuppetEvent reports whether event content was sent by the bridge as a linked Matrix account
+func IsPuppetEvent(content map[string]any) bool {
+	marked, _ := content[PuppetEventKey].(bool)
+	return marked
+}
+
+// markPuppetEvent returns a copy of event content marked as sent by the bridge as a linked Matrix account
+func markPuppetEvent(content any) any {
+	fields, ok := content.(map[string]any)
+	if !ok {
+		return content
+	}
+	marked := make(map[string]any, len(fields)+1)
+	for key, value := range fields {
+		marked[key] = value
+	}
+	marked[PuppetEventKey] = true
+	return marked
+}
+
+// putEventAsUser PUTs an event to a room send or redact endpoint as a user. Ghost users with a linked Matrix
+// account act through that account, falling back to the ghost user when it can't, such as when the account
+// isn't in the room or its token was revoked.
+func (c *Client) putEventAsUser(endpoint string, content any, userID string) (*SendEventResponse, error) {
+	if puppet := c.puppetFor(userID); puppet != nil {
+		response, err := c.putEvent(endpoint, markPuppetEvent(content), "", puppet.AccessToken)
+		if err == nil {
+			return response, nil
+		}
+		c.logger.LogWarn("Failed to act as linked Matrix account, using ghost user", "error", err, "matrix_user_id", puppet.UserID, "ghost_user_id", userID)
+	}
+	return c.putEvent(endpoint, content, userID, c.asToken)
+}
+
+// putEvent PUTs an event to a room send or redact endpoint with an access token. A userID impersonates that
+// user through the application service token.
+func (c *Client) putEvent(endpoint string, content any, userID, accessToken string) (*SendEventResponse, error) {
+	reqURL := c.serverURL + endpoint
+	if userID != "" {
+		reqURL += "?user_id=" + url.QueryEscape(userID)
+	}
+
+	jsonData, err := json.Marshal(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal event content")
+	}
+
+	req, err := http.NewRequest("PUT", reqURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read response body")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("matrix API error: %d %s", resp.StatusCode, string(body))
+	}
+
+	var response SendEventResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal response")
+	}
+
+	return &response, nil
+}
+
+// WhoAmI returns the Matrix user ID an access token belongs to
+func (c *Client) WhoAmI(accessToken string) (string, error) {
+	req, err := http.NewRequest("GET", c.serverURL+"/_matrix/client/v3/account/whoami", nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create whoami request")
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var response struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.doJSON(req, &response); err != nil {
+		return "", errors.Wrap(err, "failed to look up access token owner")
+	}
+	if response.UserID == "" {
+		return "", errors.New("whoami response missing user_id")
+	}
+	return response.UserID, nil
+}
+
+// LoginAsUser logs in as a user of the homeserver through an application service whose namespace covers them
+// (m.login.application_service), returning the access token of a new device. An empty asToken uses the
+// bridge's own.
+func (c *Client) LoginAsUser(userID, asToken, deviceName string) (string, error) {
+	if asToken == "" {
+		asToken = c.asToken
+	}
+
+	jsonData, err := json.Marshal(map[string]any{
+		"type":                        "m.login.application_service",
+		"identifier":                  map[string]any{"type": "m.id.user", "user": userID},
+		"initial_device_display_name": deviceName,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal login request")
+	}
+
+	req, err := http.NewRequest("POST", c.serverURL+"/_matrix/client/v3/login", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create login request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+asToken)
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := c.doJSON(req, &response); err != nil {
+		return "", errors.Wrap(err, "failed to log in as Matrix user")
+	}
+	if response.AccessToken == "" {
+		return "", errors.New("login response missing access_token")
+	}
+	return response.AccessToken, nil
+}
+
+// Logout invalidates an access token
+func (c *Client) Logout(accessToken string) error {
+	req, err := http.NewRequest("POST", c.serverURL+"/_matrix/client/v3/logout", bytes.NewBufferString("{}"))
+	if err != nil {
+		return errors.Wrap(err, "failed to create logout request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	return errors.Wrap(c.doJSON(req, nil), "failed to log out")
+}
+
+// doJSON sends a request and decodes a successful JSON response into result, which may be nil
+func (c *Client) doJSON(req *http.Request, result any) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read response body")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("matrix API error: %d %s", resp.StatusCode, string(body))
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(body, result), "failed to unmarshal response")
+}
@@ -0,0 +1,133 @@
+package matrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPuppetStore map[string]*Puppet
+
+func (s testPuppetStore) GetPuppet(ghostUserID string) *Puppet {
+	return s[ghostUserID]
+}
+
+// puppetRequest is a request the test homeserver received
+type puppetRequest struct {
+	path    string
+	token   string
+	userID  string
+	content map[string]any
+}
+
+func TestPuppetSending(t *testing.T) {
+	const ghost = "@_mattermost_user1:test.com"
+
+	setup := func(t *testing.T, puppetStatus int) (*Client, func() []puppetRequest) {
+		var mutex sync.Mutex
+		var requests []puppetRequest
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request := puppetRequest{
+				path:   r.URL.Path,
+				token:  strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+				userID: r.URL.Query().Get("user_id"),
+			}
+			_ = json.NewDecoder(r.Body).Decode(&request.content)
+			mutex.Lock()
+			requests = append(requests, request)
+			mutex.Unlock()
+
+			if request.token == "puppet_token" && puppetStatus != http.StatusOK {
+				w.WriteHeader(puppetStatus)
+				_, _ = w.Write([]byte(`{"errcode":"M_FORBIDDEN"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"event_id":"$sent"}`))
+		}))
+		t.Cleanup(server.Close)
+
+		client := NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", NewTestLogger(t), TestRateLimitConfig())
+		client.SetPuppetStore(testPuppetStore{ghost: {UserID: "@alice:test.com", AccessToken: "puppet_token"}})
+		return client, func() []puppetRequest {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return append([]puppetRequest(nil), requests...)
+		}
+	}
+
+	t.Run("linked ghosts send as their Matrix account", func(t *testing.T) {
+		client, requests := setup(t, http.StatusOK)
+
+		_, err := client.SendReactionAsGhost("!room:test.com", "$event", "👍", ghost)
+		require.NoError(t, err)
+
+		sent := requests()
+		require.Len(t, sent, 1)
+		assert.Equal(t, "puppet_token", sent[0].token)
+		assert.Empty(t, sent[0].userID)
+		assert.True(t, IsPuppetEvent(sent[0].content))
+	})
+
+	t.Run("rooms the account can't send to fall back to the ghost", func(t *testing.T) {
+		client, requests := setup(t, http.StatusForbidden)
+
+		_, err := client.SendReactionAsGhost("!room:test.com", "$event", "👍", ghost)
+		require.NoError(t, err)
+
+		sent := requests()
+		require.Len(t, sent, 2)
+		assert.Equal(t, "as_token", sent[1].token)
+		assert.Equal(t, ghost, sent[1].userID)
+		assert.False(t, IsPuppetEvent(sent[1].content))
+	})
+
+	t.Run("redactions go through the linked account", func(t *testing.T) {
+		client, requests := setup(t, http.StatusOK)
+
+		_, err := client.RedactEventAsGhost("!room:test.com", "$event", ghost)
+		require.NoError(t, err)
+
+		sent := requests()
+		require.Len(t, sent, 1)
+		assert.Contains(t, sent[0].path, "/redact/")
+		assert.Equal(t, "puppet_token", sent[0].token)
+	})
+
+	t.Run("unlinked ghosts send as themselves", func(t *testing.T) {
+		client, requests := setup(t, http.StatusOK)
+
+		_, err := client.SendReactionAsGhost("!room:test.com", "$event", "👍", "@_mattermost_user2:test.com")
+		require.NoError(t, err)
+
+		sent := requests()
+		require.Len(t, sent, 1)
+		assert.Equal(t, "as_token", sent[0].token)
+		assert.Equal(t, "@_mattermost_user2:test.com", sent[0].userID)
+	})
+}
+
+func TestLoginAsUser(t *testing.T) {
+	var body map[string]any
+	var token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/_matrix/client/v3/login", r.URL.Path)
+		token = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"access_token":"new_token","user_id":"@alice:test.com"}`))
+	}))
+	t.Cleanup(server.Close)
+	client := NewClientWithLoggerAndRateLimit(server.URL, "as_token", "remote_id", "", NewTestLogger(t), TestRateLimitConfig())
+
+	accessToken, err := client.LoginAsUser("@alice:test.com", "puppet_as_token", "Mattermost Bridge")
+	require.NoError(t, err)
+	assert.Equal(t, "new_token", accessToken)
+	assert.Equal(t, "Bearer puppet_as_token", token)
+	assert.Equal(t, "m.login.application_service", body["type"])
+	assert.Equal(t, map[string]any{"type": "m.id.user", "user": "@alice:test.com"}, body["identifier"])
+}
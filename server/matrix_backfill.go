@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// BackfillMatrixRoom imports up to limit of a Matrix room's most recent messages into its mapped channel,
+// oldest first, and returns how many were imported
+func (p *Plugin) BackfillMatrixRoom(channelID, roomID string, limit int) (int, error) {
+	if p.matrixClient == nil {
+		return 0, errors.New("matrix client not configured")
+	}
+
+	rawEvents, err := p.matrixClient.GetRoomMessages(roomID, limit)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get Matrix room history")
+	}
+
+	imported := 0
+	// Messages come back newest first - replay them in the order they were sent
+	for i := len(rawEvents) - 1; i >= 0; i-- {
+		event, err := matrixEventFromMap(rawEvents[i])
+		if err != nil {
+			p.logger.LogWarn("Failed to parse Matrix history event", "error", err, "room_id", roomID)
+			continue
+		}
+
+		if !p.shouldBackfillMatrixEvent(event) {
+			continue
+		}
+
+		if err := p.matrixToMattermostBridge.syncMatrixMessageToMattermost(event, channelID); err != nil {
+			p.logger.LogWarn("Failed to import Matrix history event", "error", err, "event_id", event.EventID, "room_id", roomID, "channel_id", channelID)
+			continue
+		}
+		imported++
+	}
+
+	p.logger.LogInfo("Backfilled Matrix room history", "room_id", roomID, "channel_id", channelID, "imported", imported, "fetched", len(rawEvents))
+	return imported, nil
+}
+
+// shouldBackfillMatrixEvent reports whether a history event is a user message worth importing
+func (p *Plugin) shouldBackfillMatrixEvent(event MatrixEvent) bool {
+	if event.Type != "m.room.message" || p.isGhostUser(event.Sender) {
+		return false
+	}
+
+	// Redacted messages come back with empty content
+	if len(event.Content) == 0 {
+		return false
+	}
+
+	// Old bridge bot commands are noise in the imported history
+	if body, ok := event.Content["body"].(string); ok {
+		if _, isCommand := parseBridgeBotCommand(body); isCommand {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matrixEventFromMap converts a raw event returned by the client-server API into a MatrixEvent
+func matrixEventFromMap(raw map[string]any) (MatrixEvent, error) {
+	var event MatrixEvent
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return event, errors.Wrap(err, "failed to marshal Matrix event")
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return event, errors.Wrap(err, "failed to unmarshal Matrix event")
+	}
+	return event, nil
+}
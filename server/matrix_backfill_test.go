@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldBackfillMatrixEvent(t *testing.T) {
+	plugin := setupPluginForTest()
+	plugin.configuration = &configuration{MatrixServerURL: "https://test.com"}
+
+	tests := []struct {
+		name     string
+		event    MatrixEvent
+		expected bool
+	}{
+		{
+			name:     "user message",
+			event:    MatrixEvent{Type: "m.room.message", Sender: "@alice:example.com", Content: map[string]any{"msgtype": "m.text", "body": "hello"}},
+			expected: true,
+		},
+		{
+			name:     "state event",
+			event:    MatrixEvent{Type: "m.room.member", Sender: "@alice:example.com", Content: map[string]any{"membership": "join"}},
+			expected: false,
+		},
+		{
+			name:     "ghost user message",
+			event:    MatrixEvent{Type: "m.room.message", Sender: "@_mattermost_abc:test.com", Content: map[string]any{"msgtype": "m.text", "body": "hello"}},
+			expected: false,
+		},
+		{
+			name:     "redacted message",
+			event:    MatrixEvent{Type: "m.room.message", Sender: "@alice:example.com", Content: map[string]any{}},
+			expected: false,
+		},
+		{
+			name:     "bridge bot command",
+			event:    MatrixEvent{Type: "m.room.message", Sender: "@alice:example.com", Content: map[string]any{"msgtype": "m.text", "body": "!mm status"}},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, plugin.shouldBackfillMatrixEvent(tt.event))
+		})
+	}
+}
+
+func TestMatrixEventFromMap(t *testing.T) {
+	event, err := matrixEventFromMap(map[string]any{
+		"type":             "m.room.message",
+		"event_id":         "$event",
+		"sender":           "@alice:example.com",
+		"room_id":          "!room:example.com",
+		"origin_server_ts": float64(1700000000000),
+		"content":          map[string]any{"msgtype": "m.text", "body": "hello"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "$event", event.EventID)
+	assert.Equal(t, "@alice:example.com", event.Sender)
+	assert.Equal(t, int64(1700000000000), event.Timestamp)
+	assert.Equal(t, "hello", event.Content["body"])
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Statuses of Matrix users invited to a bridged channel from Mattermost
+const (
+	matrixInviteStatusInvited  = "invited"
+	matrixInviteStatusJoined   = "joined"
+	matrixInviteStatusDeclined = "declined"
+)
+
+// matrixInvite is the KV record tracking a Matrix user invited to a bridged channel
+type matrixInvite struct {
+	MatrixUserID     string `json:"matrix_user_id"`
+	MattermostUserID string `json:"mattermost_user_id"`
+	ChannelID        string `json:"channel_id"`
+	RoomID           string `json:"room_id"`
+	InvitedBy        string `json:"invited_by"`
+	Status           string `json:"status"`
+	CreateAt         int64  `json:"create_at"`
+	UpdateAt         int64  `json:"update_at"`
+}
+
+// toCommandInvite converts the stored invite into the shape reported by the command handler
+func (i *matrixInvite) toCommandInvite() *command.MatrixInvite {
+	return &command.MatrixInvite{
+		MatrixUserID:     i.MatrixUserID,
+		MattermostUserID: i.MattermostUserID,
+		RoomID:           i.RoomID,
+		InvitedBy:        i.InvitedBy,
+		Status:           i.Status,
+		CreateAt:         i.CreateAt,
+		UpdateAt:         i.UpdateAt,
+	}
+}
+
+// InviteMatrixUserToChannel invites a Matrix user to the room bridged with a channel, pre-creates their
+// Mattermost user as a channel member and records the invite so its status can be reported
+func (p *Plugin) InviteMatrixUserToChannel(channelID, matrixUserID, invitedBy string) (*command.MatrixInvite, error) {
+	roomIdentifier, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Matrix room for channel")
+	}
+	if roomIdentifier == "" {
+		return nil, errors.New("channel is not mapped to a Matrix room")
+	}
+
+	roomID, err := p.matrixClient.ResolveRoomAlias(roomIdentifier)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve Matrix room identifier")
+	}
+
+	if err := p.matrixClient.InviteUserToRoom(roomID, matrixUserID); err != nil {
+		return nil, errors.Wrap(err, "failed to invite Matrix user to room")
+	}
+
+	// Pre-create the Mattermost user so the invitee shows up as a channel member right away
+	mattermostUserID, err := p.matrixToMattermostBridge.getOrCreateMattermostUser(matrixUserID, channelID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get or create Mattermost user for Matrix user")
+	}
+
+	if err := p.matrixToMattermostBridge.ensureUserInChannel(mattermostUserID, channelID); err != nil {
+		return nil, errors.Wrap(err, "failed to add Matrix user to channel")
+	}
+
+	now := model.GetMillis()
+	invite := &matrixInvite{
+		MatrixUserID:     matrixUserID,
+		MattermostUserID: mattermostUserID,
+		ChannelID:        channelID,
+		RoomID:           roomID,
+		InvitedBy:        invitedBy,
+		Status:           matrixInviteStatusInvited,
+		CreateAt:         now,
+		UpdateAt:         now,
+	}
+
+	// Re-inviting someone who already joined keeps their joined status
+	if existing := p.matrixToMattermostBridge.getMatrixInvite(channelID, matrixUserID); existing != nil && existing.Status == matrixInviteStatusJoined {
+		invite.Status = matrixInviteStatusJoined
+		invite.CreateAt = existing.CreateAt
+	}
+
+	if err := p.matrixToMattermostBridge.saveMatrixInvite(invite); err != nil {
+		p.logger.LogWarn("Failed to store Matrix invite", "error", err, "channel_id", channelID, "matrix_user_id", matrixUserID)
+	}
+
+	p.logger.LogInfo("Invited Matrix user to bridged channel", "matrix_user_id", matrixUserID, "mattermost_user_id", mattermostUserID, "channel_id", channelID, "room_id", roomID, "invited_by", invitedBy)
+	return invite.toCommandInvite(), nil
+}
+
+// GetMatrixInvites returns the tracked Matrix invites for a channel
+func (p *Plugin) GetMatrixInvites(channelID string) ([]*command.MatrixInvite, error) {
+	prefix := kvstore.BuildMatrixInviteChannelPrefix(channelID)
+	const perPage = 1000
+
+	var invites []*command.MatrixInvite
+	for page := 0; ; page++ {
+		keys, err := p.kvstore.ListKeysWithPrefix(page, perPage, prefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list Matrix invites")
+		}
+
+		for _, key := range keys {
+			data, err := p.kvstore.Get(key)
+			if err != nil || len(data) == 0 {
+				continue
+			}
+
+			var invite matrixInvite
+			if err := json.Unmarshal(data, &invite); err != nil {
+				p.logger.LogWarn("Failed to parse stored Matrix invite", "error", err, "key", key)
+				continue
+			}
+			invites = append(invites, invite.toCommandInvite())
+		}
+
+		if len(keys) < perPage {
+			break
+		}
+	}
+
+	return invites, nil
+}
+
+// getMatrixInvite loads a tracked invite, returning nil if there is none
+func (b *BridgeUtils) getMatrixInvite(channelID, matrixUserID string) *matrixInvite {
+	data, err := b.kvstore.Get(kvstore.BuildMatrixInviteKey(channelID, matrixUserID))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	var invite matrixInvite
+	if err := json.Unmarshal(data, &invite); err != nil {
+		b.logger.LogWarn("Failed to parse stored Matrix invite", "error", err, "channel_id", channelID, "matrix_user_id", matrixUserID)
+		return nil
+	}
+	return &invite
+}
+
+// saveMatrixInvite stores a tracked invite
+func (b *BridgeUtils) saveMatrixInvite(invite *matrixInvite) error {
+	data, err := json.Marshal(invite)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal Matrix invite")
+	}
+	return b.kvstore.Set(kvstore.BuildMatrixInviteKey(invite.ChannelID, invite.MatrixUserID), data)
+}
+
+// updateMatrixInviteStatus updates a tracked invite when the invitee's membership changes
+func (b *BridgeUtils) updateMatrixInviteStatus(channelID, matrixUserID, membership string) {
+	invite := b.getMatrixInvite(channelID, matrixUserID)
+	if invite == nil {
+		return
+	}
+
+	var status string
+	switch membership {
+	case "join":
+		status = matrixInviteStatusJoined
+	case "leave", "ban":
+		// Leaving after joining is a regular leave, not a declined invite
+		if invite.Status != matrixInviteStatusInvited {
+			return
+		}
+		status = matrixInviteStatusDeclined
+	default:
+		return
+	}
+
+	if invite.Status == status {
+		return
+	}
+
+	invite.Status = status
+	invite.UpdateAt = model.GetMillis()
+	if err := b.saveMatrixInvite(invite); err != nil {
+		b.logger.LogWarn("Failed to update Matrix invite status", "error", err, "channel_id", channelID, "matrix_user_id", matrixUserID, "status", status)
+		return
+	}
+
+	b.logger.LogDebug("Updated Matrix invite status", "channel_id", channelID, "matrix_user_id", matrixUserID, "status", status)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateMatrixInviteStatus(t *testing.T) {
+	setup := func(t *testing.T, status string) *Plugin {
+		plugin := setupPluginForTest()
+		plugin.kvstore = NewMemoryKVStore()
+		plugin.configuration = &configuration{MatrixServerURL: "https://test.com"}
+		plugin.initBridges()
+
+		if status != "" {
+			require.NoError(t, plugin.matrixToMattermostBridge.saveMatrixInvite(&matrixInvite{
+				MatrixUserID: "@alice:example.com",
+				ChannelID:    "channel_id",
+				RoomID:       "!room:test.com",
+				Status:       status,
+			}))
+		}
+		return plugin
+	}
+
+	tests := []struct {
+		name           string
+		initialStatus  string
+		membership     string
+		expectedStatus string
+	}{
+		{name: "join accepts invite", initialStatus: matrixInviteStatusInvited, membership: "join", expectedStatus: matrixInviteStatusJoined},
+		{name: "leave declines invite", initialStatus: matrixInviteStatusInvited, membership: "leave", expectedStatus: matrixInviteStatusDeclined},
+		{name: "ban declines invite", initialStatus: matrixInviteStatusInvited, membership: "ban", expectedStatus: matrixInviteStatusDeclined},
+		{name: "leave after joining keeps joined", initialStatus: matrixInviteStatusJoined, membership: "leave", expectedStatus: matrixInviteStatusJoined},
+		{name: "join after declining", initialStatus: matrixInviteStatusDeclined, membership: "join", expectedStatus: matrixInviteStatusJoined},
+		{name: "repeated invite membership is ignored", initialStatus: matrixInviteStatusInvited, membership: "invite", expectedStatus: matrixInviteStatusInvited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := setup(t, tt.initialStatus)
+			bridge := plugin.matrixToMattermostBridge
+
+			bridge.updateMatrixInviteStatus("channel_id", "@alice:example.com", tt.membership)
+
+			invite := bridge.getMatrixInvite("channel_id", "@alice:example.com")
+			require.NotNil(t, invite)
+			assert.Equal(t, tt.expectedStatus, invite.Status)
+		})
+	}
+
+	t.Run("untracked users are ignored", func(t *testing.T) {
+		plugin := setup(t, "")
+		bridge := plugin.matrixToMattermostBridge
+
+		bridge.updateMatrixInviteStatus("channel_id", "@alice:example.com", "join")
+		assert.Nil(t, bridge.getMatrixInvite("channel_id", "@alice:example.com"))
+	})
+
+	t.Run("invites are listed per channel", func(t *testing.T) {
+		plugin := setup(t, matrixInviteStatusInvited)
+		require.NoError(t, plugin.matrixToMattermostBridge.saveMatrixInvite(&matrixInvite{
+			MatrixUserID: "@bob:example.com",
+			ChannelID:    "other_channel_id",
+			Status:       matrixInviteStatusInvited,
+		}))
+
+		invites, err := plugin.GetMatrixInvites("channel_id")
+		require.NoError(t, err)
+		require.Len(t, invites, 1)
+		assert.Equal(t, "@alice:example.com", invites[0].MatrixUserID)
+		assert.Equal(t, "!room:test.com", invites[0].RoomID)
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// ghostRoomAliasPrefix is the exclusive alias namespace the bridge reserves in its registration
+const ghostRoomAliasPrefix = "#_mattermost_"
+
+// errMatrixQueryNotFound signals that a queried user or alias doesn't correspond to anything bridgeable
+var errMatrixQueryNotFound = errors.New("not found")
+
+// handleMatrixUserQuery answers the homeserver's query for a user in the bridge's namespace, creating the
+// ghost user on demand so Matrix users can mention and invite Mattermost users that haven't posted yet
+func (p *Plugin) handleMatrixUserQuery(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["userId"]
+
+	ghostUserID, err := p.provisionGhostUserForQuery(userID)
+	if err != nil {
+		if errors.Is(err, errMatrixQueryNotFound) {
+			p.logger.LogDebug("Matrix user query for unknown user", "user_id", userID)
+			p.writeMatrixQueryError(w, http.StatusNotFound, "M_NOT_FOUND", "No such user")
+			return
+		}
+		p.logger.LogError("Failed to provision ghost user for Matrix query", "error", err, "user_id", userID)
+		p.writeMatrixQueryError(w, http.StatusInternalServerError, "M_UNKNOWN", "Failed to provision user")
+		return
+	}
+
+	p.logger.LogDebug("Provisioned ghost user for Matrix query", "user_id", userID, "ghost_user_id", ghostUserID)
+	p.writeMatrixQueryResponse(w)
+}
+
+// handleMatrixRoomAliasQuery answers the homeserver's query for an alias in the bridge's namespace by creating
+// and mapping a Matrix room for the public channel it names
+func (p *Plugin) handleMatrixRoomAliasQuery(w http.ResponseWriter, r *http.Request) {
+	roomAlias := mux.Vars(r)["roomAlias"]
+
+	roomID, err := p.provisionRoomForAliasQuery(roomAlias)
+	if err != nil {
+		if errors.Is(err, errMatrixQueryNotFound) {
+			p.logger.LogDebug("Matrix room alias query for unknown channel", "room_alias", roomAlias)
+			p.writeMatrixQueryError(w, http.StatusNotFound, "M_NOT_FOUND", "No such room")
+			return
+		}
+		p.logger.LogError("Failed to provision room for Matrix alias query", "error", err, "room_alias", roomAlias)
+		p.writeMatrixQueryError(w, http.StatusInternalServerError, "M_UNKNOWN", "Failed to provision room")
+		return
+	}
+
+	p.logger.LogInfo("Provisioned Matrix room for alias query", "room_alias", roomAlias, "room_id", roomID)
+	p.writeMatrixQueryResponse(w)
+}
+
+// provisionGhostUserForQuery creates (or finds) the ghost user for a queried Matrix user ID
+func (p *Plugin) provisionGhostUserForQuery(userID string) (string, error) {
+	if !p.isGhostUser(userID) {
+		return "", errMatrixQueryNotFound
+	}
+
+	mattermostUserID := p.extractMattermostUserIDFromGhost(userID)
+	if !model.IsValidId(mattermostUserID) {
+		return "", errMatrixQueryNotFound
+	}
+
+	user, appErr := p.API.GetUser(mattermostUserID)
+	if appErr != nil {
+		return "", errMatrixQueryNotFound
+	}
+
+	// Matrix-originated users already have a real Matrix account and deleted users shouldn't come back
+	if user.IsRemote() || user.DeleteAt != 0 {
+		return "", errMatrixQueryNotFound
+	}
+
+	ghostUserID, err := p.mattermostToMatrixBridge.CreateOrGetGhostUser(mattermostUserID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create ghost user")
+	}
+
+	return ghostUserID, nil
+}
+
+// provisionRoomForAliasQuery resolves a queried alias to a public channel, creates and maps a room for it
+// if needed, and points the alias at that room. Returns the room ID.
+func (p *Plugin) provisionRoomForAliasQuery(roomAlias string) (string, error) {
+	channel := p.getChannelForRoomAlias(roomAlias)
+	if channel == nil || channel.Type != model.ChannelTypeOpen || channel.DeleteAt != 0 {
+		return "", errMatrixQueryNotFound
+	}
+
+	// A channel that is already bridged just gains the queried alias
+	if existingRoom, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channel.Id); err == nil && existingRoom != "" {
+		roomID, err := p.matrixClient.ResolveRoomAlias(existingRoom)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to resolve mapped Matrix room")
+		}
+		if err := p.matrixClient.AddRoomAlias(roomID, roomAlias); err != nil {
+			return "", errors.Wrap(err, "failed to add alias to mapped Matrix room")
+		}
+		return roomID, nil
+	}
+
+	// Rooms for public channels are public too, so whoever looked up the alias can join it. The alias
+	// is added separately below because CreateRoom would derive a different one from the room name.
+	roomID, err := p.matrixClient.CreateRoom(channel.DisplayName, channel.Purpose, "", true, channel.Id)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create Matrix room")
+	}
+
+	if err := p.matrixClient.AddRoomAlias(roomID, roomAlias); err != nil {
+		return "", errors.Wrap(err, "failed to add alias to new Matrix room")
+	}
+
+	if err := p.mattermostToMatrixBridge.setChannelRoomMapping(channel.Id, roomID); err != nil {
+		return "", errors.Wrap(err, "failed to save channel mapping")
+	}
+
+	if err := p.AddChannelToTeamSpace(channel.Id, roomID); err != nil {
+		p.logger.LogWarn("Failed to add alias-provisioned room to team space", "error", err, "channel_id", channel.Id, "room_id", roomID)
+	}
+
+	if err := p.shareChannelWithBridge(channel); err != nil {
+		// The mapping is in place; an admin can still enable sharing for the channel manually
+		p.logger.LogWarn("Failed to share channel for alias-provisioned room", "error", err, "channel_id", channel.Id, "room_id", roomID)
+	}
+
+	return roomID, nil
+}
+
+// getChannelForRoomAlias finds the channel named by an alias in the bridge's namespace. The local part after
+// the prefix is either a channel ID (#_mattermost_<channel_id>) or a team and channel name
+// (#_mattermost_<team>_<channel>). Team names can't contain underscores, so the first one separates the two.
+func (p *Plugin) getChannelForRoomAlias(roomAlias string) *model.Channel {
+	if !strings.HasPrefix(roomAlias, ghostRoomAliasPrefix) {
+		return nil
+	}
+
+	localpart, server, found := strings.Cut(strings.TrimPrefix(roomAlias, ghostRoomAliasPrefix), ":")
+	if !found || localpart == "" {
+		return nil
+	}
+	if serverDomain, err := p.matrixClient.GetServerDomain(); err != nil || server != serverDomain {
+		return nil
+	}
+
+	if model.IsValidId(localpart) {
+		if channel, appErr := p.API.GetChannel(localpart); appErr == nil {
+			return channel
+		}
+	}
+
+	teamName, channelName, found := strings.Cut(localpart, "_")
+	if !found || teamName == "" || channelName == "" {
+		return nil
+	}
+
+	channel, appErr := p.API.GetChannelByNameForTeamName(teamName, channelName, false)
+	if appErr != nil {
+		return nil
+	}
+	return channel
+}
+
+// shareChannelWithBridge shares a channel and invites the bridge's remote to it so its posts are synced
+func (p *Plugin) shareChannelWithBridge(channel *model.Channel) error {
+	return command.ShareChannelWithBridge(p.API, p.remoteID, channel.Id, channel.TeamId, channel.CreatorId, channel.DisplayName, channel.Purpose)
+}
+
+// writeMatrixQueryResponse writes the empty JSON object the application service API expects on success
+func (p *Plugin) writeMatrixQueryResponse(w http.ResponseWriter) {
+	p.writeMatrixQueryJSON(w, map[string]any{})
+}
+
+// writeMatrixQueryJSON writes a successful application service API response
+func (p *Plugin) writeMatrixQueryJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		p.logger.LogWarn("Failed to write Matrix query response", "error", err)
+	}
+}
+
+// writeMatrixQueryError writes a Matrix standard error response
+func (p *Plugin) writeMatrixQueryError(w http.ResponseWriter, statusCode int, errCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(map[string]string{"errcode": errCode, "error": message}); err != nil {
+		p.logger.LogWarn("Failed to write Matrix query error", "error", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAliasHomeserver records aliases the bridge adds to rooms
+type fakeAliasHomeserver struct {
+	mu      sync.Mutex
+	aliases []string
+}
+
+func (f *fakeAliasHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/directory/room/") {
+		f.aliases = append(f.aliases, strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/directory/room/"))
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func setupMatrixQueryTest(t *testing.T) (*Plugin, *plugintest.API, *fakeAliasHomeserver) {
+	homeserver := &fakeAliasHomeserver{}
+	plugin, api := setupPluginWithHomeserver(t, homeserver)
+	plugin.configuration = &configuration{
+		MatrixServerURL: "https://test.com",
+		MatrixHSToken:   "hs_token",
+		EnableSync:      true,
+	}
+	plugin.initBridges()
+
+	return plugin, api, homeserver
+}
+
+func serveMatrixQuery(plugin *Plugin, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Authorization", "Bearer hs_token")
+	plugin.ServeHTTP(nil, w, r)
+	return w
+}
+
+func TestMatrixUserQuery(t *testing.T) {
+	localUserID := model.NewId()
+
+	t.Run("users outside the ghost namespace are not found", func(t *testing.T) {
+		plugin, _, _ := setupMatrixQueryTest(t)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/users/@alice:test.com")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "M_NOT_FOUND")
+	})
+
+	t.Run("ghosts of unknown Mattermost users are not found", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		api.On("GetUser", localUserID).Return(nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound))
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/users/@_mattermost_"+localUserID+":test.com")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("remote users have no ghost", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		remoteID := "remote_id"
+		api.On("GetUser", localUserID).Return(&model.User{Id: localUserID, RemoteId: &remoteID}, nil)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/users/@_mattermost_"+localUserID+":test.com")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("existing ghosts are acknowledged", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		ghostUserID := "@_mattermost_" + localUserID + ":test.com"
+		api.On("GetUser", localUserID).Return(&model.User{Id: localUserID, Username: "alice"}, nil)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildGhostUserKey(localUserID), []byte(ghostUserID)))
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/users/"+ghostUserID)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, "{}", w.Body.String())
+	})
+
+	t.Run("queries require the homeserver token", func(t *testing.T) {
+		plugin, _, _ := setupMatrixQueryTest(t)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/_matrix/app/v1/users/@_mattermost_"+localUserID+":test.com", nil)
+		r.Header.Set("Authorization", "Bearer wrong")
+		plugin.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestMatrixRoomAliasQuery(t *testing.T) {
+	channelID := model.NewId()
+	aliasPath := func(alias string) string {
+		return "/_matrix/app/v1/rooms/" + url.PathEscape(alias)
+	}
+
+	t.Run("aliases outside the bridge namespace are not found", func(t *testing.T) {
+		plugin, _, _ := setupMatrixQueryTest(t)
+
+		w := serveMatrixQuery(plugin, aliasPath("#general:test.com"))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("aliases on another server are not found", func(t *testing.T) {
+		plugin, _, _ := setupMatrixQueryTest(t)
+
+		w := serveMatrixQuery(plugin, aliasPath("#_mattermost_"+channelID+":other.com"))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("private channels are not exposed", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		api.On("GetChannelByNameForTeamName", "team", "secret", false).Return(&model.Channel{Id: channelID, Type: model.ChannelTypePrivate}, nil)
+
+		w := serveMatrixQuery(plugin, aliasPath("#_mattermost_team_secret:test.com"))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("mapped public channels gain the alias", func(t *testing.T) {
+		plugin, api, homeserver := setupMatrixQueryTest(t)
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Type: model.ChannelTypeOpen}, nil)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(channelID), []byte("!room:test.com")))
+
+		alias := "#_mattermost_" + channelID + ":test.com"
+		w := serveMatrixQuery(plugin, aliasPath(alias))
+		assert.Equal(t, http.StatusOK, w.Code)
+		require.Len(t, homeserver.aliases, 1)
+
+		addedAlias, err := url.QueryUnescape(homeserver.aliases[0])
+		require.NoError(t, err)
+		assert.Equal(t, alias, addedAlias)
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// thirdPartyProtocol is the protocol name the bridge advertises in its registration
+const thirdPartyProtocol = "mattermost"
+
+// thirdPartySearchLimit caps how many users or channels a single third-party lookup returns
+const thirdPartySearchLimit = 20
+
+// thirdPartyChannelsPerPage is the page size used when listing a team's public channels for a lookup without a
+// search term
+const thirdPartyChannelsPerPage = 200
+
+// thirdPartyFieldType describes how clients should validate and prompt for a protocol field
+type thirdPartyFieldType struct {
+	Regexp      string `json:"regexp"`
+	Placeholder string `json:"placeholder"`
+}
+
+// thirdPartyProtocolInstance is a network reachable through the protocol - one per Mattermost team
+type thirdPartyProtocolInstance struct {
+	Desc      string            `json:"desc"`
+	Fields    map[string]string `json:"fields"`
+	NetworkID string            `json:"network_id"`
+}
+
+// thirdPartyProtocolMetadata is the response to a protocol metadata query
+type thirdPartyProtocolMetadata struct {
+	UserFields     []string                       `json:"user_fields"`
+	LocationFields []string                       `json:"location_fields"`
+	Icon           string                         `json:"icon"`
+	FieldTypes     map[string]thirdPartyFieldType `json:"field_types"`
+	Instances      []thirdPartyProtocolInstance   `json:"instances"`
+}
+
+// thirdPartyUser maps a Mattermost user to the Matrix ID of their ghost
+type thirdPartyUser struct {
+	UserID   string            `json:"userid"`
+	Protocol string            `json:"protocol"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// thirdPartyLocation maps a Mattermost channel to the Matrix alias that bridges it
+type thirdPartyLocation struct {
+	Alias    string            `json:"alias"`
+	Protocol string            `json:"protocol"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// handleThirdPartyProtocol describes the mattermost protocol: users are looked up by username and
+// locations by team and channel name
+func (p *Plugin) handleThirdPartyProtocol(w http.ResponseWriter, r *http.Request) {
+	if mux.Vars(r)["protocol"] != thirdPartyProtocol {
+		p.writeMatrixQueryError(w, http.StatusNotFound, "M_NOT_FOUND", "Unknown protocol")
+		return
+	}
+
+	metadata := thirdPartyProtocolMetadata{
+		UserFields:     []string{"username"},
+		LocationFields: []string{"team", "channel"},
+		Icon:           "",
+		FieldTypes: map[string]thirdPartyFieldType{
+			"username": {Regexp: "[a-z0-9._-]+", Placeholder: "username"},
+			"team":     {Regexp: "[a-z0-9-]+", Placeholder: "team-name"},
+			"channel":  {Regexp: "[a-z0-9_-]+", Placeholder: "channel-name"},
+		},
+		Instances: []thirdPartyProtocolInstance{},
+	}
+
+	teams, appErr := p.API.GetTeams()
+	if appErr != nil {
+		p.logger.LogWarn("Failed to list teams for third-party protocol instances", "error", appErr)
+	}
+	for _, team := range teams {
+		if team.DeleteAt != 0 {
+			continue
+		}
+		metadata.Instances = append(metadata.Instances, thirdPartyProtocolInstance{
+			Desc:      team.DisplayName,
+			Fields:    map[string]string{"team": team.Name},
+			NetworkID: team.Name,
+		})
+	}
+
+	p.writeMatrixQueryJSON(w, metadata)
+}
+
+// handleThirdPartyUserLookup returns the ghost IDs of local Mattermost users matching the username field
+func (p *Plugin) handleThirdPartyUserLookup(w http.ResponseWriter, r *http.Request) {
+	if mux.Vars(r)["protocol"] != thirdPartyProtocol {
+		p.writeMatrixQueryError(w, http.StatusNotFound, "M_NOT_FOUND", "Unknown protocol")
+		return
+	}
+
+	term := strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("username")), "@")
+	if term == "" {
+		p.writeMatrixQueryJSON(w, []thirdPartyUser{})
+		return
+	}
+
+	users, appErr := p.API.SearchUsers(&model.UserSearch{Term: term, Limit: thirdPartySearchLimit})
+	if appErr != nil {
+		p.logger.LogError("Failed to search users for third-party lookup", "error", appErr, "term", term)
+		p.writeMatrixQueryError(w, http.StatusInternalServerError, "M_UNKNOWN", "Failed to search users")
+		return
+	}
+
+	results := []thirdPartyUser{}
+	for _, user := range users {
+		if result := p.thirdPartyUserForMattermostUser(user); result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	p.writeMatrixQueryJSON(w, results)
+}
+
+// handleThirdPartyUserReverseLookup returns the Mattermost user behind a ghost user ID
+func (p *Plugin) handleThirdPartyUserReverseLookup(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("userid")
+
+	results := []thirdPartyUser{}
+	if p.isGhostUser(userID) {
+		mattermostUserID := p.extractMattermostUserIDFromGhost(userID)
+		if model.IsValidId(mattermostUserID) {
+			if user, appErr := p.API.GetUser(mattermostUserID); appErr == nil {
+				if result := p.thirdPartyUserForMattermostUser(user); result != nil {
+					results = append(results, *result)
+				}
+			}
+		}
+	}
+
+	p.writeMatrixQueryJSON(w, results)
+}
+
+// handleThirdPartyLocationLookup returns aliases for bridged public channels in a team matching the channel
+// field. Channels that aren't bridged aren't listed, so their names stay private to Mattermost.
+func (p *Plugin) handleThirdPartyLocationLookup(w http.ResponseWriter, r *http.Request) {
+	if mux.Vars(r)["protocol"] != thirdPartyProtocol {
+		p.writeMatrixQueryError(w, http.StatusNotFound, "M_NOT_FOUND", "Unknown protocol")
+		return
+	}
+
+	teamName := strings.TrimSpace(r.URL.Query().Get("team"))
+	channelTerm := strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("channel")), "~")
+	if teamName == "" {
+		p.writeMatrixQueryJSON(w, []thirdPartyLocation{})
+		return
+	}
+
+	team, appErr := p.API.GetTeamByName(teamName)
+	if appErr != nil || team.DeleteAt != 0 {
+		p.writeMatrixQueryJSON(w, []thirdPartyLocation{})
+		return
+	}
+
+	results := []thirdPartyLocation{}
+	addChannels := func(channels []*model.Channel) {
+		for _, channel := range channels {
+			if len(results) >= thirdPartySearchLimit {
+				return
+			}
+			if result := p.thirdPartyLocationForChannel(team, channel); result != nil {
+				results = append(results, *result)
+			}
+		}
+	}
+
+	if channelTerm == "" {
+		// Most public channels may not be bridged, so keep listing until enough bridged ones are found
+		for page := 0; len(results) < thirdPartySearchLimit; page++ {
+			channels, appErr := p.API.GetPublicChannelsForTeam(team.Id, page, thirdPartyChannelsPerPage)
+			if appErr != nil {
+				p.logger.LogError("Failed to list channels for third-party lookup", "error", appErr, "team", teamName, "page", page)
+				p.writeMatrixQueryError(w, http.StatusInternalServerError, "M_UNKNOWN", "Failed to list channels")
+				return
+			}
+			addChannels(channels)
+			if len(channels) < thirdPartyChannelsPerPage {
+				break
+			}
+		}
+	} else {
+		channels, appErr := p.API.SearchChannels(team.Id, channelTerm)
+		if appErr != nil {
+			p.logger.LogError("Failed to search channels for third-party lookup", "error", appErr, "team", teamName, "term", channelTerm)
+			p.writeMatrixQueryError(w, http.StatusInternalServerError, "M_UNKNOWN", "Failed to search channels")
+			return
+		}
+		addChannels(channels)
+	}
+
+	p.writeMatrixQueryJSON(w, results)
+}
+
+// handleThirdPartyLocationReverseLookup returns the channel behind an alias in the bridge's namespace
+func (p *Plugin) handleThirdPartyLocationReverseLookup(w http.ResponseWriter, r *http.Request) {
+	alias := r.URL.Query().Get("alias")
+
+	results := []thirdPartyLocation{}
+	if channel := p.getChannelForRoomAlias(alias); channel != nil {
+		if team, appErr := p.API.GetTeam(channel.TeamId); appErr == nil {
+			if result := p.thirdPartyLocationForChannel(team, channel); result != nil {
+				// Report the alias that was asked about, which may be the channel ID form
+				result.Alias = alias
+				results = append(results, *result)
+			}
+		}
+	}
+
+	p.writeMatrixQueryJSON(w, results)
+}
+
+// thirdPartyUserForMattermostUser describes a local user, or returns nil for users that have no ghost
+func (p *Plugin) thirdPartyUserForMattermostUser(user *model.User) *thirdPartyUser {
+	if user.IsRemote() || user.DeleteAt != 0 {
+		return nil
+	}
+
+	serverDomain, err := p.matrixClient.GetServerDomain()
+	if err != nil {
+		p.logger.LogWarn("Failed to get Matrix server domain for third-party lookup", "error", err)
+		return nil
+	}
+
+	return &thirdPartyUser{
+		UserID:   "@_mattermost_" + user.Id + ":" + serverDomain,
+		Protocol: thirdPartyProtocol,
+		Fields: map[string]string{
+			"username":     user.Username,
+			"display_name": user.GetDisplayName(model.ShowFullName),
+		},
+	}
+}
+
+// thirdPartyLocationForChannel describes a bridged public channel, or returns nil for channels Matrix can't join
+// or that aren't bridged
+func (p *Plugin) thirdPartyLocationForChannel(team *model.Team, channel *model.Channel) *thirdPartyLocation {
+	if channel.Type != model.ChannelTypeOpen || channel.DeleteAt != 0 || channel.TeamId != team.Id {
+		return nil
+	}
+
+	if roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channel.Id); err != nil || roomID == "" {
+		return nil
+	}
+
+	serverDomain, err := p.matrixClient.GetServerDomain()
+	if err != nil {
+		p.logger.LogWarn("Failed to get Matrix server domain for third-party lookup", "error", err)
+		return nil
+	}
+
+	return &thirdPartyLocation{
+		Alias:    ghostRoomAliasPrefix + team.Name + "_" + channel.Name + ":" + serverDomain,
+		Protocol: thirdPartyProtocol,
+		Fields: map[string]string{
+			"team":         team.Name,
+			"channel":      channel.Name,
+			"display_name": channel.DisplayName,
+		},
+	}
+}
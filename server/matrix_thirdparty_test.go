@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThirdPartyProtocol(t *testing.T) {
+	t.Run("describes the mattermost protocol with one instance per team", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		api.On("GetTeams").Return([]*model.Team{
+			{Id: model.NewId(), Name: "engineering", DisplayName: "Engineering"},
+			{Id: model.NewId(), Name: "archived", DisplayName: "Archived", DeleteAt: 1},
+		}, nil)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/protocol/mattermost")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var metadata thirdPartyProtocolMetadata
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+		assert.Equal(t, []string{"username"}, metadata.UserFields)
+		assert.Equal(t, []string{"team", "channel"}, metadata.LocationFields)
+		require.Len(t, metadata.Instances, 1)
+		assert.Equal(t, "engineering", metadata.Instances[0].NetworkID)
+		assert.Equal(t, "engineering", metadata.Instances[0].Fields["team"])
+	})
+
+	t.Run("unknown protocols are not found", func(t *testing.T) {
+		plugin, _, _ := setupMatrixQueryTest(t)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/protocol/irc")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestThirdPartyUserLookup(t *testing.T) {
+	localUser := &model.User{Id: model.NewId(), Username: "alice", FirstName: "Alice", LastName: "Liddell"}
+	remoteID := "remote_id"
+	remoteUser := &model.User{Id: model.NewId(), Username: "matrix:bob", RemoteId: &remoteID}
+
+	t.Run("matching local users are returned as ghosts", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		api.On("SearchUsers", &model.UserSearch{Term: "ali", Limit: thirdPartySearchLimit}).Return([]*model.User{localUser, remoteUser}, nil)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/user/mattermost?username=ali")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var users []thirdPartyUser
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+		require.Len(t, users, 1)
+		assert.Equal(t, "@_mattermost_"+localUser.Id+":test.com", users[0].UserID)
+		assert.Equal(t, "mattermost", users[0].Protocol)
+		assert.Equal(t, "alice", users[0].Fields["username"])
+	})
+
+	t.Run("reverse lookup resolves a ghost", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		api.On("GetUser", localUser.Id).Return(localUser, nil)
+
+		ghostUserID := "@_mattermost_" + localUser.Id + ":test.com"
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/user?userid="+url.QueryEscape(ghostUserID))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var users []thirdPartyUser
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+		require.Len(t, users, 1)
+		assert.Equal(t, "alice", users[0].Fields["username"])
+	})
+
+	t.Run("reverse lookup of a non-ghost is empty", func(t *testing.T) {
+		plugin, _, _ := setupMatrixQueryTest(t)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/user?userid="+url.QueryEscape("@alice:example.com"))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, "[]", w.Body.String())
+	})
+}
+
+func TestThirdPartyLocationLookup(t *testing.T) {
+	team := &model.Team{Id: model.NewId(), Name: "engineering"}
+	publicChannel := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "town-square", DisplayName: "Town Square", Type: model.ChannelTypeOpen}
+	privateChannel := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "town-secrets", Type: model.ChannelTypePrivate}
+	unbridgedChannel := &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: "town-hall", Type: model.ChannelTypeOpen}
+
+	t.Run("matching bridged public channels are returned with bridge aliases", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(publicChannel.Id), []byte("!town:test.com")))
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(privateChannel.Id), []byte("!secrets:test.com")))
+		api.On("GetTeamByName", "engineering").Return(team, nil)
+		api.On("SearchChannels", team.Id, "town").Return([]*model.Channel{publicChannel, privateChannel, unbridgedChannel}, nil)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/location/mattermost?team=engineering&channel=town")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var locations []thirdPartyLocation
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &locations))
+		require.Len(t, locations, 1)
+		assert.Equal(t, "#_mattermost_engineering_town-square:test.com", locations[0].Alias)
+		assert.Equal(t, "town-square", locations[0].Fields["channel"])
+	})
+
+	t.Run("lookups without a channel find bridged channels on later pages", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		firstPage := make([]*model.Channel, thirdPartyChannelsPerPage)
+		for i := range firstPage {
+			firstPage[i] = &model.Channel{Id: model.NewId(), TeamId: team.Id, Name: fmt.Sprintf("unbridged-%d", i), Type: model.ChannelTypeOpen}
+		}
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(publicChannel.Id), []byte("!town:test.com")))
+		api.On("GetTeamByName", "engineering").Return(team, nil)
+		api.On("GetPublicChannelsForTeam", team.Id, 0, thirdPartyChannelsPerPage).Return(firstPage, nil)
+		api.On("GetPublicChannelsForTeam", team.Id, 1, thirdPartyChannelsPerPage).Return([]*model.Channel{publicChannel}, nil)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/location/mattermost?team=engineering")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var locations []thirdPartyLocation
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &locations))
+		require.Len(t, locations, 1)
+		assert.Equal(t, "town-square", locations[0].Fields["channel"])
+	})
+
+	t.Run("lookups without a team are empty", func(t *testing.T) {
+		plugin, _, _ := setupMatrixQueryTest(t)
+
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/location/mattermost?channel=town")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, "[]", w.Body.String())
+	})
+
+	t.Run("reverse lookup resolves a bridge alias", func(t *testing.T) {
+		plugin, api, _ := setupMatrixQueryTest(t)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(publicChannel.Id), []byte("!town:test.com")))
+		api.On("GetChannelByNameForTeamName", "engineering", "town-square", false).Return(publicChannel, nil)
+		api.On("GetTeam", team.Id).Return(team, nil)
+
+		alias := "#_mattermost_engineering_town-square:test.com"
+		w := serveMatrixQuery(plugin, "/_matrix/app/v1/thirdparty/location?alias="+url.QueryEscape(alias))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var locations []thirdPartyLocation
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &locations))
+		require.Len(t, locations, 1)
+		assert.Equal(t, alias, locations[0].Alias)
+		assert.Equal(t, "engineering", locations[0].Fields["team"])
+	})
+}
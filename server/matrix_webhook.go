@@ -12,7 +12,10 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/mattermost/logr/v2"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
 	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/pkg/errors"
 )
 
@@ -161,8 +164,62 @@ func (p *Plugin) handleMatrixTransaction(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// handleMatrixPing answers the homeserver's application service ping (MSC2659) and records the contact
+func (p *Plugin) handleMatrixPing(w http.ResponseWriter, r *http.Request) {
+	// The body is optional - a transaction ID is only present when the ping was requested by the bridge
+	var request struct {
+		TransactionID string `json:"transaction_id"`
+	}
+	if body, err := io.ReadAll(io.LimitReader(r.Body, 4096)); err == nil && len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			p.logger.LogWarn("Failed to parse Matrix ping body", "error", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := p.recordHomeserverContact(request.TransactionID); err != nil {
+		p.logger.LogWarn("Failed to record homeserver contact", "error", err)
+	}
+
+	p.logger.LogDebug("Received Matrix application service ping", "transaction_id", request.TransactionID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("{}")); err != nil {
+		p.logger.LogWarn("Failed to write ping response", "error", err)
+	}
+}
+
+// recordHomeserverContact stores when the homeserver last reached the bridge, for /matrix test
+func (p *Plugin) recordHomeserverContact(transactionID string) error {
+	data, err := json.Marshal(command.HomeserverContact{
+		Timestamp:     model.GetMillis(),
+		TransactionID: transactionID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal homeserver contact")
+	}
+	return p.kvstore.Set(kvstore.KeyLastHomeserverContact, data)
+}
+
 // processMatrixEvent routes a single Matrix event to the appropriate handler
 func (p *Plugin) processMatrixEvent(event MatrixEvent) error {
+	// Senders from servers the federation policy excludes are dropped before anything can create a user for them
+	if !p.matrixToMattermostBridge.allowsMatrixSender(event.Sender, "") {
+		p.logger.LogDebug("Ignoring event from disallowed Matrix server", "event_id", event.EventID, "sender", event.Sender, "room_id", event.RoomID)
+		return nil
+	}
+
+	// Bridge bot commands and invites are handled before any bridging, in mapped and unmapped rooms alike
+	handled, err := p.handleBridgeBotEvent(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to handle bridge bot event")
+	}
+	if handled {
+		return nil
+	}
+
 	// Check if we have an existing mapping for this room
 	channelID, err := p.getChannelIDFromMatrixRoom(event.RoomID)
 	if err != nil {
@@ -198,6 +255,29 @@ func (p *Plugin) processMatrixEvent(event MatrixEvent) error {
 		return nil
 	}
 
+	// Likewise skip the echoes of events the bridge sent as a Mattermost user's linked Matrix account
+	if matrix.IsPuppetEvent(event.Content) && p.matrixToMattermostBridge.puppets.mattermostUserID(event.Sender) != "" {
+		p.logger.LogDebug("Ignoring event sent as linked Matrix account", "sender", event.Sender, "event_type", event.Type, "room_id", event.RoomID)
+		return nil
+	}
+
+	// The mapping may narrow the federation policy further
+	if !p.matrixToMattermostBridge.allowsMatrixSender(event.Sender, channelID) {
+		p.logger.LogDebug("Ignoring event from Matrix server not allowed in this mapping", "event_id", event.EventID, "sender", event.Sender, "room_id", event.RoomID, "channel_id", channelID)
+		return nil
+	}
+
+	// Channels mapped one-way to Matrix ignore everything that happens in the room
+	if !p.syncsToMattermost(channelID) {
+		p.logger.LogDebug("Ignoring event from room mapped to Matrix only", "event_id", event.EventID, "event_type", event.Type, "room_id", event.RoomID, "channel_id", channelID)
+		return nil
+	}
+
+	// Messages and reactions create posts, so they count towards the inbound rate limits
+	if (event.Type == "m.room.message" || event.Type == "m.reaction") && !p.allowInboundEvent(event, channelID) {
+		return nil
+	}
+
 	p.logger.LogDebug("Processing Matrix event", "event_id", event.EventID, "event_type", event.Type, "sender", event.Sender, "room_id", event.RoomID, "channel_id", channelID)
 
 	// Route event based on type
@@ -207,6 +287,10 @@ func (p *Plugin) processMatrixEvent(event MatrixEvent) error {
 	case "m.reaction":
 		return p.matrixToMattermostBridge.syncMatrixReactionToMattermost(event, channelID)
 	case "m.room.member":
+		// Rooms bridged to DMs and group messages follow membership changes by switching channels
+		if handled, err := p.syncDirectRoomMembership(event, channelID); handled || err != nil {
+			return err
+		}
 		return p.matrixToMattermostBridge.syncMatrixMemberEventToMattermost(event, channelID)
 	case "m.room.redaction":
 		return p.matrixToMattermostBridge.syncMatrixRedactionToMattermost(event, channelID)
@@ -393,6 +477,9 @@ func (p *Plugin) createDMChannelForGhostUser(roomID, ghostUserID, matrixUserID s
 		return "", errors.Wrap(err, "failed to store channel room mapping")
 	}
 
+	// Let Matrix clients show the room as a DM from the ghost user's side too
+	bridgeUtils.updateGhostDirectRooms(roomID, []string{ghostUserID, matrixUserID}, nil)
+
 	p.logger.LogInfo("Created Matrix-initiated DM",
 		"matrix_room_id", roomID,
 		"mattermost_channel_id", dmChannel.Id,
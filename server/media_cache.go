@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+)
+
+// mediaCache is the KV backed record of media already on the homeserver: uploads by content hash, Mattermost
+// files by ID, and the last avatar synced for each user. Without a KV store it remembers nothing.
+type mediaCache struct {
+	store  kvstore.KVStore
+	logger Logger
+}
+
+// cachedFile is the Matrix upload of a Mattermost file
+type cachedFile struct {
+	MxcURI string           `json:"mxc_uri"`
+	Info   matrix.MediaInfo `json:"info"`
+}
+
+// avatarRecord is the last avatar synced for a user
+type avatarRecord struct {
+	// Source is the mxc:// URI the avatar was downloaded from, for users from Matrix
+	Source string `json:"source,omitempty"`
+	Hash   string `json:"hash"`
+}
+
+func newMediaCache(store kvstore.KVStore, logger Logger) *mediaCache {
+	return &mediaCache{store: store, logger: logger}
+}
+
+// GetMediaURI returns the mxc:// URI content with the given hash was uploaded to
+func (c *mediaCache) GetMediaURI(hash string) (string, bool) {
+	data := c.get(kvstore.BuildMediaHashKey(hash))
+	return string(data), len(data) > 0
+}
+
+// StoreMediaURI records the mxc:// URI content with the given hash was uploaded to
+func (c *mediaCache) StoreMediaURI(hash, mxcURI string) {
+	c.set(kvstore.BuildMediaHashKey(hash), []byte(mxcURI))
+}
+
+// getFile returns the Matrix upload of a Mattermost file, or nil if it hasn't been uploaded
+func (c *mediaCache) getFile(fileID string) *cachedFile {
+	var file cachedFile
+	if !c.getJSON(kvstore.BuildFileMediaKey(fileID), &file) || file.MxcURI == "" {
+		return nil
+	}
+	return &file
+}
+
+// storeFile records the Matrix upload of a Mattermost file
+func (c *mediaCache) storeFile(fileID string, file *cachedFile) {
+	c.setJSON(kvstore.BuildFileMediaKey(fileID), file)
+}
+
+// getAvatar returns the last avatar synced for a user, or nil if none has been
+func (c *mediaCache) getAvatar(userID string) *avatarRecord {
+	var avatar avatarRecord
+	if !c.getJSON(kvstore.BuildAvatarHashKey(userID), &avatar) || avatar.Hash == "" {
+		return nil
+	}
+	return &avatar
+}
+
+// storeAvatar records the avatar synced for a user
+func (c *mediaCache) storeAvatar(userID string, avatar *avatarRecord) {
+	c.setJSON(kvstore.BuildAvatarHashKey(userID), avatar)
+}
+
+func (c *mediaCache) get(key string) []byte {
+	if c.store == nil {
+		return nil
+	}
+	data, err := c.store.Get(key)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (c *mediaCache) set(key string, data []byte) {
+	if c.store == nil {
+		return
+	}
+	// A failed write only costs a repeat upload later
+	if err := c.store.Set(key, data); err != nil {
+		c.logger.LogWarn("Failed to store media cache entry", "error", err, "key", key)
+	}
+}
+
+func (c *mediaCache) getJSON(key string, value any) bool {
+	data := c.get(key)
+	return len(data) > 0 && json.Unmarshal(data, value) == nil
+}
+
+func (c *mediaCache) setJSON(key string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		c.logger.LogWarn("Failed to marshal media cache entry", "error", err, "key", key)
+		return
+	}
+	c.set(key, data)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaCache(t *testing.T) {
+	cache := newMediaCache(NewMemoryKVStore(), &testLogger{t: t})
+
+	t.Run("uploads by hash", func(t *testing.T) {
+		_, ok := cache.GetMediaURI("abc")
+		assert.False(t, ok)
+
+		cache.StoreMediaURI("abc", "mxc://test.com/abc")
+		mxcURI, ok := cache.GetMediaURI("abc")
+		assert.True(t, ok)
+		assert.Equal(t, "mxc://test.com/abc", mxcURI)
+	})
+
+	t.Run("files by ID", func(t *testing.T) {
+		assert.Nil(t, cache.getFile("file"))
+
+		file := &cachedFile{MxcURI: "mxc://test.com/file", Info: matrix.MediaInfo{Width: 640, Height: 480}}
+		cache.storeFile("file", file)
+		assert.Equal(t, file, cache.getFile("file"))
+	})
+
+	t.Run("avatars by user", func(t *testing.T) {
+		assert.Nil(t, cache.getAvatar("user"))
+
+		avatar := &avatarRecord{Source: "mxc://test.com/avatar", Hash: "abc"}
+		cache.storeAvatar("user", avatar)
+		assert.Equal(t, avatar, cache.getAvatar("user"))
+	})
+
+	t.Run("without a KV store nothing is remembered", func(t *testing.T) {
+		cache := newMediaCache(nil, &testLogger{t: t})
+		cache.StoreMediaURI("abc", "mxc://test.com/abc")
+		_, ok := cache.GetMediaURI("abc")
+		assert.False(t, ok)
+		assert.Nil(t, cache.getFile("file"))
+	})
+}
+
+func TestUpdateMattermostUserAvatar(t *testing.T) {
+	avatars := map[string]string{"/old": "old avatar", "/new": "new avatar"}
+	var downloads int
+	plugin, api := setupPluginWithHomeserver(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for suffix, avatar := range avatars {
+			if strings.HasSuffix(r.URL.Path, suffix) {
+				downloads++
+				w.Header().Set("Content-Type", "image/png")
+				_, _ = w.Write([]byte(avatar))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	plugin.maxProfileImageSize = DefaultMaxProfileImageSize
+	plugin.initBridges()
+	user := &model.User{Id: model.NewId()}
+	update := func(avatarURL string) {
+		plugin.matrixToMattermostBridge.updateMattermostUserAvatar(user, "@alice:matrix.org", avatarURL, &ProfileUpdateContext{Source: "api"})
+	}
+
+	t.Run("the current profile image is compared by hash the first time", func(t *testing.T) {
+		api.On("GetProfileImage", user.Id).Return([]byte("old avatar"), nil).Once()
+		update("mxc://matrix.org/old")
+
+		assert.Equal(t, 1, downloads)
+		assert.Equal(t, &avatarRecord{Source: "mxc://matrix.org/old", Hash: matrix.MediaHash([]byte("old avatar"))}, plugin.matrixToMattermostBridge.mediaCache.getAvatar(user.Id))
+	})
+
+	t.Run("avatars already synced aren't downloaded", func(t *testing.T) {
+		update("mxc://matrix.org/old")
+		assert.Equal(t, 1, downloads)
+	})
+
+	t.Run("new avatars are set", func(t *testing.T) {
+		api.On("SetProfileImage", user.Id, []byte("new avatar")).Return(nil).Once()
+		update("mxc://matrix.org/new")
+
+		assert.Equal(t, 2, downloads)
+		assert.Equal(t, "mxc://matrix.org/new", plugin.matrixToMattermostBridge.mediaCache.getAvatar(user.Id).Source)
+	})
+
+	api.AssertExpectations(t)
+}
+
+func TestProfileImageSyncSkipsUnchangedAvatars(t *testing.T) {
+	var requests int
+	plugin, api := setupPluginWithHomeserver(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"content_uri": "mxc://test.com/avatar"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	plugin.configuration = &configuration{EnableSync: true}
+	plugin.remoteID = "remote_id"
+	plugin.matrixClient.SetMediaCache(newMediaCache(plugin.kvstore, plugin.logger))
+	plugin.initBridges()
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	user := &model.User{Id: model.NewId(), Username: "alice"}
+	require.NoError(t, plugin.kvstore.Set("ghost_user_"+user.Id, []byte("@_mattermost_alice:test.com")))
+	api.On("GetProfileImage", user.Id).Return([]byte("avatar"), nil)
+
+	// The first sync uploads and sets the avatar
+	require.NoError(t, plugin.OnSharedChannelsProfileImageSyncMsg(user, nil))
+	assert.Equal(t, 2, requests)
+
+	// Later syncs of the same image do neither
+	require.NoError(t, plugin.OnSharedChannelsProfileImageSyncMsg(user, nil))
+	assert.Equal(t, 2, requests)
+
+	// Identical content is never uploaded twice, even for another user
+	_, err := plugin.matrixClient.UploadAvatarFromData([]byte("avatar"), "image/png")
+	require.NoError(t, err)
+	assert.Equal(t, 2, requests)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	_ "image/jpeg" // Mattermost thumbnails and previews are JPEGs
+	_ "image/png"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// buildMediaInfo collects the metadata Matrix clients need to lay out and preview a Mattermost file: image
+// dimensions, audio and video duration, and Mattermost's preview uploaded as the thumbnail, with a BlurHash of
+// it. Anything that can't be worked out is left out.
+func (p *Plugin) buildMediaInfo(fi *model.FileInfo, data []byte) matrix.MediaInfo {
+	info := matrix.MediaInfo{
+		Width:  fi.Width,
+		Height: fi.Height,
+	}
+
+	if strings.HasPrefix(fi.MimeType, "audio/") || strings.HasPrefix(fi.MimeType, "video/") {
+		info.DurationMs = mediaDurationMs(data)
+	}
+
+	if !fi.HasPreviewImage {
+		return info
+	}
+
+	// The thumbnail is small enough to hash quickly
+	if fi.ThumbnailPath != "" {
+		if thumbnail, appErr := p.API.ReadFile(fi.ThumbnailPath); appErr == nil {
+			if img, _, err := image.Decode(bytes.NewReader(thumbnail)); err == nil {
+				if hash, err := encodeBlurHash(img); err == nil {
+					info.BlurHash = hash
+				}
+			}
+		} else {
+			p.logger.LogDebug("Failed to read file thumbnail", "error", appErr, "file_id", fi.Id)
+		}
+	}
+
+	// The preview is the better fit for Matrix clients, which show thumbnails a lot larger than Mattermost does
+	previewPath := fi.PreviewPath
+	if previewPath == "" {
+		previewPath = fi.ThumbnailPath
+	}
+	if previewPath == "" {
+		return info
+	}
+	preview, appErr := p.API.ReadFile(previewPath)
+	if appErr != nil {
+		p.logger.LogDebug("Failed to read file preview", "error", appErr, "file_id", fi.Id)
+		return info
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(preview))
+	if err != nil {
+		p.logger.LogDebug("Failed to decode file preview", "error", err, "file_id", fi.Id)
+		return info
+	}
+	mimeType := "image/" + format
+	mxcURI, err := p.matrixClient.UploadMedia(preview, "thumbnail"+extensionForMimeType(mimeType), mimeType)
+	if err != nil {
+		p.logger.LogWarn("Failed to upload file thumbnail to Matrix", "error", err, "file_id", fi.Id)
+		return info
+	}
+	info.Thumbnail = &matrix.MediaThumbnail{
+		MxcURI:   mxcURI,
+		MimeType: mimeType,
+		Size:     int64(len(preview)),
+		Width:    config.Width,
+		Height:   config.Height,
+	}
+	return info
+}
+
+// extensionForMimeType returns the usual file extension for a MIME type, or nothing if there isn't one
+func extensionForMimeType(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		// mime lists .jfif first on some systems
+		return ".jpg"
+	case "":
+		return ""
+	}
+	extensions, err := mime.ExtensionsByType(mimeType)
+	if err != nil || len(extensions) == 0 {
+		return ""
+	}
+	return extensions[0]
+}
+
+// matrixFileName returns the name to give a file from Matrix in Mattermost. Mattermost works a file's type out
+// from its extension, so names without one get the extension of the MIME type Matrix reported.
+func matrixFileName(name, mimeType string) string {
+	if filepath.Ext(name) != "" {
+		return name
+	}
+	return name + extensionForMimeType(mimeType)
+}
+
+// matrixFileInfo returns the MIME type and size from a Matrix file message's info block, where given
+func matrixFileInfo(content map[string]any) (string, int64) {
+	info, ok := content["info"].(map[string]any)
+	if !ok {
+		return "", 0
+	}
+	mimeType, _ := info["mimetype"].(string)
+	size, _ := info["size"].(float64)
+	return mimeType, int64(size)
+}
+
+// matrixMediaFileName returns the file name of a Matrix media message and whether its body is a caption. Under
+// MSC2530 a filename field different from the body means the body is a caption; otherwise the body is the name.
+func matrixMediaFileName(content map[string]any) (string, bool) {
+	body, _ := content["body"].(string)
+	filename, _ := content["filename"].(string)
+	if filename == "" || filename == body {
+		return body, false
+	}
+	return filename, true
+}
+
+// mediaDurationMs returns the duration of MP4 and QuickTime files, including M4A audio, WAV, MP3, and Ogg Opus and
+// Vorbis files, or 0 if it can't be found
+func mediaDurationMs(data []byte) int64 {
+	if len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE" {
+		return wavDurationMs(data[12:])
+	}
+	if len(data) >= 4 && string(data[0:4]) == "OggS" {
+		return oggDurationMs(data)
+	}
+	if moov := findMP4Box(data, "moov"); moov != nil {
+		return mp4DurationMs(findMP4Box(moov, "mvhd"))
+	}
+	if frames := mp3Frames(data); len(frames) > 0 {
+		var ms float64
+		for _, frame := range frames {
+			ms += float64(frame.samples) * 1000 / float64(frame.sampleRate)
+		}
+		return int64(ms)
+	}
+	return 0
+}
+
+// findMP4Box returns the contents of the first box of the given type in data, or nil
+func findMP4Box(data []byte, boxType string) []byte {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			// The box runs to the end of the data
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil
+		}
+		if string(data[4:8]) == boxType {
+			return data[header:size]
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// mp4DurationMs reads the duration from the contents of an mvhd box
+func mp4DurationMs(mvhd []byte) int64 {
+	var timescale, duration uint64
+	switch {
+	case len(mvhd) >= 32 && mvhd[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	case len(mvhd) >= 20 && mvhd[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return int64(duration * 1000 / timescale)
+}
+
+// wavDurationMs reads the duration from the chunks of a WAV file
+func wavDurationMs(chunks []byte) int64 {
+	var byteRate, dataSize uint64
+	for len(chunks) >= 8 {
+		chunkType := string(chunks[0:4])
+		size := uint64(binary.LittleEndian.Uint32(chunks[4:8]))
+		body := chunks[8:]
+		switch chunkType {
+		case "fmt ":
+			if len(body) >= 12 {
+				byteRate = uint64(binary.LittleEndian.Uint32(body[8:12]))
+			}
+		case "data":
+			dataSize = size
+		}
+		// Chunks are padded to an even size
+		next := 8 + size + size%2
+		if next > uint64(len(chunks)) {
+			break
+		}
+		chunks = chunks[next:]
+	}
+	if byteRate == 0 || dataSize == 0 {
+		return 0
+	}
+	return int64(dataSize * 1000 / byteRate)
+}
+
+// oggDurationMs reads the duration of an Ogg Opus or Vorbis file from the granule position of its last page
+func oggDurationMs(data []byte) int64 {
+	// The first page holds the codec's identification header, after a segment table of data[26] entries
+	if len(data) < 28 || 27+int(data[26]) > len(data) {
+		return 0
+	}
+	header := data[27+int(data[26]):]
+	var sampleRate, preSkip uint64
+	switch {
+	case len(header) >= 12 && string(header[0:8]) == "OpusHead":
+		// Opus granule positions always count 48kHz samples
+		sampleRate = 48000
+		preSkip = uint64(binary.LittleEndian.Uint16(header[10:12]))
+	case len(header) >= 16 && string(header[0:7]) == "\x01vorbis":
+		sampleRate = uint64(binary.LittleEndian.Uint32(header[12:16]))
+	default:
+		return 0
+	}
+
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if sampleRate == 0 || last < 0 || last+14 > len(data) {
+		return 0
+	}
+	granule := binary.LittleEndian.Uint64(data[last+6 : last+14])
+	if granule <= preSkip || granule == ^uint64(0) {
+		return 0
+	}
+	return int64((granule - preSkip) * 1000 / sampleRate)
+}
+
+// mp3Frame is what's needed of an MPEG audio layer III frame
+type mp3Frame struct {
+	samples    int
+	sampleRate int
+	// globalGain is the frame's quantizer step size, which follows how loud the frame is
+	globalGain int
+}
+
+var (
+	mp3Bitrates    = [2][16]int{{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}, {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}}
+	mp3SampleRates = [4][3]int{{11025, 12000, 8000}, {}, {22050, 24000, 16000}, {44100, 48000, 32000}}
+)
+
+// mp3Frames returns the layer III frames of an MP3 file, skipping an ID3v2 tag at the start, or nil if data isn't
+// one. Walking stops at the first thing that isn't a frame, such as an ID3v1 tag at the end.
+func mp3Frames(data []byte) []mp3Frame {
+	if len(data) >= 10 && string(data[0:3]) == "ID3" {
+		// The tag size is syncsafe: seven bits to a byte
+		size := 10 + (int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f))
+		if size > len(data) {
+			return nil
+		}
+		data = data[size:]
+	}
+
+	var frames []mp3Frame
+	for len(data) >= 4 {
+		header := binary.BigEndian.Uint32(data[0:4])
+		version := header >> 19 & 0x3
+		bitrateIndex := header >> 12 & 0xf
+		sampleRateIndex := header >> 10 & 0x3
+		// Frame sync, layer III, and no reserved or free format values
+		if header>>21 != 0x7ff || header>>17&0x3 != 1 || version == 1 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+			break
+		}
+
+		mpeg1 := version == 3
+		mono := header>>6&0x3 == 3
+		sampleRate := mp3SampleRates[version][sampleRateIndex]
+		frame := mp3Frame{samples: 576, sampleRate: sampleRate}
+		bitrate := mp3Bitrates[1][bitrateIndex]
+		size := 72 * bitrate * 1000 / sampleRate
+		// The side info's first granule starts with main_data_begin, private bits and, for MPEG-1, scfsi, then
+		// part2_3_length and big_values come before global_gain
+		gainBit := 31
+		switch {
+		case mpeg1 && mono:
+			frame.samples, bitrate, gainBit = 1152, mp3Bitrates[0][bitrateIndex], 39
+		case mpeg1:
+			frame.samples, bitrate, gainBit = 1152, mp3Bitrates[0][bitrateIndex], 41
+		case mono:
+			gainBit = 30
+		}
+		if mpeg1 {
+			size = 144 * bitrate * 1000 / sampleRate
+		}
+		size += int(header >> 9 & 0x1)
+		sideInfo := 4
+		if header>>16&0x1 == 0 {
+			// A CRC follows the header
+			sideInfo += 2
+		}
+		if size < sideInfo+8 || size > len(data) {
+			break
+		}
+		side := binary.BigEndian.Uint64(data[sideInfo : sideInfo+8])
+		frame.globalGain = int(side >> (64 - gainBit - 8) & 0xff)
+
+		frames = append(frames, frame)
+		data = data[size:]
+	}
+	return frames
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeBlurHash(t *testing.T) {
+	t.Run("solid images", func(t *testing.T) {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+		for i := range img.Pix {
+			img.Pix[i] = 0xff
+		}
+
+		hash, err := encodeBlurHash(img)
+		require.NoError(t, err)
+		assert.Equal(t, "LsTSUA_3fQ_3~qt7fQt7fQfQfQfQ", hash)
+	})
+
+	t.Run("gradients", func(t *testing.T) {
+		hash, err := encodeBlurHash(gradientImage(32, 24))
+		require.NoError(t, err)
+		assert.Len(t, hash, 28)
+		assert.NotEqual(t, "0", hash[1:2], "AC components should be present")
+	})
+
+	t.Run("empty images are errors", func(t *testing.T) {
+		_, err := encodeBlurHash(image.NewRGBA(image.Rect(0, 0, 0, 0)))
+		assert.Error(t, err)
+	})
+}
+
+func TestMediaDurationMs(t *testing.T) {
+	box := func(boxType string, contents ...[]byte) []byte {
+		body := bytes.Join(contents, nil)
+		data := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+		return append(append(data, boxType...), body...)
+	}
+
+	t.Run("MP4", func(t *testing.T) {
+		// Version 0 mvhd: version and flags, creation and modification times, timescale, duration
+		mvhd := make([]byte, 20)
+		binary.BigEndian.PutUint32(mvhd[12:16], 600)
+		binary.BigEndian.PutUint32(mvhd[16:20], 1500)
+		data := append(box("ftyp", []byte("isom")), box("moov", box("mvhd", mvhd))...)
+
+		assert.Equal(t, int64(2500), mediaDurationMs(data))
+	})
+
+	t.Run("WAV", func(t *testing.T) {
+		format := make([]byte, 16)
+		binary.LittleEndian.PutUint32(format[8:12], 16000)
+		data := []byte("RIFF\x00\x00\x00\x00WAVE")
+		data = append(data, "fmt "...)
+		data = binary.LittleEndian.AppendUint32(data, 16)
+		data = append(data, format...)
+		data = append(data, "data"...)
+		data = binary.LittleEndian.AppendUint32(data, 24000)
+
+		assert.Equal(t, int64(1500), mediaDurationMs(data))
+	})
+
+	t.Run("Ogg Opus", func(t *testing.T) {
+		page := func(granule uint64, packet []byte) []byte {
+			data := append([]byte("OggS\x00\x00"), binary.LittleEndian.AppendUint64(nil, granule)...)
+			data = append(data, make([]byte, 12)...)
+			data = append(data, 1, byte(len(packet)))
+			return append(data, packet...)
+		}
+		opusHead := binary.LittleEndian.AppendUint16([]byte("OpusHead\x01\x01"), 312)
+		opusHead = append(opusHead, 0x80, 0xbb, 0, 0, 0, 0, 0)
+		data := append(page(0, opusHead), page(96312, []byte("audio"))...)
+
+		assert.Equal(t, int64(2000), mediaDurationMs(data))
+	})
+
+	t.Run("MP3", func(t *testing.T) {
+		// 38 frames of 1152 samples at 44.1kHz
+		gains := make([]int, 38)
+		assert.Equal(t, int64(992), mediaDurationMs(testMP3(gains...)))
+	})
+
+	t.Run("unknown formats", func(t *testing.T) {
+		assert.Zero(t, mediaDurationMs([]byte("OggS not parsed")))
+		assert.Zero(t, mediaDurationMs(box("moov", []byte{1, 2, 3})))
+	})
+
+	t.Run("truncated and malformed files", func(t *testing.T) {
+		// An Ogg page claiming more segments than there is data
+		ogg := append([]byte("OggS"), make([]byte, 22)...)
+		ogg = append(ogg, 0xff, 0)
+		assert.Zero(t, mediaDurationMs(ogg))
+
+		// An MP3 frame cut short, and an ID3 tag longer than the file
+		assert.Zero(t, mediaDurationMs(testMP3(0)[:30]))
+		assert.Zero(t, mediaDurationMs([]byte("ID3\x04\x00\x00\x7f\x7f\x7f\x7f")))
+
+		// MP4 boxes with sizes past the end of the data or smaller than their header
+		assert.Zero(t, mediaDurationMs([]byte("\x00\x00\xff\xffmoov")))
+		assert.Zero(t, mediaDurationMs([]byte("\x00\x00\x00\x01moov\xff\xff\xff\xff\xff\xff\xff\xff")))
+		assert.Zero(t, mediaDurationMs([]byte("\x00\x00\x00\x04moov")))
+
+		// WAV chunks longer than the file
+		assert.Zero(t, mediaDurationMs([]byte("RIFF\x00\x00\x00\x00WAVEfmt \xff\xff\xff\xff")))
+	})
+}
+
+func FuzzMediaDurationMs(f *testing.F) {
+	f.Add(testWAV([]int16{0, 1000, -1000}))
+	f.Add(testMP3(200, 184))
+	f.Add([]byte("OggS\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x13OpusHead"))
+	f.Add([]byte("\x00\x00\x00\x10moov\x00\x00\x00\x08mvhd"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Uploaded files are untrusted, so nothing may panic
+		mediaDurationMs(data)
+	})
+}
+
+func TestMatrixFileName(t *testing.T) {
+	assert.Equal(t, "photo.png", matrixFileName("photo.png", "image/jpeg"))
+	assert.Equal(t, "photo.jpg", matrixFileName("photo", "image/jpeg"))
+	assert.Equal(t, "photo", matrixFileName("photo", ""))
+}
+
+func TestMatrixMediaFileName(t *testing.T) {
+	tests := []struct {
+		name             string
+		content          map[string]any
+		expectedFilename string
+		expectedCaption  bool
+	}{
+		{"body only", map[string]any{"body": "photo.jpg"}, "photo.jpg", false},
+		{"filename matching the body", map[string]any{"body": "photo.jpg", "filename": "photo.jpg"}, "photo.jpg", false},
+		{"captioned", map[string]any{"body": "Look at this", "filename": "photo.jpg"}, "photo.jpg", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename, hasCaption := matrixMediaFileName(tt.content)
+			assert.Equal(t, tt.expectedFilename, filename)
+			assert.Equal(t, tt.expectedCaption, hasCaption)
+		})
+	}
+}
+
+func TestMatrixCaptionEdit(t *testing.T) {
+	edit := func(newContent map[string]any) MatrixEvent {
+		return MatrixEvent{
+			EventID: "$edit",
+			Type:    "m.room.message",
+			Sender:  "@alice:matrix.org",
+			RoomID:  "!room:test.com",
+			Content: map[string]any{
+				"msgtype":       "m.image",
+				"body":          " * edited",
+				"m.new_content": newContent,
+				"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": "$photo"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		newContent      map[string]any
+		expectedMessage string
+	}{
+		{"caption changed", map[string]any{"msgtype": "m.image", "body": "A better caption", "filename": "photo.jpg"}, "A better caption"},
+		{"caption removed", map[string]any{"msgtype": "m.image", "body": "photo.jpg", "filename": "photo.jpg"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin, api, channelID, _ := setupSyncDirectionTest(t)
+			post := &model.Post{Id: model.NewId(), ChannelId: channelID, Message: "A caption", FileIds: []string{model.NewId()}}
+			require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixEventPostKey("$photo"), []byte(post.Id)))
+			api.On("GetPost", post.Id).Return(post, nil)
+			api.On("UpdatePost", mock.MatchedBy(func(updated *model.Post) bool {
+				return updated.Id == post.Id && updated.Message == tt.expectedMessage && len(updated.FileIds) == 1
+			})).Return(post, nil).Once()
+
+			require.NoError(t, plugin.processMatrixEvent(edit(tt.newContent)))
+			api.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBuildMediaInfo(t *testing.T) {
+	var uploads int
+	plugin, api := setupPluginWithHomeserver(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		uploads++
+		_, _ = w.Write([]byte(`{"content_uri": "mxc://test.com/thumbnail"}`))
+	}))
+
+	var preview bytes.Buffer
+	require.NoError(t, jpeg.Encode(&preview, gradientImage(64, 48), nil))
+	var thumbnail bytes.Buffer
+	require.NoError(t, jpeg.Encode(&thumbnail, gradientImage(16, 12), nil))
+	api.On("ReadFile", "preview.jpg").Return(preview.Bytes(), nil)
+	api.On("ReadFile", "thumb.jpg").Return(thumbnail.Bytes(), nil)
+
+	t.Run("images get a thumbnail and BlurHash", func(t *testing.T) {
+		info := plugin.buildMediaInfo(&model.FileInfo{
+			MimeType:        "image/png",
+			Width:           640,
+			Height:          480,
+			HasPreviewImage: true,
+			PreviewPath:     "preview.jpg",
+			ThumbnailPath:   "thumb.jpg",
+		}, nil)
+
+		assert.Equal(t, 640, info.Width)
+		assert.Equal(t, 480, info.Height)
+		assert.Len(t, info.BlurHash, 28)
+		assert.Equal(t, &matrix.MediaThumbnail{
+			MxcURI:   "mxc://test.com/thumbnail",
+			MimeType: "image/jpeg",
+			Size:     int64(preview.Len()),
+			Width:    64,
+			Height:   48,
+		}, info.Thumbnail)
+		assert.Equal(t, 1, uploads)
+	})
+
+	t.Run("other files get what can be worked out", func(t *testing.T) {
+		info := plugin.buildMediaInfo(&model.FileInfo{MimeType: "application/pdf"}, []byte("%PDF"))
+		assert.Equal(t, matrix.MediaInfo{}, info)
+	})
+}
+
+// gradientImage returns an image shading from black in the top left to red and blue
+func gradientImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), B: uint8(y * 255 / height), A: 0xff})
+		}
+	}
+	return img
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	// pluginID is the plugin's ID, under which its HTTP routes are served
+	pluginID = "com.mattermost.plugin-matrix-bridge"
+
+	// mediaProxyCacheSize is the most proxied media kept in memory, in bytes
+	mediaProxyCacheSize = 256 * 1024 * 1024
+	// mediaProxyCacheMaxItemSize is the largest file kept in memory; larger files are fetched on every view
+	mediaProxyCacheMaxItemSize = 16 * 1024 * 1024
+
+	// matrixAttachmentTextProp is the post prop holding the text that stands in for a Matrix file, a proxy link
+	// or a notice, so caption edits keep it
+	matrixAttachmentTextProp = "matrix_attachment_text"
+)
+
+// proxiedMedia is a Matrix file shown in Mattermost through the media proxy instead of being imported
+type proxiedMedia struct {
+	MxcURI    string `json:"mxc_uri"`
+	ChannelID string `json:"channel_id"`
+	Filename  string `json:"filename"`
+	MimeType  string `json:"mime_type,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+}
+
+// siteURL returns the Mattermost site URL without a trailing slash, or "" if it isn't configured
+func siteURL(api plugin.API) string {
+	config := api.GetConfig()
+	if config == nil || config.ServiceSettings.SiteURL == nil {
+		return ""
+	}
+	return strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+}
+
+// mediaProxyURL returns the URL the media proxy serves a file at
+func mediaProxyURL(siteURL, token string) string {
+	return siteURL + "/plugins/" + pluginID + "/api/v1/media/" + token
+}
+
+// mattermostRetainsFiles reports whether Mattermost keeps its own copy of every post's files, for compliance
+// exports or message export, in which case Matrix files have to be imported
+func (s *BridgeUtils) mattermostRetainsFiles() bool {
+	config := s.API.GetConfig()
+	if config == nil {
+		return false
+	}
+	return (config.ComplianceSettings.Enable != nil && *config.ComplianceSettings.Enable) ||
+		(config.MessageExportSettings.EnableExport != nil && *config.MessageExportSettings.EnableExport)
+}
+
+// proxyMatrixFile records a Matrix file for the media proxy and returns the markdown that shows it in a post.
+// An empty string means the file has to be imported instead: the proxy is off, the site URL isn't configured,
+// Mattermost retains files, or the file is a voice message, which Mattermost plays from its own file store.
+func (b *MatrixToMattermostBridge) proxyMatrixFile(event MatrixEvent, media proxiedMedia) string {
+	if !b.getConfiguration().MediaProxy || b.kvstore == nil {
+		return ""
+	}
+	if _, _, isVoice := matrixVoiceMessage(event.Content); isVoice || b.mattermostRetainsFiles() {
+		return ""
+	}
+	site := siteURL(b.API)
+	if site == "" {
+		b.logger.LogWarn("Importing Matrix file because the site URL isn't configured for the media proxy", "event_id", event.EventID)
+		return ""
+	}
+
+	data, err := json.Marshal(media)
+	if err != nil {
+		b.logger.LogWarn("Failed to marshal proxied media", "error", err, "event_id", event.EventID)
+		return ""
+	}
+	token := model.NewId()
+	if err := b.kvstore.Set(kvstore.BuildProxiedMediaKey(token), data); err != nil {
+		b.logger.LogWarn("Importing Matrix file because the media proxy record couldn't be stored", "error", err, "event_id", event.EventID)
+		return ""
+	}
+
+	link := mediaProxyURL(site, token)
+	name := escapeMarkdownLinkText(media.Filename)
+	if strings.HasPrefix(media.MimeType, "image/") && media.MimeType != "image/svg+xml" {
+		return "![" + name + "](" + link + ")"
+	}
+	if media.Size > 0 {
+		return "[" + name + "](" + link + ") (" + formatFileSize(media.Size) + ")"
+	}
+	return "[" + name + "](" + link + ")"
+}
+
+// getProxiedMedia returns the Matrix file the media proxy serves under a token, or nil if there is none
+func (s *BridgeUtils) getProxiedMedia(token string) *proxiedMedia {
+	if s.kvstore == nil || !model.IsValidId(token) {
+		return nil
+	}
+	data, err := s.kvstore.Get(kvstore.BuildProxiedMediaKey(token))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var media proxiedMedia
+	if err := json.Unmarshal(data, &media); err != nil || media.MxcURI == "" {
+		return nil
+	}
+	return &media
+}
+
+// captionWithAttachmentText returns a post message with the text standing in for a file after its caption
+func captionWithAttachmentText(caption, attachmentText string) string {
+	if caption == "" {
+		return attachmentText
+	}
+	return caption + "\n\n" + attachmentText
+}
+
+// escapeMarkdownLinkText escapes the characters that would end a markdown link's text early
+func escapeMarkdownLinkText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(text)
+}
+
+// handleGetProxiedMedia serves a Matrix file shown through the media proxy to users who can read its channel.
+// The file is fetched from the homeserver when first viewed and kept in memory for later views.
+func (p *Plugin) handleGetProxiedMedia(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	token := mux.Vars(r)["token"]
+
+	media := p.matrixToMattermostBridge.getProxiedMedia(token)
+	if media == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if !p.API.HasPermissionToChannel(userID, media.ChannelID, model.PermissionReadChannel) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if cached := p.mediaProxyCache.get(token); cached != nil {
+		writeProxiedMediaHeaders(w, media, cached.contentType)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(cached.data))
+		return
+	}
+
+	if p.matrixClient == nil {
+		http.Error(w, "Matrix not configured", http.StatusServiceUnavailable)
+		return
+	}
+	download, err := p.matrixClient.DownloadFileStream(media.MxcURI, p.maxFileSize, "")
+	if err != nil {
+		p.logger.LogWarn("Failed to fetch proxied Matrix media", "error", err, "mxc_uri", media.MxcURI)
+		http.Error(w, "Failed to fetch media from Matrix", http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = download.Body.Close() }()
+
+	writeProxiedMediaHeaders(w, media, download.ContentType)
+	if download.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(download.Size, 10))
+	}
+
+	// Files of a known, small enough size are kept for the next view once they've arrived in full
+	var body io.Reader = download.Body
+	var buffer *bytes.Buffer
+	if download.Size > 0 && download.Size <= mediaProxyCacheMaxItemSize {
+		buffer = bytes.NewBuffer(make([]byte, 0, download.Size))
+		body = io.TeeReader(download.Body, buffer)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		p.logger.LogWarn("Failed to stream proxied Matrix media", "error", err, "mxc_uri", media.MxcURI)
+		return
+	}
+	if buffer != nil && int64(buffer.Len()) == download.Size {
+		p.mediaProxyCache.put(token, &cachedMedia{data: buffer.Bytes(), contentType: download.ContentType})
+	}
+}
+
+// writeProxiedMediaHeaders sets the headers of a proxied file. Only images, audio and video are shown inline;
+// everything else downloads, and nothing may run scripts on the Mattermost origin.
+func writeProxiedMediaHeaders(w http.ResponseWriter, media *proxiedMedia, downloadedType string) {
+	contentType := media.MimeType
+	if contentType == "" {
+		contentType = downloadedType
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	disposition := "attachment"
+	mediaType, _, _ := strings.Cut(contentType, "/")
+	if (mediaType == "image" || mediaType == "audio" || mediaType == "video") && !strings.HasPrefix(contentType, "image/svg") {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": media.Filename}))
+	w.Header().Set("Content-Security-Policy", "sandbox; default-src 'none'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+}
+
+// cachedMedia is a proxied file kept in memory
+type cachedMedia struct {
+	data        []byte
+	contentType string
+}
+
+// mediaProxyCache keeps recently viewed proxied files in memory, dropping the least recently viewed once they
+// take up more than maxSize bytes
+type mediaProxyCache struct {
+	mutex   sync.Mutex
+	maxSize int64
+	size    int64
+	order   *list.List               // most recently viewed first
+	entries map[string]*list.Element // token -> element holding a *mediaProxyCacheEntry
+}
+
+type mediaProxyCacheEntry struct {
+	token string
+	media *cachedMedia
+}
+
+func newMediaProxyCache(maxSize int64) *mediaProxyCache {
+	return &mediaProxyCache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached file for a token, or nil if it isn't cached
+func (c *mediaProxyCache) get(token string) *cachedMedia {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[token]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*mediaProxyCacheEntry).media
+}
+
+// put caches the file for a token, unless it's larger than the whole cache
+func (c *mediaProxyCache) put(token string, media *cachedMedia) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	size := int64(len(media.data))
+	if size > c.maxSize {
+		return
+	}
+	if element, ok := c.entries[token]; ok {
+		c.remove(element)
+	}
+	c.entries[token] = c.order.PushFront(&mediaProxyCacheEntry{token: token, media: media})
+	c.size += size
+
+	for c.size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *mediaProxyCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*mediaProxyCacheEntry)
+	delete(c.entries, entry.token)
+	c.size -= int64(len(entry.media.data))
+}
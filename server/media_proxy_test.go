@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaProxyCache(t *testing.T) {
+	cache := newMediaProxyCache(10)
+	cache.put("a", &cachedMedia{data: []byte("aaaa")})
+	cache.put("b", &cachedMedia{data: []byte("bbbb")})
+
+	// Viewing a makes b the least recently viewed, so b goes when c doesn't fit
+	require.NotNil(t, cache.get("a"))
+	cache.put("c", &cachedMedia{data: []byte("cccc")})
+	assert.NotNil(t, cache.get("a"))
+	assert.Nil(t, cache.get("b"))
+	assert.NotNil(t, cache.get("c"))
+	assert.Equal(t, int64(8), cache.size)
+
+	// Files larger than the whole cache aren't kept
+	cache.put("d", &cachedMedia{data: []byte("ddddddddddd")})
+	assert.Nil(t, cache.get("d"))
+	assert.NotNil(t, cache.get("a"))
+}
+
+func TestPluginIDMatchesManifest(t *testing.T) {
+	// Mattermost serves the plugin's routes under the ID in plugin.json, so proxy links only work if they agree
+	data, err := os.ReadFile("../plugin.json")
+	require.NoError(t, err)
+	var pluginManifest model.Manifest
+	require.NoError(t, json.Unmarshal(data, &pluginManifest))
+	assert.Equal(t, pluginManifest.Id, pluginID)
+}
+
+func TestEscapeMarkdownLinkText(t *testing.T) {
+	assert.Equal(t, `notes \[draft\].txt`, escapeMarkdownLinkText("notes [draft].txt"))
+	assert.Equal(t, `a\\b`, escapeMarkdownLinkText(`a\b`))
+}
+
+func TestProxiedMatrixFiles(t *testing.T) {
+	setup := func(t *testing.T, config *model.Config) (*Plugin, *plugintest.API, string) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		plugin.configuration.MediaProxy = true
+
+		userID := model.NewId()
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixUserKey("@alice:matrix.org"), []byte(userID)))
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Type: model.ChannelTypeOpen}, nil)
+		config.ServiceSettings.SiteURL = model.NewPointer("https://chat.example.com/")
+		api.On("GetConfig").Return(config)
+		return plugin, api, channelID
+	}
+	fileEvent := func(msgtype, filename, mimeType string) MatrixEvent {
+		return MatrixEvent{
+			EventID: "$file",
+			Type:    "m.room.message",
+			Sender:  "@alice:matrix.org",
+			RoomID:  "!room:test.com",
+			Content: map[string]any{
+				"msgtype":  msgtype,
+				"body":     "Have a look",
+				"filename": filename,
+				"url":      "mxc://matrix.org/media",
+				"info":     map[string]any{"mimetype": mimeType, "size": float64(2048)},
+			},
+		}
+	}
+	// checkProxyLink checks that a post message links to the media proxy and that the link has a record
+	checkProxyLink := func(t *testing.T, plugin *Plugin, message, channelID string) {
+		prefix := "https://chat.example.com/plugins/" + pluginID + "/api/v1/media/"
+		_, link, found := strings.Cut(message, prefix)
+		require.True(t, found, message)
+		token := link[:26]
+
+		media := plugin.matrixToMattermostBridge.getProxiedMedia(token)
+		require.NotNil(t, media)
+		assert.Equal(t, "mxc://matrix.org/media", media.MxcURI)
+		assert.Equal(t, channelID, media.ChannelID)
+	}
+
+	t.Run("images are shown inline without being downloaded", func(t *testing.T) {
+		plugin, api, channelID := setup(t, &model.Config{})
+		var created *model.Post
+		api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			created = args.Get(0).(*model.Post)
+		}).Return(&model.Post{Id: model.NewId()}, nil)
+
+		require.NoError(t, plugin.processMatrixEvent(fileEvent("m.image", "cat [1].png", "image/png")))
+		require.NotNil(t, created)
+		assert.Empty(t, created.FileIds)
+		assert.True(t, strings.HasPrefix(created.Message, "Have a look\n\n![cat \\[1\\].png](https://chat.example.com/"), created.Message)
+		checkProxyLink(t, plugin, created.Message, channelID)
+		assert.Equal(t, strings.TrimPrefix(created.Message, "Have a look\n\n"), created.GetProp(matrixAttachmentTextProp))
+	})
+
+	t.Run("other files are linked with their size", func(t *testing.T) {
+		plugin, api, _ := setup(t, &model.Config{})
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return strings.HasPrefix(post.Message, "Have a look\n\n[report.pdf](https://chat.example.com/") &&
+				strings.HasSuffix(post.Message, ") (2.0 KB)")
+		})).Return(&model.Post{Id: model.NewId()}, nil).Once()
+
+		require.NoError(t, plugin.processMatrixEvent(fileEvent("m.file", "report.pdf", "application/pdf")))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("files are imported while Mattermost retains them", func(t *testing.T) {
+		config := &model.Config{}
+		config.MessageExportSettings.EnableExport = model.NewPointer(true)
+		plugin, _, _ := setup(t, config)
+
+		// The homeserver refuses every download, so only an import fails
+		assert.Error(t, plugin.processMatrixEvent(fileEvent("m.file", "report.pdf", "application/pdf")))
+	})
+
+	t.Run("voice messages are imported", func(t *testing.T) {
+		plugin, _, _ := setup(t, &model.Config{})
+		event := fileEvent("m.audio", "voice.ogg", "audio/ogg")
+		event.Content["org.matrix.msc3245.voice"] = map[string]any{}
+
+		assert.Error(t, plugin.processMatrixEvent(event))
+	})
+
+	t.Run("caption edits keep the link", func(t *testing.T) {
+		plugin, api, channelID := setup(t, &model.Config{})
+		link := "[report.pdf](https://chat.example.com/plugins/" + pluginID + "/api/v1/media/token)"
+		post := &model.Post{Id: model.NewId(), ChannelId: channelID, Message: "Have a look\n\n" + link}
+		post.AddProp(matrixAttachmentTextProp, link)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildMatrixEventPostKey("$file"), []byte(post.Id)))
+		api.On("GetPost", post.Id).Return(post, nil)
+		api.On("UpdatePost", mock.MatchedBy(func(updated *model.Post) bool {
+			return updated.Message == "Read this first\n\n"+link
+		})).Return(post, nil).Once()
+
+		edit := MatrixEvent{
+			EventID: "$edit",
+			Type:    "m.room.message",
+			Sender:  "@alice:matrix.org",
+			RoomID:  "!room:test.com",
+			Content: map[string]any{
+				"msgtype":       "m.file",
+				"body":          "* Read this first",
+				"m.new_content": map[string]any{"msgtype": "m.file", "body": "Read this first", "filename": "report.pdf", "url": "mxc://matrix.org/media"},
+				"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": "$file"},
+			},
+		}
+		require.NoError(t, plugin.matrixToMattermostBridge.handleMatrixMessageEdit(edit, channelID))
+		api.AssertNumberOfCalls(t, "UpdatePost", 1)
+	})
+}
+
+func TestMediaProxyAPI(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *plugintest.API, string, *int32) {
+		var downloads int32
+		plugin, api, channelID := setupMappedChannelTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/_matrix/client/v1/media/download/matrix.org/") {
+				http.NotFound(w, r)
+				return
+			}
+			atomic.AddInt32(&downloads, 1)
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<script>alert(1)</script>"))
+		}))
+
+		token := model.NewId()
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildProxiedMediaKey(token), []byte(`{"mxc_uri":"mxc://matrix.org/page","channel_id":"`+channelID+`","filename":"page.html","mime_type":"text/html"}`)))
+		return plugin, api, token, &downloads
+	}
+	serve := func(plugin *Plugin, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/media/"+token, nil)
+		r.Header.Set("Mattermost-User-ID", "user-id")
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("channel members get the file, fetched once", func(t *testing.T) {
+		plugin, api, token, downloads := setup(t)
+		api.On("HasPermissionToChannel", "user-id", mock.Anything, model.PermissionReadChannel).Return(true)
+
+		for range 2 {
+			w := serve(plugin, token)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "<script>alert(1)</script>", w.Body.String())
+			assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+			assert.Equal(t, `attachment; filename=page.html`, w.Header().Get("Content-Disposition"))
+			assert.Contains(t, w.Header().Get("Content-Security-Policy"), "sandbox")
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(downloads))
+	})
+
+	t.Run("users who can't read the channel are refused", func(t *testing.T) {
+		plugin, api, token, downloads := setup(t)
+		api.On("HasPermissionToChannel", "user-id", mock.Anything, model.PermissionReadChannel).Return(false)
+
+		assert.Equal(t, http.StatusForbidden, serve(plugin, token).Code)
+		assert.Zero(t, atomic.LoadInt32(downloads))
+	})
+
+	t.Run("unknown tokens are not found", func(t *testing.T) {
+		plugin, _, _, _ := setup(t)
+		assert.Equal(t, http.StatusNotFound, serve(plugin, model.NewId()).Code)
+	})
+}
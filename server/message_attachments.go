@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// attachmentColorNames maps the named colours accepted by Mattermost attachments to hex values
+var attachmentColorNames = map[string]string{
+	"good":    "#2eb886",
+	"warning": "#daa038",
+	"danger":  "#a30200",
+}
+
+var hexColorRegex = regexp.MustCompile(`^#?([0-9a-fA-F]{6}|[0-9a-fA-F]{3})$`)
+
+// convertPostToMatrix converts a post's message and any message attachments to Matrix plain text and HTML.
+// The HTML content is empty when the post has no formatting, mirroring convertMattermostToMatrix.
+func convertPostToMatrix(post *model.Post) (plainText string, htmlContent string) {
+	plainText, htmlContent = convertMattermostToMatrix(post.Message)
+
+	attachmentsPlain, attachmentsHTML := renderMessageAttachments(post.Attachments())
+	if attachmentsPlain == "" && attachmentsHTML == "" {
+		return plainText, htmlContent
+	}
+
+	// Attachments are always rendered as HTML, so the message needs an HTML form as well
+	if htmlContent == "" && plainText != "" {
+		htmlContent = convertMarkdownToHTML(post.Message)
+	}
+
+	if plainText != "" {
+		plainText += "\n\n"
+	}
+	plainText += attachmentsPlain
+
+	if htmlContent != "" {
+		htmlContent += "<br>"
+	}
+	htmlContent += attachmentsHTML
+
+	return plainText, htmlContent
+}
+
+// renderMessageAttachments renders Mattermost message attachments as Matrix plain text and HTML.
+// Interactive elements such as buttons and menus are rendered as non-interactive text.
+func renderMessageAttachments(attachments []*model.SlackAttachment) (plainText string, htmlContent string) {
+	var plainParts []string
+	var htmlParts []string
+
+	for _, attachment := range attachments {
+		if attachment == nil {
+			continue
+		}
+
+		plain, formatted := renderMessageAttachment(attachment)
+		if plain == "" && formatted == "" {
+			continue
+		}
+
+		plainParts = append(plainParts, plain)
+		htmlParts = append(htmlParts, formatted)
+	}
+
+	return strings.Join(plainParts, "\n\n"), strings.Join(htmlParts, "")
+}
+
+// renderMessageAttachment renders a single message attachment as plain text and HTML
+func renderMessageAttachment(attachment *model.SlackAttachment) (string, string) {
+	var plainLines []string
+	var htmlLines []string
+
+	if pretext := strings.TrimSpace(attachment.Pretext); pretext != "" {
+		plainLines = append(plainLines, pretext)
+		htmlLines = append(htmlLines, convertMarkdownToHTML(pretext))
+	}
+
+	if author := strings.TrimSpace(attachment.AuthorName); author != "" {
+		plainLines = append(plainLines, author)
+		htmlLines = append(htmlLines, "<em>"+renderAttachmentLink(author, attachment.AuthorLink)+"</em>")
+	}
+
+	if title := strings.TrimSpace(attachment.Title); title != "" {
+		if isValidURL(attachment.TitleLink) && attachment.TitleLink != "" {
+			plainLines = append(plainLines, fmt.Sprintf("%s (%s)", title, attachment.TitleLink))
+		} else {
+			plainLines = append(plainLines, title)
+		}
+		htmlLines = append(htmlLines, "<strong>"+renderAttachmentLink(title, attachment.TitleLink)+"</strong>")
+	}
+
+	if text := strings.TrimSpace(attachment.Text); text != "" {
+		plainLines = append(plainLines, text)
+		htmlLines = append(htmlLines, convertMarkdownToHTML(text))
+	}
+
+	for _, field := range attachment.Fields {
+		if field == nil {
+			continue
+		}
+
+		title := strings.TrimSpace(field.Title)
+		var value string
+		if field.Value != nil {
+			value = strings.TrimSpace(fmt.Sprint(field.Value))
+		}
+		if title == "" && value == "" {
+			continue
+		}
+
+		switch {
+		case title != "" && value != "":
+			plainLines = append(plainLines, title+": "+value)
+			htmlLines = append(htmlLines, "<strong>"+html.EscapeString(title)+"</strong><br>"+convertMarkdownToHTML(value))
+		case title != "":
+			plainLines = append(plainLines, title)
+			htmlLines = append(htmlLines, "<strong>"+html.EscapeString(title)+"</strong>")
+		default:
+			plainLines = append(plainLines, value)
+			htmlLines = append(htmlLines, convertMarkdownToHTML(value))
+		}
+	}
+
+	// Matrix clients only render images hosted on the homeserver, so external images are linked instead
+	imageURL := attachment.ImageURL
+	if imageURL == "" {
+		imageURL = attachment.ThumbURL
+	}
+	if imageURL != "" && isValidURL(imageURL) {
+		plainLines = append(plainLines, "Image: "+imageURL)
+		htmlLines = append(htmlLines, renderAttachmentLink("Image", imageURL))
+	}
+
+	if plainActions, htmlActions := renderAttachmentActions(attachment.Actions); plainActions != "" {
+		plainLines = append(plainLines, plainActions)
+		htmlLines = append(htmlLines, htmlActions)
+	}
+
+	if footer := strings.TrimSpace(attachment.Footer); footer != "" {
+		plainLines = append(plainLines, footer)
+		htmlLines = append(htmlLines, "<sub>"+html.EscapeString(footer)+"</sub>")
+	}
+
+	// Fall back to the attachment's own fallback text when nothing else is renderable
+	if len(plainLines) == 0 {
+		fallback := strings.TrimSpace(attachment.Fallback)
+		if fallback == "" {
+			return "", ""
+		}
+		plainLines = append(plainLines, fallback)
+		htmlLines = append(htmlLines, html.EscapeString(fallback))
+	}
+
+	// Quote every plain text line so the attachment stands apart from the message
+	for i, line := range plainLines {
+		plainLines[i] = "> " + strings.ReplaceAll(line, "\n", "\n> ")
+	}
+
+	htmlBody := strings.Join(htmlLines, "<br>")
+	if color := normalizeAttachmentColor(attachment.Color); color != "" {
+		htmlBody = fmt.Sprintf(`<font data-mx-color="%s">▍</font>`, color) + htmlBody
+	}
+
+	return strings.Join(plainLines, "\n"), "<blockquote>" + htmlBody + "</blockquote>"
+}
+
+// renderAttachmentActions renders attachment buttons and menus as non-interactive text
+func renderAttachmentActions(actions []*model.PostAction) (string, string) {
+	var plainActions []string
+	var htmlActions []string
+
+	for _, action := range actions {
+		if action == nil || strings.TrimSpace(action.Name) == "" {
+			continue
+		}
+
+		name := strings.TrimSpace(action.Name)
+		if action.Type == model.PostActionTypeSelect {
+			var options []string
+			for _, option := range action.Options {
+				if option != nil && option.Text != "" {
+					options = append(options, option.Text)
+				}
+			}
+			if len(options) > 0 {
+				name = fmt.Sprintf("%s: %s", name, strings.Join(options, " | "))
+			}
+		}
+
+		plainActions = append(plainActions, "["+name+"]")
+		htmlActions = append(htmlActions, "<code>"+html.EscapeString(name)+"</code>")
+	}
+
+	if len(plainActions) == 0 {
+		return "", ""
+	}
+
+	return strings.Join(plainActions, " "), strings.Join(htmlActions, " ")
+}
+
+// renderAttachmentLink renders escaped text, wrapped in a link when the URL is safe
+func renderAttachmentLink(text, link string) string {
+	escaped := html.EscapeString(text)
+	if link == "" || !isValidURL(link) {
+		return escaped
+	}
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(link), escaped)
+}
+
+// normalizeAttachmentColor converts an attachment colour into a hex value usable in Matrix HTML
+func normalizeAttachmentColor(color string) string {
+	color = strings.TrimSpace(color)
+	if named, ok := attachmentColorNames[strings.ToLower(color)]; ok {
+		return named
+	}
+	if !hexColorRegex.MatchString(color) {
+		return ""
+	}
+	if !strings.HasPrefix(color, "#") {
+		color = "#" + color
+	}
+	return strings.ToLower(color)
+}
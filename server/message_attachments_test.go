@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMessageAttachments(t *testing.T) {
+	tests := []struct {
+		name          string
+		attachments   []*model.SlackAttachment
+		expectedPlain string
+		expectedHTML  string
+	}{
+		{
+			name:          "no attachments",
+			attachments:   nil,
+			expectedPlain: "",
+			expectedHTML:  "",
+		},
+		{
+			name: "title with link and text",
+			attachments: []*model.SlackAttachment{{
+				Title:     "Build #42",
+				TitleLink: "https://ci.example.com/42",
+				Text:      "Build **passed**",
+			}},
+			expectedPlain: "> Build #42 (https://ci.example.com/42)\n> Build **passed**",
+			expectedHTML:  `<blockquote><strong><a href="https://ci.example.com/42">Build #42</a></strong><br>Build <strong>passed</strong></blockquote>`,
+		},
+		{
+			name: "named colour and fields",
+			attachments: []*model.SlackAttachment{{
+				Color: "good",
+				Fields: []*model.SlackAttachmentField{
+					{Title: "Status", Value: "Deployed"},
+					{Title: "Replicas", Value: 3},
+				},
+			}},
+			expectedPlain: "> Status: Deployed\n> Replicas: 3",
+			expectedHTML:  `<blockquote><font data-mx-color="#2eb886">▍</font><strong>Status</strong><br>Deployed<br><strong>Replicas</strong><br>3</blockquote>`,
+		},
+		{
+			name: "buttons and menus are rendered as text",
+			attachments: []*model.SlackAttachment{{
+				Text: "Approve?",
+				Actions: []*model.PostAction{
+					{Type: model.PostActionTypeButton, Name: "Approve"},
+					{Type: model.PostActionTypeSelect, Name: "Reviewer", Options: []*model.PostActionOptions{{Text: "Alice"}, {Text: "Bob"}}},
+				},
+			}},
+			expectedPlain: "> Approve?\n> [Approve] [Reviewer: Alice | Bob]",
+			expectedHTML:  `<blockquote>Approve?<br><code>Approve</code> <code>Reviewer: Alice | Bob</code></blockquote>`,
+		},
+		{
+			name: "image is linked",
+			attachments: []*model.SlackAttachment{{
+				ImageURL: "https://example.com/chart.png",
+			}},
+			expectedPlain: "> Image: https://example.com/chart.png",
+			expectedHTML:  `<blockquote><a href="https://example.com/chart.png">Image</a></blockquote>`,
+		},
+		{
+			name: "unsafe links are not rendered",
+			attachments: []*model.SlackAttachment{{
+				Title:     "Click me",
+				TitleLink: "javascript:alert(1)",
+			}},
+			expectedPlain: "> Click me",
+			expectedHTML:  `<blockquote><strong>Click me</strong></blockquote>`,
+		},
+		{
+			name: "fallback only",
+			attachments: []*model.SlackAttachment{{
+				Fallback: "Something <happened>",
+			}},
+			expectedPlain: "> Something <happened>",
+			expectedHTML:  `<blockquote>Something &lt;happened&gt;</blockquote>`,
+		},
+		{
+			name:          "empty attachment is skipped",
+			attachments:   []*model.SlackAttachment{{}, nil},
+			expectedPlain: "",
+			expectedHTML:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, html := renderMessageAttachments(tt.attachments)
+			assert.Equal(t, tt.expectedPlain, plain)
+			assert.Equal(t, tt.expectedHTML, html)
+		})
+	}
+}
+
+func TestConvertPostToMatrix(t *testing.T) {
+	t.Run("message without attachments is unchanged", func(t *testing.T) {
+		post := &model.Post{Message: "Hello world"}
+		plain, html := convertPostToMatrix(post)
+		assert.Equal(t, "Hello world", plain)
+		assert.Equal(t, "", html)
+	})
+
+	t.Run("message with attachments", func(t *testing.T) {
+		post := &model.Post{Message: "Deploy finished"}
+		post.AddProp("attachments", []*model.SlackAttachment{{Title: "prod", Color: "#FF0000"}})
+
+		plain, html := convertPostToMatrix(post)
+		assert.Equal(t, "Deploy finished\n\n> prod", plain)
+		assert.Equal(t, `Deploy finished<br><blockquote><font data-mx-color="#ff0000">▍</font><strong>prod</strong></blockquote>`, html)
+	})
+
+	t.Run("attachments without message", func(t *testing.T) {
+		post := &model.Post{}
+		post.AddProp("attachments", []any{map[string]any{"text": "Only an attachment"}})
+
+		plain, html := convertPostToMatrix(post)
+		assert.Equal(t, "> Only an attachment", plain)
+		assert.Equal(t, "<blockquote>Only an attachment</blockquote>", html)
+	})
+}
+
+func TestNormalizeAttachmentColor(t *testing.T) {
+	assert.Equal(t, "#daa038", normalizeAttachmentColor("warning"))
+	assert.Equal(t, "#abcdef", normalizeAttachmentColor("ABCDEF"))
+	assert.Equal(t, "#fff", normalizeAttachmentColor("#fff"))
+	assert.Equal(t, "", normalizeAttachmentColor("not-a-colour"))
+	assert.Equal(t, "", normalizeAttachmentColor(""))
+}
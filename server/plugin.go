@@ -69,6 +69,15 @@ type Plugin struct {
 	// maxFileSize is the maximum size for file attachments in bytes
 	maxFileSize int64
 
+	// filterMetrics counts messages dropped or redacted by content filters
+	filterMetrics *contentFilterMetrics
+
+	// inboundLimiter rate limits Matrix senders and rooms posting to Mattermost
+	inboundLimiter *inboundLimiter
+
+	// mediaProxyCache keeps recently viewed Matrix media served through the media proxy
+	mediaProxyCache *mediaProxyCache
+
 	// Bridge components for dependency injection architecture
 	mattermostToMatrixBridge *MattermostToMatrixBridge
 	matrixToMattermostBridge *MatrixToMattermostBridge
@@ -159,9 +168,23 @@ func (p *Plugin) initMatrixClient() {
 		p.API,
 		rateLimitConfig,
 	)
+	if p.kvstore != nil {
+		p.matrixClient.SetMediaCache(newMediaCache(p.kvstore, p.logger))
+		p.matrixClient.SetPuppetStore(newPuppetStore(p.kvstore, p.logger, p))
+	}
 }
 
 func (p *Plugin) initBridges() {
+	if p.filterMetrics == nil {
+		p.filterMetrics = newContentFilterMetrics()
+	}
+	if p.inboundLimiter == nil {
+		p.inboundLimiter = newInboundLimiter()
+	}
+	if p.mediaProxyCache == nil {
+		p.mediaProxyCache = newMediaProxyCache(mediaProxyCacheSize)
+	}
+
 	// Create shared utilities
 	sharedUtils := NewBridgeUtils(BridgeUtilsConfig{
 		Logger:              p.logger,
@@ -172,6 +195,7 @@ func (p *Plugin) initBridges() {
 		MaxProfileImageSize: p.maxProfileImageSize,
 		MaxFileSize:         p.maxFileSize,
 		ConfigGetter:        p,
+		FilterMetrics:       p.filterMetrics,
 	})
 
 	// Create bridge instances
@@ -199,7 +223,7 @@ func (p *Plugin) registerForSharedChannels() error {
 
 	opts := model.RegisterPluginOpts{
 		Displayname:  "Matrix_Bridge",
-		PluginID:     "com.mattermost.plugin-matrix-bridge",
+		PluginID:     pluginID,
 		CreatorID:    creatorID,
 		AutoShareDMs: false,
 		AutoInvited:  false,
@@ -244,6 +268,59 @@ func (p *Plugin) GetMatrixUserIDFromMattermostUser(mattermostUserID string) (str
 	return p.mattermostToMatrixBridge.GetMatrixUserIDFromMattermostUser(mattermostUserID)
 }
 
+// CreateDirectMessageWithMatrixUser opens a Mattermost DM between a local user and a Matrix user,
+// creating the Matrix user's Mattermost account and the bridged Matrix DM room as needed
+func (p *Plugin) CreateDirectMessageWithMatrixUser(mattermostUserID, matrixUserID string) (*command.DirectMessageResult, error) {
+	user, appErr := p.API.GetUser(mattermostUserID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get Mattermost user")
+	}
+	if user.IsRemote() {
+		return nil, errors.New("remote users cannot start bridged direct messages")
+	}
+
+	// Create (or find) the Matrix user's account in Mattermost
+	remoteUserID, err := p.matrixToMattermostBridge.getOrCreateMattermostUser(matrixUserID, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get or create Mattermost user for Matrix user")
+	}
+
+	remoteUser, appErr := p.API.GetUser(remoteUserID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get Mattermost user for Matrix user")
+	}
+
+	dmChannel, appErr := p.API.GetDirectChannel(mattermostUserID, remoteUserID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to create DM channel in Mattermost")
+	}
+
+	existingRoomID, _ := p.mattermostToMatrixBridge.GetMatrixRoomID(dmChannel.Id)
+
+	roomID, err := p.mattermostToMatrixBridge.getOrCreateDMRoom(dmChannel.Id, []string{mattermostUserID, remoteUserID}, mattermostUserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get or create Matrix DM room")
+	}
+
+	// Join the local user's ghost right away so the Matrix user sees who invited them
+	ghostUserID, err := p.mattermostToMatrixBridge.CreateOrGetGhostUser(mattermostUserID)
+	if err != nil {
+		p.logger.LogWarn("Failed to create ghost user for DM", "error", err, "user_id", mattermostUserID)
+	} else if err := p.mattermostToMatrixBridge.ensureGhostUserInRoom(ghostUserID, roomID, mattermostUserID); err != nil {
+		p.logger.LogWarn("Failed to join ghost user to DM room", "error", err, "ghost_user_id", ghostUserID, "room_id", roomID)
+	}
+
+	p.logger.LogInfo("Opened direct message with Matrix user", "user_id", mattermostUserID, "matrix_user_id", matrixUserID, "channel_id", dmChannel.Id, "room_id", roomID)
+
+	return &command.DirectMessageResult{
+		ChannelID:    dmChannel.Id,
+		RoomID:       roomID,
+		UserID:       remoteUserID,
+		Username:     remoteUser.Username,
+		RoomExisting: existingRoomID != "",
+	}, nil
+}
+
 // GetPluginAPI returns the Mattermost plugin API
 func (p *Plugin) GetPluginAPI() plugin.API {
 	return p.API
@@ -301,6 +378,10 @@ func (p *Plugin) UserHasJoinedChannel(_ *plugin.Context, channelMember *model.Ch
 		return
 	}
 
+	if !p.syncsToMatrix(channelMember.ChannelId) {
+		return
+	}
+
 	// Get the user who joined the channel
 	// If the actor is the same as the user who joined, use the provided actor to avoid API call
 	var user *model.User
@@ -341,6 +422,9 @@ func (p *Plugin) UserHasJoinedChannel(_ *plugin.Context, channelMember *model.Ch
 		if err := p.inviteRemoteUserToMatrixRoom(user, channelMember.ChannelId); err != nil {
 			p.logger.LogError("Failed to invite remote user to Matrix room", "error", err, "user_id", user.Id, "username", user.Username, "channel_id", channelMember.ChannelId)
 		}
+	} else if p.RelaysToMatrix(channelMember.ChannelId) {
+		// The bridge bot relays the channel's messages, so the user doesn't need a ghost user in the room
+		p.logger.LogDebug("Skipping ghost user join for relayed channel", "user_id", user.Id, "channel_id", channelMember.ChannelId)
 	} else {
 		// This is a local Mattermost user - create ghost user and join them to the Matrix room
 		ghostUserID, err := p.CreateOrGetGhostUser(user.Id)
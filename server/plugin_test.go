@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServeHTTP(t *testing.T) {
@@ -27,3 +33,44 @@ func TestServeHTTP(t *testing.T) {
 
 	assert.Equal("Hello, world!", bodyString)
 }
+
+func TestMatrixPing(t *testing.T) {
+	plugin, _, _ := setupMatrixQueryTest(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/_matrix/app/v1/ping", strings.NewReader(`{"transaction_id":"txn-1"}`))
+	r.Header.Set("Authorization", "Bearer hs_token")
+	plugin.ServeHTTP(nil, w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, "{}", w.Body.String())
+
+	data, err := plugin.kvstore.Get(kvstore.KeyLastHomeserverContact)
+	require.NoError(t, err)
+	var contact command.HomeserverContact
+	require.NoError(t, json.Unmarshal(data, &contact))
+	assert.Equal(t, "txn-1", contact.TransactionID)
+	assert.NotZero(t, contact.Timestamp)
+}
+
+func TestLegacyTransactionRoute(t *testing.T) {
+	plugin, api, _ := setupMatrixQueryTest(t)
+	api.On("GetConfig").Return(&model.Config{})
+	transactionLogger, err := CreateTransactionLogger()
+	require.NoError(t, err)
+	plugin.transactionLogger = transactionLogger
+
+	t.Run("accepts the hs_token as an access_token query parameter", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/transactions/legacy-1?access_token=hs_token", strings.NewReader(`{"events":[]}`))
+		plugin.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("rejects a wrong access_token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/transactions/legacy-2?access_token=wrong", strings.NewReader(`{"events":[]}`))
+		plugin.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
 	"github.com/pkg/errors"
 )
 
@@ -106,6 +107,9 @@ type PendingFile struct {
 	MxcURI     string
 	MimeType   string
 	Size       int64
+	Info       matrix.MediaInfo
+	Notice     string // Sent in place of a file that wasn't uploaded, with MxcURI left empty
+	NoticeHTML string
 	UploadedAt int64
 }
 
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/pkg/errors"
+)
+
+// puppetDeviceName is the display name of the Matrix devices the bridge logs in for double puppeting
+const puppetDeviceName = "Mattermost Bridge"
+
+// puppetRecord is the Matrix account a Mattermost user linked for double puppeting
+type puppetRecord struct {
+	MatrixUserID string `json:"matrix_user_id"`
+	AccessToken  string `json:"access_token"`
+	// AppserviceLogin is set when the bridge logged in for the user, so unlinking logs its device out again
+	AppserviceLogin bool `json:"appservice_login,omitempty"`
+}
+
+// puppetStore keeps the Matrix accounts Mattermost users linked, encrypted with the configured key. The index
+// from Matrix user ID to Mattermost user ID holds no secrets and is stored in the clear.
+type puppetStore struct {
+	store        kvstore.KVStore
+	logger       Logger
+	configGetter ConfigurationGetter
+}
+
+func newPuppetStore(store kvstore.KVStore, logger Logger, configGetter ConfigurationGetter) *puppetStore {
+	return &puppetStore{store: store, logger: logger, configGetter: configGetter}
+}
+
+// GetPuppet returns the Matrix account linked to a ghost user's Mattermost user, or nil if there is none
+func (s *puppetStore) GetPuppet(ghostUserID string) *matrix.Puppet {
+	record := s.get(mattermostUserIDFromGhost(ghostUserID))
+	if record == nil {
+		return nil
+	}
+	return &matrix.Puppet{UserID: record.MatrixUserID, AccessToken: record.AccessToken}
+}
+
+// get returns the Matrix account a Mattermost user linked, or nil if they haven't linked one or it can't be
+// decrypted with the current key
+func (s *puppetStore) get(mattermostUserID string) *puppetRecord {
+	if s == nil || s.store == nil || mattermostUserID == "" {
+		return nil
+	}
+	data, err := s.store.Get(kvstore.BuildPuppetKey(mattermostUserID))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	plaintext, err := s.decrypt(data)
+	if err != nil {
+		s.logger.LogWarn("Failed to decrypt linked Matrix account", "error", err, "user_id", mattermostUserID)
+		return nil
+	}
+	var record puppetRecord
+	if err := json.Unmarshal(plaintext, &record); err != nil || record.MatrixUserID == "" {
+		return nil
+	}
+	return &record
+}
+
+// matrixUserID returns the Matrix user ID a Mattermost user linked, or "" if there is none
+func (s *puppetStore) matrixUserID(mattermostUserID string) string {
+	if record := s.get(mattermostUserID); record != nil {
+		return record.MatrixUserID
+	}
+	return ""
+}
+
+// mattermostUserID returns the Mattermost user who linked a Matrix account, or "" if nobody has
+func (s *puppetStore) mattermostUserID(matrixUserID string) string {
+	if s == nil || s.store == nil || matrixUserID == "" {
+		return ""
+	}
+	data, err := s.store.Get(kvstore.BuildPuppetMatrixUserKey(matrixUserID))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// sentAs reports whether a Matrix event's sender is a ghost user or the Matrix account linked to it
+func (s *puppetStore) sentAs(sender, ghostUserID string) bool {
+	if sender == ghostUserID {
+		return true
+	}
+	mattermostUserID := s.mattermostUserID(sender)
+	return mattermostUserID != "" && mattermostUserID == mattermostUserIDFromGhost(ghostUserID)
+}
+
+// set links a Matrix account to a Mattermost user, replacing any account they linked before
+func (s *puppetStore) set(mattermostUserID string, record *puppetRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal linked Matrix account")
+	}
+	ciphertext, err := s.encrypt(data)
+	if err != nil {
+		return err
+	}
+
+	if previous := s.get(mattermostUserID); previous != nil && previous.MatrixUserID != record.MatrixUserID {
+		if err := s.store.Delete(kvstore.BuildPuppetMatrixUserKey(previous.MatrixUserID)); err != nil {
+			return errors.Wrap(err, "failed to delete previous linked Matrix account index")
+		}
+	}
+	if err := s.store.Set(kvstore.BuildPuppetKey(mattermostUserID), ciphertext); err != nil {
+		return errors.Wrap(err, "failed to store linked Matrix account")
+	}
+	if err := s.store.Set(kvstore.BuildPuppetMatrixUserKey(record.MatrixUserID), []byte(mattermostUserID)); err != nil {
+		return errors.Wrap(err, "failed to store linked Matrix account index")
+	}
+	return nil
+}
+
+// delete unlinks a Mattermost user's Matrix account
+func (s *puppetStore) delete(mattermostUserID, matrixUserID string) error {
+	if err := s.store.Delete(kvstore.BuildPuppetKey(mattermostUserID)); err != nil {
+		return errors.Wrap(err, "failed to delete linked Matrix account")
+	}
+	if matrixUserID != "" {
+		if err := s.store.Delete(kvstore.BuildPuppetMatrixUserKey(matrixUserID)); err != nil {
+			return errors.Wrap(err, "failed to delete linked Matrix account index")
+		}
+	}
+	return nil
+}
+
+// aead returns the cipher linked accounts are encrypted with, keyed by the configured encryption key
+func (s *puppetStore) aead() (cipher.AEAD, error) {
+	key := s.configGetter.getConfiguration().PuppetEncryptionKey
+	if key == "" {
+		return nil, command.ErrPuppetEncryptionKeyMissing
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	return aead, nil
+}
+
+// encrypt seals data with a random nonce, which is stored in front of the ciphertext
+func (s *puppetStore) encrypt(data []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (s *puppetStore) decrypt(data []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt")
+	}
+	return plaintext, nil
+}
+
+// mattermostUserIDFromGhost returns the Mattermost user ID in a ghost user ID
+// (@_mattermost_<mattermost_user_id>:<server_domain>), or "" for other Matrix users
+func mattermostUserIDFromGhost(ghostUserID string) string {
+	rest, found := strings.CutPrefix(ghostUserID, "@_mattermost_")
+	if !found {
+		return ""
+	}
+	mattermostUserID, _, found := strings.Cut(rest, ":")
+	if !found {
+		return ""
+	}
+	return mattermostUserID
+}
+
+// LoginMatrixPuppet links a Matrix account to a Mattermost user so the bridge sends as it (double puppeting).
+// With an access token, the account is the one the token belongs to. Without one, the bridge logs in through
+// the double puppeting application service as the Matrix account the user linked with a verification code.
+func (p *Plugin) LoginMatrixPuppet(mattermostUserID, accessToken string) (string, error) {
+	config := p.getConfiguration()
+	if config.PuppetEncryptionKey == "" {
+		return "", command.ErrPuppetEncryptionKeyMissing
+	}
+	if p.matrixClient == nil {
+		return "", errors.New("matrix client not configured")
+	}
+	puppets := p.mattermostToMatrixBridge.puppets
+
+	record := &puppetRecord{AccessToken: accessToken}
+	if accessToken != "" {
+		matrixUserID, err := p.matrixClient.WhoAmI(accessToken)
+		if err != nil {
+			return "", errors.Wrap(command.ErrPuppetTokenInvalid, err.Error())
+		}
+		record.MatrixUserID = matrixUserID
+	} else {
+		if config.DoublePuppetASToken == "" {
+			return "", command.ErrAppserviceLoginUnavailable
+		}
+		// The application service can log in as anyone on the homeserver, so it only logs in as an account
+		// the user proved is theirs by confirming the code the bridge bot sent it
+		matrixUserID := p.matrixToMattermostBridge.linkedMatrixUserID(mattermostUserID)
+		if matrixUserID == "" {
+			return "", command.ErrPuppetAccountNotVerified
+		}
+		record.MatrixUserID = matrixUserID
+		record.AppserviceLogin = true
+	}
+
+	// Ghost users and the bridge bot are the bridge's own, and an account can only stand in for one user
+	if p.isGhostUser(record.MatrixUserID) || strings.HasPrefix(record.MatrixUserID, "@"+matrix.BridgeBotLocalpart+":") {
+		return "", command.ErrPuppetAccountInUse
+	}
+	if linkedUserID := puppets.mattermostUserID(record.MatrixUserID); linkedUserID != "" && linkedUserID != mattermostUserID {
+		return "", command.ErrPuppetAccountInUse
+	}
+
+	if record.AppserviceLogin {
+		token, err := p.matrixClient.LoginAsUser(record.MatrixUserID, config.DoublePuppetASToken, puppetDeviceName)
+		if err != nil {
+			return "", errors.Wrap(command.ErrAppserviceLoginUnavailable, err.Error())
+		}
+		record.AccessToken = token
+	}
+
+	previous := puppets.get(mattermostUserID)
+	if err := puppets.set(mattermostUserID, record); err != nil {
+		return "", err
+	}
+	p.logoutPuppetDevice(previous)
+
+	p.logger.LogInfo("Linked Matrix account for double puppeting", "user_id", mattermostUserID, "matrix_user_id", record.MatrixUserID, "appservice_login", record.AppserviceLogin)
+	return record.MatrixUserID, nil
+}
+
+// LogoutMatrixPuppet unlinks a Mattermost user's Matrix account, returning the account that was linked
+func (p *Plugin) LogoutMatrixPuppet(mattermostUserID string) (string, error) {
+	puppets := p.mattermostToMatrixBridge.puppets
+	record := puppets.get(mattermostUserID)
+	if record == nil {
+		return "", command.ErrPuppetNotLinked
+	}
+	if err := puppets.delete(mattermostUserID, record.MatrixUserID); err != nil {
+		return "", err
+	}
+	p.logoutPuppetDevice(record)
+
+	p.logger.LogInfo("Unlinked Matrix account for double puppeting", "user_id", mattermostUserID, "matrix_user_id", record.MatrixUserID)
+	return record.MatrixUserID, nil
+}
+
+// GetMatrixPuppet returns the Matrix account a Mattermost user linked for double puppeting, or ""
+func (p *Plugin) GetMatrixPuppet(mattermostUserID string) string {
+	return p.mattermostToMatrixBridge.puppets.matrixUserID(mattermostUserID)
+}
+
+// logoutPuppetDevice logs out the device the bridge logged in for a linked account. Tokens users gave the
+// bridge are theirs to revoke.
+func (p *Plugin) logoutPuppetDevice(record *puppetRecord) {
+	if record == nil || !record.AppserviceLogin || p.matrixClient == nil {
+		return
+	}
+	if err := p.matrixClient.Logout(record.AccessToken); err != nil {
+		p.logger.LogWarn("Failed to log out linked Matrix account device", "error", err, "matrix_user_id", record.MatrixUserID)
+	}
+}
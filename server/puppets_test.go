@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPuppetStore(t *testing.T) {
+	plugin, _, _, _ := setupSyncDirectionTest(t)
+	plugin.configuration.PuppetEncryptionKey = "key"
+	puppets := plugin.mattermostToMatrixBridge.puppets
+
+	require.NoError(t, puppets.set("user1", &puppetRecord{MatrixUserID: "@alice:test.com", AccessToken: "secret"}))
+
+	// The token is stored encrypted
+	stored, err := plugin.kvstore.Get(kvstore.BuildPuppetKey("user1"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), "secret")
+
+	assert.Equal(t, &matrix.Puppet{UserID: "@alice:test.com", AccessToken: "secret"}, puppets.GetPuppet("@_mattermost_user1:test.com"))
+	assert.Equal(t, "user1", puppets.mattermostUserID("@alice:test.com"))
+	assert.True(t, puppets.sentAs("@alice:test.com", "@_mattermost_user1:test.com"))
+	assert.False(t, puppets.sentAs("@alice:test.com", "@_mattermost_user2:test.com"))
+	assert.Nil(t, puppets.GetPuppet("@bob:test.com"))
+
+	// Linking another account drops the old one from the index
+	require.NoError(t, puppets.set("user1", &puppetRecord{MatrixUserID: "@alice2:test.com", AccessToken: "secret2"}))
+	assert.Empty(t, puppets.mattermostUserID("@alice:test.com"))
+	assert.Equal(t, "@alice2:test.com", puppets.matrixUserID("user1"))
+
+	// A changed key makes stored tokens unusable rather than wrong
+	plugin.configuration.PuppetEncryptionKey = "other key"
+	assert.Nil(t, puppets.get("user1"))
+
+	plugin.configuration.PuppetEncryptionKey = ""
+	assert.ErrorIs(t, puppets.set("user1", &puppetRecord{MatrixUserID: "@alice:test.com"}), command.ErrPuppetEncryptionKeyMissing)
+}
+
+func TestLoginMatrixPuppet(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *plugintest.API, *[]string) {
+		var logouts []string
+		plugin, api, _ := setupMappedChannelTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			switch r.URL.Path {
+			case "/_matrix/client/v3/account/whoami":
+				if token != "alice_token" {
+					http.Error(w, `{"errcode":"M_UNKNOWN_TOKEN"}`, http.StatusUnauthorized)
+					return
+				}
+				_, _ = w.Write([]byte(`{"user_id":"@alice:test.com"}`))
+			case "/_matrix/client/v3/login":
+				if token != "puppet_as_token" {
+					http.Error(w, `{"errcode":"M_FORBIDDEN"}`, http.StatusForbidden)
+					return
+				}
+				_, _ = w.Write([]byte(`{"access_token":"device_token"}`))
+			case "/_matrix/client/v3/logout":
+				logouts = append(logouts, token)
+				_, _ = w.Write([]byte(`{}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		plugin.configuration.PuppetEncryptionKey = "key"
+		return plugin, api, &logouts
+	}
+
+	t.Run("access tokens link the account they belong to", func(t *testing.T) {
+		plugin, _, _ := setup(t)
+
+		matrixUserID, err := plugin.LoginMatrixPuppet("user1", "alice_token")
+		require.NoError(t, err)
+		assert.Equal(t, "@alice:test.com", matrixUserID)
+		assert.Equal(t, "@alice:test.com", plugin.GetMatrixPuppet("user1"))
+	})
+
+	t.Run("invalid tokens are refused", func(t *testing.T) {
+		plugin, _, _ := setup(t)
+
+		_, err := plugin.LoginMatrixPuppet("user1", "wrong")
+		assert.ErrorIs(t, err, command.ErrPuppetTokenInvalid)
+		assert.Empty(t, plugin.GetMatrixPuppet("user1"))
+	})
+
+	t.Run("an account stands in for one user only", func(t *testing.T) {
+		plugin, _, _ := setup(t)
+
+		_, err := plugin.LoginMatrixPuppet("user1", "alice_token")
+		require.NoError(t, err)
+		_, err = plugin.LoginMatrixPuppet("user2", "alice_token")
+		assert.ErrorIs(t, err, command.ErrPuppetAccountInUse)
+	})
+
+	t.Run("the application service logs in as the user's verified account", func(t *testing.T) {
+		plugin, _, logouts := setup(t)
+
+		_, err := plugin.LoginMatrixPuppet("user1", "")
+		assert.ErrorIs(t, err, command.ErrAppserviceLoginUnavailable)
+
+		// A namesake on the homeserver isn't proof the account is the user's
+		plugin.configuration.DoublePuppetASToken = "puppet_as_token"
+		_, err = plugin.LoginMatrixPuppet("user1", "")
+		assert.ErrorIs(t, err, command.ErrPuppetAccountNotVerified)
+		assert.Empty(t, plugin.GetMatrixPuppet("user1"))
+
+		require.NoError(t, plugin.storeAccountLink("user1", &accountLink{MatrixUserID: "@alice:test.com"}))
+		matrixUserID, err := plugin.LoginMatrixPuppet("user1", "")
+		require.NoError(t, err)
+		assert.Equal(t, "@alice:test.com", matrixUserID)
+		assert.Equal(t, "device_token", plugin.mattermostToMatrixBridge.puppets.get("user1").AccessToken)
+
+		// Unlinking logs out the device the bridge logged in
+		_, err = plugin.LogoutMatrixPuppet("user1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"device_token"}, *logouts)
+		assert.Empty(t, plugin.GetMatrixPuppet("user1"))
+
+		_, err = plugin.LogoutMatrixPuppet("user1")
+		assert.ErrorIs(t, err, command.ErrPuppetNotLinked)
+	})
+
+	t.Run("unlinking the verified account logs the application service out", func(t *testing.T) {
+		plugin, _, logouts := setup(t)
+		plugin.configuration.DoublePuppetASToken = "puppet_as_token"
+
+		require.NoError(t, plugin.storeAccountLink("user1", &accountLink{MatrixUserID: "@alice:test.com"}))
+		_, err := plugin.LoginMatrixPuppet("user1", "")
+		require.NoError(t, err)
+
+		_, err = plugin.UnlinkMatrixAccount("user1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"device_token"}, *logouts)
+		assert.Empty(t, plugin.GetMatrixPuppet("user1"))
+	})
+
+	t.Run("unlinking keeps accounts logged in with the user's own token", func(t *testing.T) {
+		plugin, _, logouts := setup(t)
+
+		require.NoError(t, plugin.storeAccountLink("user1", &accountLink{MatrixUserID: "@alice:test.com"}))
+		_, err := plugin.LoginMatrixPuppet("user1", "alice_token")
+		require.NoError(t, err)
+
+		_, err = plugin.UnlinkMatrixAccount("user1")
+		require.NoError(t, err)
+		assert.Empty(t, *logouts)
+		assert.Equal(t, "@alice:test.com", plugin.GetMatrixPuppet("user1"))
+	})
+}
+
+func TestPuppetEchoesAreSkipped(t *testing.T) {
+	plugin, _, _, _ := setupSyncDirectionTest(t)
+	plugin.configuration.PuppetEncryptionKey = "key"
+	require.NoError(t, plugin.mattermostToMatrixBridge.puppets.set("user1", &puppetRecord{MatrixUserID: "@alice:test.com", AccessToken: "secret"}))
+
+	// The API mock has no expectations, so anything but skipping the event panics
+	event := MatrixEvent{
+		EventID: "$echo",
+		Type:    "m.room.message",
+		Sender:  "@alice:test.com",
+		RoomID:  "!room:test.com",
+		Content: map[string]any{"msgtype": "m.text", "body": "hello", matrix.PuppetEventKey: true},
+	}
+	assert.NoError(t, plugin.processMatrixEvent(event))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Relay settings of a channel mapping
+const (
+	relaySettingDefault = "default" // Follow the Relay Mode setting (the default)
+	relaySettingOn      = "on"      // The bridge bot relays the channel's messages
+	relaySettingOff     = "off"     // The channel's messages are sent as ghost users
+)
+
+// relaySettingResponse is the body of the relay setting API
+type relaySettingResponse struct {
+	ChannelID string `json:"channel_id"`
+	RoomID    string `json:"room_id"`
+	Relay     string `json:"relay"`
+	Relayed   bool   `json:"relayed"`
+}
+
+// isValidRelaySetting reports whether a string is a known relay setting
+func isValidRelaySetting(setting string) bool {
+	switch setting {
+	case relaySettingDefault, relaySettingOn, relaySettingOff:
+		return true
+	default:
+		return false
+	}
+}
+
+// getRelaySetting returns the relay setting of a channel's mapping. Mappings without one follow the Relay Mode
+// setting.
+func (s *BridgeUtils) getRelaySetting(channelID string) string {
+	data, err := s.kvstore.Get(kvstore.BuildRelayModeKey(channelID))
+	if err != nil || len(data) == 0 || !isValidRelaySetting(string(data)) {
+		return relaySettingDefault
+	}
+	return string(data)
+}
+
+// relaysToMatrix reports whether the bridge bot posts a channel's messages into its Matrix room on their senders'
+// behalf instead of sending them as ghost users. Direct and group messages always use ghost users.
+func (s *BridgeUtils) relaysToMatrix(channelID string) bool {
+	switch s.getRelaySetting(channelID) {
+	case relaySettingOff:
+		return false
+	case relaySettingDefault:
+		if !s.getConfiguration().RelayMode {
+			return false
+		}
+	}
+
+	channel, appErr := s.API.GetChannel(channelID)
+	if appErr != nil {
+		s.logger.LogWarn("Failed to get channel to check relay mode", "error", appErr, "channel_id", channelID)
+		return false
+	}
+	return !channel.IsGroupOrDirect()
+}
+
+// getRelaySender returns the bridge bot's Matrix user ID and the metadata identifying the Mattermost user it
+// relays for
+func (s *BridgeUtils) getRelaySender(user *model.User) (string, *matrix.RelaySender, error) {
+	botUserID, err := s.matrixClient.GetBridgeBotUserID()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get bridge bot user ID")
+	}
+	return botUserID, &matrix.RelaySender{
+		UserID:      user.Id,
+		Username:    user.Username,
+		DisplayName: user.GetDisplayName(model.ShowFullName),
+	}, nil
+}
+
+// renderRelayedPost converts a post to the Matrix body and formatted body the bridge bot relays it with, its
+// message filled into the relay template. The user's names are filled in after the conversion, so markdown in
+// them shows as written instead of formatting or linking the message.
+func renderRelayedPost(template string, user *model.User, post *model.Post) (string, string) {
+	// The placeholders are unguessable, so a message can't contain them
+	nonce := model.NewId()
+	displayNamePlaceholder, usernamePlaceholder := "relaydisplayname"+nonce, "relayusername"+nonce
+
+	relayed := post.Clone()
+	relayed.Message = strings.TrimSpace(strings.NewReplacer(
+		"{{displayname}}", displayNamePlaceholder,
+		"{{username}}", usernamePlaceholder,
+		"{{message}}", post.Message,
+	).Replace(template))
+	plainText, htmlContent := convertPostToMatrix(relayed)
+
+	displayName := user.GetDisplayName(model.ShowFullName)
+	plainText = strings.NewReplacer(displayNamePlaceholder, displayName, usernamePlaceholder, user.Username).Replace(plainText)
+	htmlContent = strings.NewReplacer(
+		displayNamePlaceholder, html.EscapeString(displayName),
+		usernamePlaceholder, html.EscapeString(user.Username),
+	).Replace(htmlContent)
+	return plainText, htmlContent
+}
+
+// RelaysToMatrix reports whether the bridge bot relays a channel's messages instead of ghost users sending them
+func (p *Plugin) RelaysToMatrix(channelID string) bool {
+	return p.mattermostToMatrixBridge.relaysToMatrix(channelID)
+}
+
+// SetRelaySetting sets the relay setting of a mapped channel
+func (p *Plugin) SetRelaySetting(channelID, setting string) error {
+	if !isValidRelaySetting(setting) {
+		return errors.Errorf("invalid relay setting %q", setting)
+	}
+
+	roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID)
+	if err != nil || roomID == "" {
+		return errors.New("channel is not mapped to a Matrix room")
+	}
+
+	// Following the global setting is the default, so it doesn't need storing
+	if setting == relaySettingDefault {
+		return errors.Wrap(p.kvstore.Delete(kvstore.BuildRelayModeKey(channelID)), "failed to delete relay setting")
+	}
+	return errors.Wrap(p.kvstore.Set(kvstore.BuildRelayModeKey(channelID), []byte(setting)), "failed to save relay setting")
+}
+
+// handleGetRelaySetting handles GET requests for the relay setting of a channel's mapping
+func (p *Plugin) handleGetRelaySetting(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := mux.Vars(r)["channelId"]
+
+	if !p.API.HasPermissionToChannel(userID, channelID, model.PermissionReadChannel) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	p.writeRelaySetting(w, channelID)
+}
+
+// handleSetRelaySetting handles PUT requests changing the relay setting of a channel's mapping. Only system
+// admins can change it.
+func (p *Plugin) handleSetRelaySetting(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := mux.Vars(r)["channelId"]
+
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Relay string `json:"relay"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !isValidRelaySetting(request.Relay) {
+		http.Error(w, "relay must be one of default, on or off", http.StatusBadRequest)
+		return
+	}
+
+	if roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID); err != nil || roomID == "" {
+		http.Error(w, "Channel is not mapped to a Matrix room", http.StatusNotFound)
+		return
+	}
+
+	if err := p.SetRelaySetting(channelID, request.Relay); err != nil {
+		p.logger.LogError("Failed to set relay setting", "error", err, "channel_id", channelID, "relay", request.Relay)
+		http.Error(w, "Failed to set relay setting", http.StatusInternalServerError)
+		return
+	}
+
+	p.logger.LogInfo("Relay setting changed", "channel_id", channelID, "relay", request.Relay, "user_id", userID)
+	p.writeRelaySetting(w, channelID)
+}
+
+// writeRelaySetting writes a channel's mapping and relay setting as JSON
+func (p *Plugin) writeRelaySetting(w http.ResponseWriter, channelID string) {
+	roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID)
+	if err != nil || roomID == "" {
+		http.Error(w, "Channel is not mapped to a Matrix room", http.StatusNotFound)
+		return
+	}
+
+	p.writeJSON(w, relaySettingResponse{
+		ChannelID: channelID,
+		RoomID:    roomID,
+		Relay:     p.mattermostToMatrixBridge.getRelaySetting(channelID),
+		Relayed:   p.RelaysToMatrix(channelID),
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const relayBotUserID = "@_mattermost_bridge:test.com"
+
+func TestRenderRelayedPost(t *testing.T) {
+	user := &model.User{Username: "alice", FirstName: "Alice", LastName: "Smith"}
+	render := func(template string, user *model.User, message string) (string, string) {
+		return renderRelayedPost(template, user, &model.Post{Message: message})
+	}
+
+	plainText, htmlContent := render(DefaultRelayTemplate, user, "hello *world*")
+	assert.Equal(t, "**Alice Smith**: hello *world*", plainText)
+	assert.Equal(t, "<strong>Alice Smith</strong>: hello <em>world</em>", htmlContent)
+
+	plainText, _ = render("<{{username}}> {{message}}", user, "hi {{username}}")
+	assert.Equal(t, "<alice> hi {{username}}", plainText)
+	plainText, _ = render(DefaultRelayTemplate, &model.User{Username: "alice"}, "")
+	assert.Equal(t, "**alice**:", plainText)
+
+	// Names show as written, without formatting or linking the message
+	mallory := &model.User{Username: "mallory", FirstName: "*Boss* [click](https://evil.example)", LastName: "<b>"}
+	plainText, htmlContent = render(DefaultRelayTemplate, mallory, "*hi*")
+	assert.Equal(t, "***Boss* [click](https://evil.example) <b>**: *hi*", plainText)
+	assert.Equal(t, "<strong>*Boss* [click](https://evil.example) &lt;b&gt;</strong>: <em>hi</em>", htmlContent)
+}
+
+func TestRelaysToMatrix(t *testing.T) {
+	plugin, api, channelID, _ := setupSyncDirectionTest(t)
+	api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Type: model.ChannelTypeOpen}, nil)
+
+	assert.False(t, plugin.RelaysToMatrix(channelID))
+
+	plugin.configuration.RelayMode = true
+	assert.True(t, plugin.RelaysToMatrix(channelID))
+
+	// Mappings override the global setting either way
+	require.NoError(t, plugin.SetRelaySetting(channelID, relaySettingOff))
+	assert.False(t, plugin.RelaysToMatrix(channelID))
+	plugin.configuration.RelayMode = false
+	require.NoError(t, plugin.SetRelaySetting(channelID, relaySettingOn))
+	assert.True(t, plugin.RelaysToMatrix(channelID))
+
+	require.NoError(t, plugin.SetRelaySetting(channelID, relaySettingDefault))
+	stored, _ := plugin.kvstore.Get(kvstore.BuildRelayModeKey(channelID))
+	assert.Empty(t, stored)
+	assert.False(t, plugin.RelaysToMatrix(channelID))
+
+	assert.Error(t, plugin.SetRelaySetting(channelID, "sometimes"))
+	assert.Error(t, plugin.SetRelaySetting(model.NewId(), relaySettingOn))
+
+	// Direct messages keep their ghost users
+	plugin.configuration.RelayMode = true
+	dmChannelID := model.NewId()
+	api.On("GetChannel", dmChannelID).Return(&model.Channel{Id: dmChannelID, Type: model.ChannelTypeDirect}, nil)
+	assert.False(t, plugin.RelaysToMatrix(dmChannelID))
+}
+
+// relayRequest is a request the test homeserver received
+type relayRequest struct {
+	method  string
+	path    string
+	userID  string
+	content map[string]any
+}
+
+func TestRelayedPosts(t *testing.T) {
+	// setup returns a plugin relaying a channel's messages, whose homeserver records the requests it receives
+	// and answers relation lookups with the reactions set through the returned function
+	setup := func(t *testing.T) (*Plugin, *plugintest.API, string, *model.User, func() []relayRequest, func([]map[string]any)) {
+		var mutex sync.Mutex
+		var requests []relayRequest
+		var reactions []map[string]any
+		plugin, api, channelID := setupMappedChannelTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request := relayRequest{method: r.Method, path: r.URL.Path, userID: r.URL.Query().Get("user_id")}
+			_ = json.NewDecoder(r.Body).Decode(&request.content)
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, request)
+
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/event/$relayed"):
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"event_id": "$relayed",
+					"sender":   relayBotUserID,
+					"content":  map[string]any{"msgtype": "m.text", "body": "**Alice Smith**: hello", matrix.RelaySenderKey: map[string]any{"user_id": "alice"}},
+				})
+			case strings.Contains(r.URL.Path, "/relations/"):
+				_ = json.NewEncoder(w).Encode(map[string]any{"chunk": reactions})
+			case strings.Contains(r.URL.Path, "/send/"), strings.Contains(r.URL.Path, "/redact/"):
+				_, _ = w.Write([]byte(`{"event_id":"$relayed"}`))
+			default:
+				http.Error(w, `{"errcode":"M_UNKNOWN"}`, http.StatusInternalServerError)
+			}
+		}))
+		plugin.configuration.RelayMode = true
+
+		user := &model.User{Id: model.NewId(), Username: "alice", FirstName: "Alice", LastName: "Smith"}
+		api.On("GetUser", user.Id).Return(user, nil)
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Type: model.ChannelTypeOpen}, nil)
+
+		return plugin, api, channelID, user, func() []relayRequest {
+				mutex.Lock()
+				defer mutex.Unlock()
+				return append([]relayRequest(nil), requests...)
+			}, func(chunk []map[string]any) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				reactions = chunk
+			}
+	}
+	sent := func(requests []relayRequest, kind string) []relayRequest {
+		var matching []relayRequest
+		for _, request := range requests {
+			if strings.Contains(request.path, kind) {
+				matching = append(matching, request)
+			}
+		}
+		return matching
+	}
+
+	t.Run("the bridge bot posts messages with their sender", func(t *testing.T) {
+		plugin, api, channelID, user, requests, _ := setup(t)
+		api.On("UpdatePost", mock.Anything).Return(&model.Post{UpdateAt: 1}, nil)
+
+		post := &model.Post{Id: model.NewId(), ChannelId: channelID, UserId: user.Id, Message: "hello"}
+		require.NoError(t, plugin.mattermostToMatrixBridge.SyncPostToMatrix(post, channelID))
+
+		messages := sent(requests(), "/send/m.room.message/")
+		require.Len(t, messages, 1)
+		assert.Equal(t, relayBotUserID, messages[0].userID)
+		assert.Equal(t, "**Alice Smith**: hello", messages[0].content["body"])
+		assert.Equal(t, map[string]any{"user_id": user.Id, "username": "alice", "displayname": "Alice Smith"}, messages[0].content[matrix.RelaySenderKey])
+		assert.Equal(t, post.Id, messages[0].content["mattermost_post_id"])
+
+		// No ghost user was created or joined
+		_, exists := plugin.mattermostToMatrixBridge.getGhostUser(user.Id)
+		assert.False(t, exists)
+		assert.Empty(t, sent(requests(), "/join/"))
+	})
+
+	t.Run("relayed posts are edited and deleted by the bridge bot after relaying is turned off", func(t *testing.T) {
+		plugin, _, channelID, user, requests, _ := setup(t)
+		plugin.configuration.RelayMode = false
+
+		post := &model.Post{
+			Id:        model.NewId(),
+			ChannelId: channelID,
+			UserId:    user.Id,
+			Message:   "hello again",
+			Props:     model.StringInterface{"matrix_event_id_test_com": "$relayed"},
+		}
+		require.NoError(t, plugin.mattermostToMatrixBridge.SyncPostToMatrix(post, channelID))
+
+		edits := sent(requests(), "/send/m.room.message/")
+		require.Len(t, edits, 1)
+		assert.Equal(t, relayBotUserID, edits[0].userID)
+		newContent, _ := edits[0].content["m.new_content"].(map[string]any)
+		assert.Equal(t, "**Alice Smith**: hello again", newContent["body"])
+
+		post.DeleteAt = model.GetMillis()
+		require.NoError(t, plugin.mattermostToMatrixBridge.SyncPostToMatrix(post, channelID))
+
+		redactions := sent(requests(), "/redact/")
+		require.Len(t, redactions, 1)
+		assert.Equal(t, relayBotUserID, redactions[0].userID)
+	})
+
+	t.Run("the bridge bot reacts once for everyone relayed", func(t *testing.T) {
+		plugin, api, channelID, user, requests, setReactions := setup(t)
+		other := &model.User{Id: model.NewId(), Username: "bob"}
+		api.On("GetUser", other.Id).Return(other, nil)
+		post := &model.Post{Id: model.NewId(), ChannelId: channelID, Props: model.StringInterface{"matrix_event_id_test_com": "$relayed"}}
+		api.On("GetPost", post.Id).Return(post, nil)
+		reaction := &model.Reaction{PostId: post.Id, UserId: user.Id, EmojiName: "tada"}
+		otherReaction := &model.Reaction{PostId: post.Id, UserId: other.Id, EmojiName: "tada"}
+
+		require.NoError(t, plugin.mattermostToMatrixBridge.SyncReactionToMatrix(reaction, channelID))
+		reactions := sent(requests(), "/send/m.reaction/")
+		require.Len(t, reactions, 1)
+		assert.Equal(t, relayBotUserID, reactions[0].userID)
+		relatesTo, _ := reactions[0].content["m.relates_to"].(map[string]any)
+		setReactions([]map[string]any{{
+			"type":     "m.reaction",
+			"event_id": "$reaction",
+			"sender":   relayBotUserID,
+			"content":  map[string]any{"m.relates_to": relatesTo},
+		}})
+
+		require.NoError(t, plugin.mattermostToMatrixBridge.SyncReactionToMatrix(otherReaction, channelID))
+		assert.Len(t, sent(requests(), "/send/m.reaction/"), 1)
+
+		// The reaction stays while someone on Mattermost still has it
+		api.On("GetReactions", post.Id).Return([]*model.Reaction{otherReaction}, nil).Once()
+		reaction.DeleteAt = model.GetMillis()
+		require.NoError(t, plugin.mattermostToMatrixBridge.SyncReactionToMatrix(reaction, channelID))
+		assert.Empty(t, sent(requests(), "/redact/"))
+
+		api.On("GetReactions", post.Id).Return([]*model.Reaction{}, nil).Once()
+		otherReaction.DeleteAt = model.GetMillis()
+		require.NoError(t, plugin.mattermostToMatrixBridge.SyncReactionToMatrix(otherReaction, channelID))
+		redactions := sent(requests(), "/redact/")
+		require.Len(t, redactions, 1)
+		assert.Contains(t, redactions[0].path, "/redact/$reaction/")
+		assert.Equal(t, relayBotUserID, redactions[0].userID)
+	})
+
+	t.Run("relayed reactions are removed by the bridge bot after relaying is turned off", func(t *testing.T) {
+		plugin, api, channelID, user, requests, setReactions := setup(t)
+		plugin.configuration.RelayMode = false
+		post := &model.Post{Id: model.NewId(), ChannelId: channelID, Props: model.StringInterface{"matrix_event_id_test_com": "$relayed"}}
+		api.On("GetPost", post.Id).Return(post, nil)
+		api.On("GetReactions", post.Id).Return([]*model.Reaction{}, nil)
+		setReactions([]map[string]any{{
+			"type":     "m.reaction",
+			"event_id": "$reaction",
+			"sender":   relayBotUserID,
+			"content":  map[string]any{"m.relates_to": map[string]any{"rel_type": "m.annotation", "event_id": "$relayed", "key": "🎉"}},
+		}})
+
+		reaction := &model.Reaction{PostId: post.Id, UserId: user.Id, EmojiName: "tada", DeleteAt: model.GetMillis()}
+		require.NoError(t, plugin.mattermostToMatrixBridge.SyncReactionToMatrix(reaction, channelID))
+
+		redactions := sent(requests(), "/redact/")
+		require.Len(t, redactions, 1)
+		assert.Contains(t, redactions[0].path, "/redact/$reaction/")
+		assert.Equal(t, relayBotUserID, redactions[0].userID)
+	})
+}
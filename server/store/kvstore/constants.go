@@ -27,6 +27,48 @@ const (
 	// KeyPrefixMatrixReaction is the prefix for Matrix reaction event ID -> reaction info mappings
 	KeyPrefixMatrixReaction = "matrix_reaction_"
 
+	// KeyPrefixMatrixInvite is the prefix for tracking Matrix users invited to a channel from Mattermost
+	KeyPrefixMatrixInvite = "matrix_invite_"
+
+	// KeyPrefixTeamSpace is the prefix for Mattermost team ID -> Matrix space mappings
+	KeyPrefixTeamSpace = "team_space_"
+	// KeyPrefixRoomSpace is the prefix for Matrix room ID -> the space currently listing the room
+	KeyPrefixRoomSpace = "room_space_"
+
+	// KeyPrefixAutoBridgeSkip is the prefix for channels the bridge is creating itself, which the auto-bridge policy must not bridge again
+	KeyPrefixAutoBridgeSkip = "auto_bridge_skip_"
+
+	// KeyPrefixSyncDirection is the prefix for Mattermost channel ID -> sync direction of its mapping
+	KeyPrefixSyncDirection = "sync_direction_"
+	// KeyPrefixContentFilter is the prefix for Mattermost channel ID -> content filter rules of its mapping
+	KeyPrefixContentFilter = "content_filter_"
+	// KeyPrefixFederationPolicy is the prefix for Mattermost channel ID -> federation policy of its mapping
+	KeyPrefixFederationPolicy = "federation_policy_"
+	// KeyPrefixRelayMode is the prefix for Mattermost channel ID -> relay setting of its mapping
+	KeyPrefixRelayMode = "relay_mode_"
+
+	// KeyPrefixMediaHash is the prefix for SHA-256 content hash -> mxc:// URI of uploaded media
+	KeyPrefixMediaHash = "media_hash_"
+	// KeyPrefixFileMedia is the prefix for Mattermost file ID -> mxc:// URI and media info of its upload
+	KeyPrefixFileMedia = "file_media_"
+	// KeyPrefixAvatarHash is the prefix for Mattermost user ID -> source and hash of the last avatar synced
+	KeyPrefixAvatarHash = "avatar_hash_"
+	// KeyPrefixProxiedMedia is the prefix for media proxy token -> Matrix file served under it
+	KeyPrefixProxiedMedia = "proxied_media_"
+
+	// KeyPrefixPuppet is the prefix for Mattermost user ID -> encrypted Matrix account linked for double puppeting
+	KeyPrefixPuppet = "puppet_"
+	// KeyPrefixPuppetMatrixUser is the prefix for linked Matrix user ID -> Mattermost user ID
+	KeyPrefixPuppetMatrixUser = "puppet_matrix_user_"
+
+	// KeyPrefixAccountLink is the prefix for Mattermost user ID -> Matrix account linked to it
+	KeyPrefixAccountLink = "account_link_"
+	// KeyPrefixAccountLinkRequest is the prefix for Mattermost user ID -> pending Matrix account link and its code
+	KeyPrefixAccountLinkRequest = "account_link_request_"
+
+	// KeyLastHomeserverContact is the key recording the last application service ping from the homeserver
+	KeyLastHomeserverContact = "last_homeserver_contact"
+
 	// KeyStoreVersion is the key for tracking the current KV store schema version
 	KeyStoreVersion = "kv_store_version"
 
@@ -77,3 +119,88 @@ func BuildMatrixEventPostKey(matrixEventID string) string {
 func BuildMatrixReactionKey(reactionEventID string) string {
 	return KeyPrefixMatrixReaction + reactionEventID
 }
+
+// BuildMatrixInviteKey creates a key for tracking a Matrix user invited to a channel
+func BuildMatrixInviteKey(channelID, matrixUserID string) string {
+	return BuildMatrixInviteChannelPrefix(channelID) + matrixUserID
+}
+
+// BuildMatrixInviteChannelPrefix creates the key prefix shared by all invites for a channel
+func BuildMatrixInviteChannelPrefix(channelID string) string {
+	return KeyPrefixMatrixInvite + channelID + "_"
+}
+
+// BuildTeamSpaceKey creates a key for team -> space mapping
+func BuildTeamSpaceKey(teamID string) string {
+	return KeyPrefixTeamSpace + teamID
+}
+
+// BuildRoomSpaceKey creates a key for tracking which space lists a room
+func BuildRoomSpaceKey(roomID string) string {
+	return KeyPrefixRoomSpace + roomID
+}
+
+// BuildAutoBridgeSkipKey creates a key marking a channel name in a team as exempt from auto-bridging
+func BuildAutoBridgeSkipKey(teamID, channelName string) string {
+	return KeyPrefixAutoBridgeSkip + teamID + "_" + channelName
+}
+
+// BuildSyncDirectionKey creates a key for the sync direction of a channel's mapping
+func BuildSyncDirectionKey(channelID string) string {
+	return KeyPrefixSyncDirection + channelID
+}
+
+// BuildContentFilterKey creates a key for the content filter rules of a channel's mapping
+func BuildContentFilterKey(channelID string) string {
+	return KeyPrefixContentFilter + channelID
+}
+
+// BuildFederationPolicyKey creates a key for the federation policy of a channel's mapping
+func BuildFederationPolicyKey(channelID string) string {
+	return KeyPrefixFederationPolicy + channelID
+}
+
+// BuildRelayModeKey creates a key for the relay setting of a channel's mapping
+func BuildRelayModeKey(channelID string) string {
+	return KeyPrefixRelayMode + channelID
+}
+
+// BuildMediaHashKey creates a key for the mxc:// URI of uploaded content
+func BuildMediaHashKey(hash string) string {
+	return KeyPrefixMediaHash + hash
+}
+
+// BuildFileMediaKey creates a key for the Matrix upload of a Mattermost file
+func BuildFileMediaKey(fileID string) string {
+	return KeyPrefixFileMedia + fileID
+}
+
+// BuildAvatarHashKey creates a key for the last avatar synced for a user
+func BuildAvatarHashKey(userID string) string {
+	return KeyPrefixAvatarHash + userID
+}
+
+// BuildProxiedMediaKey creates a key for the Matrix file the media proxy serves under a token
+func BuildProxiedMediaKey(token string) string {
+	return KeyPrefixProxiedMedia + token
+}
+
+// BuildPuppetKey creates a key for the Matrix account a Mattermost user linked for double puppeting
+func BuildPuppetKey(mattermostUserID string) string {
+	return KeyPrefixPuppet + mattermostUserID
+}
+
+// BuildPuppetMatrixUserKey creates a key for the Mattermost user who linked a Matrix account
+func BuildPuppetMatrixUserKey(matrixUserID string) string {
+	return KeyPrefixPuppetMatrixUser + matrixUserID
+}
+
+// BuildAccountLinkKey creates a key for the Matrix account linked to a Mattermost user
+func BuildAccountLinkKey(mattermostUserID string) string {
+	return KeyPrefixAccountLink + mattermostUserID
+}
+
+// BuildAccountLinkRequestKey creates a key for a Mattermost user's pending Matrix account link
+func BuildAccountLinkRequestKey(mattermostUserID string) string {
+	return KeyPrefixAccountLinkRequest + mattermostUserID
+}
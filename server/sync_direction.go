@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// syncDirectionResponse is the body of the sync direction API
+type syncDirectionResponse struct {
+	ChannelID string `json:"channel_id"`
+	RoomID    string `json:"room_id"`
+	Direction string `json:"direction"`
+}
+
+// GetSyncDirection returns the sync direction of a channel's mapping. Mappings without one sync both ways.
+func (p *Plugin) GetSyncDirection(channelID string) string {
+	data, err := p.kvstore.Get(kvstore.BuildSyncDirectionKey(channelID))
+	if err != nil || len(data) == 0 || !command.IsValidSyncDirection(string(data)) {
+		return command.SyncDirectionBoth
+	}
+	return string(data)
+}
+
+// SetSyncDirection sets the sync direction of a mapped channel
+func (p *Plugin) SetSyncDirection(channelID, direction string) error {
+	if !command.IsValidSyncDirection(direction) {
+		return errors.Errorf("invalid sync direction %q", direction)
+	}
+
+	roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID)
+	if err != nil || roomID == "" {
+		return errors.New("channel is not mapped to a Matrix room")
+	}
+
+	// Bidirectional is the default, so it doesn't need storing
+	if direction == command.SyncDirectionBoth {
+		return errors.Wrap(p.kvstore.Delete(kvstore.BuildSyncDirectionKey(channelID)), "failed to delete sync direction")
+	}
+	return errors.Wrap(p.kvstore.Set(kvstore.BuildSyncDirectionKey(channelID), []byte(direction)), "failed to save sync direction")
+}
+
+// syncsToMatrix reports whether changes in a channel are bridged to its Matrix room
+func (p *Plugin) syncsToMatrix(channelID string) bool {
+	return p.GetSyncDirection(channelID) != command.SyncDirectionToMattermost
+}
+
+// syncsToMattermost reports whether changes in a channel's Matrix room are bridged to the channel
+func (p *Plugin) syncsToMattermost(channelID string) bool {
+	return p.GetSyncDirection(channelID) != command.SyncDirectionToMatrix
+}
+
+// handleGetSyncDirection handles GET requests for the sync direction of a channel's mapping
+func (p *Plugin) handleGetSyncDirection(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := mux.Vars(r)["channelId"]
+
+	if !p.API.HasPermissionToChannel(userID, channelID, model.PermissionReadChannel) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	p.writeSyncDirection(w, channelID)
+}
+
+// handleSetSyncDirection handles PUT requests changing the sync direction of a channel's mapping. Only
+// system admins can change it.
+func (p *Plugin) handleSetSyncDirection(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := mux.Vars(r)["channelId"]
+
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Direction string `json:"direction"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !command.IsValidSyncDirection(request.Direction) {
+		http.Error(w, "direction must be one of both, to-matrix or to-mattermost", http.StatusBadRequest)
+		return
+	}
+
+	if roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID); err != nil || roomID == "" {
+		http.Error(w, "Channel is not mapped to a Matrix room", http.StatusNotFound)
+		return
+	}
+
+	if err := p.SetSyncDirection(channelID, request.Direction); err != nil {
+		p.logger.LogError("Failed to set sync direction", "error", err, "channel_id", channelID, "direction", request.Direction)
+		http.Error(w, "Failed to set sync direction", http.StatusInternalServerError)
+		return
+	}
+
+	p.logger.LogInfo("Sync direction changed", "channel_id", channelID, "direction", request.Direction, "user_id", userID)
+	p.writeSyncDirection(w, channelID)
+}
+
+// writeSyncDirection writes a channel's mapping and sync direction as JSON
+func (p *Plugin) writeSyncDirection(w http.ResponseWriter, channelID string) {
+	roomID, err := p.mattermostToMatrixBridge.GetMatrixRoomID(channelID)
+	if err != nil || roomID == "" {
+		http.Error(w, "Channel is not mapped to a Matrix room", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(syncDirectionResponse{
+		ChannelID: channelID,
+		RoomID:    roomID,
+		Direction: p.GetSyncDirection(channelID),
+	}); err != nil {
+		p.logger.LogError("Failed to write sync direction response", "error", err, "channel_id", channelID)
+	}
+}
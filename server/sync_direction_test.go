@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSyncDirectionTest creates a plugin with one mapped channel and a homeserver that counts the requests it gets
+func setupSyncDirectionTest(t *testing.T) (*Plugin, *plugintest.API, string, *int32) {
+	var requests int32
+	plugin, api, channelID := setupMappedChannelTest(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, `{"errcode":"M_UNKNOWN"}`, http.StatusInternalServerError)
+	}))
+	return plugin, api, channelID, &requests
+}
+
+func TestSetSyncDirection(t *testing.T) {
+	plugin, _, channelID, _ := setupSyncDirectionTest(t)
+
+	assert.Equal(t, command.SyncDirectionBoth, plugin.GetSyncDirection(channelID))
+
+	require.NoError(t, plugin.SetSyncDirection(channelID, command.SyncDirectionToMatrix))
+	assert.Equal(t, command.SyncDirectionToMatrix, plugin.GetSyncDirection(channelID))
+	assert.True(t, plugin.syncsToMatrix(channelID))
+	assert.False(t, plugin.syncsToMattermost(channelID))
+
+	require.NoError(t, plugin.SetSyncDirection(channelID, command.SyncDirectionBoth))
+	stored, _ := plugin.kvstore.Get(kvstore.BuildSyncDirectionKey(channelID))
+	assert.Empty(t, stored)
+
+	assert.Error(t, plugin.SetSyncDirection(channelID, "sideways"))
+	assert.Error(t, plugin.SetSyncDirection(model.NewId(), command.SyncDirectionToMatrix))
+}
+
+func TestSyncDirectionEnforcement(t *testing.T) {
+	t.Run("rooms mapped to Matrix only are ignored", func(t *testing.T) {
+		plugin, _, channelID, requests := setupSyncDirectionTest(t)
+		require.NoError(t, plugin.SetSyncDirection(channelID, command.SyncDirectionToMatrix))
+
+		// Reaching Mattermost would call the unmocked plugin API or the homeserver
+		for _, eventType := range []string{"m.reaction", "m.room.redaction", "m.room.member"} {
+			stateKey := "@bob:matrix.org"
+			err := plugin.processMatrixEvent(MatrixEvent{
+				EventID:  "$event",
+				Type:     eventType,
+				Sender:   "@bob:matrix.org",
+				RoomID:   "!room:test.com",
+				StateKey: &stateKey,
+				Content:  map[string]any{"membership": "join"},
+			})
+			require.NoError(t, err)
+		}
+		assert.Zero(t, atomic.LoadInt32(requests))
+	})
+
+	t.Run("channels mapped from Matrix only don't sync to Matrix", func(t *testing.T) {
+		plugin, _, channelID, requests := setupSyncDirectionTest(t)
+		require.NoError(t, plugin.SetSyncDirection(channelID, command.SyncDirectionToMattermost))
+
+		_, err := plugin.OnSharedChannelsSyncMsg(&model.SyncMsg{
+			ChannelId: channelID,
+			Posts:     []*model.Post{{Id: model.NewId(), ChannelId: channelID, UserId: model.NewId(), Message: "announcement"}},
+			Reactions: []*model.Reaction{{UserId: model.NewId(), PostId: model.NewId(), EmojiName: "smile"}},
+		}, nil)
+		require.NoError(t, err)
+
+		err = plugin.OnSharedChannelsAttachmentSyncMsg(&model.FileInfo{Id: model.NewId()}, &model.Post{Id: model.NewId(), ChannelId: channelID}, nil)
+		require.NoError(t, err)
+
+		assert.Zero(t, atomic.LoadInt32(requests))
+	})
+}
+
+func TestSyncDirectionAPI(t *testing.T) {
+	serve := func(plugin *Plugin, method, channelID, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/api/v1/mappings/"+channelID+"/direction", strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", "admin-id")
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("admins can change the direction", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+
+		w := serve(plugin, http.MethodPut, channelID, `{"direction":"to-mattermost"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response syncDirectionResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, syncDirectionResponse{ChannelID: channelID, RoomID: "!room:test.com", Direction: command.SyncDirectionToMattermost}, response)
+		assert.Equal(t, command.SyncDirectionToMattermost, plugin.GetSyncDirection(channelID))
+	})
+
+	t.Run("invalid directions are rejected", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+
+		w := serve(plugin, http.MethodPut, channelID, `{"direction":"sideways"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("other users can't change the direction", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(false)
+
+		w := serve(plugin, http.MethodPut, channelID, `{"direction":"to-matrix"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, command.SyncDirectionBoth, plugin.GetSyncDirection(channelID))
+	})
+
+	t.Run("channel members can read the direction", func(t *testing.T) {
+		plugin, api, channelID, _ := setupSyncDirectionTest(t)
+		api.On("HasPermissionToChannel", "admin-id", channelID, model.PermissionReadChannel).Return(true)
+
+		w := serve(plugin, http.MethodGet, channelID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"direction":"both"`)
+	})
+
+	t.Run("unmapped channels are not found", func(t *testing.T) {
+		plugin, api, _, _ := setupSyncDirectionTest(t)
+		channelID := model.NewId()
+		api.On("HasPermissionToChannel", "admin-id", channelID, model.PermissionReadChannel).Return(true)
+
+		w := serve(plugin, http.MethodGet, channelID, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
 	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
 	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
 	"github.com/mattermost/mattermost/server/public/model"
@@ -97,6 +98,11 @@ func (b *MattermostToMatrixBridge) CreateOrGetGhostUser(userID string) (string,
 		// Continue anyway, the ghost user was created successfully
 	}
 
+	// Remember the avatar so profile image syncs can tell when it changes
+	if err == nil && len(avatarData) > 0 {
+		b.mediaCache.storeAvatar(userID, &avatarRecord{Hash: matrix.MediaHash(avatarData)})
+	}
+
 	if displayName != "" {
 		b.logger.LogDebug("Created new ghost user with display name", "mattermost_user_id", userID, "ghost_user_id", ghostUser.UserID, "display_name", displayName)
 	} else {
@@ -131,6 +137,52 @@ func (b *MattermostToMatrixBridge) ensureGhostUserInRoom(ghostUserID, roomID, us
 	return nil
 }
 
+// getPostSender returns the Matrix user a Mattermost user's messages and reactions in a room are sent as. Relayed
+// ones are sent by the bridge bot, with metadata identifying the user; the rest by the user's ghost user, which
+// is joined to the room first.
+func (b *MattermostToMatrixBridge) getPostSender(user *model.User, matrixRoomID string, relayed bool) (string, *matrix.RelaySender, error) {
+	if relayed {
+		return b.getRelaySender(user)
+	}
+
+	ghostUserID, err := b.CreateOrGetGhostUser(user.Id)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to create or get ghost user")
+	}
+
+	if err := b.ensureGhostUserInRoom(ghostUserID, matrixRoomID, user.Id); err != nil {
+		return "", nil, errors.Wrap(err, "failed to ensure ghost user is in room")
+	}
+	return ghostUserID, nil, nil
+}
+
+// renderPostForMatrix converts a post to the Matrix body and formatted body, with the sender's name added to
+// relayed messages. Text rewritten by content filters replaces the post's message and attachments, which
+// it was rendered from.
+func (b *MattermostToMatrixBridge) renderPostForMatrix(post *model.Post, user *model.User, relayed bool, redactedText string) (string, string) {
+	content := post
+	if redactedText != "" {
+		content = post.Clone()
+		content.Message = redactedText
+		content.DelProp("attachments")
+	}
+	if relayed {
+		return renderRelayedPost(b.getConfiguration().GetRelayTemplate(), user, content)
+	}
+	return convertPostToMatrix(content)
+}
+
+// wasRelayed reports whether a Matrix event is a message the bridge bot relayed for a Mattermost user. Events
+// that couldn't be fetched are assumed to follow the channel's current relay setting.
+func (b *MattermostToMatrixBridge) wasRelayed(event map[string]any, channelID string) bool {
+	if event == nil {
+		return b.relaysToMatrix(channelID)
+	}
+	content, _ := event["content"].(map[string]any)
+	_, relayed := content[matrix.RelaySenderKey]
+	return relayed
+}
+
 func (b *MattermostToMatrixBridge) convertEmojiForMatrix(emojiName string) string {
 	// Remove colons if present
 	cleanName := strings.Trim(emojiName, ":")
@@ -195,9 +247,9 @@ func (b *MattermostToMatrixBridge) getFileEventIDsFromMetadata(matrixRoomID, pos
 			continue
 		}
 
-		// Check if this event is from our ghost user
+		// Check if this event is from our ghost user, or the Matrix account it acts as
 		sender, ok := event["sender"].(string)
-		if !ok || sender != ghostUserID {
+		if !ok || !b.puppets.sentAs(sender, ghostUserID) {
 			continue
 		}
 
@@ -301,6 +353,18 @@ func (b *MattermostToMatrixBridge) SyncPostToMatrix(post *model.Post, channelID
 		return errors.Wrap(appErr, "failed to get user")
 	}
 
+	// Content filters see the text as Matrix users would read it, attachments included, and can drop it or
+	// rewrite what is sent. The Mattermost post itself is left as it is.
+	renderedText, _ := convertPostToMatrix(post)
+	filtered := &filterMessage{SenderIDs: []string{user.Id, user.Username}, Type: post.Type, Text: renderedText}
+	if !b.filterContent(channelID, command.SyncDirectionToMatrix, filtered) {
+		return nil
+	}
+	var redactedText string
+	if filtered.Text != renderedText {
+		redactedText = filtered.Text
+	}
+
 	// Check if this post already has a Matrix event ID (indicating it's an edit)
 	config := b.getConfiguration()
 	serverDomain := extractServerDomain(b.logger, config.MatrixServerURL)
@@ -330,14 +394,14 @@ func (b *MattermostToMatrixBridge) SyncPostToMatrix(post *model.Post, channelID
 		}
 
 		// This is a genuine post edit - update the existing Matrix message
-		err = b.updatePostInMatrix(post, matrixRoomID, existingEventID, user)
+		err = b.updatePostInMatrix(post, matrixRoomID, existingEventID, user, redactedText)
 		if err != nil {
 			return errors.Wrap(err, "failed to update post in Matrix")
 		}
 		b.logger.LogDebug("Successfully updated post in Matrix", "post_id", post.Id, "matrix_event_id", existingEventID)
 	} else {
 		// This is a new post - create new Matrix message
-		err = b.createPostInMatrix(post, matrixRoomID, user, propertyKey)
+		err = b.createPostInMatrix(post, matrixRoomID, user, propertyKey, redactedText)
 		if err != nil {
 			return errors.Wrap(err, "failed to create post in Matrix")
 		}
@@ -347,31 +411,26 @@ func (b *MattermostToMatrixBridge) SyncPostToMatrix(post *model.Post, channelID
 	return nil
 }
 
-// createPostInMatrix creates a new post in Matrix and stores the event ID
-func (b *MattermostToMatrixBridge) createPostInMatrix(post *model.Post, matrixRoomID string, user *model.User, propertyKey string) error {
+// createPostInMatrix creates a new post in Matrix and stores the event ID. A non-empty redactedText is the
+// post's text as rewritten by content filters, sent in place of the post's own.
+func (b *MattermostToMatrixBridge) createPostInMatrix(post *model.Post, matrixRoomID string, user *model.User, propertyKey, redactedText string) error {
 	// Skip creating ghost users for Matrix-originated users to prevent loops
 	if user.IsRemote() {
 		b.logger.LogDebug("Skipping ghost user creation for remote user", "user_id", user.Id, "username", user.Username)
 		return nil
 	}
 
-	// Create or get ghost user
-	ghostUserID, err := b.CreateOrGetGhostUser(user.Id)
-	if err != nil {
-		return errors.Wrap(err, "failed to create or get ghost user")
-	}
-
-	// Ensure ghost user is joined to the room
-	err = b.ensureGhostUserInRoom(ghostUserID, matrixRoomID, user.Id)
+	// Send as the user's ghost user, or as the bridge bot in relay mode
+	senderUserID, relaySender, err := b.getPostSender(user, matrixRoomID, b.relaysToMatrix(post.ChannelId))
 	if err != nil {
-		return errors.Wrap(err, "failed to ensure ghost user is in room")
+		return err
 	}
 
 	// Process mentions first on the original text
 	mentionData := b.extractMattermostMentions(post)
 
-	// Convert post content to Matrix format
-	plainText, htmlContent := convertMattermostToMatrix(post.Message)
+	// Convert post content to Matrix format, with the sender's name added to relayed messages
+	plainText, htmlContent := b.renderPostForMatrix(post, user, relaySender != nil, redactedText)
 
 	// Create Matrix message content structure
 	messageContent := map[string]any{
@@ -417,10 +476,13 @@ func (b *MattermostToMatrixBridge) createPostInMatrix(post *model.Post, matrixRo
 	var fileAttachments []matrix.FileAttachment
 	for _, file := range pendingFiles {
 		fileAttachments = append(fileAttachments, matrix.FileAttachment{
-			Filename: file.Filename,
-			MxcURI:   file.MxcURI,
-			MimeType: file.MimeType,
-			Size:     file.Size,
+			Filename:   file.Filename,
+			MxcURI:     file.MxcURI,
+			MimeType:   file.MimeType,
+			Size:       file.Size,
+			Info:       file.Info,
+			Notice:     file.Notice,
+			NoticeHTML: file.NoticeHTML,
 		})
 	}
 
@@ -432,13 +494,15 @@ func (b *MattermostToMatrixBridge) createPostInMatrix(post *model.Post, matrixRo
 	// Send message using consolidated method
 	messageRequest := matrix.MessageRequest{
 		RoomID:        matrixRoomID,
-		GhostUserID:   ghostUserID,
+		GhostUserID:   senderUserID,
 		Message:       finalPlainText,
 		HTMLMessage:   finalHTMLContent,
 		ThreadEventID: threadEventID,
 		PostID:        post.Id,
 		Files:         fileAttachments,
 		Mentions:      finalMentions,
+		Captions:      b.getConfiguration().MediaCaptions,
+		RelaySender:   relaySender,
 	}
 
 	sendResponse, err := b.matrixClient.SendMessage(messageRequest)
@@ -473,35 +537,33 @@ func (b *MattermostToMatrixBridge) createPostInMatrix(post *model.Post, matrixRo
 		}
 	}
 
-	b.logger.LogDebug("Successfully created post in Matrix", "post_id", post.Id, "ghost_user_id", ghostUserID, "event_id", sendResponse.EventID)
+	b.logger.LogDebug("Successfully created post in Matrix", "post_id", post.Id, "sender_user_id", senderUserID, "relayed", relaySender != nil, "event_id", sendResponse.EventID)
 	return nil
 }
 
-// updatePostInMatrix updates an existing post in Matrix
-func (b *MattermostToMatrixBridge) updatePostInMatrix(post *model.Post, matrixRoomID string, eventID string, user *model.User) error {
+// updatePostInMatrix updates an existing post in Matrix. A non-empty redactedText is the post's text as
+// rewritten by content filters, sent in place of the post's own.
+func (b *MattermostToMatrixBridge) updatePostInMatrix(post *model.Post, matrixRoomID string, eventID string, user *model.User, redactedText string) error {
 	// Skip updating posts for Matrix-originated users to prevent loops
 	if user.IsRemote() {
 		b.logger.LogDebug("Skipping post update for remote user", "user_id", user.Id, "username", user.Username)
 		return nil
 	}
 
-	// Create or get ghost user
-	ghostUserID, err := b.CreateOrGetGhostUser(user.Id)
-	if err != nil {
-		return errors.Wrap(err, "failed to create or get ghost user")
-	}
+	// Fetch the current Matrix event content to compare
+	currentEvent, fetchErr := b.matrixClient.GetEvent(matrixRoomID, eventID)
 
-	// Ensure ghost user is still in the room
-	err = b.ensureGhostUserInRoom(ghostUserID, matrixRoomID, user.Id)
+	// Messages are edited by whoever sent them, even if the mapping's relay setting changed since
+	senderUserID, relaySender, err := b.getPostSender(user, matrixRoomID, b.wasRelayed(currentEvent, post.ChannelId))
 	if err != nil {
-		return errors.Wrap(err, "failed to ensure ghost user is in room")
+		return err
 	}
 
 	// Process mentions first on the original text
 	mentionData := b.extractMattermostMentions(post)
 
-	// Convert post content to Matrix format
-	plainText, htmlContent := convertMattermostToMatrix(post.Message)
+	// Convert post content to Matrix format, with the sender's name added to relayed messages
+	plainText, htmlContent := b.renderPostForMatrix(post, user, relaySender != nil, redactedText)
 
 	// Create Matrix message content structure
 	messageContent := map[string]any{
@@ -525,10 +587,13 @@ func (b *MattermostToMatrixBridge) updatePostInMatrix(post *model.Post, matrixRo
 	pendingFiles := b.fileTracker.GetFiles(post.Id)
 	for _, file := range pendingFiles {
 		currentFiles = append(currentFiles, matrix.FileAttachment{
-			Filename: file.Filename,
-			MxcURI:   file.MxcURI,
-			MimeType: file.MimeType,
-			Size:     file.Size,
+			Filename:   file.Filename,
+			MxcURI:     file.MxcURI,
+			MimeType:   file.MimeType,
+			Size:       file.Size,
+			Info:       file.Info,
+			Notice:     file.Notice,
+			NoticeHTML: file.NoticeHTML,
 		})
 	}
 
@@ -565,10 +630,8 @@ func (b *MattermostToMatrixBridge) updatePostInMatrix(post *model.Post, matrixRo
 		}())
 	}
 
-	// Fetch the current Matrix event content to compare
-	currentEvent, err := b.matrixClient.GetEvent(matrixRoomID, eventID)
-	if err != nil {
-		b.logger.LogWarn("Failed to fetch current Matrix event for comparison", "error", err, "event_id", eventID)
+	if fetchErr != nil {
+		b.logger.LogWarn("Failed to fetch current Matrix event for comparison", "error", fetchErr, "event_id", eventID)
 		// Continue with update if we can't fetch current content
 	} else {
 		// Compare content and file attachments to see if anything actually changed
@@ -578,13 +641,24 @@ func (b *MattermostToMatrixBridge) updatePostInMatrix(post *model.Post, matrixRo
 		}
 	}
 
+	// Posts sent as a media event, captioned or file-only, are edited by changing the caption so the file stays
+	if currentContent, ok := currentEvent["content"].(map[string]any); ok {
+		if msgType, _ := currentContent["msgtype"].(string); matrix.IsMediaMsgType(msgType) {
+			if _, err = b.matrixClient.EditMediaCaptionAsGhost(matrixRoomID, eventID, currentContent, finalPlainText, finalHTMLContent, senderUserID); err != nil {
+				return errors.Wrap(err, "failed to edit media caption as ghost user")
+			}
+			b.logger.LogDebug("Successfully updated media caption in Matrix", "post_id", post.Id, "sender_user_id", senderUserID, "matrix_event_id", eventID)
+			return nil
+		}
+	}
+
 	// Send edit as ghost user with proper HTML formatting support
-	_, err = b.matrixClient.EditMessageAsGhost(matrixRoomID, eventID, finalPlainText, finalHTMLContent, ghostUserID)
+	_, err = b.matrixClient.EditMessageAsGhost(matrixRoomID, eventID, finalPlainText, finalHTMLContent, senderUserID)
 	if err != nil {
 		return errors.Wrap(err, "failed to edit message as ghost user")
 	}
 
-	b.logger.LogDebug("Successfully updated post in Matrix", "post_id", post.Id, "ghost_user_id", ghostUserID, "matrix_event_id", eventID)
+	b.logger.LogDebug("Successfully updated post in Matrix", "post_id", post.Id, "sender_user_id", senderUserID, "matrix_event_id", eventID)
 	return nil
 }
 
@@ -630,27 +704,38 @@ func (b *MattermostToMatrixBridge) deletePostFromMatrix(post *model.Post, channe
 		return errors.Wrap(appErr, "failed to get user for post deletion")
 	}
 
-	// Check if ghost user exists (needed for redaction)
-	ghostUserID, exists := b.getGhostUser(user.Id)
-	if !exists {
-		b.logger.LogWarn("No ghost user found for post deletion", "user_id", post.UserId, "post_id", post.Id)
-		return nil // Can't delete a message from a user that doesn't have a ghost user
+	// Messages the bridge bot relayed are redacted by the bridge bot
+	var senderUserID string
+	currentEvent, _ := b.matrixClient.GetEvent(matrixRoomID, matrixEventID)
+	if b.wasRelayed(currentEvent, channelID) {
+		senderUserID, _, err = b.getRelaySender(user)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Check if ghost user exists (needed for redaction)
+		var exists bool
+		senderUserID, exists = b.getGhostUser(user.Id)
+		if !exists {
+			b.logger.LogWarn("No ghost user found for post deletion", "user_id", post.UserId, "post_id", post.Id)
+			return nil // Can't delete a message from a user that doesn't have a ghost user
+		}
 	}
 
 	// First, find and delete any file attachment replies to this message
-	err = b.deleteAllFileReplies(matrixRoomID, matrixEventID, ghostUserID)
+	err = b.deleteAllFileReplies(matrixRoomID, matrixEventID, senderUserID)
 	if err != nil {
 		b.logger.LogWarn("Failed to delete file attachment replies", "error", err, "post_id", post.Id, "matrix_event_id", matrixEventID)
 		// Continue anyway - we'll still delete the main message
 	}
 
 	// Redact the main message event
-	_, err = b.matrixClient.RedactEventAsGhost(matrixRoomID, matrixEventID, ghostUserID)
+	_, err = b.matrixClient.RedactEventAsGhost(matrixRoomID, matrixEventID, senderUserID)
 	if err != nil {
 		return errors.Wrap(err, "failed to redact post in Matrix")
 	}
 
-	b.logger.LogDebug("Successfully deleted post and file attachments from Matrix", "post_id", post.Id, "ghost_user_id", ghostUserID, "matrix_event_id", matrixEventID)
+	b.logger.LogDebug("Successfully deleted post and file attachments from Matrix", "post_id", post.Id, "sender_user_id", senderUserID, "matrix_event_id", matrixEventID)
 	return nil
 }
 
@@ -719,28 +804,33 @@ func (b *MattermostToMatrixBridge) addReactionToMatrix(reaction *model.Reaction,
 		return nil
 	}
 
-	// Create or get ghost user
-	ghostUserID, err := b.CreateOrGetGhostUser(user.Id)
-	if err != nil {
-		return errors.Wrap(err, "failed to create or get ghost user for reaction")
-	}
-
-	// Ensure ghost user is in the room
-	err = b.ensureGhostUserInRoom(ghostUserID, matrixRoomID, user.Id)
+	// React as the user's ghost user, or as the bridge bot in relay mode
+	senderUserID, relaySender, err := b.getPostSender(user, matrixRoomID, b.relaysToMatrix(channelID))
 	if err != nil {
-		return errors.Wrap(err, "failed to ensure ghost user is in room for reaction")
+		return errors.Wrap(err, "failed to get sender for reaction")
 	}
 
 	// Convert Mattermost emoji name to Matrix reaction format
 	emoji := b.convertEmojiForMatrix(reaction.EmojiName)
 
+	// The bridge bot reacts once for everyone relayed, so a second user reacting the same way adds nothing
+	if relaySender != nil {
+		existingEventID, err := b.findReactionEvent(matrixRoomID, matrixEventID, senderUserID, emoji)
+		if err != nil {
+			b.logger.LogWarn("Failed to check for an existing relayed reaction", "error", err, "post_id", reaction.PostId, "emoji", reaction.EmojiName)
+		} else if existingEventID != "" {
+			b.logger.LogDebug("Bridge bot already relayed this reaction", "post_id", reaction.PostId, "emoji", reaction.EmojiName, "reaction_event_id", existingEventID)
+			return nil
+		}
+	}
+
 	// Send reaction as ghost user
-	_, err = b.matrixClient.SendReactionAsGhost(matrixRoomID, matrixEventID, emoji, ghostUserID)
+	_, err = b.matrixClient.SendReactionAsGhost(matrixRoomID, matrixEventID, emoji, senderUserID)
 	if err != nil {
 		return errors.Wrap(err, "failed to send reaction as ghost user")
 	}
 
-	b.logger.LogDebug("Successfully synced reaction as ghost user", "post_id", reaction.PostId, "emoji", reaction.EmojiName, "ghost_user_id", ghostUserID, "matrix_event_id", matrixEventID)
+	b.logger.LogDebug("Successfully synced reaction as ghost user", "post_id", reaction.PostId, "emoji", reaction.EmojiName, "sender_user_id", senderUserID, "matrix_event_id", matrixEventID)
 	return nil
 }
 
@@ -792,24 +882,61 @@ func (b *MattermostToMatrixBridge) removeReactionFromMatrix(reaction *model.Reac
 		return errors.Wrap(appErr, "failed to get user for reaction removal")
 	}
 
-	// Check if ghost user exists (needed for determining which reaction to remove)
-	ghostUserID, exists := b.getGhostUser(user.Id)
-	if !exists {
-		b.logger.LogWarn("No ghost user found for reaction removal", "user_id", reaction.UserId, "post_id", reaction.PostId)
-		return nil // Can't remove a reaction from a user that doesn't have a ghost user
-	}
-
 	// Convert Mattermost emoji name to Matrix reaction format for matching
 	emoji := b.convertEmojiForMatrix(reaction.EmojiName)
 
+	// The reaction is removed by whoever sent it, which the relay setting may no longer match: the user's ghost
+	// user, or else the bridge bot relaying for them
+	var senderUserID, reactionEventID string
+	if ghostUserID, exists := b.getGhostUser(user.Id); exists {
+		reactionEventID, err = b.findReactionEvent(matrixRoomID, matrixEventID, ghostUserID, emoji)
+		if err != nil {
+			return err
+		}
+		senderUserID = ghostUserID
+	}
+
+	if reactionEventID == "" {
+		botUserID, err := b.matrixClient.GetBridgeBotUserID()
+		if err != nil {
+			return errors.Wrap(err, "failed to get bridge bot user ID")
+		}
+		reactionEventID, err = b.findReactionEvent(matrixRoomID, matrixEventID, botUserID, emoji)
+		if err != nil {
+			return err
+		}
+		if reactionEventID == "" {
+			b.logger.LogWarn("No matching reaction found in Matrix to remove", "post_id", reaction.PostId, "emoji", reaction.EmojiName, "user_id", reaction.UserId)
+			return nil // No matching reaction found to remove
+		}
+
+		// The bridge bot's reaction stands for everyone relayed, so it stays while anyone else still has it
+		if b.hasOtherLocalReaction(reaction) {
+			b.logger.LogDebug("Keeping relayed reaction other users still have", "post_id", reaction.PostId, "emoji", reaction.EmojiName)
+			return nil
+		}
+		senderUserID = botUserID
+	}
+
+	// Redact the reaction event
+	_, err = b.matrixClient.RedactEventAsGhost(matrixRoomID, reactionEventID, senderUserID)
+	if err != nil {
+		return errors.Wrap(err, "failed to redact reaction in Matrix")
+	}
+
+	b.logger.LogDebug("Successfully removed reaction from Matrix", "post_id", reaction.PostId, "emoji", reaction.EmojiName, "sender_user_id", senderUserID, "reaction_event_id", reactionEventID)
+	return nil
+}
+
+// findReactionEvent returns the ID of the reaction a ghost user, or the Matrix account it acts as, sent to an
+// event with a key, or an empty string if it hasn't reacted that way
+func (b *MattermostToMatrixBridge) findReactionEvent(matrixRoomID, matrixEventID, ghostUserID, emoji string) (string, error) {
 	// Get all reactions for this message from Matrix (as the ghost user)
 	relations, err := b.matrixClient.GetEventRelationsAsUser(matrixRoomID, matrixEventID, ghostUserID)
 	if err != nil {
-		return errors.Wrap(err, "failed to get event relations from Matrix")
+		return "", errors.Wrap(err, "failed to get event relations from Matrix")
 	}
 
-	// Find the matching reaction event to redact
-	var reactionEventID string
 	for _, event := range relations {
 		// Check if this is a reaction event
 		eventType, ok := event["type"].(string)
@@ -817,9 +944,9 @@ func (b *MattermostToMatrixBridge) removeReactionFromMatrix(reaction *model.Reac
 			continue
 		}
 
-		// Check if this reaction is from our ghost user
+		// Check if this reaction is from our ghost user, or the Matrix account it acts as
 		sender, ok := event["sender"].(string)
-		if !ok || sender != ghostUserID {
+		if !ok || !b.puppets.sentAs(sender, ghostUserID) {
 			continue
 		}
 
@@ -840,26 +967,32 @@ func (b *MattermostToMatrixBridge) removeReactionFromMatrix(reaction *model.Reac
 		}
 
 		// Found the matching reaction event
-		eventID, ok := event["event_id"].(string)
-		if ok {
-			reactionEventID = eventID
-			break
+		if eventID, ok := event["event_id"].(string); ok {
+			return eventID, nil
 		}
 	}
 
-	if reactionEventID == "" {
-		b.logger.LogWarn("No matching reaction found in Matrix to remove", "post_id", reaction.PostId, "emoji", reaction.EmojiName, "ghost_user_id", ghostUserID)
-		return nil // No matching reaction found to remove
-	}
+	return "", nil
+}
 
-	// Redact the reaction event
-	_, err = b.matrixClient.RedactEventAsGhost(matrixRoomID, reactionEventID, ghostUserID)
-	if err != nil {
-		return errors.Wrap(err, "failed to redact reaction in Matrix")
+// hasOtherLocalReaction reports whether Mattermost users other than the one removing a reaction still have it
+// on the post. Reactions of Matrix users don't count; they have their own in Matrix.
+func (b *MattermostToMatrixBridge) hasOtherLocalReaction(removed *model.Reaction) bool {
+	reactions, appErr := b.API.GetReactions(removed.PostId)
+	if appErr != nil {
+		b.logger.LogWarn("Failed to get reactions of post", "error", appErr, "post_id", removed.PostId)
+		return false
 	}
 
-	b.logger.LogDebug("Successfully removed reaction from Matrix", "post_id", reaction.PostId, "emoji", reaction.EmojiName, "ghost_user_id", ghostUserID, "reaction_event_id", reactionEventID)
-	return nil
+	for _, reaction := range reactions {
+		if reaction.EmojiName != removed.EmojiName || reaction.UserId == removed.UserId || reaction.DeleteAt != 0 {
+			continue
+		}
+		if user, appErr := b.API.GetUser(reaction.UserId); appErr == nil && !user.IsRemote() {
+			return true
+		}
+	}
+	return false
 }
 
 // extractMattermostMentions extracts @mentions from Mattermost post content
@@ -919,8 +1052,21 @@ func (b *MattermostToMatrixBridge) addMatrixMentionsWithData(content map[string]
 		var matrixUserID string
 		var displayName string
 
-		// Check if this user has a Matrix ghost user (Mattermost user → Matrix ghost)
-		if ghostUserID, exists := b.getGhostUser(user.Id); exists {
+		// Users who linked their own Matrix account, for double puppeting or as their Mattermost account, are
+		// mentioned as that account
+		linkedMatrixUserID := b.puppets.matrixUserID(user.Id)
+		if linkedMatrixUserID == "" {
+			linkedMatrixUserID = b.linkedMatrixUserID(user.Id)
+		}
+		if linkedMatrixUserID != "" {
+			matrixUserID = linkedMatrixUserID
+			displayName = user.GetDisplayName(model.ShowFullName)
+			if displayName == "" {
+				displayName = user.Username // Fallback to username
+			}
+			b.logger.LogDebug("Found linked Matrix account for Mattermost user mention", "username", username, "matrix_user_id", linkedMatrixUserID, "display_name", displayName)
+		} else if ghostUserID, exists := b.getGhostUser(user.Id); exists {
+			// Check if this user has a Matrix ghost user (Mattermost user → Matrix ghost)
 			matrixUserID = ghostUserID
 			displayName = user.GetDisplayName(model.ShowFullName)
 			if displayName == "" {
@@ -937,6 +1083,10 @@ func (b *MattermostToMatrixBridge) addMatrixMentionsWithData(content map[string]
 					displayName = user.Username // Fallback to username
 				}
 				b.logger.LogDebug("Found original Matrix user for bridged user mention", "username", username, "original_matrix_user_id", originalMatrixUserID, "display_name", displayName)
+			} else if b.relaysToMatrix(post.ChannelId) {
+				// Relayed channels don't get ghost users, so the mention stays plain text
+				b.logger.LogDebug("Skipping ghost user creation for mention in relayed channel", "username", username, "user_id", user.Id)
+				continue
 			} else {
 				// No existing ghost user or original Matrix user found - create new ghost user for mention
 				b.logger.LogDebug("Creating new ghost user for mentioned user", "username", username, "user_id", user.Id)
@@ -1223,6 +1373,7 @@ func (b *MattermostToMatrixBridge) getOrCreateDMRoom(channelID string, userIDs [
 
 	// For DMs, handle both local and remote users appropriately
 	var matrixUserIDs []string
+	localGhostUserIDs := make(map[string]string)
 	for _, userID := range userIDs {
 		user, appErr := b.API.GetUser(userID)
 		if appErr != nil {
@@ -1249,6 +1400,7 @@ func (b *MattermostToMatrixBridge) getOrCreateDMRoom(channelID string, userIDs [
 			}
 			b.logger.LogDebug("Created ghost user for DM", "mattermost_user_id", userID, "ghost_user_id", ghostUserID)
 			matrixUserIDs = append(matrixUserIDs, ghostUserID)
+			localGhostUserIDs[userID] = ghostUserID
 		}
 	}
 
@@ -1275,6 +1427,14 @@ func (b *MattermostToMatrixBridge) getOrCreateDMRoom(channelID string, userIDs [
 		// Continue anyway - the room was created successfully
 	}
 
+	// Mattermost participants are already in the channel, so their ghosts join rather than wait on an invite
+	for userID, ghostUserID := range localGhostUserIDs {
+		if err := b.ensureGhostUserInRoom(ghostUserID, matrixRoomID, userID); err != nil {
+			b.logger.LogWarn("Failed to join ghost user to DM room", "error", err, "ghost_user_id", ghostUserID, "matrix_room_id", matrixRoomID)
+		}
+	}
+	b.updateGhostDirectRooms(matrixRoomID, matrixUserIDs, nil)
+
 	b.logger.LogInfo("Successfully created Matrix DM room", "channel_id", channelID, "matrix_room_id", matrixRoomID, "matrix_users", matrixUserIDs)
 	return matrixRoomID, nil
 }
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/command"
 	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
 	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
 	"github.com/mattermost/mattermost/server/public/model"
@@ -59,11 +60,23 @@ func (b *MatrixToMattermostBridge) syncMatrixMessageToMattermost(event MatrixEve
 	}
 
 	// Check if this is a file/image attachment
-	if msgType, exists := event.Content["msgtype"].(string); exists {
-		switch msgType {
-		case "m.image", "m.file", "m.video", "m.audio":
-			return b.syncMatrixFileToMattermost(event, channelID)
+	msgType, _ := event.Content["msgtype"].(string)
+	if matrix.IsMediaMsgType(msgType) {
+		// Content filters see the caption, which they can rewrite, or else the file name, which they can only drop
+		filename, hasCaption := matrixMediaFileName(event.Content)
+		filtered := &filterMessage{SenderIDs: []string{event.Sender}, Type: msgType, Text: filename}
+		if hasCaption {
+			filtered.Text = b.convertMatrixToMattermost(b.extractMatrixMessageContent(event))
 		}
+		if !b.filterContent(channelID, command.SyncDirectionToMattermost, filtered) {
+			return nil
+		}
+
+		var caption string
+		if hasCaption {
+			caption = filtered.Text
+		}
+		return b.syncMatrixFileToMattermost(event, channelID, caption)
 	}
 
 	// Extract message content (smart format detection: prefer formatted text, fallback to plain text)
@@ -75,15 +88,22 @@ func (b *MatrixToMattermostBridge) syncMatrixMessageToMattermost(event MatrixEve
 		return nil // Empty new messages don't need to be synced
 	}
 
+	// Convert Matrix content to Mattermost format
+	mattermostContent := b.convertMatrixToMattermost(content)
+
+	// Content filters can drop the message or rewrite its text before a user is created for the sender
+	filtered := &filterMessage{SenderIDs: []string{event.Sender}, Type: msgType, Text: mattermostContent}
+	if !b.filterContent(channelID, command.SyncDirectionToMattermost, filtered) {
+		return nil
+	}
+	mattermostContent = filtered.Text
+
 	// Get or create Mattermost user for the Matrix sender
 	mattermostUserID, err := b.getOrCreateMattermostUser(event.Sender, channelID)
 	if err != nil {
 		return errors.Wrap(err, "failed to get or create Mattermost user")
 	}
 
-	// Convert Matrix content to Mattermost format
-	mattermostContent := b.convertMatrixToMattermost(content)
-
 	// Check if this is a threaded message (reply)
 	var rootID string
 	if relatesTo, exists := event.Content["m.relates_to"].(map[string]any); exists {
@@ -190,8 +210,29 @@ func (b *MatrixToMattermostBridge) handleMatrixMessageEdit(event MatrixEvent, ch
 		return errors.Wrap(appErr, "failed to get post for edit")
 	}
 
-	// Update the post content (allow empty content - user may have deleted all text)
-	post.Message = b.convertMatrixToMattermost(newContent)
+	// Edits go through the content filters too; a dropped edit leaves the earlier version in place
+	msgType, _ := event.Content["msgtype"].(string)
+	if newContentMap, ok := event.Content["m.new_content"].(map[string]any); ok {
+		msgType, _ = newContentMap["msgtype"].(string)
+
+		// Media edits change the caption; without one, the body is just the file name and the post has no text
+		if matrix.IsMediaMsgType(msgType) {
+			if _, hasCaption := matrixMediaFileName(newContentMap); !hasCaption {
+				newContent = ""
+			}
+		}
+	}
+	filtered := &filterMessage{SenderIDs: []string{event.Sender}, Type: msgType, Text: b.convertMatrixToMattermost(newContent)}
+	if !b.filterContent(channelID, command.SyncDirectionToMattermost, filtered) {
+		return nil
+	}
+
+	// Update the post content (allow empty content - user may have deleted all text), keeping the link or
+	// notice that stands in for a file that wasn't imported
+	post.Message = filtered.Text
+	if attachmentText, ok := post.GetProp(matrixAttachmentTextProp).(string); ok && attachmentText != "" {
+		post.Message = captionWithAttachmentText(filtered.Text, attachmentText)
+	}
 	post.EditAt = event.Timestamp
 
 	// Update the post
@@ -204,16 +245,17 @@ func (b *MatrixToMattermostBridge) handleMatrixMessageEdit(event MatrixEvent, ch
 	return nil
 }
 
-// syncMatrixFileToMattermost handles syncing Matrix file attachments to Mattermost
-func (b *MatrixToMattermostBridge) syncMatrixFileToMattermost(event MatrixEvent, channelID string) error {
+// syncMatrixFileToMattermost handles syncing Matrix file attachments to Mattermost. A caption becomes the
+// post's message.
+func (b *MatrixToMattermostBridge) syncMatrixFileToMattermost(event MatrixEvent, channelID, caption string) error {
 	b.logger.LogDebug("Syncing Matrix file to Mattermost", "event_id", event.EventID, "sender", event.Sender, "channel_id", channelID)
 
 	// Extract file metadata
-	body, exists := event.Content["body"].(string)
-	if !exists {
+	if _, exists := event.Content["body"].(string); !exists {
 		b.logger.LogWarn("Matrix file message missing body field", "event_id", event.EventID)
 		return nil
 	}
+	filename, _ := matrixMediaFileName(event.Content)
 
 	url, exists := event.Content["url"].(string)
 	if !exists {
@@ -227,16 +269,37 @@ func (b *MatrixToMattermostBridge) syncMatrixFileToMattermost(event MatrixEvent,
 		return errors.Wrap(err, "failed to get or create Mattermost user for file")
 	}
 
-	// Download the file from Matrix
-	fileData, err := b.downloadMatrixFile(url)
-	if err != nil {
-		return errors.Wrap(err, "failed to download Matrix file")
-	}
+	// Matrix's file info fills in what Mattermost would otherwise have to guess
+	mimeType, size := matrixFileInfo(event.Content)
+
+	// Files of blocked types, and files too large for Mattermost, are replaced with a notice. Large files link
+	// to the Matrix event, where readers can sign in to their own homeserver to get them. With the media proxy,
+	// other files are linked through the plugin and only fetched from Matrix when first viewed.
+	var notice string
+	var uploadedFileInfo *model.FileInfo
+	limit := b.mattermostFileLimit()
+	tooLarge := oversizedAttachmentNotice(filename, size, "Open it in Matrix", matrixEventLink(event.RoomID, event.EventID)).Text
+	switch fileType := attachmentMimeType(mimeType, filename); {
+	case mimeTypeBlocked(b.getConfiguration().getBlockedMimeTypes(command.SyncDirectionToMattermost), fileType):
+		b.logger.LogInfo("Replacing Matrix file of a blocked type with a notice", "event_id", event.EventID, "mime_type", fileType)
+		notice = blockedAttachmentNotice(filename, fileType).Text
+	case limit > 0 && size > limit:
+		b.logger.LogWarn("Replacing Matrix file larger than the maximum file size with a notice", "event_id", event.EventID, "size", size, "max", limit)
+		notice = tooLarge
+	default:
+		notice = b.proxyMatrixFile(event, proxiedMedia{MxcURI: url, ChannelID: channelID, Filename: filename, MimeType: fileType, Size: size})
+		if notice != "" {
+			break
+		}
 
-	// Upload file to Mattermost
-	uploadedFileInfo, appErr := b.API.UploadFile(fileData, channelID, body)
-	if appErr != nil {
-		return errors.Wrap(appErr, "failed to upload file to Mattermost")
+		// Stream the file from Matrix into Mattermost
+		uploadedFileInfo, err = b.copyMatrixFileToMattermost(url, channelID, mattermostUserID, matrixFileName(filename, mimeType), limit)
+		if errors.Is(err, matrix.ErrFileTooLarge) {
+			b.logger.LogWarn("Replacing Matrix file larger than the maximum file size with a notice", "event_id", event.EventID, "max", limit)
+			notice = tooLarge
+		} else if err != nil {
+			return errors.Wrap(err, "failed to copy Matrix file to Mattermost")
+		}
 	}
 
 	// Check if this is a threaded message (reply)
@@ -268,17 +331,22 @@ func (b *MatrixToMattermostBridge) syncMatrixFileToMattermost(event MatrixEvent,
 		}
 	}
 
-	// Create Mattermost post with file attachment (no message text needed)
+	// Create Mattermost post with file attachment, or the notice in its place, and the caption if there is one
 	post := &model.Post{
 		UserId:    mattermostUserID,
 		ChannelId: channelID,
-		Message:   "", // Empty message - like chess, the file attachment speaks for itself
+		Message:   caption,
 		CreateAt:  event.Timestamp,
 		RootId:    rootID,
 		RemoteId:  &b.remoteID,
-		FileIds:   []string{uploadedFileInfo.Id},
 		Props:     make(map[string]any),
 	}
+	if uploadedFileInfo != nil {
+		post.FileIds = []string{uploadedFileInfo.Id}
+	} else {
+		post.Message = captionWithAttachmentText(caption, notice)
+		post.Props[matrixAttachmentTextProp] = notice
+	}
 
 	// Store Matrix event ID in post properties for reaction mapping and edit tracking
 	config := b.getConfiguration()
@@ -287,6 +355,11 @@ func (b *MatrixToMattermostBridge) syncMatrixFileToMattermost(event MatrixEvent,
 	post.Props[propertyKey] = event.EventID
 	post.Props["from_matrix"] = true
 
+	// Voice messages become Mattermost voice messages
+	if durationMs, waveform, isVoice := matrixVoiceMessage(event.Content); isVoice && uploadedFileInfo != nil {
+		b.addVoiceProps(post, uploadedFileInfo, durationMs, waveform)
+	}
+
 	// Create the post in Mattermost
 	createdPost, appErr := b.API.CreatePost(post)
 	if appErr != nil {
@@ -296,7 +369,7 @@ func (b *MatrixToMattermostBridge) syncMatrixFileToMattermost(event MatrixEvent,
 	// Store Matrix event ID to Mattermost post ID mapping for efficient reverse lookups
 	b.storeMatrixEventPostMapping(event.EventID, createdPost.Id)
 
-	b.logger.LogDebug("Successfully synced Matrix file to Mattermost", "matrix_event_id", event.EventID, "mattermost_post_id", createdPost.Id, "filename", body, "file_id", uploadedFileInfo.Id)
+	b.logger.LogDebug("Successfully synced Matrix file to Mattermost", "matrix_event_id", event.EventID, "mattermost_post_id", createdPost.Id, "filename", filename, "file_ids", post.FileIds)
 	return nil
 }
 
@@ -513,6 +586,10 @@ func (b *MatrixToMattermostBridge) handlePostDeletion(redactsEventID, channelID,
 // getOrCreateMattermostUser gets or creates a Mattermost user for a Matrix user
 // If channelID is provided, ensures the user is added to the team associated with that channel
 func (b *MatrixToMattermostBridge) getOrCreateMattermostUser(matrixUserID string, channelID string) (string, error) {
+	if !b.allowsMatrixSender(matrixUserID, channelID) {
+		return "", errMatrixServerNotAllowed
+	}
+
 	// Check if we already have a mapping for this Matrix user
 	userMapKey := kvstore.BuildMatrixUserKey(matrixUserID)
 	userIDBytes, err := b.kvstore.Get(userMapKey)
@@ -556,6 +633,7 @@ func (b *MatrixToMattermostBridge) getOrCreateMattermostUser(matrixUserID string
 	var displayName string
 	var firstName, lastName string
 	var avatarData []byte
+	var avatarURL string
 
 	if b.matrixClient != nil {
 		profile, err := b.matrixClient.GetUserProfile(matrixUserID)
@@ -577,6 +655,7 @@ func (b *MatrixToMattermostBridge) getOrCreateMattermostUser(matrixUserID string
 				if err != nil {
 					b.logger.LogWarn("Failed to download Matrix user avatar", "error", err, "user_id", matrixUserID, "avatar_url", profile.AvatarURL)
 				}
+				avatarURL = profile.AvatarURL
 			}
 		}
 	} else {
@@ -623,6 +702,7 @@ func (b *MatrixToMattermostBridge) getOrCreateMattermostUser(matrixUserID string
 		if appErr != nil {
 			b.logger.LogWarn("Failed to set Matrix user avatar", "error", appErr, "user_id", createdUser.Id, "matrix_user_id", matrixUserID)
 		} else {
+			b.mediaCache.storeAvatar(createdUser.Id, &avatarRecord{Source: avatarURL, Hash: matrix.MediaHash(avatarData)})
 			b.logger.LogDebug("Successfully set avatar for Matrix user", "user_id", createdUser.Id, "matrix_user_id", matrixUserID)
 		}
 	}
@@ -1007,7 +1087,8 @@ type ProfileUpdateContext struct {
 // updateMattermostUserProfile updates an existing Mattermost user's profile from Matrix
 // Can be called either proactively (fetching current profile) or reactively (from Matrix events)
 func (b *MatrixToMattermostBridge) updateMattermostUserProfile(mattermostUser *model.User, matrixUserID string, context *ProfileUpdateContext, profileData ...*matrix.UserProfile) {
-	if b.matrixClient == nil {
+	// Users who linked their Matrix account keep their own Mattermost profile
+	if b.matrixClient == nil || b.getAccountLink(mattermostUser.Id) != nil {
 		return
 	}
 
@@ -1081,18 +1162,13 @@ func (b *MatrixToMattermostBridge) updateMattermostUserProfile(mattermostUser *m
 }
 
 // updateMattermostUserAvatar updates a Mattermost user's profile image from Matrix
-// Compares current and new image data to avoid unnecessary updates
+// Compares hashes of the current and new images to avoid unnecessary updates
 func (b *MatrixToMattermostBridge) updateMattermostUserAvatar(mattermostUser *model.User, matrixUserID, matrixAvatarURL string, context *ProfileUpdateContext) {
-	// Get current Mattermost profile image
-	currentAvatarData, appErr := b.API.GetProfileImage(mattermostUser.Id)
-	if appErr != nil {
-		if context.Source == "event" {
-			b.logger.LogWarn("Failed to get current Mattermost profile image for comparison", "error", appErr, "user_id", mattermostUser.Id, "matrix_user_id", matrixUserID, "event_id", context.EventID)
-		} else {
-			b.logger.LogDebug("Failed to get current Mattermost profile image for comparison", "error", appErr, "user_id", mattermostUser.Id, "matrix_user_id", matrixUserID)
-		}
-		// Continue with update even if we can't get current image
-		currentAvatarData = nil
+	// Matrix gives new content a new mxc:// URI, so an avatar already synced from this URI is unchanged
+	avatar := b.mediaCache.getAvatar(mattermostUser.Id)
+	if avatar != nil && avatar.Source == matrixAvatarURL {
+		b.logger.LogDebug("Matrix avatar unchanged, skipping update", "user_id", mattermostUser.Id, "matrix_user_id", matrixUserID, "source", context.Source)
+		return
 	}
 
 	// Download Matrix avatar image
@@ -1105,15 +1181,32 @@ func (b *MatrixToMattermostBridge) updateMattermostUserAvatar(mattermostUser *mo
 		}
 		return
 	}
+	newAvatar := &avatarRecord{Source: matrixAvatarURL, Hash: matrix.MediaHash(newAvatarData)}
 
-	// Compare image data to see if update is needed
-	if currentAvatarData != nil && b.compareImageData(currentAvatarData, newAvatarData) {
+	// Without a record of the last avatar synced, hash the current Mattermost profile image once
+	if avatar == nil {
+		currentAvatarData, appErr := b.API.GetProfileImage(mattermostUser.Id)
+		if appErr != nil {
+			if context.Source == "event" {
+				b.logger.LogWarn("Failed to get current Mattermost profile image for comparison", "error", appErr, "user_id", mattermostUser.Id, "matrix_user_id", matrixUserID, "event_id", context.EventID)
+			} else {
+				b.logger.LogDebug("Failed to get current Mattermost profile image for comparison", "error", appErr, "user_id", mattermostUser.Id, "matrix_user_id", matrixUserID)
+			}
+			// Continue with update even if we can't get current image
+		} else {
+			avatar = &avatarRecord{Hash: matrix.MediaHash(currentAvatarData)}
+		}
+	}
+
+	// Compare image hashes to see if update is needed
+	if avatar != nil && avatar.Hash == newAvatar.Hash {
+		b.mediaCache.storeAvatar(mattermostUser.Id, newAvatar)
 		b.logger.LogDebug("Matrix avatar unchanged, skipping update", "user_id", mattermostUser.Id, "matrix_user_id", matrixUserID, "source", context.Source)
 		return
 	}
 
 	// Images are different, update the profile image
-	appErr = b.API.SetProfileImage(mattermostUser.Id, newAvatarData)
+	appErr := b.API.SetProfileImage(mattermostUser.Id, newAvatarData)
 	if appErr != nil {
 		if context.Source == "event" {
 			b.logger.LogError("Failed to update Mattermost user avatar from Matrix event", "error", appErr, "user_id", mattermostUser.Id, "matrix_user_id", matrixUserID, "event_id", context.EventID)
@@ -1122,6 +1215,7 @@ func (b *MatrixToMattermostBridge) updateMattermostUserAvatar(mattermostUser *mo
 		}
 		return
 	}
+	b.mediaCache.storeAvatar(mattermostUser.Id, newAvatar)
 
 	// Log successful avatar update
 	if context.Source == "event" {
@@ -1131,29 +1225,6 @@ func (b *MatrixToMattermostBridge) updateMattermostUserAvatar(mattermostUser *mo
 	}
 }
 
-// compareImageData compares two image byte arrays to determine if they're the same
-// Uses a simple byte comparison for now, could be enhanced with more sophisticated comparison
-func (b *MatrixToMattermostBridge) compareImageData(currentData, newData []byte) bool {
-	// Quick size check first
-	if len(currentData) != len(newData) {
-		return false
-	}
-
-	// If both are empty, they're the same
-	if len(currentData) == 0 {
-		return true
-	}
-
-	// Compare byte by byte
-	for i := range currentData {
-		if currentData[i] != newData[i] {
-			return false
-		}
-	}
-
-	return true
-}
-
 // syncMatrixMemberEventToMattermost handles Matrix member events (joins, leaves, profile changes)
 func (b *MatrixToMattermostBridge) syncMatrixMemberEventToMattermost(event MatrixEvent, channelID string) error {
 	b.logger.LogDebug("Processing Matrix member event", "event_id", event.EventID, "sender", event.Sender, "channel_id", channelID)
@@ -1171,6 +1242,11 @@ func (b *MatrixToMattermostBridge) syncMatrixMemberEventToMattermost(event Matri
 		return nil
 	}
 
+	// Track responses to invites sent with /matrix invite - the state key is the invited user
+	if event.StateKey != nil && *event.StateKey != "" {
+		b.updateMatrixInviteStatus(channelID, *event.StateKey, membership)
+	}
+
 	// Check if we have a Mattermost user for this Matrix user
 	userMapKey := kvstore.BuildMatrixUserKey(event.Sender)
 	userIDBytes, err := b.kvstore.Get(userMapKey)
@@ -1236,6 +1312,11 @@ func (b *MatrixToMattermostBridge) handleMatrixMemberLeave(event MatrixEvent, ch
 	membership := event.Content["membership"].(string)
 	b.logger.LogDebug("Matrix user leaving room", "event_id", event.EventID, "sender", event.Sender, "membership", membership, "channel_id", channelID)
 
+	// Linked Mattermost users manage their own channel memberships
+	if b.getAccountLink(existingUserID) != nil {
+		return nil
+	}
+
 	// Remove user from the Mattermost channel
 	return b.removeUserFromChannel(existingUserID, channelID)
 }
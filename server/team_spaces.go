@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// teamSpace is the stored record of the Matrix space that mirrors a Mattermost team
+type teamSpace struct {
+	SpaceID            string `json:"space_id"`
+	TeamID             string `json:"team_id"`
+	DisplayName        string `json:"display_name"`
+	LastTeamIconUpdate int64  `json:"last_team_icon_update"`
+}
+
+// AddChannelToTeamSpace lists a mapped channel's room in the space for the channel's team, creating the
+// space on first use and moving the room out of any other team's space. DMs, group messages and archived
+// channels aren't listed.
+func (p *Plugin) AddChannelToTeamSpace(channelID, roomID string) error {
+	if p.matrixClient == nil {
+		return errors.New("matrix client not configured")
+	}
+
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get channel")
+	}
+	if channel.TeamId == "" || channel.DeleteAt != 0 {
+		return nil
+	}
+
+	team, appErr := p.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get team")
+	}
+
+	space, err := p.getOrCreateTeamSpace(team)
+	if err != nil {
+		return errors.Wrap(err, "failed to get team space")
+	}
+
+	roomID, err = p.matrixClient.ResolveRoomAlias(roomID)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve Matrix room")
+	}
+
+	currentSpaceID, err := p.getRoomSpace(roomID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get room's current space")
+	}
+	if currentSpaceID == space.SpaceID {
+		return nil
+	}
+
+	// The channel moved to another team since it was listed
+	if currentSpaceID != "" {
+		if err := p.RemoveChannelFromTeamSpace(roomID); err != nil {
+			p.logger.LogWarn("Failed to remove room from previous team space", "error", err, "room_id", roomID, "space_id", currentSpaceID)
+		}
+	}
+
+	if err := p.matrixClient.AddSpaceChild(space.SpaceID, roomID); err != nil {
+		return errors.Wrap(err, "failed to add room to space")
+	}
+
+	// Rooms the bridge didn't create may not let it set their parent; the child event alone is enough for
+	// the room to show up in the space
+	if err := p.matrixClient.SetSpaceParent(roomID, space.SpaceID); err != nil {
+		p.logger.LogWarn("Failed to set space parent on Matrix room", "error", err, "room_id", roomID, "space_id", space.SpaceID)
+	}
+
+	if err := p.kvstore.Set(kvstore.BuildRoomSpaceKey(roomID), []byte(space.SpaceID)); err != nil {
+		return errors.Wrap(err, "failed to save room space")
+	}
+
+	p.logger.LogDebug("Added Matrix room to team space", "room_id", roomID, "space_id", space.SpaceID, "channel_id", channelID, "team_id", team.Id)
+	return nil
+}
+
+// RemoveChannelFromTeamSpace removes a room from the team space currently listing it, if any
+func (p *Plugin) RemoveChannelFromTeamSpace(roomID string) error {
+	if p.matrixClient == nil {
+		return errors.New("matrix client not configured")
+	}
+
+	roomID, err := p.matrixClient.ResolveRoomAlias(roomID)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve Matrix room")
+	}
+
+	spaceID, err := p.getRoomSpace(roomID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get room's current space")
+	}
+	if spaceID == "" {
+		return nil
+	}
+
+	if err := p.matrixClient.RemoveSpaceChild(spaceID, roomID); err != nil {
+		return errors.Wrap(err, "failed to remove room from space")
+	}
+
+	if err := p.matrixClient.RemoveSpaceParent(roomID, spaceID); err != nil {
+		p.logger.LogWarn("Failed to remove space parent from Matrix room", "error", err, "room_id", roomID, "space_id", spaceID)
+	}
+
+	if err := p.kvstore.Delete(kvstore.BuildRoomSpaceKey(roomID)); err != nil {
+		return errors.Wrap(err, "failed to delete room space")
+	}
+
+	p.logger.LogDebug("Removed Matrix room from team space", "room_id", roomID, "space_id", spaceID)
+	return nil
+}
+
+// reconcileTeamSpaces brings team spaces in line with changes Mattermost has no plugin hooks for: channels
+// that were archived or moved to another team, and teams that were renamed or given a new icon
+func (p *Plugin) reconcileTeamSpaces() {
+	if p.matrixClient == nil {
+		return
+	}
+
+	mappings, err := p.listChannelMappings()
+	if err != nil {
+		p.logger.LogError("Failed to list channel mappings for team spaces", "error", err)
+		return
+	}
+
+	for channelID, roomIdentifier := range mappings {
+		channel, appErr := p.API.GetChannel(channelID)
+		if appErr != nil {
+			p.logger.LogWarn("Failed to get mapped channel for team spaces", "error", appErr, "channel_id", channelID)
+			continue
+		}
+
+		if channel.DeleteAt != 0 {
+			if err := p.RemoveChannelFromTeamSpace(roomIdentifier); err != nil {
+				p.logger.LogWarn("Failed to remove archived channel from team space", "error", err, "channel_id", channelID, "room_identifier", roomIdentifier)
+			}
+			continue
+		}
+
+		if err := p.AddChannelToTeamSpace(channelID, roomIdentifier); err != nil {
+			p.logger.LogWarn("Failed to add channel to team space", "error", err, "channel_id", channelID, "room_identifier", roomIdentifier)
+		}
+	}
+
+	spaceKeys, err := p.listKeysWithPrefix(kvstore.KeyPrefixTeamSpace)
+	if err != nil {
+		p.logger.LogError("Failed to list team spaces", "error", err)
+		return
+	}
+
+	for _, key := range spaceKeys {
+		teamID := strings.TrimPrefix(key, kvstore.KeyPrefixTeamSpace)
+		space, err := p.getTeamSpace(teamID)
+		if err != nil || space == nil {
+			continue
+		}
+
+		team, appErr := p.API.GetTeam(teamID)
+		if appErr != nil {
+			p.logger.LogWarn("Failed to get team for space profile", "error", appErr, "team_id", teamID)
+			continue
+		}
+
+		if err := p.syncTeamSpaceProfile(team, space); err != nil {
+			p.logger.LogWarn("Failed to sync team space profile", "error", err, "team_id", teamID, "space_id", space.SpaceID)
+		}
+	}
+}
+
+// getOrCreateTeamSpace returns the space for a team, creating it if the team doesn't have one yet
+func (p *Plugin) getOrCreateTeamSpace(team *model.Team) (*teamSpace, error) {
+	space, err := p.getTeamSpace(team.Id)
+	if err != nil {
+		return nil, err
+	}
+	if space != nil {
+		return space, nil
+	}
+
+	spaceID, err := p.matrixClient.CreateSpace(team.DisplayName, team.Description, team.Type == model.TeamOpen, team.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Matrix space")
+	}
+
+	space = &teamSpace{
+		SpaceID:     spaceID,
+		TeamID:      team.Id,
+		DisplayName: team.DisplayName,
+	}
+
+	// Save the space before anything else can fail, so a retry doesn't create another one
+	if err := p.saveTeamSpace(space); err != nil {
+		return nil, errors.Wrap(err, "failed to save team space")
+	}
+
+	// Picks up the team icon. A failure leaves the icon for the next profile sync to retry.
+	if err := p.syncTeamSpaceProfile(team, space); err != nil {
+		p.logger.LogWarn("Failed to sync new team space profile", "error", err, "team_id", team.Id, "space_id", spaceID)
+	}
+
+	p.logger.LogInfo("Created Matrix space for team", "team_id", team.Id, "space_id", spaceID)
+	return space, nil
+}
+
+// syncTeamSpaceProfile updates the space's name and avatar if the team's have changed, and saves the space
+func (p *Plugin) syncTeamSpaceProfile(team *model.Team, space *teamSpace) error {
+	if team.DisplayName != space.DisplayName {
+		if err := p.matrixClient.SetRoomName(space.SpaceID, team.DisplayName); err != nil {
+			return errors.Wrap(err, "failed to update space name")
+		}
+		space.DisplayName = team.DisplayName
+	}
+
+	if team.LastTeamIconUpdate != space.LastTeamIconUpdate {
+		avatarURL := ""
+		if team.LastTeamIconUpdate > 0 {
+			iconData, appErr := p.API.GetTeamIcon(team.Id)
+			if appErr != nil {
+				return errors.Wrap(appErr, "failed to get team icon")
+			}
+			// Mattermost stores team icons as PNG
+			mxcURI, err := p.matrixClient.UploadAvatarFromData(iconData, "image/png")
+			if err != nil {
+				return errors.Wrap(err, "failed to upload team icon")
+			}
+			avatarURL = mxcURI
+		}
+
+		if err := p.matrixClient.SetRoomAvatar(space.SpaceID, avatarURL); err != nil {
+			return errors.Wrap(err, "failed to update space avatar")
+		}
+		space.LastTeamIconUpdate = team.LastTeamIconUpdate
+	}
+
+	return p.saveTeamSpace(space)
+}
+
+// getTeamSpace returns the stored space for a team, or nil if the team doesn't have one
+func (p *Plugin) getTeamSpace(teamID string) (*teamSpace, error) {
+	data, err := p.kvstore.Get(kvstore.BuildTeamSpaceKey(teamID))
+	if err != nil || len(data) == 0 {
+		return nil, nil
+	}
+
+	var space teamSpace
+	if err := json.Unmarshal(data, &space); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal team space")
+	}
+	return &space, nil
+}
+
+// saveTeamSpace stores a team's space
+func (p *Plugin) saveTeamSpace(space *teamSpace) error {
+	data, err := json.Marshal(space)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal team space")
+	}
+	return p.kvstore.Set(kvstore.BuildTeamSpaceKey(space.TeamID), data)
+}
+
+// getRoomSpace returns the ID of the space currently listing a room, or "" if none does
+func (p *Plugin) getRoomSpace(roomID string) (string, error) {
+	data, err := p.kvstore.Get(kvstore.BuildRoomSpaceKey(roomID))
+	if err != nil || len(data) == 0 {
+		return "", nil
+	}
+	return string(data), nil
+}
+
+// listChannelMappings returns every channel mapping as channel ID -> room identifier
+func (p *Plugin) listChannelMappings() (map[string]string, error) {
+	keys, err := p.listKeysWithPrefix(kvstore.KeyPrefixChannelMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make(map[string]string, len(keys))
+	for _, key := range keys {
+		data, err := p.kvstore.Get(key)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		mappings[strings.TrimPrefix(key, kvstore.KeyPrefixChannelMapping)] = string(data)
+	}
+	return mappings, nil
+}
+
+// listKeysWithPrefix pages through every KV store key with a prefix
+func (p *Plugin) listKeysWithPrefix(prefix string) ([]string, error) {
+	const batchSize = 1000
+
+	var allKeys []string
+	for page := 0; ; page++ {
+		keys, err := p.kvstore.ListKeysWithPrefix(page, batchSize, prefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list KV store keys")
+		}
+		allKeys = append(allKeys, keys...)
+		if len(keys) < batchSize {
+			return allKeys, nil
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/store/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSpaceHomeserver records spaces the bridge creates and the state it sends
+type fakeSpaceHomeserver struct {
+	mu     sync.Mutex
+	spaces int
+	state  map[string]map[string]any
+}
+
+func (f *fakeSpaceHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/_matrix/client/v3/createRoom":
+		f.spaces++
+		_, _ = fmt.Fprintf(w, `{"room_id":"!space%d:test.com"}`, f.spaces)
+	case r.Method == http.MethodPost && r.URL.Path == "/_matrix/media/v3/upload":
+		_, _ = w.Write([]byte(`{"content_uri":"mxc://test.com/icon"}`))
+	case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/state/"):
+		body, _ := io.ReadAll(r.Body)
+		var content map[string]any
+		_ = json.Unmarshal(body, &content)
+		path, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/_matrix/client/v3/rooms/"))
+		f.state[path] = content
+		_, _ = w.Write([]byte(`{"event_id":"$event"}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// stateContent returns the latest content sent for a room's state event, or nil if none was sent
+func (f *fakeSpaceHomeserver) stateContent(roomID, eventType, stateKey string) map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state[roomID+"/state/"+eventType+"/"+stateKey]
+}
+
+func setupTeamSpaceTest(t *testing.T) (*Plugin, *plugintest.API, *fakeSpaceHomeserver) {
+	homeserver := &fakeSpaceHomeserver{state: map[string]map[string]any{}}
+	plugin, api := setupPluginWithHomeserver(t, homeserver)
+	return plugin, api, homeserver
+}
+
+func TestAddChannelToTeamSpace(t *testing.T) {
+	team := &model.Team{Id: model.NewId(), DisplayName: "Engineering", Type: model.TeamOpen}
+	channelID := model.NewId()
+	roomID := "!room:test.com"
+
+	t.Run("creates the team space and links the room both ways", func(t *testing.T) {
+		plugin, api, homeserver := setupTeamSpaceTest(t)
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, TeamId: team.Id}, nil)
+		api.On("GetTeam", team.Id).Return(team, nil)
+
+		require.NoError(t, plugin.AddChannelToTeamSpace(channelID, roomID))
+
+		space, err := plugin.getTeamSpace(team.Id)
+		require.NoError(t, err)
+		require.NotNil(t, space)
+		assert.Equal(t, "!space1:test.com", space.SpaceID)
+		assert.Equal(t, []any{"test.com"}, homeserver.stateContent(space.SpaceID, "m.space.child", roomID)["via"])
+		assert.Equal(t, true, homeserver.stateContent(roomID, "m.space.parent", space.SpaceID)["canonical"])
+
+		// A second channel in the same team reuses the space
+		require.NoError(t, plugin.AddChannelToTeamSpace(channelID, "!other:test.com"))
+		assert.Equal(t, 1, homeserver.spaces)
+	})
+
+	t.Run("direct and group messages are not listed", func(t *testing.T) {
+		plugin, api, homeserver := setupTeamSpaceTest(t)
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Type: model.ChannelTypeDirect}, nil)
+
+		require.NoError(t, plugin.AddChannelToTeamSpace(channelID, roomID))
+		assert.Equal(t, 0, homeserver.spaces)
+	})
+
+	t.Run("rooms follow channels moved to another team", func(t *testing.T) {
+		plugin, api, homeserver := setupTeamSpaceTest(t)
+		otherTeam := &model.Team{Id: model.NewId(), DisplayName: "Sales"}
+		api.On("GetTeam", team.Id).Return(team, nil)
+		api.On("GetTeam", otherTeam.Id).Return(otherTeam, nil)
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, TeamId: team.Id}, nil).Once()
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, TeamId: otherTeam.Id}, nil).Once()
+
+		require.NoError(t, plugin.AddChannelToTeamSpace(channelID, roomID))
+		require.NoError(t, plugin.AddChannelToTeamSpace(channelID, roomID))
+
+		assert.Empty(t, homeserver.stateContent("!space1:test.com", "m.space.child", roomID))
+		assert.NotEmpty(t, homeserver.stateContent("!space2:test.com", "m.space.child", roomID))
+		spaceID, err := plugin.getRoomSpace(roomID)
+		require.NoError(t, err)
+		assert.Equal(t, "!space2:test.com", spaceID)
+	})
+
+	t.Run("spaces are kept when the team icon can't be synced", func(t *testing.T) {
+		plugin, api, homeserver := setupTeamSpaceTest(t)
+		iconTeam := &model.Team{Id: model.NewId(), DisplayName: "Design", LastTeamIconUpdate: 1}
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, TeamId: iconTeam.Id}, nil)
+		api.On("GetTeam", iconTeam.Id).Return(iconTeam, nil)
+		api.On("GetTeamIcon", iconTeam.Id).Return(nil, model.NewAppError("GetTeamIcon", "not_found", nil, "", http.StatusNotFound))
+
+		require.NoError(t, plugin.AddChannelToTeamSpace(channelID, roomID))
+		require.NoError(t, plugin.AddChannelToTeamSpace(channelID, "!other:test.com"))
+
+		assert.Equal(t, 1, homeserver.spaces)
+		space, err := plugin.getTeamSpace(iconTeam.Id)
+		require.NoError(t, err)
+		require.NotNil(t, space)
+		assert.Zero(t, space.LastTeamIconUpdate)
+	})
+}
+
+func TestRemoveChannelFromTeamSpace(t *testing.T) {
+	t.Run("clears the child and parent links", func(t *testing.T) {
+		plugin, _, homeserver := setupTeamSpaceTest(t)
+		require.NoError(t, plugin.kvstore.Set(kvstore.BuildRoomSpaceKey("!room:test.com"), []byte("!space1:test.com")))
+
+		require.NoError(t, plugin.RemoveChannelFromTeamSpace("!room:test.com"))
+
+		child := homeserver.stateContent("!space1:test.com", "m.space.child", "!room:test.com")
+		require.NotNil(t, child)
+		assert.Empty(t, child)
+		spaceID, err := plugin.getRoomSpace("!room:test.com")
+		require.NoError(t, err)
+		assert.Empty(t, spaceID)
+	})
+
+	t.Run("rooms outside any space are left alone", func(t *testing.T) {
+		plugin, _, homeserver := setupTeamSpaceTest(t)
+
+		require.NoError(t, plugin.RemoveChannelFromTeamSpace("!room:test.com"))
+		assert.Empty(t, homeserver.state)
+	})
+}
+
+func TestReconcileTeamSpaces(t *testing.T) {
+	team := &model.Team{Id: model.NewId(), DisplayName: "Engineering"}
+	activeChannelID := model.NewId()
+	archivedChannelID := model.NewId()
+
+	plugin, api, homeserver := setupTeamSpaceTest(t)
+	api.On("GetChannel", activeChannelID).Return(&model.Channel{Id: activeChannelID, TeamId: team.Id}, nil)
+	api.On("GetChannel", archivedChannelID).Return(&model.Channel{Id: archivedChannelID, TeamId: team.Id, DeleteAt: 1}, nil)
+	api.On("GetTeam", team.Id).Return(&model.Team{Id: team.Id, DisplayName: "Platform", LastTeamIconUpdate: 5}, nil)
+	api.On("GetTeamIcon", team.Id).Return([]byte("png"), nil)
+
+	require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(activeChannelID), []byte("!active:test.com")))
+	require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(archivedChannelID), []byte("!archived:test.com")))
+	require.NoError(t, plugin.kvstore.Set(kvstore.BuildRoomSpaceKey("!archived:test.com"), []byte("!space0:test.com")))
+	require.NoError(t, plugin.saveTeamSpace(&teamSpace{SpaceID: "!space0:test.com", TeamID: team.Id, DisplayName: "Engineering"}))
+
+	plugin.reconcileTeamSpaces()
+
+	assert.NotEmpty(t, homeserver.stateContent("!space0:test.com", "m.space.child", "!active:test.com"))
+	assert.Empty(t, homeserver.stateContent("!space0:test.com", "m.space.child", "!archived:test.com"))
+	assert.Equal(t, "Platform", homeserver.stateContent("!space0:test.com", "m.room.name", "")["name"])
+	assert.Equal(t, "mxc://test.com/icon", homeserver.stateContent("!space0:test.com", "m.room.avatar", "")["url"])
+
+	space, err := plugin.getTeamSpace(team.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "Platform", space.DisplayName)
+	assert.Equal(t, int64(5), space.LastTeamIconUpdate)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"sort"
 	"strings"
@@ -17,6 +19,7 @@ import (
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // testLogger implements Logger interface for testing
@@ -87,6 +90,41 @@ func createMatrixClientWithTestLogger(t *testing.T, serverURL, asToken, remoteID
 	return matrix.NewClientWithLoggerAndRateLimit(serverURL, asToken, remoteID, "", testLogger, matrix.TestRateLimitConfig())
 }
 
+// setupPluginWithHomeserver creates a plugin with an in-memory KV store whose Matrix client talks to a test server
+// running the given homeserver. Bridges are not initialized, so callers can adjust the plugin first.
+func setupPluginWithHomeserver(t *testing.T, homeserver http.Handler) (*Plugin, *plugintest.API) {
+	server := httptest.NewServer(homeserver)
+	t.Cleanup(server.Close)
+
+	plugin := setupPluginForTest()
+	plugin.kvstore = NewMemoryKVStore()
+	plugin.matrixClient = createMatrixClientWithTestLogger(t, server.URL, "as_token", "remote_id")
+	plugin.matrixClient.SetServerDomain("test.com")
+
+	return plugin, plugin.API.(*plugintest.API)
+}
+
+// setupMappedChannelTest creates a syncing plugin with initialized bridges and one channel mapped to
+// !room:test.com, whose Matrix client talks to a test server running the given homeserver
+func setupMappedChannelTest(t *testing.T, homeserver http.Handler) (*Plugin, *plugintest.API, string) {
+	plugin, api := setupPluginWithHomeserver(t, homeserver)
+	plugin.configuration = &configuration{
+		MatrixServerURL: "https://test.com",
+		MatrixHSToken:   "hs_token",
+		EnableSync:      true,
+	}
+	plugin.remoteID = "remote_id"
+	plugin.pendingFiles = NewPendingFileTracker()
+	plugin.postTracker = NewPostTracker(DefaultPostTrackerMaxEntries)
+	plugin.initBridges()
+
+	channelID := model.NewId()
+	require.NoError(t, plugin.kvstore.Set(kvstore.BuildChannelMappingKey(channelID), []byte("!room:test.com")))
+	require.NoError(t, plugin.kvstore.Set(kvstore.BuildRoomMappingKey("!room:test.com"), []byte(channelID)))
+
+	return plugin, api, channelID
+}
+
 // TestMatrixClientTestLogger verifies that matrix client uses test logger correctly
 func TestMatrixClientTestLogger(t *testing.T) {
 	// Create a matrix client with test logger
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// postTypeVoice is the type of Mattermost voice message posts, which carry the recording as their only file
+	postTypeVoice = model.PostCustomTypePrefix + "voice"
+
+	// Props of voice message posts: the duration in milliseconds and the waveform
+	voiceDurationProp = "duration"
+	voiceWaveformProp = "waveform"
+
+	// voiceWaveformLength is the number of waveform values generated, the number Matrix clients record
+	voiceWaveformLength = 100
+	// voiceWaveformMax is the largest waveform value under MSC3245
+	voiceWaveformMax = 1024
+
+	// voiceRecordingMaxAnalyzedSize is the largest recording read into memory to work out a missing duration or
+	// waveform. Ogg durations are at the end of the file, so a prefix wouldn't do.
+	voiceRecordingMaxAnalyzedSize = 8 * 1024 * 1024
+)
+
+// isVoicePost reports whether a Mattermost post is a voice message
+func isVoicePost(post *model.Post) bool {
+	return post != nil && post.Type == postTypeVoice
+}
+
+// voiceMediaInfo marks the media info of a voice message's recording as a voice message. The duration and
+// waveform come from the post where it has them, and from the audio otherwise.
+func voiceMediaInfo(info *matrix.MediaInfo, post *model.Post, data []byte) {
+	info.Voice = true
+	if durationMs := int64Prop(post.GetProp(voiceDurationProp)); durationMs > 0 {
+		info.DurationMs = durationMs
+	}
+	if waveform := waveformProp(post.GetProp(voiceWaveformProp)); len(waveform) > 0 {
+		info.Waveform = waveform
+	} else {
+		info.Waveform = audioWaveform(data)
+	}
+}
+
+// matrixVoiceMessage returns the duration and waveform of a Matrix voice message, and whether the content is one
+func matrixVoiceMessage(content map[string]any) (int64, []int, bool) {
+	if _, ok := content[matrix.VoiceKey]; !ok {
+		return 0, nil, false
+	}
+
+	audio, _ := content[matrix.VoiceAudioKey].(map[string]any)
+	durationMs := int64Prop(audio["duration"])
+	if durationMs == 0 {
+		if info, ok := content["info"].(map[string]any); ok {
+			durationMs = int64Prop(info["duration"])
+		}
+	}
+	return durationMs, waveformProp(audio["waveform"]), true
+}
+
+// addVoiceProps makes a post with a Matrix voice message's recording a Mattermost voice message. A missing
+// duration or waveform is worked out from the uploaded recording, unless it is too large to read.
+func (b *BridgeUtils) addVoiceProps(post *model.Post, file *model.FileInfo, durationMs int64, waveform []int) {
+	post.Type = postTypeVoice
+
+	if durationMs == 0 || len(waveform) == 0 {
+		if file.Size > voiceRecordingMaxAnalyzedSize {
+			b.logger.LogDebug("Voice message recording too large to read for its duration and waveform", "file_id", file.Id, "size", file.Size)
+		} else if data, appErr := b.API.GetFile(file.Id); appErr == nil {
+			if durationMs == 0 {
+				durationMs = mediaDurationMs(data)
+			}
+			if len(waveform) == 0 {
+				waveform = audioWaveform(data)
+			}
+		} else {
+			b.logger.LogDebug("Failed to read voice message recording", "error", appErr, "file_id", file.Id)
+		}
+	}
+
+	if durationMs > 0 {
+		post.AddProp(voiceDurationProp, durationMs)
+	}
+	if len(waveform) > 0 {
+		post.AddProp(voiceWaveformProp, waveform)
+	}
+}
+
+// int64Prop reads a whole number from a post prop or event field, which are float64 once decoded from JSON
+func int64Prop(value any) int64 {
+	switch v := value.(type) {
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	}
+	return 0
+}
+
+// waveformProp reads a waveform from a post prop or event field, dropping values outside the MSC3245 range
+func waveformProp(value any) []int {
+	var waveform []int
+	switch v := value.(type) {
+	case []int:
+		waveform = v
+	case []any:
+		for _, item := range v {
+			waveform = append(waveform, int(int64Prop(item)))
+		}
+	}
+
+	valid := make([]int, 0, len(waveform))
+	for _, value := range waveform {
+		if value >= 0 && value <= voiceWaveformMax {
+			valid = append(valid, value)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return valid
+}
+
+// audioWaveform generates the waveform of 8 and 16 bit PCM WAV and MP3 recordings, or returns nil for other
+// formats. Without a decoder, an MP3 frame's loudness is estimated from its global gain.
+func audioWaveform(data []byte) []int {
+	if len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE" {
+		return wavWaveform(data[12:])
+	}
+
+	frames := mp3Frames(data)
+	if len(frames) == 0 {
+		return nil
+	}
+	maxGain := 0
+	for _, frame := range frames {
+		maxGain = max(maxGain, frame.globalGain)
+	}
+	// Each step of global gain scales the quantizer by a fourth root of two
+	return waveform(len(frames), func(i int) float64 {
+		return math.Pow(2, float64(frames[i].globalGain-maxGain)/4)
+	})
+}
+
+// wavWaveform generates the waveform of the first channel of a PCM WAV file from the chunks after its header
+func wavWaveform(chunks []byte) []int {
+	var blockAlign, bitsPerSample int
+	var samples []byte
+	for len(chunks) >= 8 {
+		size := uint64(binary.LittleEndian.Uint32(chunks[4:8]))
+		body := chunks[8:]
+		if size > uint64(len(body)) {
+			size = uint64(len(body))
+		}
+		switch string(chunks[0:4]) {
+		case "fmt ":
+			// Only uncompressed PCM can be read directly
+			if size < 16 || binary.LittleEndian.Uint16(body[0:2]) != 1 {
+				return nil
+			}
+			blockAlign = int(binary.LittleEndian.Uint16(body[12:14]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+		case "data":
+			samples = body[:size]
+		}
+		// Chunks are padded to an even size
+		next := 8 + size + size%2
+		if next > uint64(len(chunks)) {
+			break
+		}
+		chunks = chunks[next:]
+	}
+
+	// A sample frame must hold at least one sample of the first channel
+	if blockAlign == 0 || blockAlign < bitsPerSample/8 || len(samples) < blockAlign {
+		return nil
+	}
+	var level func(i int) float64
+	switch bitsPerSample {
+	case 8:
+		// 8 bit samples are unsigned
+		level = func(i int) float64 {
+			return math.Abs(float64(samples[i*blockAlign])-128) / 128
+		}
+	case 16:
+		level = func(i int) float64 {
+			return math.Abs(float64(int16(binary.LittleEndian.Uint16(samples[i*blockAlign:])))) / 32768
+		}
+	default:
+		return nil
+	}
+	return waveform(len(samples)/blockAlign, level)
+}
+
+// waveform reduces count levels to at most voiceWaveformLength values, each the peak of its stretch of the
+// audio, scaled so the loudest is voiceWaveformMax
+func waveform(count int, level func(i int) float64) []int {
+	length := min(count, voiceWaveformLength)
+	peaks := make([]float64, length)
+	for i := 0; i < count; i++ {
+		bucket := i * length / count
+		peaks[bucket] = math.Max(peaks[bucket], level(i))
+	}
+
+	loudest := 0.0
+	for _, peak := range peaks {
+		loudest = math.Max(loudest, peak)
+	}
+	values := make([]int, length)
+	if loudest == 0 {
+		return values
+	}
+	for i, peak := range peaks {
+		values[i] = int(math.Round(peak / loudest * voiceWaveformMax))
+	}
+	return values
+}
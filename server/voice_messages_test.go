@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-matrix-bridge/server/matrix"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWAV returns a 16 bit mono PCM WAV file of the given samples
+func testWAV(samples []int16) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	data = binary.LittleEndian.AppendUint32(data, 16)
+	data = binary.LittleEndian.AppendUint16(data, 1)     // PCM
+	data = binary.LittleEndian.AppendUint16(data, 1)     // Channels
+	data = binary.LittleEndian.AppendUint32(data, 8000)  // Sample rate
+	data = binary.LittleEndian.AppendUint32(data, 16000) // Byte rate
+	data = binary.LittleEndian.AppendUint16(data, 2)     // Block align
+	data = binary.LittleEndian.AppendUint16(data, 16)    // Bits per sample
+	data = append(data, "data"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(2*len(samples)))
+	for _, sample := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(sample))
+	}
+	return data
+}
+
+// testMP3 returns MPEG-1 layer III frames at 128kbps and 44.1kHz with the given global gains, after an ID3v2 tag
+func testMP3(gains ...int) []byte {
+	data := []byte("ID3\x04\x00\x00\x00\x00\x00\x04TAG!")
+	for _, gain := range gains {
+		frame := make([]byte, 417)
+		binary.BigEndian.PutUint32(frame[0:4], 0xfffb9000)
+		binary.BigEndian.PutUint64(frame[4:12], uint64(gain)<<15)
+		data = append(data, frame...)
+	}
+	return data
+}
+
+func TestAudioWaveform(t *testing.T) {
+	t.Run("WAV", func(t *testing.T) {
+		samples := make([]int16, 400)
+		for i := range samples {
+			samples[i] = int16(i * 80)
+			if i%2 == 1 {
+				samples[i] = -samples[i]
+			}
+		}
+
+		waveform := audioWaveform(testWAV(samples))
+		require.Len(t, waveform, voiceWaveformLength)
+		assert.Equal(t, 1024, waveform[99])
+		assert.Less(t, waveform[10], waveform[50])
+	})
+
+	t.Run("short recordings get a value per sample", func(t *testing.T) {
+		assert.Equal(t, []int{0, 1024, 512}, audioWaveform(testWAV([]int16{0, -16000, 8000})))
+	})
+
+	t.Run("silence", func(t *testing.T) {
+		assert.Equal(t, []int{0, 0}, audioWaveform(testWAV([]int16{0, 0})))
+	})
+
+	t.Run("MP3", func(t *testing.T) {
+		assert.Equal(t, []int{1024, 64, 512}, audioWaveform(testMP3(200, 184, 196)))
+	})
+
+	t.Run("unknown formats", func(t *testing.T) {
+		assert.Nil(t, audioWaveform([]byte("OggS not decoded")))
+	})
+
+	t.Run("malformed files", func(t *testing.T) {
+		// 16 bit samples with a block align of one byte
+		wav := testWAV([]int16{1000, -1000, 2000})
+		binary.LittleEndian.PutUint16(wav[32:34], 1)
+		assert.Nil(t, audioWaveform(wav))
+
+		// A data chunk claiming more than the file holds is read as far as it goes
+		wav = testWAV([]int16{1000, -1000})
+		binary.LittleEndian.PutUint32(wav[40:44], 0xffffffff)
+		assert.Equal(t, []int{1024, 1024}, audioWaveform(wav))
+
+		assert.Nil(t, audioWaveform([]byte("RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00\x01\x00")))
+		assert.Nil(t, audioWaveform(testMP3(200)[:20]))
+	})
+}
+
+func FuzzAudioWaveform(f *testing.F) {
+	f.Add(testWAV([]int16{0, 1000, -1000}))
+	f.Add(testMP3(200, 184, 196))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Uploaded files are untrusted, so nothing may panic
+		waveform := audioWaveform(data)
+		assert.LessOrEqual(t, len(waveform), voiceWaveformLength)
+		for _, value := range waveform {
+			assert.True(t, value >= 0 && value <= voiceWaveformMax)
+		}
+	})
+}
+
+func TestMatrixVoiceMessage(t *testing.T) {
+	t.Run("voice messages", func(t *testing.T) {
+		durationMs, waveform, isVoice := matrixVoiceMessage(map[string]any{
+			"msgtype":            "m.audio",
+			matrix.VoiceKey:      map[string]any{},
+			matrix.VoiceAudioKey: map[string]any{"duration": float64(2500), "waveform": []any{float64(0), float64(512), float64(2000)}},
+		})
+		assert.True(t, isVoice)
+		assert.Equal(t, int64(2500), durationMs)
+		assert.Equal(t, []int{0, 512}, waveform)
+	})
+
+	t.Run("the duration falls back to the file info", func(t *testing.T) {
+		durationMs, waveform, isVoice := matrixVoiceMessage(map[string]any{
+			matrix.VoiceKey: map[string]any{},
+			"info":          map[string]any{"duration": float64(1200)},
+		})
+		assert.True(t, isVoice)
+		assert.Equal(t, int64(1200), durationMs)
+		assert.Nil(t, waveform)
+	})
+
+	t.Run("other audio", func(t *testing.T) {
+		_, _, isVoice := matrixVoiceMessage(map[string]any{"msgtype": "m.audio"})
+		assert.False(t, isVoice)
+	})
+}
+
+func TestAddVoiceProps(t *testing.T) {
+	plugin := setupPluginForTest()
+	plugin.initBridges()
+	api := plugin.API.(*plugintest.API)
+
+	t.Run("Matrix values are kept", func(t *testing.T) {
+		post := &model.Post{}
+		plugin.matrixToMattermostBridge.addVoiceProps(post, &model.FileInfo{Id: "file", Size: 40}, 2500, []int{1, 2, 3})
+
+		assert.Equal(t, postTypeVoice, post.Type)
+		assert.Equal(t, int64(2500), post.GetProp(voiceDurationProp))
+		assert.Equal(t, []int{1, 2, 3}, post.GetProp(voiceWaveformProp))
+	})
+
+	t.Run("missing values come from the recording", func(t *testing.T) {
+		api.On("GetFile", "recording").Return(testWAV([]int16{0, -16000, 8000, 0}), nil).Once()
+		post := &model.Post{}
+		plugin.matrixToMattermostBridge.addVoiceProps(post, &model.FileInfo{Id: "recording", Size: 52}, 0, nil)
+
+		assert.Equal(t, postTypeVoice, post.Type)
+		assert.Nil(t, post.GetProp(voiceDurationProp), "a fraction of a millisecond rounds down to nothing")
+		assert.Equal(t, []int{0, 1024, 512, 0}, post.GetProp(voiceWaveformProp))
+	})
+
+	t.Run("large recordings are not read", func(t *testing.T) {
+		post := &model.Post{}
+		plugin.matrixToMattermostBridge.addVoiceProps(post, &model.FileInfo{Id: "long", Size: voiceRecordingMaxAnalyzedSize + 1}, 0, nil)
+
+		assert.Equal(t, postTypeVoice, post.Type)
+		assert.Nil(t, post.GetProp(voiceDurationProp))
+		assert.Nil(t, post.GetProp(voiceWaveformProp))
+		api.AssertNotCalled(t, "GetFile", "long")
+	})
+}
+
+func TestVoiceMediaInfo(t *testing.T) {
+	t.Run("post props win", func(t *testing.T) {
+		post := &model.Post{Type: postTypeVoice}
+		post.AddProp(voiceDurationProp, float64(3000))
+		post.AddProp(voiceWaveformProp, []any{float64(10), float64(20)})
+
+		info := matrix.MediaInfo{DurationMs: 2900}
+		voiceMediaInfo(&info, post, testMP3(200))
+		assert.Equal(t, matrix.MediaInfo{Voice: true, DurationMs: 3000, Waveform: []int{10, 20}}, info)
+	})
+
+	t.Run("the waveform is generated from the recording", func(t *testing.T) {
+		data := testMP3(200, 196)
+		info := matrix.MediaInfo{DurationMs: mediaDurationMs(data)}
+		voiceMediaInfo(&info, &model.Post{Type: postTypeVoice}, data)
+		assert.Equal(t, matrix.MediaInfo{Voice: true, DurationMs: 52, Waveform: []int{1024, 512}}, info)
+	})
+}